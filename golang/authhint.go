@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// authErrorDetail describes the credentials behind a rejected login: what
+// the IAM token said about itself and the likeliest reason DSQL refused it.
+type authErrorDetail struct {
+	User           string `json:"user"`
+	IAMToken       bool   `json:"iam_token"`
+	TokenAction    string `json:"token_action,omitempty"`
+	TokenRegion    string `json:"token_region,omitempty"`
+	TokenExpiresAt string `json:"token_expires_at,omitempty"`
+	Hint           string `json:"hint"`
+}
+
+// newAuthErrorDetail returns the auth details of err, or nil if it isn't a
+// *dsqltest.AuthError.
+func newAuthErrorDetail(err error, now time.Time) *authErrorDetail {
+	var authErr *dsqltest.AuthError
+	if !errors.As(err, &authErr) {
+		return nil
+	}
+	d := &authErrorDetail{
+		User:        authErr.User,
+		IAMToken:    authErr.Token,
+		TokenAction: authErr.Action,
+		TokenRegion: authErr.Region,
+		Hint:        authHint(authErr, now),
+	}
+	if !authErr.ExpiresAt.IsZero() {
+		d.TokenExpiresAt = authErr.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	return d
+}
+
+// authHint names what is wrong with the credentials in e, checking the
+// token's expiry, signing region and action in that order, since an
+// expired token is by far the most common cause.
+func authHint(e *dsqltest.AuthError, now time.Time) string {
+	wantAction := strings.TrimPrefix(iamAction(e.User), "dsql:")
+	if !e.Token {
+		command := "generate-db-connect-auth-token"
+		if wantAction == "DbConnectAdmin" {
+			command = "generate-db-connect-admin-auth-token"
+		}
+		return fmt.Sprintf("the password is not a DSQL auth token; set DSQL_USE_IAM=true or generate one with aws dsql %s", command)
+	}
+
+	var problems []string
+	if !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt) {
+		problems = append(problems, fmt.Sprintf("the token expired %s ago; generate a new one",
+			now.Sub(e.ExpiresAt).Round(time.Second)))
+	}
+	if want := dsqltest.RegionFromHostname(e.Hostname); want != "" && e.Region != "" && e.Region != want {
+		problems = append(problems, fmt.Sprintf("the token was signed for %s but the cluster is in %s",
+			e.Region, want))
+	}
+	if e.Action != wantAction {
+		problems = append(problems, fmt.Sprintf("the token was signed for %s but user %q needs %s",
+			e.Action, e.User, wantAction))
+	}
+	if len(problems) > 0 {
+		return strings.Join(problems, "; ")
+	}
+	return fmt.Sprintf("the token is current and signed for %s; check that the signing identity's IAM policy allows dsql:%s on this cluster",
+		e.Action, wantAction)
+}
+
+// writeAuthDetails prints the auth details of err, if it has any.
+func writeAuthDetails(w io.Writer, err error) {
+	d := newAuthErrorDetail(err, time.Now())
+	if d == nil {
+		return
+	}
+	if d.IAMToken {
+		fmt.Fprintf(w, "Token Action: %s\n", d.TokenAction)
+		fmt.Fprintf(w, "Token Region: %s\n", valueOrUnknown(d.TokenRegion))
+		fmt.Fprintf(w, "Token Expires: %s\n", valueOrUnknown(d.TokenExpiresAt))
+	}
+	fmt.Fprintf(w, "Auth Hint: %s\n", d.Hint)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// loadAWSConfig resolves the credentials that sign auth tokens, assuming
+// roleARN on top of them when it's set. Resolving credentials is the slow
+// part of getting a token, so a positive tokenTimeout bounds it too. The
+// SDK's retries are counted in retries when it's non-nil.
+func loadAWSConfig(ctx context.Context, region, profile, roleARN, externalID string, tokenTimeout time.Duration, retries *retryBudget) (aws.Config, error) {
+	loadCtx := ctx
+	if tokenTimeout > 0 {
+		var cancel context.CancelFunc
+		loadCtx, cancel = context.WithTimeout(ctx, tokenTimeout)
+		defer cancel()
+	}
+	var opts []func(*config.LoadOptions) error
+	if retries != nil {
+		opts = append(opts, config.WithRetryer(retries.awsRetryer))
+	}
+	awsCfg, err := dsqltest.LoadAWSConfig(loadCtx, region, profile, opts...)
+	if err == nil && roleARN != "" {
+		awsCfg, err = dsqltest.AssumeRole(loadCtx, awsCfg, roleARN, externalID)
+	}
+	if err != nil && loadCtx.Err() != nil && ctx.Err() == nil {
+		return awsCfg, tokenPhaseError(fmt.Errorf("%w after %s: %w", dsqltest.ErrTokenTimeout, tokenTimeout, err))
+	}
+	return awsCfg, err
+}
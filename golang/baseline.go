@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// baselineSettings are the settings --save-baseline captures: the ones
+// --capabilities reports plus the session defaults a managed cluster could
+// change under an application. application_name is left out since it
+// carries the run's correlation ID.
+var baselineSettings = append(slices.Clone(capabilitySettings),
+	"client_encoding",
+	"DateStyle",
+	"IntervalStyle",
+	"search_path",
+	"standard_conforming_strings",
+	"default_transaction_read_only",
+	"lock_timeout",
+)
+
+// settingsBaseline is the --baseline file. Only the settings it lists are
+// compared, so entries can be removed by hand or others added.
+type settingsBaseline struct {
+	Hostname   string            `json:"hostname"`
+	CapturedAt string            `json:"captured_at"`
+	Settings   map[string]string `json:"settings"`
+}
+
+// settingDiff is a setting whose live value differs from the baseline.
+type settingDiff struct {
+	Setting  string `json:"setting"`
+	Baseline string `json:"baseline"`
+	Current  string `json:"current"`
+}
+
+// loadBaseline reads and parses a --baseline file.
+func loadBaseline(path string) (*settingsBaseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline: %w", err)
+	}
+	var b settingsBaseline
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline %s: %w", path, err)
+	}
+	if len(b.Settings) == 0 {
+		return nil, fmt.Errorf("baseline %s lists no settings", path)
+	}
+	return &b, nil
+}
+
+// baselineCheck compares the live values of the settings in the --baseline
+// file against the ones it recorded, failing on any difference. With
+// --save-baseline it records the current values to the file instead.
+var baselineCheck = check{name: "settings-baseline", run: runBaselineCheck}
+
+func runBaselineCheck(ctx context.Context, s *session, r *checkResult) error {
+	r.detail("baseline", s.cfg.baselinePath)
+	if s.cfg.baseline == nil {
+		return saveBaseline(ctx, s, r)
+	}
+
+	baseline := s.cfg.baseline
+	r.detail("captured_at", baseline.CapturedAt)
+	var diffs []settingDiff
+	for _, name := range slices.Sorted(maps.Keys(baseline.Settings)) {
+		// A setting that has become unavailable counts as a difference
+		current, err := readSetting(ctx, s, name)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			current = fmt.Sprintf("unavailable (%s: %s)", pgErr.Code, pgErr.Message)
+		} else if err != nil {
+			return r.step("read "+name, err)
+		}
+		if want := baseline.Settings[name]; current != want {
+			diffs = append(diffs, settingDiff{Setting: name, Baseline: want, Current: current})
+		}
+	}
+	r.step("read settings", nil)
+	r.detail("compared", len(baseline.Settings))
+
+	var err error
+	if len(diffs) > 0 {
+		// The list goes into the JSON details as is; the text output gets
+		// one readable line per setting instead
+		r.Details["differences"] = diffs
+		for _, d := range diffs {
+			fmt.Fprintf(r.out, "  %s: %q in baseline, now %q\n", d.Setting, d.Baseline, d.Current)
+		}
+		err = fmt.Errorf("%d of %d settings differ from the baseline captured %s", len(diffs), len(baseline.Settings), baseline.CapturedAt)
+	}
+	return r.step("settings match baseline", err)
+}
+
+// saveBaseline writes the current values of baselineSettings to the
+// --baseline file. A setting the server doesn't have is left out rather
+// than recorded as an error, so it isn't flagged on every later run.
+func saveBaseline(ctx context.Context, s *session, r *checkResult) error {
+	b := settingsBaseline{
+		Hostname:   s.cfg.conn.Hostname,
+		CapturedAt: time.Now().UTC().Format(time.RFC3339),
+		Settings:   make(map[string]string),
+	}
+	var unavailable []string
+	for _, name := range baselineSettings {
+		value, err := readSetting(ctx, s, name)
+		var pgErr *pgconn.PgError
+		switch {
+		case err == nil:
+			b.Settings[name] = value
+		case errors.As(err, &pgErr):
+			unavailable = append(unavailable, name)
+		default:
+			return r.step("read "+name, err)
+		}
+	}
+	r.step("read settings", nil)
+	if len(unavailable) > 0 {
+		r.detail("unavailable_settings", unavailable)
+	}
+	r.detail("saved", len(b.Settings))
+
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err == nil {
+		err = writeBaseline(s.cfg.baselinePath, append(data, '\n'))
+	}
+	return r.step("write baseline", err)
+}
+
+// readSetting returns the live value of a setting.
+func readSetting(ctx context.Context, s *session, name string) (string, error) {
+	var value string
+	err := s.conn.QueryRow(ctx, "SELECT current_setting($1)", name).Scan(&value)
+	return value, err
+}
+
+// writeBaseline replaces the baseline file through a temporary file, so a
+// failed write leaves the previous baseline intact.
+func writeBaseline(path string, data []byte) error {
+	f, err := openOutput(path, false)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write baseline: %w", err)
+	}
+	return f.commit()
+}
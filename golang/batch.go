@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// batchQuery is the statement each --batch query runs, with its index bound
+// to $1 so every result can be checked.
+const batchQuery = "SELECT $1::bigint"
+
+// batchCheck compares s.cfg.batchSize queries run one at a time against the
+// same queries pipelined in a single pgx.Batch.
+var batchCheck = check{name: "batch", run: runBatchTest}
+
+func runBatchTest(ctx context.Context, s *session, r *checkResult) error {
+	n := s.cfg.batchSize
+	r.detail("queries", n)
+
+	seqStart := time.Now()
+	for i := 0; i < n; i++ {
+		var got int64
+		err := s.conn.QueryRow(ctx, batchQuery, int64(i)).Scan(&got)
+		if err == nil {
+			err = expectBatchValue(i, got)
+		}
+		if err != nil {
+			return r.step("sequential queries", fmt.Errorf("query %d of %d: %w", i+1, n, err))
+		}
+	}
+	seq := time.Since(seqStart)
+	r.step("sequential queries", nil)
+
+	batch := &pgx.Batch{}
+	for i := 0; i < n; i++ {
+		batch.Queue(batchQuery, int64(i))
+	}
+	batchStart := time.Now()
+	err := readBatch(s.conn.SendBatch(ctx, batch), n)
+	elapsed := time.Since(batchStart)
+
+	// A batch runs as one implicit transaction, so a server error here
+	// usually means the batch was too large for DSQL rather than that
+	// pipelining doesn't work; report it as the finding
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		r.step("batched queries", nil)
+		r.detail("result", fmt.Sprintf("server rejected a batch of %d queries", n))
+		r.detail("sqlstate", pgErr.Code)
+		r.detail("message", pgErr.Message)
+		return nil
+	}
+	if err := r.step("batched queries", err); err != nil {
+		return err
+	}
+
+	r.detail("sequential_ms", durationMs(seq))
+	r.detail("batch_ms", durationMs(elapsed))
+	r.detail("speedup", fmt.Sprintf("%.2fx", seq.Seconds()/elapsed.Seconds()))
+	return nil
+}
+
+// readBatch reads and checks the n results of a sent batch, then closes it.
+func readBatch(br pgx.BatchResults, n int) error {
+	for i := 0; i < n; i++ {
+		var got int64
+		err := br.QueryRow().Scan(&got)
+		if err == nil {
+			err = expectBatchValue(i, got)
+		}
+		if err != nil {
+			br.Close()
+			return fmt.Errorf("query %d of %d: %w", i+1, n, err)
+		}
+	}
+	return br.Close()
+}
+
+// expectBatchValue reports a query that returned another query's result.
+func expectBatchValue(i int, got int64) error {
+	if got != int64(i) {
+		return fmt.Errorf("got %d, want %d", got, i)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultBenchDuration is how long --bench runs when --duration isn't set.
+const defaultBenchDuration = 30 * time.Second
+
+// benchReport summarizes a --bench run. Endpoint is only set for the two
+// runs of --compare.
+type benchReport struct {
+	Endpoint        string          `json:"endpoint,omitempty"`
+	Workers         int             `json:"workers"`
+	Query           string          `json:"query"`
+	DurationSeconds float64         `json:"duration_seconds"`
+	Queries         int             `json:"queries"`
+	Errors          int             `json:"errors"`
+	QPS             float64         `json:"qps"`
+	Latency         *latencySummary `json:"latency,omitempty"`
+	FirstRow        *latencySummary `json:"first_row_latency,omitempty"`
+	P50Ms           float64         `json:"p50_ms"`
+	P99Ms           float64         `json:"p99_ms"`
+	Reconnects      int             `json:"reconnects"`
+	FirstError      string          `json:"first_error,omitempty"`
+	Warmup          *warmupReport   `json:"warmup,omitempty"`
+	Rate            *rateReport     `json:"rate,omitempty"`
+	RoundRobin      []addressStats  `json:"round_robin,omitempty"`
+
+	samples []latencySample
+}
+
+// warmupReport times the discarded cycles run before a benchmark. The first
+// cycle pays for DNS, the TLS handshake and token generation, so comparing
+// it with the last shows the cold-start cost the warmup kept out of the
+// measured numbers.
+type warmupReport struct {
+	Cycles          int     `json:"cycles"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	FirstCycleMs    float64 `json:"first_cycle_ms"`
+	LastCycleMs     float64 `json:"last_cycle_ms"`
+}
+
+// benchWorker is one goroutine's share of a --bench run.
+type benchWorker struct {
+	samples  []latencySample
+	errors   int
+	firstErr error
+}
+
+// runBench runs the info query (or --query) in a loop on each of workers
+// connections until duration elapses or the process is interrupted. Each
+// worker holds a reconnectingConn so runs longer than DSQL's connection cap
+// keep going. A positive warmup first runs that many connect, query and
+// close cycles whose timings are reported separately. Format json writes
+// the report to stdout and csv every measured query.
+func runBench(rootCtx context.Context, cfg testConfig, workers, warmup int, duration time.Duration, out, stdout io.Writer, format string) int {
+	report, err := benchmark(rootCtx, cfg, workers, warmup, duration, out)
+	if err != nil {
+		return exitCodeOf(err)
+	}
+
+	code := exitOK
+	if report.Errors > 0 {
+		code = exitQuery
+	}
+	if format == "csv" {
+		if err := writeSamplesCSV(stdout, report.samples); err != nil {
+			slog.Error("failed to write CSV samples", "error", err)
+			return exitFailure
+		}
+		return code
+	}
+	return writeReport(stdout, out, report, format == "json", code)
+}
+
+// benchmark does the warmup and measured run of runBench and returns the
+// report. A failed warmup or connection is logged and returned; query
+// errors during the run are only counted.
+func benchmark(rootCtx context.Context, cfg testConfig, workers, warmup int, duration time.Duration, out io.Writer) (*benchReport, error) {
+	var warm *warmupReport
+	if warmup > 0 {
+		fmt.Fprintf(out, "Warming up with %d connect and query cycle(s)\n", warmup)
+		var err error
+		warm, err = runWarmup(rootCtx, cfg, warmup)
+		if err != nil {
+			err = interruptedError(rootCtx, err)
+			slog.Error("benchmark warmup failed", "error", err)
+			return nil, err
+		}
+	}
+
+	// Open every connection before the clock starts so connect time isn't
+	// counted against throughput
+	conns := make([]*reconnectingConn, workers)
+	for i := range conns {
+		dialCtx, cancel := context.WithTimeout(rootCtx, cfg.timeout)
+		rc, err := newReconnectingConn(dialCtx, cfg)
+		cancel()
+		if err != nil {
+			err = interruptedError(rootCtx, err)
+			slog.Error("failed to open benchmark connection", "worker", i+1, "error", err)
+			return nil, err
+		}
+		defer rc.close()
+		conns[i] = rc
+	}
+
+	query := cfg.query
+	label := query
+	if query == "" {
+		label = "connection info query"
+	}
+	fmt.Fprintf(out, "Benchmarking %s with %d worker(s) for %s\n", label, workers, duration)
+
+	ctx, cancel := context.WithTimeout(rootCtx, duration)
+	defer cancel()
+
+	results := make([]benchWorker, workers)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = benchLoop(ctx, conns[i], cfg)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := &benchReport{Workers: workers, Query: label, DurationSeconds: elapsed.Seconds(), Warmup: warm}
+	for i, w := range results {
+		report.samples = append(report.samples, w.samples...)
+		report.Errors += w.errors
+		if w.firstErr != nil && report.FirstError == "" {
+			report.FirstError = w.firstErr.Error()
+		}
+		report.Reconnects += conns[i].Reconnects()
+	}
+	// Workers interleave, so the CSV rows are put back in time order
+	slices.SortFunc(report.samples, func(a, b latencySample) int { return a.at.Compare(b.at) })
+	latencies := sampleLatencies(report.samples)
+	report.Queries = len(latencies)
+	report.Rate = cfg.rate.report()
+	report.RoundRobin = cfg.rotation.report()
+	report.QPS = float64(report.Queries) / elapsed.Seconds()
+	report.Latency = summarizeLatencies(latencies)
+	report.FirstRow = summarizeLatencies(sampleFirstRows(report.samples))
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		report.P50Ms = durationMs(percentile(latencies, 50))
+		report.P99Ms = durationMs(percentile(latencies, 99))
+	}
+	return report, nil
+}
+
+// runWarmup runs n cycles of connecting, running the benchmark query and
+// closing the connection, each within cfg.timeout. The first failure ends
+// the warmup.
+func runWarmup(rootCtx context.Context, cfg testConfig, n int) (*warmupReport, error) {
+	report := &warmupReport{Cycles: n}
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		cycleStart := time.Now()
+		if err := warmupCycle(rootCtx, cfg); err != nil {
+			return nil, fmt.Errorf("warmup cycle %d of %d: %w", i+1, n, err)
+		}
+		cycleMs := durationMs(time.Since(cycleStart))
+		if i == 0 {
+			report.FirstCycleMs = cycleMs
+		}
+		report.LastCycleMs = cycleMs
+	}
+	report.DurationSeconds = time.Since(start).Seconds()
+	return report, nil
+}
+
+// warmupCycle opens a fresh connection, runs the benchmark query once and
+// closes the connection.
+func warmupCycle(rootCtx context.Context, cfg testConfig) error {
+	ctx, cancel := context.WithTimeout(rootCtx, cfg.timeout)
+	defer cancel()
+
+	config, err := newConnConfig(ctx, cfg, nil)
+	if err != nil {
+		return configFailure(err)
+	}
+	conn, err := dsqltest.ConnectWithRetry(ctx, config, cfg.retry)
+	if err != nil {
+		return connectFailure(phaseError(ctx, "connect", cfg.timeout, err))
+	}
+	defer closeConn(conn)
+
+	if cfg.query == "" {
+		_, err = dsqltest.QueryConnectionInfo(ctx, cfg.infoQuerier(conn))
+	} else {
+		_, err = runQuery(ctx, conn, cfg.query, cfg.maxRows)
+	}
+	if err != nil {
+		return withExitCode(exitQuery, phaseError(ctx, "query", cfg.timeout, err))
+	}
+	return nil
+}
+
+// benchLoop runs cfg's query, or the info query, back to back until ctx is
+// done. Queries cut short by the deadline aren't counted as errors. A
+// non-nil cfg.rate spaces the queries out to --rate.
+func benchLoop(ctx context.Context, rc *reconnectingConn, cfg testConfig) benchWorker {
+	var w benchWorker
+	for ctx.Err() == nil {
+		if cfg.rate.wait(ctx) != nil {
+			break
+		}
+		queryStart := time.Now()
+		var firstRow time.Duration
+		_, err := rc.do(ctx, func(conn *pgx.Conn) error {
+			if cfg.query == "" {
+				_, err := dsqltest.QueryConnectionInfo(ctx, cfg.infoQuerier(conn))
+				return err
+			}
+			res, err := runQuery(ctx, conn, cfg.query, cfg.maxRows)
+			if res != nil {
+				firstRow = res.firstRow
+			}
+			return err
+		})
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			w.errors++
+			if w.firstErr == nil {
+				w.firstErr = err
+			}
+			continue
+		}
+		w.samples = append(w.samples, latencySample{queryStart, time.Since(queryStart), firstRow})
+	}
+	return w
+}
+
+// writeText prints the benchmark summary.
+func (r *benchReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nBenchmark Summary:")
+	fmt.Fprintln(w, "==================")
+	fmt.Fprintf(w, "Queries: %d (%d errors) in %.2fs\n", r.Queries, r.Errors, r.DurationSeconds)
+	if r.Warmup != nil {
+		fmt.Fprintf(w, "Warmup: %d cycle(s) in %.2fs (first %.2fms, last %.2fms), not measured\n",
+			r.Warmup.Cycles, r.Warmup.DurationSeconds, r.Warmup.FirstCycleMs, r.Warmup.LastCycleMs)
+	}
+	fmt.Fprintf(w, "Throughput: %.2f queries/sec across %d worker(s)\n", r.QPS, r.Workers)
+	if r.Latency != nil {
+		r.Latency.writeText(w, "Latency")
+		fmt.Fprintf(w, "Latency p50 %.2fms, p99 %.2fms\n", r.P50Ms, r.P99Ms)
+	}
+	if r.FirstRow != nil {
+		r.FirstRow.writeText(w, "Time to first row")
+	}
+	if r.Reconnects > 0 {
+		fmt.Fprintf(w, "Reconnects: %d\n", r.Reconnects)
+	}
+	if r.Rate != nil {
+		r.Rate.writeText(w)
+	}
+	writeAddressStats(w, r.RoundRobin)
+	if r.FirstError != "" {
+		fmt.Fprintf(w, "First error: %s\n", r.FirstError)
+	}
+}
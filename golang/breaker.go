@@ -0,0 +1,94 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// defaultBreakerInterval is how long --watch waits between probes while the
+// circuit breaker is open.
+const defaultBreakerInterval = 2 * time.Minute
+
+// Circuit breaker states.
+const (
+	breakerClosed   = "closed"    // probing every --interval
+	breakerOpen     = "open"      // backing off to --breaker-interval
+	breakerHalfOpen = "half-open" // one probe decides whether to close
+)
+
+// circuitBreaker backs --watch off a cluster that keeps failing: after
+// threshold consecutive failures it opens and probes only every
+// openInterval. The first probe after that wait is a half-open trial that
+// closes the breaker on success and reopens it on failure. A nil breaker is
+// always closed.
+type circuitBreaker struct {
+	threshold    int
+	openInterval time.Duration
+
+	state    string
+	failures int
+	trips    int
+}
+
+// newCircuitBreaker returns a closed breaker, or nil when threshold is zero.
+func newCircuitBreaker(threshold int, openInterval time.Duration) *circuitBreaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &circuitBreaker{threshold: threshold, openInterval: openInterval, state: breakerClosed}
+}
+
+// beforeProbe moves an open breaker to half-open, since the probe about to
+// run is the recovery trial.
+func (b *circuitBreaker) beforeProbe() {
+	if b != nil && b.state == breakerOpen {
+		b.transition(breakerHalfOpen)
+	}
+}
+
+// record updates the breaker with a probe's outcome.
+func (b *circuitBreaker) record(ok bool) {
+	if b == nil {
+		return
+	}
+	if ok {
+		if b.state != breakerClosed {
+			b.transition(breakerClosed)
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= b.threshold) {
+		b.trips++
+		b.transition(breakerOpen)
+	}
+}
+
+// nextInterval is the delay before the next probe.
+func (b *circuitBreaker) nextInterval(interval time.Duration) time.Duration {
+	if b != nil && b.state == breakerOpen {
+		return b.openInterval
+	}
+	return interval
+}
+
+// currentState returns the state for reports, or "" when disabled.
+func (b *circuitBreaker) currentState() string {
+	if b == nil {
+		return ""
+	}
+	return b.state
+}
+
+// transition logs and applies a state change.
+func (b *circuitBreaker) transition(to string) {
+	attrs := []any{"from", b.state, "to", to, "consecutive_failures", b.failures}
+	if to == breakerOpen {
+		attrs = append(attrs, "next_probe_in", b.openInterval.String())
+		slog.Warn("circuit breaker opened", attrs...)
+	} else {
+		slog.Info("circuit breaker "+to, attrs...)
+	}
+	b.state = to
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgconn/ctxwatch"
+)
+
+const (
+	// cancelAfter is how long the cancel check lets the slow statement run
+	// before cancelling it, so the server is executing it when the request
+	// arrives.
+	cancelAfter = 500 * time.Millisecond
+
+	// cancelGrace is how long the server has to act on the cancel request
+	// before the client gives up and breaks the connection.
+	cancelGrace = 5 * time.Second
+)
+
+// cancelCheck runs a slow statement on a connection that turns context
+// cancellation into a cancel request, cancels the context once the statement
+// is underway and verifies the server stops it with SQLSTATE 57014.
+var cancelCheck = check{name: "cancel-test", run: runCancelTest}
+
+func runCancelTest(ctx context.Context, s *session, r *checkResult) error {
+	// pgx's default handler only sets a deadline on the socket, which kills
+	// the connection without telling the server. This one sends a
+	// CancelRequest with the backend key on a separate connection first.
+	config, err := newConnConfig(ctx, s.cfg, nil)
+	if err != nil {
+		return r.step("connect with cancel requests", err)
+	}
+	config.BuildContextWatcherHandler = func(pgConn *pgconn.PgConn) ctxwatch.Handler {
+		return &pgconn.CancelRequestContextWatcherHandler{Conn: pgConn, DeadlineDelay: cancelGrace}
+	}
+	conn, err := dsqltest.ConnectWithRetry(ctx, config, s.cfg.retry)
+	if err := r.step("connect with cancel requests", err); err != nil {
+		return err
+	}
+	defer closeConn(conn)
+	r.detail("backend_pid", conn.PgConn().PID())
+
+	// As in the timeout check, a statement the server rejects before the
+	// cancel is sent fails with another code and the next one is tried
+	for _, stmt := range slowStatements {
+		queryCtx, cancel := context.WithCancel(ctx)
+		cancelled := make(chan time.Time, 1)
+		timer := time.AfterFunc(cancelAfter, func() {
+			cancelled <- time.Now()
+			cancel()
+		})
+		start := time.Now()
+		err = execStmt(queryCtx, conn, stmt.sql)
+		returned := time.Now()
+		fired := !timer.Stop()
+		cancel()
+		code := sqlState(err)
+		if !fired && err != nil && code != "" && ctx.Err() == nil {
+			r.detail(stmt.name+"_error", code)
+			continue
+		}
+
+		r.detail("slow_statement", stmt.name)
+		if code != "" {
+			r.detail("sqlstate", code)
+		}
+		var cancelErr error
+		switch {
+		case !fired && err == nil:
+			cancelErr = fmt.Errorf("%s finished in %s, before it could be cancelled", stmt.name, returned.Sub(start).Round(time.Millisecond))
+		case !fired:
+			cancelErr = fmt.Errorf("%s failed before it was cancelled: %w", stmt.name, err)
+		case err == nil:
+			cancelErr = fmt.Errorf("%s finished without being cancelled", stmt.name)
+		case code != sqlStateQueryCanceled:
+			cancelErr = fmt.Errorf("server didn't cancel %s within %s of the cancel request: %w", stmt.name, cancelGrace, err)
+		}
+		if fired {
+			elapsed := returned.Sub(<-cancelled)
+			r.detail("cancel_to_termination_ms", durationMs(elapsed))
+		}
+		if err := r.step("server cancelled slow statement", cancelErr); err != nil {
+			return err
+		}
+
+		var one int
+		return r.step("session usable after cancel", conn.QueryRow(ctx, "SELECT 1").Scan(&one))
+	}
+	return r.step("server cancelled slow statement", errors.New("no slow statement is supported by the server"))
+}
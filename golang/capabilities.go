@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// capabilitySettings are the server settings reported by --capabilities.
+var capabilitySettings = []string{
+	"server_version",
+	"max_connections",
+	"default_transaction_isolation",
+	"TimeZone",
+	"statement_timeout",
+	"idle_in_transaction_session_timeout",
+}
+
+// capabilitiesCheck reports key server settings and probes Postgres features
+// DSQL may not support. A feature that errors is reported as unsupported
+// rather than failing the check; only errors that aren't server rejections,
+// such as a dropped connection, fail it.
+var capabilitiesCheck = check{name: "capabilities", run: runCapabilities}
+
+func runCapabilities(ctx context.Context, s *session, r *checkResult) error {
+	for _, name := range capabilitySettings {
+		var value string
+		err := s.conn.QueryRow(ctx, "SELECT current_setting($1)", name).Scan(&value)
+		var pgErr *pgconn.PgError
+		switch {
+		case err == nil:
+			r.detail(name, value)
+		case errors.As(err, &pgErr):
+			r.detail(name, fmt.Sprintf("unavailable (%s: %s)", pgErr.Code, pgErr.Message))
+		default:
+			return r.step("read "+name, err)
+		}
+	}
+
+	var unsupported []string
+	for _, p := range capabilityProbes {
+		err := p.try(ctx, s.conn)
+		var pgErr *pgconn.PgError
+		switch {
+		case err == nil:
+			r.detail(p.name, "supported")
+		case errors.As(err, &pgErr) && !isConnectionClass(pgErr.Code):
+			r.detail(p.name, fmt.Sprintf("unsupported feature (%s: %s)", pgErr.Code, pgErr.Message))
+			unsupported = append(unsupported, p.name)
+		default:
+			return r.step("probe "+p.name, err)
+		}
+	}
+
+	// Spell out what a migrating application would have to change, so a
+	// rejected LISTEN reads as a missing feature and not a broken cluster
+	if len(unsupported) > 0 {
+		r.detail("unsupported_features", unsupported)
+		fmt.Fprintln(r.out, "  Won't work on this cluster:")
+		for _, p := range capabilityProbes {
+			if slices.Contains(unsupported, p.name) {
+				fmt.Fprintf(r.out, "    - %s: %s\n", p.label, p.hint)
+			}
+		}
+	}
+	return nil
+}
+
+// capabilityProbe tries one Postgres feature. A server error from try means
+// the feature is unsupported; hint suggests what to use instead.
+type capabilityProbe struct {
+	name  string
+	label string
+	hint  string
+	try   func(ctx context.Context, conn *pgx.Conn) error
+}
+
+var capabilityProbes = []capabilityProbe{
+	{"listen_notify", "LISTEN/NOTIFY", "poll a table or publish events through an external queue", probeListenNotify},
+	{"sequences", "Sequences", "generate keys with gen_random_uuid() or in the application", probeSequences},
+	{"triggers", "Triggers", "move the trigger logic into the application or a scheduled job", probeTriggers},
+	{"foreign_keys", "Foreign keys", "enforce referential integrity in the application", probeForeignKeys},
+	{"temporary_tables", "Temporary tables", "use a regular table with a per-session key and drop it afterwards", probeTemporaryTables},
+}
+
+// isConnectionClass reports whether sqlstate is a connection exception or
+// operator intervention, which means the session broke rather than that the
+// statement was refused.
+func isConnectionClass(sqlstate string) bool {
+	return strings.HasPrefix(sqlstate, "08") || strings.HasPrefix(sqlstate, "57")
+}
+
+func probeListenNotify(ctx context.Context, conn *pgx.Conn) error {
+	if err := execStmt(ctx, conn, "LISTEN "+testTablePrefix+"capabilities"); err != nil {
+		return err
+	}
+	if err := execStmt(ctx, conn, "NOTIFY "+testTablePrefix+"capabilities"); err != nil {
+		return err
+	}
+	return execStmt(ctx, conn, "UNLISTEN *")
+}
+
+func probeSequences(ctx context.Context, conn *pgx.Conn) error {
+	seq := pgx.Identifier{newTestTableName("seq")}.Sanitize()
+	if err := execStmt(ctx, conn, "CREATE SEQUENCE "+seq); err != nil {
+		return err
+	}
+	defer dropTestObject(conn, "SEQUENCE "+seq)
+	return execStmt(ctx, conn, "SELECT nextval('"+seq+"')")
+}
+
+func probeTriggers(ctx context.Context, conn *pgx.Conn) error {
+	table := pgx.Identifier{newTestTableName("trg")}.Sanitize()
+	fn := pgx.Identifier{newTestTableName("trg_fn")}.Sanitize()
+
+	if err := execStmt(ctx, conn, "CREATE TABLE "+table+" (id int PRIMARY KEY)"); err != nil {
+		return fmt.Errorf("failed to create trigger table: %v", err)
+	}
+	defer dropTestObject(conn, "TABLE "+table)
+
+	// Triggers need a PL/pgSQL function, so a rejected language also means
+	// no triggers
+	if err := execStmt(ctx, conn,
+		"CREATE FUNCTION "+fn+"() RETURNS trigger LANGUAGE plpgsql AS $$BEGIN RETURN NEW; END$$"); err != nil {
+		return err
+	}
+	defer dropTestObject(conn, "FUNCTION "+fn+"() CASCADE")
+
+	return execStmt(ctx, conn,
+		"CREATE TRIGGER "+testTablePrefix+"trg BEFORE INSERT ON "+table+" FOR EACH ROW EXECUTE FUNCTION "+fn+"()")
+}
+
+func probeForeignKeys(ctx context.Context, conn *pgx.Conn) error {
+	parent := pgx.Identifier{newTestTableName("fk_parent")}.Sanitize()
+	child := pgx.Identifier{newTestTableName("fk_child")}.Sanitize()
+
+	// A parent table that can't be created is a real failure, not a missing
+	// feature, so don't let it be reported as unsupported
+	if err := execStmt(ctx, conn, "CREATE TABLE "+parent+" (id int PRIMARY KEY)"); err != nil {
+		return fmt.Errorf("failed to create parent table: %v", err)
+	}
+	defer dropTestObject(conn, "TABLE "+parent)
+
+	if err := execStmt(ctx, conn,
+		"CREATE TABLE "+child+" (id int PRIMARY KEY, parent_id int REFERENCES "+parent+" (id))"); err != nil {
+		return err
+	}
+	dropTestObject(conn, "TABLE "+child)
+	return nil
+}
+
+func probeTemporaryTables(ctx context.Context, conn *pgx.Conn) error {
+	table := pgx.Identifier{newTestTableName("temp")}.Sanitize()
+	if err := execStmt(ctx, conn, "CREATE TEMPORARY TABLE "+table+" (id int)"); err != nil {
+		return err
+	}
+	dropTestObject(conn, "TABLE "+table)
+	return nil
+}
+
+// dropTestObject drops an object a probe created, with a fresh context since
+// the check's may have expired. Failures are ignored: the prefix makes
+// leftovers easy to find.
+func dropTestObject(conn *pgx.Conn, object string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = execStmt(ctx, conn, "DROP "+object)
+}
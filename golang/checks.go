@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// session is an established test connection handed to optional checks.
+type session struct {
+	conn *pgx.Conn
+	cfg  testConfig
+	out  io.Writer
+}
+
+// connect opens an additional connection with the same settings, for checks
+// that need more than one session. The caller must close it.
+func (s *session) connect(ctx context.Context) (*pgx.Conn, error) {
+	config, err := newConnConfig(ctx, s.cfg, nil)
+	if err != nil {
+		return nil, err
+	}
+	return dsqltest.ConnectWithRetry(ctx, config, s.cfg.retry)
+}
+
+// errConnClosed fails a check whose connection an earlier check lost, for
+// instance when a query cancelled by the timeout made pgx close it.
+var errConnClosed = errors.New("connection was closed by an earlier failure, such as an interrupted query")
+
+// check is an optional test run after the connection info query.
+type check struct {
+	name string
+	run  func(ctx context.Context, s *session, r *checkResult) error
+}
+
+// checkResult records the outcome of a check and each of its steps.
+type checkResult struct {
+	Name       string         `json:"name"`
+	Success    bool           `json:"success"`
+	DurationMs float64        `json:"duration_ms"`
+	Steps      []stepResult   `json:"steps,omitempty"`
+	Details    map[string]any `json:"details,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	SQLState   string         `json:"sqlstate,omitempty"`
+
+	ErrorCategory errorCategory `json:"error_category,omitempty"`
+
+	out  io.Writer
+	err  error
+	mark time.Time // when the current step started
+}
+
+// stepResult is the outcome of one operation within a check.
+type stepResult struct {
+	Name       string  `json:"name"`
+	Success    bool    `json:"success"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// step records and prints the outcome of a step, returning err unchanged so
+// callers can write `if err := r.step(...); err != nil`.
+func (r *checkResult) step(name string, err error) error {
+	sr := stepResult{Name: name, Success: err == nil, DurationMs: durationMs(time.Since(r.mark))}
+	r.mark = time.Now()
+	if err != nil {
+		sr.Error = err.Error()
+		fmt.Fprintf(r.out, "  %s %s: %v\n", okOrFail(false, "[FAIL]"), name, err)
+	} else {
+		fmt.Fprintf(r.out, "  %s %s\n", okOrFail(true, "[PASS]"), name)
+	}
+	r.Steps = append(r.Steps, sr)
+	return err
+}
+
+// detail records and prints a measurement that isn't pass/fail.
+func (r *checkResult) detail(name string, value any) {
+	if r.Details == nil {
+		r.Details = make(map[string]any)
+	}
+	r.Details[name] = value
+	if ms, ok := value.(float64); ok {
+		fmt.Fprintf(r.out, "  %s: %.2fms\n", name, ms)
+		return
+	}
+	fmt.Fprintf(r.out, "  %s: %v\n", name, value)
+}
+
+// runCheck runs c and records its duration and outcome.
+func runCheck(ctx context.Context, s *session, c check) checkResult {
+	fmt.Fprintf(s.out, "\nRunning %s check:\n", c.name)
+	start := time.Now()
+	r := checkResult{Name: c.name, out: s.out, mark: start}
+	var err error
+	if s.conn.IsClosed() {
+		err = errConnClosed
+		r.step("connection open", err)
+	} else {
+		err = c.run(ctx, s, &r)
+	}
+	r.DurationMs = durationMs(time.Since(start))
+	r.Success = err == nil
+	if err != nil {
+		r.Error = err.Error()
+		// A check that fails for no more specific reason failed a query
+		r.ErrorCategory = classifyError(err)
+		if r.ErrorCategory == "" {
+			r.ErrorCategory = categoryQuery
+		}
+		r.err = err
+		if d := newPGErrorDetail(err); d != nil {
+			r.SQLState = d.Code
+		}
+	}
+	return r
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// testTableNameRe matches the names newTestTableName generates, capturing
+// the creation time, so --cleanup never touches a table that merely starts
+// with the prefix.
+var testTableNameRe = regexp.MustCompile(`^` + testTablePrefix + `[a-z_]+_(\d+)_[0-9a-f]{8}$`)
+
+// leftoverTablesSQL lists the tables carrying testTablePrefix. The prefix's
+// underscores are escaped so LIKE matches them literally.
+const leftoverTablesSQL = `
+	SELECT schemaname, tablename
+	FROM pg_catalog.pg_tables
+	WHERE tablename LIKE $1
+	ORDER BY schemaname, tablename
+`
+
+// cleanupReport is the outcome of --cleanup: the leftover test tables found
+// and what happened to each.
+type cleanupReport struct {
+	Found   int      `json:"found"`
+	Dropped []string `json:"dropped"`
+	Skipped []string `json:"skipped_recent,omitempty"`
+	Failed  int      `json:"failed"`
+
+	MinAge     string   `json:"min_age"`
+	Errors     []string `json:"errors,omitempty"`
+	DurationMs float64  `json:"duration_ms"`
+}
+
+// runCleanup drops the tables earlier runs left behind, such as after a
+// crash or a kill -9 between create and drop. Only names newTestTableName
+// could have produced are considered, and those created less than minAge
+// ago are kept, since a concurrent run may still be using them. Each drop
+// is its own DDL transaction, as DSQL requires, and uses IF EXISTS, so
+// running it twice, or alongside another cleanup, is harmless.
+func runCleanup(ctx context.Context, cfg testConfig, minAge time.Duration, out io.Writer) (*cleanupReport, error) {
+	fmt.Fprintf(out, "Looking for leftover %s* tables on %s via %s\n", testTablePrefix, cfg.conn.Hostname, cfg.conn.Address())
+	s := &session{cfg: cfg}
+	conn, err := s.connect(ctx)
+	if err != nil {
+		return nil, connectFailure(err)
+	}
+	defer closeConn(conn)
+
+	start := time.Now()
+	rows, err := conn.Query(ctx, leftoverTablesSQL, strings.ReplaceAll(testTablePrefix, "_", `\_`)+"%")
+	if err != nil {
+		return nil, withExitCode(exitQuery, fmt.Errorf("failed to list leftover tables: %w", err))
+	}
+	tables, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (pgx.Identifier, error) {
+		var schema, name string
+		err := row.Scan(&schema, &name)
+		return pgx.Identifier{schema, name}, err
+	})
+	if err != nil {
+		return nil, withExitCode(exitQuery, fmt.Errorf("failed to list leftover tables: %w", err))
+	}
+
+	report := &cleanupReport{Dropped: []string{}, MinAge: minAge.String()}
+	var firstErr error
+	for _, table := range tables {
+		m := testTableNameRe.FindStringSubmatch(table[1])
+		if m == nil {
+			continue
+		}
+		report.Found++
+		name := table.Sanitize()
+		if created, err := strconv.ParseInt(m[1], 10, 64); err == nil && time.Since(time.Unix(created, 0)) < minAge {
+			report.Skipped = append(report.Skipped, name)
+			continue
+		}
+		if err := execStmt(ctx, conn, "DROP TABLE IF EXISTS "+name); err != nil {
+			report.Failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			if len(report.Errors) < maxReportedErrors {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", name, err))
+			}
+			continue
+		}
+		report.Dropped = append(report.Dropped, name)
+	}
+	report.DurationMs = durationMs(time.Since(start))
+	if firstErr != nil {
+		return report, withExitCode(exitQuery, fmt.Errorf("failed to drop %d of %d leftover tables: %w", report.Failed, report.Found, firstErr))
+	}
+	return report, nil
+}
+
+// writeText prints the counts, then each table dropped or kept.
+func (r *cleanupReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nCleanup Report:")
+	fmt.Fprintln(w, "===============")
+	fmt.Fprintf(w, "Found %d leftover test tables: %d dropped, %d newer than %s kept, %d failed (%.2fms)\n",
+		r.Found, len(r.Dropped), len(r.Skipped), r.MinAge, r.Failed, r.DurationMs)
+	for _, name := range r.Dropped {
+		fmt.Fprintf(w, "  dropped: %s\n", name)
+	}
+	for _, name := range r.Skipped {
+		fmt.Fprintf(w, "  kept: %s\n", name)
+	}
+	for _, msg := range r.Errors {
+		fmt.Fprintf(w, "  error: %s\n", msg)
+	}
+}
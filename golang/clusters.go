@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// clusterEntry is one cluster listed in a --config file. Empty fields fall
+// back to the values resolved from flags and environment variables.
+type clusterEntry struct {
+	Name     string `yaml:"name" json:"name" toml:"name"`
+	Hostname string `yaml:"hostname" json:"hostname" toml:"hostname"`
+	HostAddr string `yaml:"hostaddr" json:"hostaddr" toml:"hostaddr"`
+	Port     int    `yaml:"port" json:"port" toml:"port"`
+	Region   string `yaml:"region" json:"region" toml:"region"`
+	User     string `yaml:"user" json:"user" toml:"user"`
+	Database string `yaml:"database" json:"database" toml:"database"`
+	SSLMode  string `yaml:"sslmode" json:"sslmode" toml:"sslmode"`
+
+	SNIHostname string `yaml:"sni_hostname" json:"sni_hostname" toml:"sni_hostname"`
+
+	RoleARN    string `yaml:"role_arn" json:"role_arn" toml:"role_arn"`
+	ExternalID string `yaml:"external_id" json:"external_id" toml:"external_id"`
+}
+
+// clusterFile is the top-level layout of a --config file.
+type clusterFile struct {
+	Clusters []clusterEntry `yaml:"clusters" json:"clusters" toml:"clusters"`
+}
+
+// loadClusterFile reads a cluster list in the format its extension names:
+// .yaml or .yml, .json or .toml. Unknown keys are rejected so typos don't
+// silently fall back to defaults.
+func loadClusterFile(path string) ([]clusterEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file clusterFile
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&file)
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(&file)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), &file)
+		if undecoded := md.Undecoded(); err == nil && len(undecoded) > 0 {
+			err = fmt.Errorf("unknown key %s", undecoded[0])
+		}
+	default:
+		return nil, fmt.Errorf("config file %s has unsupported extension %q: use .yaml, .yml, .json or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if len(file.Clusters) == 0 {
+		return nil, fmt.Errorf("config file %s lists no clusters", path)
+	}
+
+	seen := make(map[string]bool)
+	for i := range file.Clusters {
+		c := &file.Clusters[i]
+		if c.Name == "" {
+			c.Name = c.Hostname
+		}
+		if c.Name == "" {
+			return nil, fmt.Errorf("cluster %d in %s has neither a name nor a hostname", i+1, path)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("duplicate cluster name %q in %s", c.Name, path)
+		}
+		seen[c.Name] = true
+	}
+	return file.Clusters, nil
+}
+
+// clusterDefaults is what each cluster inherits from the command line.
+type clusterDefaults struct {
+	region    string
+	profile   string
+	useIAM    bool
+	tokenSkew time.Duration
+
+	tokenTimeout time.Duration
+	tokenRetries int
+
+	roleARN    string
+	externalID string
+}
+
+// testConfig returns base with the entry's non-empty fields applied and an
+// IAM token provider for the entry's own hostname and region. The returned
+// config is filled in even when loading AWS credentials fails.
+func (c clusterEntry) testConfig(ctx context.Context, base testConfig, d clusterDefaults) (testConfig, error) {
+	cfg := base
+	// Each cluster's retries are its own
+	cfg.retries = newRetryBudget()
+	cfg.retry.OnRetry = cfg.retries.connectHook
+	conn := &cfg.conn
+	conn.Hostname = firstNonEmpty(c.Hostname, conn.Hostname)
+	conn.HostAddr = firstNonEmpty(c.HostAddr, conn.HostAddr)
+	conn.User = firstNonEmpty(c.User, conn.User)
+	conn.Database = firstNonEmpty(c.Database, conn.Database)
+	conn.SSLMode = firstNonEmpty(c.SSLMode, conn.SSLMode)
+	conn.SNIHostname = c.SNIHostname
+	if c.Port != 0 {
+		conn.Port = c.Port
+	}
+	conn.Tokens = nil
+	if d.useIAM {
+		awsCfg, err := loadAWSConfig(ctx, firstNonEmpty(c.Region, d.region), d.profile,
+			firstNonEmpty(c.RoleARN, d.roleARN), firstNonEmpty(c.ExternalID, d.externalID), d.tokenTimeout, cfg.retries)
+		if err != nil {
+			return cfg, withExitCode(exitAuth, err)
+		}
+		conn.Tokens = dsqltest.NewTokenProvider(conn.Hostname, awsCfg, conn.User == dsqltest.DefaultUser, d.tokenSkew, d.tokenTimeout)
+		conn.Tokens.SetRetry(cfg.retries.tokenRetry(d.tokenRetries))
+	}
+	return cfg, nil
+}
+
+// clusterReport aggregates per-cluster results for --config runs.
+type clusterReport struct {
+	Success   bool                         `json:"success"`
+	Succeeded int                          `json:"succeeded"`
+	Failed    int                          `json:"failed"`
+	Fastest   string                       `json:"fastest,omitempty"`
+	Clusters  map[string]*ConnectionResult `json:"clusters"`
+
+	order   []string
+	regions map[string]string
+}
+
+// writeText prints one line per cluster, fastest connect first and failed
+// clusters last, followed by the fastest cluster.
+func (r *clusterReport) writeText(w io.Writer) {
+	names := slices.Clone(r.order)
+	slices.SortStableFunc(names, func(a, b string) int {
+		ra, rb := r.Clusters[a], r.Clusters[b]
+		if ra.Success != rb.Success {
+			if ra.Success {
+				return -1
+			}
+			return 1
+		}
+		if !ra.Success {
+			return 0
+		}
+		return cmp.Compare(ra.ConnectLatencyMs, rb.ConnectLatencyMs)
+	})
+
+	fmt.Fprintln(w, "\nCluster Report:")
+	fmt.Fprintln(w, "===============")
+	for _, name := range names {
+		res := r.Clusters[name]
+		region := firstNonEmpty(r.regions[name], "-")
+		if res.Success {
+			fmt.Fprintf(w, "%-20s %s   %-14s %s connect=%.2fms latency=%.2fms\n", name, okOrFail(true, "OK"), region, res.Host, res.ConnectLatencyMs, res.LatencyMs)
+		} else {
+			fmt.Fprintf(w, "%-20s %s %-14s %s %s\n", name, okOrFail(false, "FAIL"), region, res.Host, res.Error)
+		}
+	}
+	fmt.Fprintf(w, "\n%d succeeded, %d failed\n", r.Succeeded, r.Failed)
+	if r.Fastest != "" {
+		fmt.Fprintf(w, "Fastest connect: %s (%s) %.2fms\n", r.Fastest,
+			firstNonEmpty(r.regions[r.Fastest], "-"), r.Clusters[r.Fastest].ConnectLatencyMs)
+	}
+}
+
+// clusterRun is the outcome of testing one cluster.
+type clusterRun struct {
+	result  *ConnectionResult
+	output  bytes.Buffer
+	skipped bool
+}
+
+// runClusters tests the clusters with up to parallel of them in flight at
+// once, each with its own timeout so an unreachable cluster doesn't hold up
+// the others. A failure is recorded and the remaining clusters are still
+// tested; the exit code is that of the first failed cluster in file order.
+// Concurrent clusters' progress is buffered and printed as each finishes.
+// Cancelling rootCtx skips the clusters not yet started and reports the
+// ones already tested. With jsonOutput the report is written to stdout.
+func runClusters(rootCtx context.Context, base testConfig, clusters []clusterEntry, d clusterDefaults, parallel int, out, stdout io.Writer, jsonOutput bool) int {
+	runs := make([]*clusterRun, len(clusters))
+	var outMu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(parallel, 1))
+
+	for i, c := range clusters {
+		runs[i] = &clusterRun{}
+		select {
+		case sem <- struct{}{}:
+		case <-rootCtx.Done():
+		}
+		if rootCtx.Err() != nil {
+			runs[i].skipped = true
+			continue
+		}
+		wg.Add(1)
+		go func(c clusterEntry, run *clusterRun) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			w := out
+			if parallel > 1 {
+				w = &run.output
+			}
+			run.result = testCluster(rootCtx, base, c, d, w)
+			if parallel > 1 {
+				outMu.Lock()
+				out.Write(run.output.Bytes())
+				outMu.Unlock()
+			}
+		}(c, runs[i])
+	}
+	wg.Wait()
+
+	report := &clusterReport{
+		Clusters: make(map[string]*ConnectionResult, len(clusters)),
+		regions:  make(map[string]string, len(clusters)),
+	}
+	exitCode := exitOK
+	for i, c := range clusters {
+		run := runs[i]
+		if run.skipped {
+			exitCode = exitInterrupted
+			continue
+		}
+		res := run.result
+		report.Clusters[c.Name] = res
+		report.order = append(report.order, c.Name)
+		report.regions[c.Name] = firstNonEmpty(c.Region, d.region)
+		if !res.Success {
+			report.Failed++
+			if exitCode == exitOK || res.ExitCode == exitInterrupted {
+				exitCode = res.ExitCode
+			}
+			continue
+		}
+		report.Succeeded++
+		if report.Fastest == "" || res.ConnectLatencyMs < report.Clusters[report.Fastest].ConnectLatencyMs {
+			report.Fastest = c.Name
+		}
+	}
+	report.Success = report.Failed == 0
+
+	return writeReport(stdout, out, report, jsonOutput, exitCode)
+}
+
+// testCluster runs the connectivity test against one cluster with its own
+// timeout and returns the result, with the error and exit code filled in on
+// failure.
+func testCluster(rootCtx context.Context, base testConfig, c clusterEntry, d clusterDefaults, out io.Writer) *ConnectionResult {
+	ctx, cancel := context.WithTimeout(rootCtx, base.timeout)
+	defer cancel()
+
+	cfg, err := c.testConfig(ctx, base, d)
+	result := &ConnectionResult{Host: cfg.conn.HostAddr, Port: cfg.conn.Port, SSLMode: cfg.conn.SSLMode}
+
+	fmt.Fprintf(out, "\n[%s]\n", c.Name)
+	if err == nil {
+		err = withExitCode(exitConfig, cfg.conn.Validate())
+	}
+	if err == nil {
+		err = runConnectivityTest(ctx, cfg, out, result)
+	}
+	if err != nil {
+		err = interruptedError(rootCtx, err)
+		result.setError(err, exitCodeOf(err))
+		slog.Error("cluster check failed", "cluster", c.Name, "error", err, "exit_code", result.ExitCode)
+	}
+	result.Retries = cfg.retries.summary()
+	return result
+}
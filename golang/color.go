@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ANSI foreground colors. Every code is two digits so colored strings of
+// the same text are the same length, which keeps tabwriter columns aligned.
+const (
+	colorRed     = "31"
+	colorGreen   = "32"
+	colorYellow  = "33"
+	colorDefault = "39"
+)
+
+// colorEnabled is set once from --color before any output is written.
+var colorEnabled bool
+
+// setColorMode decides whether human output is colored. auto colors only
+// when dest is a terminal, NO_COLOR is unset and TERM isn't "dumb";
+// always and never override that. JSON and CSV output are never colored.
+func setColorMode(mode string, structured bool, dest *os.File) error {
+	switch mode {
+	case "always":
+		colorEnabled = true
+	case "never":
+		colorEnabled = false
+	case "auto":
+		colorEnabled = os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && isTerminal(dest)
+	default:
+		return fmt.Errorf("unsupported --color %q: use auto, always or never", mode)
+	}
+	if structured {
+		colorEnabled = false
+	}
+	return nil
+}
+
+// isTerminal reports whether f is a character device such as a TTY.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// colorize wraps s in the given color when color output is on.
+func colorize(color, s string) string {
+	if !colorEnabled {
+		return s
+	}
+	return "\x1b[" + color + "m" + s + "\x1b[0m"
+}
+
+// okOrFail colors a pass/fail marker green or red.
+func okOrFail(ok bool, s string) string {
+	if ok {
+		return colorize(colorGreen, s)
+	}
+	return colorize(colorRed, s)
+}
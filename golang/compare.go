@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// compareEndpoints holds the --compare addrA=<addr> and addrB=<addr> pairs,
+// each an endpoint address as parseEndpoint reads it.
+type compareEndpoints struct {
+	a, b string
+}
+
+// String lists the endpoints that are set.
+func (c *compareEndpoints) String() string {
+	var pairs []string
+	if c.a != "" {
+		pairs = append(pairs, "addrA="+c.a)
+	}
+	if c.b != "" {
+		pairs = append(pairs, "addrB="+c.b)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set parses one or more addrA=<addr> and addrB=<addr> pairs separated by
+// spaces or commas.
+func (c *compareEndpoints) Set(s string) error {
+	for _, pair := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		key, addr, ok := strings.Cut(pair, "=")
+		if !ok || addr == "" {
+			return fmt.Errorf("expected addrA=<addr> or addrB=<addr>, got %q", pair)
+		}
+		switch key {
+		case "addrA":
+			c.a = addr
+		case "addrB":
+			c.b = addr
+		default:
+			return fmt.Errorf("unknown endpoint %q: use addrA or addrB", key)
+		}
+	}
+	return nil
+}
+
+// set reports whether either endpoint was given.
+func (c *compareEndpoints) set() bool {
+	return c.a != "" || c.b != ""
+}
+
+// compareReport is the outcome of --compare: both benchmark reports and how
+// far addrB's latency and throughput differ from addrA's, in percent. A
+// difference is left out when addrA has nothing to compare against.
+type compareReport struct {
+	A *benchReport `json:"addrA"`
+	B *benchReport `json:"addrB"`
+
+	P50DiffPct *float64 `json:"p50_diff_pct,omitempty"`
+	P95DiffPct *float64 `json:"p95_diff_pct,omitempty"`
+	QPSDiffPct *float64 `json:"qps_diff_pct,omitempty"`
+}
+
+// runCompare benchmarks addrA and then addrB with the same workers, warmup
+// and duration, one after the other so the runs don't compete for the
+// client's network or CPU. With jsonOutput the report is written to stdout.
+func runCompare(rootCtx context.Context, base testConfig, endpoints compareEndpoints, d clusterDefaults, workers, warmup int, duration time.Duration, out, stdout io.Writer, jsonOutput bool) int {
+	report := &compareReport{}
+	for _, side := range []struct {
+		name, addr string
+		dst        **benchReport
+	}{{"addrA", endpoints.a, &report.A}, {"addrB", endpoints.b, &report.B}} {
+		cfg, err := endpointConfig(rootCtx, base, side.name, side.addr, d)
+		if err != nil {
+			err = interruptedError(rootCtx, err)
+			slog.Error("invalid compare endpoint", "endpoint", side.name, "error", err, "exit_code", exitCodeOf(err))
+			return exitCodeOf(err)
+		}
+		fmt.Fprintf(out, "\nEndpoint %s: %s via %s\n", side.name, cfg.conn.Hostname, cfg.conn.Address())
+		r, err := benchmark(rootCtx, cfg, workers, warmup, duration, out)
+		if err != nil {
+			return exitCodeOf(err)
+		}
+		r.Endpoint = side.addr
+		*side.dst = r
+	}
+
+	a, b := report.A, report.B
+	if a.Latency != nil && b.Latency != nil {
+		report.P50DiffPct = pctDiff(a.P50Ms, b.P50Ms)
+		report.P95DiffPct = pctDiff(a.Latency.P95Ms, b.Latency.P95Ms)
+	}
+	report.QPSDiffPct = pctDiff(a.QPS, b.QPS)
+
+	code := exitOK
+	if a.Errors > 0 || b.Errors > 0 {
+		code = exitQuery
+	}
+	return writeReport(stdout, out, report, jsonOutput, code)
+}
+
+// pctDiff returns how much larger b is than a, in percent, or nil when a is
+// zero.
+func pctDiff(a, b float64) *float64 {
+	if a == 0 {
+		return nil
+	}
+	diff := (b - a) / a * 100
+	return &diff
+}
+
+// writeText prints the two runs side by side.
+func (r *compareReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nBenchmark Comparison:")
+	fmt.Fprintln(w, "=====================")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\taddrA\taddrB\tDIFF")
+	fmt.Fprintf(tw, "Endpoint\t%s\t%s\t\n", r.A.Endpoint, r.B.Endpoint)
+	fmt.Fprintf(tw, "Queries\t%d (%d errors)\t%d (%d errors)\t\n", r.A.Queries, r.A.Errors, r.B.Queries, r.B.Errors)
+	fmt.Fprintf(tw, "Throughput\t%.2f/s\t%.2f/s\t%s\n", r.A.QPS, r.B.QPS, formatPctDiff(r.QPSDiffPct))
+	var p95A, p95B float64
+	if r.A.Latency != nil {
+		p95A = r.A.Latency.P95Ms
+	}
+	if r.B.Latency != nil {
+		p95B = r.B.Latency.P95Ms
+	}
+	fmt.Fprintf(tw, "p50\t%.2fms\t%.2fms\t%s\n", r.A.P50Ms, r.B.P50Ms, formatPctDiff(r.P50DiffPct))
+	fmt.Fprintf(tw, "p95\t%.2fms\t%.2fms\t%s\n", p95A, p95B, formatPctDiff(r.P95DiffPct))
+	tw.Flush()
+	for _, side := range []*benchReport{r.A, r.B} {
+		if side.FirstError != "" {
+			fmt.Fprintf(w, "First error on %s: %s\n", side.Endpoint, side.FirstError)
+		}
+	}
+}
+
+// formatPctDiff shows a difference with its sign, or - when there is none.
+func formatPctDiff(pct *float64) string {
+	if pct == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", *pct)
+}
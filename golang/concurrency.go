@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Outcome categories for --concurrency sessions.
+const (
+	outcomeOK        = "ok"
+	outcomeConnLimit = "conn_limit_exceeded"
+	outcomeThrottled = "throttled"
+	outcomeAuth      = "auth"
+	outcomeTimeout   = "timeout"
+	outcomeConnect   = "connect"
+	outcomeQuery     = "query"
+)
+
+// concurrencyReport summarizes a --concurrency run. Sessions refused
+// because the cluster's connection limit was reached are counted apart from
+// rate throttling and other failures.
+type concurrencyReport struct {
+	Requested         int `json:"requested"`
+	Succeeded         int `json:"succeeded"`
+	ConnLimitExceeded int `json:"conn_limit_exceeded"`
+	Throttled         int `json:"throttled"`
+	Failed            int `json:"failed"`
+
+	Outcomes       map[string]int  `json:"outcomes"`
+	ConnectLatency *latencySummary `json:"connect_latency,omitempty"`
+	Errors         []string        `json:"errors,omitempty"`
+	DurationMs     float64         `json:"duration_ms"`
+	Rate           *rateReport     `json:"rate,omitempty"`
+	RoundRobin     []addressStats  `json:"round_robin,omitempty"`
+}
+
+// maxReportedErrors caps the distinct error messages kept in the report.
+const maxReportedErrors = 10
+
+// sessionOutcome is the result of one concurrent session.
+type sessionOutcome struct {
+	category string
+	connect  time.Duration
+	err      error
+}
+
+// runConcurrency opens n sessions at once, runs the info query on each and
+// keeps every connection open until all have finished, so the cluster sees n
+// simultaneous sessions. Connects aren't retried, since retries would hide
+// the throttling this mode is meant to observe.
+func runConcurrency(ctx context.Context, cfg testConfig, n int, out io.Writer) (*concurrencyReport, error) {
+	fmt.Fprintf(out, "Opening %d concurrent connections to %s via %s\n", n, cfg.conn.Hostname, cfg.conn.Address())
+
+	outcomes := make([]sessionOutcome, n)
+	conns := make([]*pgx.Conn, n)
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], outcomes[i] = openSession(ctx, cfg)
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	for _, conn := range conns {
+		if conn != nil {
+			closeConn(conn)
+		}
+	}
+
+	report := &concurrencyReport{Requested: n, Outcomes: make(map[string]int), DurationMs: durationMs(elapsed)}
+	var latencies []time.Duration
+	seenErrs := make(map[string]bool)
+	var firstErr error
+	for _, o := range outcomes {
+		report.Outcomes[o.category]++
+		switch o.category {
+		case outcomeOK:
+			report.Succeeded++
+		case outcomeConnLimit:
+			report.ConnLimitExceeded++
+		case outcomeThrottled:
+			report.Throttled++
+		default:
+			report.Failed++
+		}
+		if o.connect > 0 {
+			latencies = append(latencies, o.connect)
+		}
+		if o.err != nil {
+			if firstErr == nil {
+				firstErr = o.err
+			}
+			msg := o.err.Error()
+			if !seenErrs[msg] && len(report.Errors) < maxReportedErrors {
+				seenErrs[msg] = true
+				report.Errors = append(report.Errors, msg)
+			}
+		}
+	}
+	report.ConnectLatency = summarizeLatencies(latencies)
+	report.Rate = cfg.rate.report()
+	report.RoundRobin = cfg.rotation.report()
+
+	if firstErr != nil {
+		return report, fmt.Errorf("%d of %d sessions failed: %w", n-report.Succeeded, n, firstErr)
+	}
+	return report, nil
+}
+
+// openSession connects and runs the info query once, returning the open
+// connection (nil on failure) for the caller to close.
+func openSession(ctx context.Context, cfg testConfig) (*pgx.Conn, sessionOutcome) {
+	config, err := newConnConfig(ctx, cfg, nil)
+	if err != nil {
+		return nil, sessionOutcome{category: classifySessionError(ctx, err), err: configFailure(err)}
+	}
+
+	connectStart := time.Now()
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, sessionOutcome{category: classifySessionError(ctx, err), err: connectFailure(err)}
+	}
+	connect := time.Since(connectStart)
+
+	if _, err := dsqltest.QueryConnectionInfo(ctx, cfg.infoQuerier(conn)); err != nil {
+		return conn, sessionOutcome{category: outcomeQuery, connect: connect, err: withExitCode(exitQuery, err)}
+	}
+	return conn, sessionOutcome{category: outcomeOK, connect: connect}
+}
+
+// classifySessionError sorts a connect failure into an outcome category.
+func classifySessionError(ctx context.Context, err error) string {
+	switch {
+	case dsqltest.IsConnLimitExceeded(err):
+		return outcomeConnLimit
+	case dsqltest.IsThrottled(err):
+		return outcomeThrottled
+	case dsqltest.IsAuthError(err):
+		return outcomeAuth
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return outcomeTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return outcomeTimeout
+	}
+	return outcomeConnect
+}
+
+// writeText prints the outcome counts and connect latency distribution.
+func (r *concurrencyReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nConcurrency Report:")
+	fmt.Fprintln(w, "===================")
+	fmt.Fprintf(w, "Sessions: %d requested, %d OK, %d connection limit exceeded, %d throttled, %d failed (%.2fms)\n",
+		r.Requested, r.Succeeded, r.ConnLimitExceeded, r.Throttled, r.Failed, r.DurationMs)
+	for _, category := range []string{outcomeConnLimit, outcomeThrottled, outcomeAuth, outcomeTimeout, outcomeConnect, outcomeQuery} {
+		if count := r.Outcomes[category]; count > 0 {
+			fmt.Fprintf(w, "  %s: %d\n", category, count)
+		}
+	}
+	if r.ConnectLatency != nil {
+		r.ConnectLatency.writeText(w, "Connect latency")
+	}
+	if r.Rate != nil {
+		r.Rate.writeText(w)
+	}
+	writeAddressStats(w, r.RoundRobin)
+	for _, msg := range r.Errors {
+		fmt.Fprintf(w, "  error: %s\n", msg)
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"slices"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// testConfig is everything a single connectivity test run needs.
+type testConfig struct {
+	conn      dsqltest.Config
+	usePool   bool
+	reuseConn bool // --watch keeps one reconnecting connection
+	preflight bool // check DNS and TCP reachability before connecting
+	trace     bool // log every pgx query and its timing
+	pool      dsqltest.PoolOptions
+	retry     dsqltest.RetryPolicy
+	retries   *retryBudget // retries made across the run, by phase
+	samples   int
+	batchSize int       // queries per --batch check
+	compareN  int       // runs per mode in the --compare-prepared check
+	rate      *rateGate // throttles connects, and --bench queries, to --rate
+	timeout   time.Duration
+	query     string // replaces the info query when set
+	maxRows   int    // rows of a --query result kept, or 0 for all
+	checks    []check
+	failFast  bool   // stop at the first failing check
+	runID     string // correlation ID, reported with every --watch probe
+	prewarm   bool   // open the pool's MinConns connections up front
+
+	queryTimeout  time.Duration  // bounds the query phase, within timeout
+	verifyQuery   string         // boolean assertion run by the verify-query check
+	expectVersion *regexp.Regexp // version() pattern for the expect-version check
+
+	maxConnLifetime time.Duration // expected cap on a held connection's life
+	lifetimeWarn    float64       // fraction of maxConnLifetime that triggers a warning
+
+	simpleProtocol bool // run the info query over the simple protocol
+
+	insertRows  int // rows the --insert-bench check inserts
+	insertBatch int // rows per --insert-bench batch, and so per transaction
+
+	rotation *roundRobin // spreads connects across --hostaddr with --round-robin
+
+	baselinePath string            // --baseline file the settings-baseline check reads or writes
+	baseline     *settingsBaseline // loaded from baselinePath, or nil to save it
+
+	scripts         []sqlScript // --query-file scripts the query-files check runs
+	continueOnError bool        // run every script even after one fails
+
+	latency latencyLimits // connect and query latency bounds the run must meet
+
+	statsd *statsdClient // sends each probe's outcome to --statsd-addr
+
+	dumpConfig io.Writer // --dump-pgx-config prints the pgx config here before connecting
+}
+
+// runConnectivityTest connects through the tunnel, runs the info query and
+// fills in result. Progress lines are written to out. The run, connect and
+// query phases are traced as spans.
+func runConnectivityTest(ctx context.Context, cfg testConfig, out io.Writer, result *ConnectionResult) (err error) {
+	ctx, span := startSpan(ctx, "dsql.connectivity_test", cfg)
+	defer func() { endSpan(span, err) }()
+
+	report := newTestReport(cfg)
+	result.Report = report
+	defer func() { report.finish(err) }()
+
+	opts := cfg.conn
+	result.ExecMode = dsqltest.QueryExecModeName(opts.QueryExecMode)
+	result.QueryProtocol = queryProtocolName(opts.QueryExecMode)
+	if cfg.simpleProtocol {
+		result.QueryProtocol = queryProtocolName(pgx.QueryExecModeSimpleProtocol)
+	}
+
+	fmt.Fprintf(out, "Connecting to DSQL cluster: %s\n", opts.Hostname)
+	fmt.Fprintf(out, "Through tunnel address: %s\n", opts.Address())
+	if opts.SOCKS5Proxy != "" {
+		result.Proxy = "socks5://" + opts.SOCKS5Proxy
+		fmt.Fprintf(out, "Via SOCKS5 proxy: %s\n", opts.SOCKS5Proxy)
+	}
+
+	if cfg.preflight {
+		result.Preflight, err = runPreflight(ctx, opts.HostAddrs(), opts.Port, out)
+		if err != nil {
+			return withExitCode(exitConnect, err)
+		}
+		report.pass("preflight")
+	}
+
+	tlsObs := &tlsObserver{}
+	start := time.Now()
+
+	var conn *pgx.Conn
+	connectCtx, connectSpan := startSpan(ctx, "dsql.connect", cfg)
+	if cfg.usePool {
+		// Build a pool and run the info query on an acquired connection
+		pool, err := connectPool(connectCtx, cfg, tlsObs)
+		if err != nil {
+			err = withExitCode(exitConfig, fmt.Errorf("failed to create connection pool: %w", err))
+			endSpan(connectSpan, err)
+			return err
+		}
+		defer pool.Close()
+		// Snapshot the pool once the test's connection has been released
+		defer func() { result.PoolStats = newPoolStats(pool) }()
+
+		poolCfg := pool.Config()
+		if cfg.dumpConfig != nil {
+			writePgxConfig(cfg.dumpConfig, &poolCfg.ConnConfig.Config)
+		}
+		fmt.Fprintf(out, "Connection pool created (max: %d, min: %d, max lifetime: %s)\n",
+			poolCfg.MaxConns, poolCfg.MinConns, poolCfg.MaxConnLifetime)
+		if cfg.prewarm {
+			warm, err := dsqltest.PrewarmPool(connectCtx, pool)
+			result.PoolPrewarm = newPrewarmReport(warm)
+			if err != nil {
+				err = connectFailure(connectPhaseError(ctx, cfg, fmt.Errorf("failed to pre-warm connection pool: %w", err)))
+				endSpan(connectSpan, err)
+				return err
+			}
+			fmt.Fprintf(out, "Connection pool pre-warmed: %s\n", result.PoolPrewarm)
+		}
+
+		connectStart := time.Now()
+		pooled, err := pool.Acquire(connectCtx)
+		if err != nil {
+			err = connectFailure(connectPhaseError(ctx, cfg,
+				tlsVersionFailure(opts, fmt.Errorf("failed to acquire connection from pool: %w", err))))
+			endSpan(connectSpan, err)
+			return err
+		}
+		defer pooled.Release()
+		result.ConnectLatencyMs = durationMs(time.Since(connectStart))
+		slog.DebugContext(ctx, "connection phase complete",
+			"phase", "dial", "hostaddr", opts.HostAddr, "duration_ms", result.ConnectLatencyMs, "pool", true)
+		fmt.Fprintln(out, "Connection acquired from pool successfully!")
+
+		conn = pooled.Conn()
+	} else {
+		config, err := newConnConfig(connectCtx, cfg, tlsObs)
+		if err != nil {
+			err = configFailure(err)
+			endSpan(connectSpan, err)
+			return err
+		}
+
+		timeConnectPhases(&config.Config)
+		if cfg.dumpConfig != nil {
+			writePgxConfig(cfg.dumpConfig, &config.Config)
+		}
+
+		// Connect to database
+		connectStart := time.Now()
+		conn, err = dsqltest.ConnectWithRetry(connectCtx, config, cfg.retry)
+		if err != nil {
+			err = connectFailure(connectPhaseError(ctx, cfg,
+				tlsVersionFailure(opts, serverNameFailure(opts, fmt.Errorf("failed to connect to database: %w", err)))))
+			endSpan(connectSpan, err)
+			// Diagnose where the failure is unless preflight already passed.
+			// Only the proxy can reach the tunnel address when there is one
+			if !cfg.preflight && opts.SOCKS5Proxy == "" && exitCodeOf(err) == exitConnect {
+				diagCtx, cancel := context.WithTimeout(context.Background(), 2*preflightDialTimeout)
+				result.Preflight, _ = runPreflight(diagCtx, opts.HostAddrs(), opts.Port, out)
+				cancel()
+			}
+			return err
+		}
+		defer closeConn(conn)
+		ready := time.Now()
+		result.ConnectLatencyMs = durationMs(ready.Sub(connectStart))
+		result.ConnectPhases = connPhases(conn, ready)
+		attrs := []any{"phase", "dial", "hostaddr", opts.HostAddr, "duration_ms", result.ConnectLatencyMs}
+		if p := result.ConnectPhases; p != nil {
+			attrs = append(attrs, "tcp_dial_ms", p.TCPDialMs, "ssl_request_ms", p.SSLRequestMs,
+				"tls_handshake_ms", p.TLSHandshakeMs, "startup_ms", p.StartupMs)
+		}
+		slog.DebugContext(ctx, "connection phase complete", attrs...)
+
+		fmt.Fprintln(out, "Connection established successfully!")
+	}
+	connectSpan.SetAttributes(attribute.String("tls.protocol.version", tlsObs.version()))
+	// With several tunnel addresses pgx stops at the first that accepts.
+	// Through a proxy the remote address is the proxy's, so it isn't shown
+	if len(opts.HostAddrs()) > 1 && opts.SOCKS5Proxy == "" {
+		result.ConnectedAddr = conn.PgConn().Conn().RemoteAddr().String()
+		fmt.Fprintf(out, "Connected via %s\n", result.ConnectedAddr)
+	}
+	// Only a --demo run against a local Postgres may ask for plaintext
+	plaintext := opts.Demo && opts.SSLMode == "disable"
+	if err := requireTLS(conn); err != nil && !plaintext {
+		endSpan(connectSpan, err)
+		return err
+	}
+	result.SSL = !plaintext
+	// The PID the server reported at startup, matching pid in its session views
+	result.BackendPID = conn.PgConn().PID()
+	connectSpan.SetAttributes(attribute.Int("db.backend_pid", int(result.BackendPID)))
+	endSpan(connectSpan, nil)
+	report.pass("connect")
+
+	// A --query statement replaces the built-in info query
+	var info dsqltest.ConnectionInfo
+	var querySamples []latencySample
+	queryCtx, querySpan := startSpan(ctx, "dsql.query", cfg)
+	if cfg.queryTimeout > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = context.WithTimeout(queryCtx, cfg.queryTimeout)
+		defer cancel()
+	}
+	if cfg.query != "" {
+		result.QueryResult, querySamples, err = sampleQuery(queryCtx, conn, cfg.query, cfg.samples, cfg.maxRows)
+		if err != nil {
+			err = withExitCode(exitQuery, queryPhaseError(ctx, cfg, fmt.Errorf("failed to execute query: %w", err)))
+		}
+	} else {
+		info, querySamples, err = sampleConnectionInfo(queryCtx, cfg.infoQuerier(conn), cfg.samples)
+		if err != nil {
+			err = withExitCode(exitQuery, queryPhaseError(ctx, cfg, fmt.Errorf("failed to execute connection info query: %w", err)))
+		}
+	}
+	querySpan.SetAttributes(attribute.Int("dsql.query.samples", len(querySamples)))
+	endSpan(querySpan, err)
+	if err != nil {
+		return err
+	}
+
+	// A role mapped to the wrong IAM identity, or a login the server
+	// resolved differently, would otherwise pass unnoticed
+	if cfg.query == "" && info.User != opts.User && !slices.Contains(info.Unavailable, "user") {
+		return withExitCode(exitAuth, fmt.Errorf("connected as role %q, expected %q", info.User, opts.User))
+	}
+	// Landing in another database means tables the application expects
+	// are missing, which would only surface later as query errors
+	if cfg.query == "" && info.Database != opts.Database && !slices.Contains(info.Unavailable, "database") {
+		return withExitCode(exitQuery, fmt.Errorf("connected to database %q, expected %q", info.Database, opts.Database))
+	}
+	if opts.SearchPath != "" {
+		result.SearchPath, err = verifySearchPath(queryCtx, conn, opts.SearchPath)
+		if err != nil {
+			return withExitCode(exitQuery, queryPhaseError(ctx, cfg, err))
+		}
+	}
+
+	slog.DebugContext(ctx, "connection phase complete",
+		"phase", "query", "hostaddr", opts.HostAddr, "duration_ms", durationMs(querySamples[0].latency), "samples", len(querySamples))
+
+	report.pass("query")
+
+	result.Success = true
+	result.Database = info.Database
+	result.User = info.User
+	result.ServerVersion = info.ServerVersion
+	result.AppName = info.ApplicationName
+	result.UnavailableFields = info.Unavailable
+	if len(info.Unavailable) > 0 {
+		slog.WarnContext(ctx, "connection info partly unavailable", "fields", info.Unavailable)
+	}
+	result.TLSVersion = tlsObs.version()
+	result.TLSCipher = tlsObs.cipherSuite()
+	result.ClientCert = tlsObs.clientCert(opts)
+	result.VerifiedName = tlsObs.verifiedName(opts)
+	result.LatencyMs = durationMs(time.Since(start))
+	result.QueryLatencyMs = durationMs(querySamples[0].latency)
+	result.FirstRowMs = durationMs(querySamples[0].firstRow)
+	result.samples = querySamples
+	if len(querySamples) > 1 {
+		result.QuerySamples = summarizeLatencies(sampleLatencies(querySamples))
+		result.FirstRowSamples = summarizeLatencies(sampleFirstRows(querySamples))
+	}
+
+	// A slow connection fails the run, but the checks still run after it
+	// as they do after a failing check
+	if cfg.latency.set() {
+		err = cfg.latency.enforce(result)
+		report.record("latency", 0, err)
+		if err != nil {
+			result.Success = false
+			if cfg.failFast {
+				fmt.Fprintln(out, "\nStopping after the latency thresholds were exceeded (--fail-fast)")
+				report.skipPending()
+				return err
+			}
+		}
+	}
+
+	// Optional checks run on the same connection once basic connectivity is
+	// proven. Every selected check runs, so the report covers them all,
+	// unless --fail-fast skips the rest; the first failure decides the error
+	sess := &session{conn: conn, cfg: cfg, out: out}
+	for _, c := range cfg.checks {
+		cr := runCheck(ctx, sess, c)
+		result.Checks = append(result.Checks, cr)
+		if !cr.Success && err == nil {
+			result.Success = false
+			err = withExitCode(exitQuery, fmt.Errorf("%s check failed: %w", c.name, cr.err))
+		}
+		report.record(c.name, cr.DurationMs, cr.err)
+		if err != nil && cfg.failFast {
+			fmt.Fprintf(out, "\nStopping after the %s check failed (--fail-fast)\n", c.name)
+			report.skipPending()
+			break
+		}
+	}
+	return err
+}
+
+// newConnConfig builds the pgx config for cfg, recording the negotiated
+// TLS parameters in tlsObs when it's non-nil.
+func newConnConfig(ctx context.Context, cfg testConfig, tlsObs *tlsObserver) (*pgx.ConnConfig, error) {
+	config, err := cfg.conn.ConnConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.trace {
+		config.Tracer = newQueryTracer(cfg.conn.Password)
+	}
+	if tlsObs != nil {
+		tlsObs.attachConfig(&config.Config)
+	}
+	cfg.rotation.apply(&config.Config)
+	cfg.rate.wrapDial(&config.Config)
+	return config, nil
+}
+
+// connectPool creates a pool for cfg, recording the negotiated TLS
+// parameters of its connections in tlsObs.
+func connectPool(ctx context.Context, cfg testConfig, tlsObs *tlsObserver) (*pgxpool.Pool, error) {
+	poolConfig, err := dsqltest.PoolConfig(cfg.conn, cfg.pool)
+	if err != nil {
+		return nil, err
+	}
+	tlsObs.attachConfig(&poolConfig.ConnConfig.Config)
+	cfg.rate.wrapDial(&poolConfig.ConnConfig.Config)
+	if cfg.trace {
+		poolConfig.ConnConfig.Tracer = newQueryTracer(cfg.conn.Password)
+	}
+	// The pool hands each new connection its own copy of the config
+	if cfg.rotation != nil {
+		beforeConnect := poolConfig.BeforeConnect
+		poolConfig.BeforeConnect = func(ctx context.Context, config *pgx.ConnConfig) error {
+			if beforeConnect != nil {
+				if err := beforeConnect(ctx, config); err != nil {
+					return err
+				}
+			}
+			cfg.rotation.apply(&config.Config)
+			return nil
+		}
+	}
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
+
+// phaseError rewrites err as an explicit timeout naming the phase that was
+// running when ctx's deadline passed; other errors are returned unchanged.
+func phaseError(ctx context.Context, phase string, timeout time.Duration, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out during %s phase after %s (see --timeout): %w", phase, timeout, err)
+	}
+	return err
+}
+
+// connectPhaseError is phaseError for the connect phase, telling the expiry
+// of a --connect-timeout or --token-timeout, which leave ctx alive, from the
+// overall timeout.
+func connectPhaseError(ctx context.Context, cfg testConfig, err error) error {
+	if errors.Is(err, dsqltest.ErrTokenTimeout) {
+		return tokenPhaseError(err)
+	}
+	if cfg.conn.ConnectTimeout > 0 && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("connect attempt timed out after %s (see --connect-timeout): %w", cfg.conn.ConnectTimeout, err)
+	}
+	return phaseError(ctx, "connect", cfg.timeout, err)
+}
+
+// queryPhaseError is phaseError for the query phase, naming --query-timeout
+// when that expired rather than the overall timeout.
+func queryPhaseError(ctx context.Context, cfg testConfig, err error) error {
+	if cfg.queryTimeout > 0 && ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out during query phase after %s (see --query-timeout): %w", cfg.queryTimeout, err)
+	}
+	return phaseError(ctx, "query", cfg.timeout, err)
+}
+
+// tokenPhaseError names --token-timeout when generating the IAM auth token
+// took too long; other errors are returned unchanged.
+func tokenPhaseError(err error) error {
+	if errors.Is(err, dsqltest.ErrTokenTimeout) {
+		return fmt.Errorf("timed out during token phase (see --token-timeout): %w", err)
+	}
+	return err
+}
+
+// closeConn closes conn with its own short deadline, since the test's
+// context may already be cancelled or expired.
+func closeConn(conn *pgx.Conn) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn.Close(ctx)
+}
+
+// infoQuerier returns q for running the info query, forced onto the simple
+// protocol under --simple-protocol. The per-field fallback queries go
+// through it too.
+func (cfg testConfig) infoQuerier(q dsqltest.RowQuerier) dsqltest.RowQuerier {
+	if !cfg.simpleProtocol {
+		return q
+	}
+	return execModeQuerier{q: q, mode: pgx.QueryExecModeSimpleProtocol}
+}
+
+// execModeQuerier runs every query through q in mode, overriding the
+// connection's DefaultQueryExecMode the way pgx allows: with the mode as the
+// first query argument.
+type execModeQuerier struct {
+	q    dsqltest.RowQuerier
+	mode pgx.QueryExecMode
+}
+
+func (e execModeQuerier) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	return e.q.QueryRow(ctx, sql, append([]any{e.mode}, args...)...)
+}
+
+// queryProtocolName reports the wire protocol queries in mode are sent
+// over. Every mode except simple uses the extended protocol's parse, bind
+// and execute messages, with a describe step in all but exec.
+func queryProtocolName(mode pgx.QueryExecMode) string {
+	if mode == pgx.QueryExecModeSimpleProtocol {
+		return "simple"
+	}
+	return "extended"
+}
+
+// sampleConnectionInfo runs the info query n times, returning the first
+// result and the latency of every run.
+func sampleConnectionInfo(ctx context.Context, q dsqltest.RowQuerier, n int) (dsqltest.ConnectionInfo, []latencySample, error) {
+	var info dsqltest.ConnectionInfo
+	samples := make([]latencySample, 0, n)
+	for i := 0; i < n; i++ {
+		queryStart := time.Now()
+		sample, err := dsqltest.QueryConnectionInfo(ctx, q)
+		if err != nil {
+			return info, samples, err
+		}
+		samples = append(samples, latencySample{at: queryStart, latency: time.Since(queryStart)})
+		if i == 0 {
+			info = sample
+		}
+	}
+	return info, samples, nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// recordingQuerier records the arguments of every query. The combined info
+// query is rejected, so QueryConnectionInfo falls back to one query per
+// field, each of which returns the field's name.
+type recordingQuerier struct {
+	args map[string][]any
+}
+
+func (q *recordingQuerier) QueryRow(_ context.Context, sql string, args ...any) pgx.Row {
+	q.args[sql] = args
+	if sql == dsqltest.ConnectionInfoSQL {
+		return errRow{&pgconn.PgError{Severity: "ERROR", Code: "42883", Message: "function does not exist"}}
+	}
+	for _, f := range dsqltest.InfoFields {
+		if f.SQL == sql {
+			return valueRow(f.Name)
+		}
+	}
+	return errRow{pgx.ErrNoRows}
+}
+
+type errRow struct{ err error }
+
+func (r errRow) Scan(...any) error { return r.err }
+
+type valueRow string
+
+func (r valueRow) Scan(dest ...any) error {
+	v := string(r)
+	*dest[0].(**string) = &v
+	return nil
+}
+
+func TestInfoQuerierExecMode(t *testing.T) {
+	for _, simple := range []bool{false, true} {
+		name := "extended"
+		if simple {
+			name = "simple"
+		}
+		t.Run(name, func(t *testing.T) {
+			q := &recordingQuerier{args: make(map[string][]any)}
+			cfg := testConfig{simpleProtocol: simple}
+			info, err := dsqltest.QueryConnectionInfo(context.Background(), cfg.infoQuerier(q))
+			if err != nil {
+				t.Fatalf("QueryConnectionInfo: %v", err)
+			}
+			if info.Database != "database" || info.ApplicationName != "application_name" {
+				t.Fatalf("fallback values not read: %+v", info)
+			}
+
+			queries := []string{dsqltest.ConnectionInfoSQL}
+			for _, f := range dsqltest.InfoFields {
+				queries = append(queries, f.SQL)
+			}
+			for _, sql := range queries {
+				args, ran := q.args[sql]
+				if !ran {
+					t.Fatalf("query %q didn't run", sql)
+				}
+				if !simple {
+					if len(args) != 0 {
+						t.Errorf("query %q got arguments %v, want none", sql, args)
+					}
+					continue
+				}
+				if len(args) != 1 || args[0] != pgx.QueryExecModeSimpleProtocol {
+					t.Errorf("query %q got arguments %v, want just QueryExecModeSimpleProtocol", sql, args)
+				}
+			}
+		})
+	}
+}
+
+// TestModesInfoQueryExecMode drives the --concurrency session and the
+// --bench warmup against a fake server and checks --simple-protocol decides
+// which protocol their info query arrives over.
+func TestModesInfoQueryExecMode(t *testing.T) {
+	modes := map[string]func(ctx context.Context, cfg testConfig) error{
+		"concurrency session": func(ctx context.Context, cfg testConfig) error {
+			conn, outcome := openSession(ctx, cfg)
+			if conn != nil {
+				closeConn(conn)
+			}
+			return outcome.err
+		},
+		"bench warmup": warmupCycle,
+	}
+	for name, run := range modes {
+		for _, simple := range []bool{false, true} {
+			want := "extended"
+			if simple {
+				want = "simple"
+			}
+			t.Run(name+" "+want, func(t *testing.T) {
+				server := startFakeServer(t, "127.0.0.1")
+				cfg := testConfig{
+					conn:           server.config(),
+					timeout:        5 * time.Second,
+					retry:          dsqltest.RetryPolicy{MaxAttempts: 1},
+					simpleProtocol: simple,
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+				defer cancel()
+				if err := run(ctx, cfg); err == nil {
+					t.Fatal("the fake server rejects every query, but the mode succeeded")
+				}
+				got := server.protocols()
+				if len(got) == 0 {
+					t.Fatal("no query reached the server")
+				}
+				if slices.ContainsFunc(got, func(p string) bool { return p != want }) {
+					t.Fatalf("queries arrived over %q, want every one over the %s protocol", got, want)
+				}
+			})
+		}
+	}
+}
+
+// connectObserved connects once the way a mode does, with or without a
+// pool, recording the handshake in tlsObs.
+var connectObserved = map[string]func(ctx context.Context, cfg testConfig, tlsObs *tlsObserver) error{
+	"conn": func(ctx context.Context, cfg testConfig, tlsObs *tlsObserver) error {
+		config, err := newConnConfig(ctx, cfg, tlsObs)
+		if err != nil {
+			return err
+		}
+		conn, err := pgx.ConnectConfig(ctx, config)
+		if err != nil {
+			return err
+		}
+		return conn.Close(ctx)
+	},
+	"pool": func(ctx context.Context, cfg testConfig, tlsObs *tlsObserver) error {
+		pool, err := connectPool(ctx, cfg, tlsObs)
+		if err != nil {
+			return err
+		}
+		defer pool.Close()
+		conn, err := pool.Acquire(ctx)
+		if err != nil {
+			return err
+		}
+		conn.Release()
+		return nil
+	},
+}
+
+// checkTLSObserved fails the test unless tlsObs recorded a handshake.
+func checkTLSObserved(t *testing.T, tlsObs *tlsObserver) {
+	t.Helper()
+	if tlsObs.version() == "" || tlsObs.cipherSuite() == "" {
+		t.Errorf("TLS version %q, cipher %q; want the handshake recorded", tlsObs.version(), tlsObs.cipherSuite())
+	}
+}
+
+// TestFallbackTLSDetails connects through a fallback address, which has a
+// TLS config of its own with --no-sni-override, while the first refuses.
+func TestFallbackTLSDetails(t *testing.T) {
+	for name, connect := range connectObserved {
+		t.Run(name, func(t *testing.T) {
+			server := startFakeServer(t, "127.0.0.2")
+			cfg := testConfig{conn: server.config(), timeout: 5 * time.Second}
+			// Nothing listens on the fake server's port on 127.0.0.1
+			cfg.conn.HostAddr = "127.0.0.1," + server.addr
+			cfg.conn.NoSNIOverride = true
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+			defer cancel()
+			tlsObs := &tlsObserver{}
+			if err := connect(ctx, cfg, tlsObs); err != nil {
+				t.Fatalf("connect through the fallback: %v", err)
+			}
+			checkTLSObserved(t, tlsObs)
+		})
+	}
+}
+
+// TestRoundRobinTLSDetails connects to the second --hostaddr address in
+// its turn, with and without the per-address TLS configs of
+// --no-sni-override.
+func TestRoundRobinTLSDetails(t *testing.T) {
+	for name, connect := range connectObserved {
+		for _, noSNIOverride := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s no-sni-override=%t", name, noSNIOverride), func(t *testing.T) {
+				server := startFakeServer(t, "127.0.0.2")
+				cfg := testConfig{conn: server.config(), timeout: 5 * time.Second, rotation: newRoundRobin(true)}
+				cfg.conn.HostAddr = "127.0.0.1," + server.addr
+				cfg.conn.NoSNIOverride = noSNIOverride
+				// The first address has had its turn
+				cfg.rotation.next.Store(1)
+				ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+				defer cancel()
+				tlsObs := &tlsObserver{}
+				if err := connect(ctx, cfg, tlsObs); err != nil {
+					t.Fatalf("connect to the second address: %v", err)
+				}
+				checkTLSObserved(t, tlsObs)
+				if stats := cfg.rotation.report(); stats[1].Connected != 1 {
+					t.Errorf("round robin report %+v, want one connect to %s", stats, server.addr)
+				}
+			})
+		}
+	}
+}
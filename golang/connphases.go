@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TLS record content types, the first byte of every record.
+const (
+	tlsRecordHandshake       = 0x16
+	tlsRecordApplicationData = 0x17
+)
+
+// connectPhases breaks one connection's setup into its round trips: the TCP
+// dial (including any SOCKS5 handshake), the SSLRequest Postgres sends
+// before TLS starts, the TLS handshake, and the startup and authentication
+// exchange after it. With retries they describe the attempt that succeeded.
+type connectPhases struct {
+	TCPDialMs      float64 `json:"tcp_dial_ms"`
+	SSLRequestMs   float64 `json:"ssl_request_ms"`
+	TLSHandshakeMs float64 `json:"tls_handshake_ms"`
+	StartupMs      float64 `json:"startup_ms"`
+}
+
+// String formats the phases for the text report.
+func (p *connectPhases) String() string {
+	return fmt.Sprintf("TCP dial %.2fms, SSLRequest %.2fms, TLS handshake %.2fms, startup %.2fms",
+		p.TCPDialMs, p.SSLRequestMs, p.TLSHandshakeMs, p.StartupMs)
+}
+
+// timedConn notes when the TLS handshake over it starts and ends, judged by
+// what is written: the ClientHello is the first handshake record, and the
+// first application data record is the startup message pgx sends once the
+// handshake is over. The VerifyConnection hook can't mark the end, since in
+// TLS 1.2 it runs a round trip before the handshake completes.
+type timedConn struct {
+	net.Conn
+	dialStart, dialEnd time.Time
+
+	mu             sync.Mutex
+	handshakeStart time.Time
+	handshakeEnd   time.Time
+}
+
+func (c *timedConn) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		c.mu.Lock()
+		switch {
+		case b[0] == tlsRecordHandshake && c.handshakeStart.IsZero():
+			c.handshakeStart = time.Now()
+		case b[0] == tlsRecordApplicationData && c.handshakeEnd.IsZero() && !c.handshakeStart.IsZero():
+			c.handshakeEnd = time.Now()
+		}
+		c.mu.Unlock()
+	}
+	return c.Conn.Write(b)
+}
+
+// timeConnectPhases wraps config's dialer so every connection made with it,
+// fallbacks and retries included, records its phase timings.
+func timeConnectPhases(config *pgconn.Config) {
+	dial := config.DialFunc
+	config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		start := time.Now()
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &timedConn{Conn: conn, dialStart: start, dialEnd: time.Now()}, nil
+	}
+}
+
+// connPhases returns the phases of conn, which became ready for queries at
+// ready, or nil if it wasn't dialed through timeConnectPhases.
+func connPhases(conn *pgx.Conn, ready time.Time) *connectPhases {
+	tlsConn, ok := conn.PgConn().Conn().(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	tc, ok := tlsConn.NetConn().(*timedConn)
+	if !ok {
+		return nil
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.handshakeStart.IsZero() || tc.handshakeEnd.IsZero() {
+		return nil
+	}
+	return &connectPhases{
+		TCPDialMs:      durationMs(tc.dialEnd.Sub(tc.dialStart)),
+		SSLRequestMs:   durationMs(tc.handshakeStart.Sub(tc.dialEnd)),
+		TLSHandshakeMs: durationMs(tc.handshakeEnd.Sub(tc.handshakeStart)),
+		StartupMs:      durationMs(ready.Sub(tc.handshakeEnd)),
+	}
+}
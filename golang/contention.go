@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// contentionReport is the outcome of --write-contention: how many of the
+// writers committed their update of the shared row on the first try and how
+// many lost the optimistic concurrency race. With retries, it also counts
+// the attempts needed until every writer had committed.
+type contentionReport struct {
+	Writers         int     `json:"writers"`
+	FirstTryCommits int     `json:"first_try_commits"`
+	Conflicts       int     `json:"conflicts"`
+	Failed          int     `json:"failed"`
+	ConflictRatePct float64 `json:"conflict_rate_pct"`
+
+	Retry         bool `json:"retry"`
+	Committed     int  `json:"committed"`
+	TotalAttempts int  `json:"total_attempts,omitempty"`
+	MaxAttempts   int  `json:"max_attempts,omitempty"`
+
+	CommitLatency *latencySummary `json:"commit_latency,omitempty"`
+	Errors        []string        `json:"errors,omitempty"`
+	DurationMs    float64         `json:"duration_ms"`
+}
+
+// writerOutcome is the result of one --write-contention writer.
+type writerOutcome struct {
+	conflicted bool // the first commit lost the race
+	committed  bool
+	attempts   int
+	commit     time.Duration // first commit's round trip
+	err        error
+}
+
+// runWriteContention opens n sessions, starts a transaction on each that
+// increments the same row, and commits them all at once once every update
+// is in, so they contend for the row the way simultaneous writers would.
+// DSQL lets the first commit win and rejects the rest with an OCC error.
+// With retry, each losing writer runs its transaction again, with the usual
+// backoff, until it commits. The row's final value is checked against the
+// commits counted.
+func runWriteContention(ctx context.Context, cfg testConfig, n int, retry bool, out io.Writer) (report *contentionReport, err error) {
+	fmt.Fprintf(out, "Running %d contending writers against %s via %s\n", n, cfg.conn.Hostname, cfg.conn.Address())
+	s := &session{cfg: cfg}
+	setup, err := s.connect(ctx)
+	if err != nil {
+		return nil, connectFailure(err)
+	}
+	defer closeConn(setup)
+
+	table := pgx.Identifier{newTestTableName("contention")}.Sanitize()
+	if err := execStmt(ctx, setup, "CREATE TABLE "+table+" (id int PRIMARY KEY, counter int NOT NULL)"); err != nil {
+		return nil, withExitCode(exitQuery, fmt.Errorf("failed to create contention table: %w", err))
+	}
+	defer func() {
+		dropCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if dropErr := execStmt(dropCtx, setup, "DROP TABLE "+table); dropErr != nil && err == nil {
+			err = withExitCode(exitQuery, fmt.Errorf("failed to drop contention table: %w", dropErr))
+		}
+	}()
+	if err := execStmt(ctx, setup, "INSERT INTO "+table+" (id, counter) VALUES (1, 0)"); err != nil {
+		return nil, withExitCode(exitQuery, fmt.Errorf("failed to insert contended row: %w", err))
+	}
+
+	conns := make([]*pgx.Conn, n)
+	connErrs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], connErrs[i] = s.connect(ctx)
+		}(i)
+	}
+	wg.Wait()
+	defer func() {
+		for _, conn := range conns {
+			if conn != nil {
+				closeConn(conn)
+			}
+		}
+	}()
+	if err := errors.Join(connErrs...); err != nil {
+		return nil, connectFailure(err)
+	}
+
+	update := "UPDATE " + table + " SET counter = counter + 1 WHERE id = 1"
+	// Each retry round lets at least one more writer through, so n writers
+	// may need up to n retries
+	maxRetries := max(conflictRetries, n)
+	outcomes := make([]writerOutcome, n)
+	// Every writer has its update in before any commits, or they'd simply
+	// run one after another
+	var updated sync.WaitGroup
+	updated.Add(n)
+	commit := make(chan struct{})
+	start := time.Now()
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn *pgx.Conn) {
+			defer wg.Done()
+			outcomes[i] = contend(ctx, conn, update, &updated, commit)
+			if outcomes[i].conflicted && retry {
+				retries, err := retryOnConflict(ctx, cfg.retries, func(ctx context.Context) error {
+					return pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+						return execTx(ctx, tx, update)
+					})
+				}, maxRetries)
+				outcomes[i].attempts += 1 + retries
+				outcomes[i].committed = err == nil
+				outcomes[i].err = err
+			}
+		}(i, conn)
+	}
+	updated.Wait()
+	close(commit)
+	wg.Wait()
+
+	report = &contentionReport{Writers: n, Retry: retry, DurationMs: durationMs(time.Since(start))}
+	var latencies []time.Duration
+	seenErrs := make(map[string]bool)
+	var firstErr error
+	for _, o := range outcomes {
+		switch {
+		case o.conflicted:
+			report.Conflicts++
+		case o.committed:
+			report.FirstTryCommits++
+		}
+		if o.committed {
+			report.Committed++
+		}
+		if o.commit > 0 {
+			latencies = append(latencies, o.commit)
+		}
+		if retry {
+			report.TotalAttempts += o.attempts
+			report.MaxAttempts = max(report.MaxAttempts, o.attempts)
+		}
+		if o.err != nil {
+			report.Failed++
+			if firstErr == nil {
+				firstErr = o.err
+			}
+			msg := o.err.Error()
+			if !seenErrs[msg] && len(report.Errors) < maxReportedErrors {
+				seenErrs[msg] = true
+				report.Errors = append(report.Errors, msg)
+			}
+		}
+	}
+	report.ConflictRatePct = float64(report.Conflicts) / float64(n) * 100
+	report.CommitLatency = summarizeLatencies(latencies)
+
+	if firstErr != nil {
+		return report, withExitCode(exitQuery, fmt.Errorf("%d of %d writers failed: %w", report.Failed, n, firstErr))
+	}
+	var counter int
+	if err := setup.QueryRow(ctx, "SELECT counter FROM "+table+" WHERE id = 1").Scan(&counter); err != nil {
+		return report, withExitCode(exitQuery, fmt.Errorf("failed to read contended row: %w", err))
+	}
+	if counter != report.Committed {
+		return report, withExitCode(exitQuery, fmt.Errorf("counter is %d, expected %d after the counted commits", counter, report.Committed))
+	}
+	return report, nil
+}
+
+// contend begins a transaction on conn and runs update, marks updated done
+// whether or not that worked, then waits for commit to be closed before
+// committing. A conflict on commit is reported as conflicted rather than as
+// an error.
+func contend(ctx context.Context, conn *pgx.Conn, update string, updated *sync.WaitGroup, commit <-chan struct{}) writerOutcome {
+	o := writerOutcome{attempts: 1}
+	tx, err := conn.Begin(ctx)
+	if err == nil {
+		defer tx.Rollback(context.Background())
+		err = execTx(ctx, tx, update)
+	}
+	updated.Done()
+	if err != nil {
+		o.err = fmt.Errorf("update failed before commit: %w", err)
+		if isOCCConflict(err) {
+			o.conflicted, o.err = true, nil
+		}
+		return o
+	}
+
+	select {
+	case <-commit:
+	case <-ctx.Done():
+		o.err = ctx.Err()
+		return o
+	}
+	commitStart := time.Now()
+	err = tx.Commit(ctx)
+	o.commit = time.Since(commitStart)
+	switch {
+	case err == nil:
+		o.committed = true
+	case isOCCConflict(err):
+		o.conflicted = true
+	default:
+		o.err = fmt.Errorf("commit failed: %w", err)
+	}
+	return o
+}
+
+// writeText prints the first-try outcome counts, the conflict rate and,
+// with retries, the attempts needed for every writer to commit.
+func (r *contentionReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nWrite Contention Report:")
+	fmt.Fprintln(w, "========================")
+	fmt.Fprintf(w, "Writers: %d, %d committed on the first try, %d conflicted, %d failed (%.2fms)\n",
+		r.Writers, r.FirstTryCommits, r.Conflicts, r.Failed, r.DurationMs)
+	fmt.Fprintf(w, "Conflict rate: %.1f%%\n", r.ConflictRatePct)
+	if r.Retry {
+		fmt.Fprintf(w, "With retries: %d/%d committed in %d attempts (at most %d for one writer)\n",
+			r.Committed, r.Writers, r.TotalAttempts, r.MaxAttempts)
+	}
+	if r.CommitLatency != nil {
+		r.CommitLatency.writeText(w, "Commit latency")
+	}
+	for _, msg := range r.Errors {
+		fmt.Fprintf(w, "  error: %s\n", msg)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// copyTestRows is how many rows the --copy-test check sends over COPY.
+const copyTestRows = 3
+
+// copyCheck probes whether the server accepts pgx's CopyFrom, which uses
+// the COPY FROM STDIN protocol rather than INSERTs. A server error is the
+// finding, reported with its SQLSTATE and message, not a failure; only a
+// connection problem or a row count mismatch fails the check.
+var copyCheck = check{name: "copy-test", run: runCopyTest}
+
+func runCopyTest(ctx context.Context, s *session, r *checkResult) (err error) {
+	name := newTestTableName("copy")
+	table := pgx.Identifier{name}.Sanitize()
+	if err := r.step("create table", execStmt(ctx, s.conn,
+		"CREATE TABLE "+table+" (id int PRIMARY KEY, payload text NOT NULL)")); err != nil {
+		return err
+	}
+	// The drop runs on a fresh context so a cancelled COPY still cleans up
+	defer func() {
+		dropCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if dropErr := r.step("drop table", execStmt(dropCtx, s.conn, "DROP TABLE "+table)); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+
+	rows := make([][]any, copyTestRows)
+	for i := range rows {
+		rows[i] = []any{int32(i + 1), fmt.Sprintf("copy row %d", i+1)}
+	}
+	copied, copyErr := s.conn.CopyFrom(ctx, pgx.Identifier{name}, []string{"id", "payload"}, pgx.CopyFromRows(rows))
+
+	var pgErr *pgconn.PgError
+	if errors.As(copyErr, &pgErr) {
+		r.step("copy from", nil)
+		r.detail("supported", false)
+		r.detail("result", "server rejected COPY FROM STDIN")
+		r.detail("sqlstate", pgErr.Code)
+		r.detail("message", pgErr.Message)
+		return nil
+	}
+	if err := r.step("copy from", copyErr); err != nil {
+		return err
+	}
+	r.detail("supported", true)
+	r.detail("rows_copied", copied)
+
+	var count int64
+	err = s.conn.QueryRow(ctx, "SELECT count(*) FROM "+table).Scan(&count)
+	if err == nil && count != copyTestRows {
+		err = fmt.Errorf("table has %d rows after copying %d", count, copyTestRows)
+	}
+	return r.step("rows visible", err)
+}
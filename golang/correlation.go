@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxCorrelationIDLen fits a UUID, leaving room for the tool's own name
+// within application_name.
+const maxCorrelationIDLen = 36
+
+// maxAppNameLen is the longest application_name Postgres keeps
+// (NAMEDATALEN - 1); anything longer is silently truncated server-side,
+// which would cut off the correlation ID.
+const maxAppNameLen = 63
+
+// resolveCorrelationID returns id, or a new random UUID when it's empty.
+// An explicit id must be short and plain enough to survive application_name
+// and log pipelines unchanged.
+func resolveCorrelationID(id string) (string, error) {
+	if id == "" {
+		return newUUID(), nil
+	}
+	if len(id) > maxCorrelationIDLen {
+		return "", fmt.Errorf("--correlation-id is %d characters, at most %d are allowed", len(id), maxCorrelationIDLen)
+	}
+	for _, r := range id {
+		if !isCorrelationIDChar(r) {
+			return "", fmt.Errorf("--correlation-id %q may only contain letters, digits, '-', '_', '.' and ':'", id)
+		}
+	}
+	return id, nil
+}
+
+func isCorrelationIDChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r)
+}
+
+// withCorrelationID appends id to the application name, shortening the name
+// rather than the id when together they'd exceed what the server keeps.
+func withCorrelationID(appName, id string) string {
+	if appName == "" {
+		return id
+	}
+	if room := maxAppNameLen - len(id) - 1; len(appName) > room {
+		appName = appName[:max(room, 0)]
+	}
+	return appName + " " + id
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// latencySample is one timed query, kept for --format csv.
+type latencySample struct {
+	at      time.Time
+	latency time.Duration
+
+	// firstRow is the time to the first row of a --query result, or 0 when
+	// the query returned none or ran the built-in info query
+	firstRow time.Duration
+}
+
+// sampleLatencies returns just the latencies of samples.
+func sampleLatencies(samples []latencySample) []time.Duration {
+	latencies := make([]time.Duration, len(samples))
+	for i, s := range samples {
+		latencies[i] = s.latency
+	}
+	return latencies
+}
+
+// sampleFirstRows returns the time to first row of the samples that have
+// one.
+func sampleFirstRows(samples []latencySample) []time.Duration {
+	var firstRows []time.Duration
+	for _, s := range samples {
+		if s.firstRow > 0 {
+			firstRows = append(firstRows, s.firstRow)
+		}
+	}
+	return firstRows
+}
+
+// writeSamplesCSV prints a header and one timestamp,latency_ms row per
+// sample, timestamped when the query was sent.
+func writeSamplesCSV(w io.Writer, samples []latencySample) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"timestamp", "latency_ms"})
+	for _, s := range samples {
+		cw.Write([]string{csvTime(s.at), csvMs(durationMs(s.latency))})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeCSV prints the per-sample rows of a successful --samples run, or
+// otherwise a header and a single summary row timestamped when the run
+// finished. Failures always take the summary form so the error is kept.
+func (r *ConnectionResult) writeCSV(w io.Writer) error {
+	if r.Success && len(r.samples) > 1 {
+		return writeSamplesCSV(w, r.samples)
+	}
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"timestamp", "success", "host", "port", "database", "user", "server_version", "application_name",
+		"tls_version", "connect_latency_ms", "query_latency_ms", "latency_ms", "sqlstate", "error",
+	})
+	cw.Write([]string{
+		csvTime(time.Now()), strconv.FormatBool(r.Success), r.Host, strconv.Itoa(r.Port), r.Database, r.User,
+		r.ServerVersion, r.AppName, r.TLSVersion, csvMs(r.ConnectLatencyMs), csvMs(r.QueryLatencyMs),
+		csvMs(r.LatencyMs), r.SQLState, r.Error,
+	})
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvTime formats t in UTC with the precision spreadsheets can parse.
+func csvTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+}
+
+// csvMs formats a millisecond latency to microsecond precision.
+func csvMs(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', 3, 64)
+}
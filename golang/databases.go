@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// sqlStateInvalidCatalog is the startup error for a database that doesn't
+// exist.
+const sqlStateInvalidCatalog = "3D000"
+
+// splitDatabases splits a comma-separated --database value into its names,
+// in order. A session is bound to the database it started in, so each name
+// needs a connection of its own.
+func splitDatabases(s string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("database %q is listed twice", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// databaseReport aggregates per-database results for a --database list.
+// Report has one sub-test per database.
+type databaseReport struct {
+	Success   bool                         `json:"success"`
+	Succeeded int                          `json:"succeeded"`
+	Failed    int                          `json:"failed"`
+	Databases map[string]*ConnectionResult `json:"databases"`
+	Report    *TestReport                  `json:"report"`
+}
+
+// runDatabases tests each database in turn, on its own connection and with
+// its own timeout and retry budget. A failure, including a database that
+// doesn't exist, is recorded as that database's failed sub-test and the
+// rest are still tested; the exit code is that of the first failure.
+// Cancelling rootCtx skips the databases not yet tested. With jsonOutput
+// the report is written to stdout.
+func runDatabases(rootCtx context.Context, base testConfig, databases []string, out, stdout io.Writer, jsonOutput bool) int {
+	report := &databaseReport{
+		Databases: make(map[string]*ConnectionResult, len(databases)),
+		Report:    &TestReport{},
+	}
+	exitCode := exitOK
+	for _, db := range databases {
+		name := "database " + db
+		if rootCtx.Err() != nil {
+			report.Report.pending = append(report.Report.pending, name)
+			exitCode = exitInterrupted
+			continue
+		}
+		start := time.Now()
+		res, err := testDatabase(rootCtx, base, db, out)
+		report.Databases[db] = res
+		report.Report.record(name, durationMs(time.Since(start)), err)
+		if err != nil {
+			report.Failed++
+			if exitCode == exitOK || res.ExitCode == exitInterrupted {
+				exitCode = res.ExitCode
+			}
+			continue
+		}
+		report.Succeeded++
+	}
+	report.Report.skipPending()
+	report.Success = report.Failed == 0
+
+	return writeReport(stdout, out, report, jsonOutput, exitCode)
+}
+
+// testDatabase runs the connectivity test against one database and returns
+// the result, with the error and exit code filled in on failure.
+func testDatabase(rootCtx context.Context, base testConfig, database string, out io.Writer) (*ConnectionResult, error) {
+	ctx, cancel := context.WithTimeout(rootCtx, base.timeout)
+	defer cancel()
+
+	cfg := base
+	cfg.conn.Database = database
+	cfg.retries = newRetryBudget()
+	cfg.retry.OnRetry = cfg.retries.connectHook
+	result := &ConnectionResult{CorrelationID: cfg.runID, Host: cfg.conn.HostAddr, Port: cfg.conn.Port, SSLMode: cfg.conn.SSLMode}
+
+	fmt.Fprintf(out, "\n[%s]\n", database)
+	err := runConnectivityTest(ctx, cfg, out, result)
+	if err != nil {
+		if sqlState(err) == sqlStateInvalidCatalog {
+			err = fmt.Errorf("database %q does not exist: %w", database, err)
+		}
+		err = interruptedError(rootCtx, err)
+		result.setError(err, exitCodeOf(err))
+		slog.Error("database check failed", "database", database, "error", err, "exit_code", result.ExitCode)
+	}
+	result.Retries = cfg.retries.summary()
+	return result, err
+}
+
+// writeText prints one line per database in the order given, and the
+// counts.
+func (r *databaseReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nDatabase Report:")
+	fmt.Fprintln(w, "================")
+	for _, t := range r.Report.Tests {
+		db := strings.TrimPrefix(t.Name, "database ")
+		res := r.Databases[db]
+		switch {
+		case res == nil:
+			fmt.Fprintf(w, "%-20s %s\n", db, colorize(statusColor(statusSkip), "SKIP"))
+		case res.Success:
+			fmt.Fprintf(w, "%-20s %s   connect=%.2fms latency=%.2fms\n", db, okOrFail(true, "OK"), res.ConnectLatencyMs, res.LatencyMs)
+		default:
+			fmt.Fprintf(w, "%-20s %s %s\n", db, okOrFail(false, "FAIL"), res.Error)
+		}
+	}
+	fmt.Fprintf(w, "\n%d succeeded, %d failed, %d skipped\n", r.Succeeded, r.Failed, r.Report.Skipped)
+}
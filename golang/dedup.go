@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// probeDedup quiets a high-frequency --watch run (--dedup-window): once the
+// cluster is up, each further passing probe is held back and counted, and a
+// single rollup line is printed per window. The first pass after a failure,
+// and every failure, are still printed as they happen, after a rollup of any
+// passes held back before them. Only the text lines are affected; jsonl
+// records, metrics and the summary still see every probe.
+type probeDedup struct {
+	window time.Duration
+
+	up    bool      // the last probe passed and its line was printed
+	held  int       // passes held back in the current window
+	since time.Time // start of the current window
+}
+
+// newProbeDedup returns a dedup for window, or nil when window is zero and
+// every probe is printed.
+func newProbeDedup(window time.Duration) *probeDedup {
+	if window <= 0 {
+		return nil
+	}
+	return &probeDedup{window: window}
+}
+
+// suppress is called with each probe's outcome before its line is printed
+// and reports whether to leave the line out, printing a rollup to w when a
+// window has filled or the streak of passes has ended.
+func (d *probeDedup) suppress(ok bool, now time.Time, w io.Writer) bool {
+	if d == nil {
+		return false
+	}
+	if !ok {
+		d.flush(now, w)
+		d.up = false
+		return false
+	}
+	if !d.up {
+		d.up = true
+		d.since = now
+		return false
+	}
+	d.held++
+	if now.Sub(d.since) >= d.window {
+		d.flush(now, w)
+		d.since = now
+	}
+	return true
+}
+
+// flush prints the rollup of the passes held back so far, if any. It's also
+// called when the watch ends so none go unreported.
+func (d *probeDedup) flush(now time.Time, w io.Writer) {
+	if d == nil || d.held == 0 {
+		return
+	}
+	noun := "probes"
+	if d.held == 1 {
+		noun = "probe"
+	}
+	fmt.Fprintf(w, "%s %s %d successful %s in last %s\n",
+		now.Format(time.RFC3339), okOrFail(true, "OK"), d.held, noun, now.Sub(d.since).Round(time.Second))
+	d.held = 0
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// demoEnv must be "true" as well as --demo being set, so a flag left in a
+// script can't quietly drop the TLS and authentication requirements
+// against a real cluster.
+const demoEnv = "DSQL_DEMO"
+
+// applyDemo prepares opts for --demo against a local Postgres: sslmode
+// disable and an empty password are accepted, the address defaults to
+// localhost and the hostname, which only matters for SNI, to the address.
+// Every other connection flag applies as usual.
+func applyDemo(opts *dsqltest.Config) error {
+	if os.Getenv(demoEnv) != "true" {
+		return fmt.Errorf("--demo requires %s=true", demoEnv)
+	}
+	opts.Demo = true
+	if opts.HostAddr == "" {
+		opts.HostAddr = "localhost"
+	}
+	if opts.Hostname == "" {
+		opts.Hostname = opts.HostAddr
+	}
+	return nil
+}
+
+// writeDemoBanner makes clear, before anything connects, that the run says
+// nothing about a DSQL cluster.
+func writeDemoBanner(w io.Writer, opts dsqltest.Config) {
+	fmt.Fprintln(w, colorize(colorYellow, "DEMO MODE: this is NOT a real DSQL connection."))
+	fmt.Fprintln(w, colorize(colorYellow, fmt.Sprintf("Connecting to a local Postgres at %s:%d with the DSQL TLS, SNI and IAM requirements relaxed.", opts.HostAddr, opts.Port)))
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// discoverClusters builds the cluster list for --discover from every cluster
+// ListClusters returns in each of regions, or in the default region when
+// none are given. Clusters are dialed at hostAddr when it's set, otherwise
+// at their public endpoint. A region the credentials may not list is
+// skipped with a warning so it doesn't hide the clusters in the others.
+func discoverClusters(ctx context.Context, regions []string, hostAddr string, d clusterDefaults, out io.Writer) ([]clusterEntry, error) {
+	if len(regions) == 0 {
+		regions = []string{""}
+	}
+
+	var clusters []clusterEntry
+	var deniedErr error
+	denied := 0
+	for _, region := range regions {
+		awsCfg, err := loadAWSConfig(ctx, region, d.profile, d.roleARN, d.externalID, d.tokenTimeout, nil)
+		if err != nil {
+			return nil, withExitCode(exitAuth, err)
+		}
+		ids, err := dsqltest.ListClusters(ctx, awsCfg)
+		if errors.Is(err, dsqltest.ErrListDenied) {
+			slog.Warn("skipping region", "region", awsCfg.Region, "error", err)
+			deniedErr = err
+			denied++
+			continue
+		}
+		if err != nil {
+			return nil, withExitCode(exitConnect, err)
+		}
+
+		fmt.Fprintf(out, "Discovered %d clusters in %s\n", len(ids), awsCfg.Region)
+		for _, id := range ids {
+			hostname := dsqltest.ClusterHostname(id, awsCfg.Region)
+			clusters = append(clusters, clusterEntry{
+				Name:     id,
+				Hostname: hostname,
+				HostAddr: firstNonEmpty(hostAddr, hostname),
+				Region:   awsCfg.Region,
+			})
+		}
+	}
+
+	if denied == len(regions) {
+		return nil, withExitCode(exitAuth, deniedErr)
+	}
+	if len(clusters) == 0 {
+		return nil, withExitCode(exitConfig, errors.New("no DSQL clusters found to test"))
+	}
+	return clusters, nil
+}
+
+// parseRegions splits a comma-separated --region list, dropping blanks.
+func parseRegions(s string) []string {
+	var regions []string
+	for _, r := range strings.Split(s, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			regions = append(regions, r)
+		}
+	}
+	return regions
+}
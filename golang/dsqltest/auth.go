@@ -0,0 +1,156 @@
+package dsqltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/dsql/auth"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// tokenLifetime is the validity requested for generated tokens. DSQL accepts
+// longer lifetimes, but short tokens limit the damage if one leaks.
+const tokenLifetime = 15 * time.Minute
+
+// MaxTokenLifetime is the longest validity DSQL accepts for an auth token.
+const MaxTokenLifetime = 7 * 24 * time.Hour
+
+// LoadAWSConfig resolves the AWS region and credentials used to sign auth
+// tokens through the standard SDK chain (env, shared config and
+// credentials files, SSO, IMDS). Empty region and profile defer to
+// AWS_REGION and AWS_PROFILE. Credentials are retrieved once up front so a
+// missing or expired login fails here instead of at connect time. Extra
+// load options, such as config.WithRetryer, are applied last.
+func LoadAWSConfig(ctx context.Context, region, profile string, optFns ...func(*config.LoadOptions) error) (aws.Config, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+
+	awsCfg, err := config.LoadDefaultConfig(ctx, append(opts, optFns...)...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("%w: failed to load AWS configuration: %w", ErrAuthToken, err)
+	}
+	if awsCfg.Region == "" {
+		return aws.Config{}, fmt.Errorf("%w: region is required (set --region, AWS_REGION or a profile region)", ErrAuthToken)
+	}
+	if _, err := awsCfg.Credentials.Retrieve(ctx); err != nil {
+		return aws.Config{}, fmt.Errorf("%w: failed to resolve AWS credentials%s: %w", ErrAuthToken, profileSuffix(profile), err)
+	}
+	return awsCfg, nil
+}
+
+// roleSessionName identifies the tool's sessions in CloudTrail when it
+// assumes a role.
+const roleSessionName = "dsql-connectivity-test"
+
+// AssumeRole returns a copy of awsCfg whose credentials come from assuming
+// roleARN through STS, with externalID when the role's trust policy
+// requires one. The temporary credentials are cached and refreshed a full
+// token lifetime before they expire, so every token signed with them stays
+// valid for as long as it claims. They're retrieved once up front so a role
+// that can't be assumed fails here.
+func AssumeRole(ctx context.Context, awsCfg aws.Config, roleARN, externalID string) (aws.Config, error) {
+	provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), roleARN, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = roleSessionName
+		if externalID != "" {
+			o.ExternalID = aws.String(externalID)
+		}
+	})
+
+	assumed := awsCfg.Copy()
+	assumed.Credentials = aws.NewCredentialsCache(provider, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = tokenLifetime
+	})
+	if _, err := assumed.Credentials.Retrieve(ctx); err != nil {
+		return aws.Config{}, fmt.Errorf("%w: failed to assume role %s: %w", ErrAuthToken, roleARN, err)
+	}
+	return assumed, nil
+}
+
+// profileSuffix names the profile in credential errors when one was given.
+func profileSuffix(profile string) string {
+	if profile == "" {
+		return ""
+	}
+	return fmt.Sprintf(" for profile %q", profile)
+}
+
+// GenerateAuthToken signs a short-lived DSQL IAM auth token for hostname
+// with the region and credentials in awsCfg. DSQL requires the
+// DbConnectAdmin action for the admin user and DbConnect for every other
+// role, so the caller must say which one it is connecting as.
+func GenerateAuthToken(ctx context.Context, awsCfg aws.Config, hostname string, admin bool) (string, error) {
+	return GenerateAuthTokenFor(ctx, awsCfg, hostname, admin, tokenLifetime)
+}
+
+// GenerateAuthTokenFor is GenerateAuthToken with the token valid for
+// lifetime, which must be between a second and MaxTokenLifetime.
+func GenerateAuthTokenFor(ctx context.Context, awsCfg aws.Config, hostname string, admin bool, lifetime time.Duration) (string, error) {
+	if awsCfg.Region == "" {
+		return "", errors.New("region is required to generate a DSQL auth token (set --region or AWS_REGION)")
+	}
+	if lifetime < time.Second || lifetime > MaxTokenLifetime {
+		return "", fmt.Errorf("token lifetime %s is outside DSQL's range of 1s to %s", lifetime, MaxTokenLifetime)
+	}
+
+	withLifetime := func(o *auth.TokenOptions) { o.ExpiresIn = lifetime }
+
+	var token string
+	var err error
+	if admin {
+		token, err = auth.GenerateDBConnectAdminAuthToken(ctx, hostname, awsCfg.Region, awsCfg.Credentials, withLifetime)
+	} else {
+		token, err = auth.GenerateDbConnectAuthToken(ctx, hostname, awsCfg.Region, awsCfg.Credentials, withLifetime)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to generate DSQL auth token: %w", err)
+	}
+
+	return token, nil
+}
+
+// authTokenInfo is what parseAuthToken reads from a presigned token.
+type authTokenInfo struct {
+	action    string
+	region    string
+	expiresAt time.Time
+}
+
+// parseAuthToken reads the action, signing region and expiry from a DSQL
+// auth token, which is a presigned URL without its scheme. ok is false when
+// token doesn't look like one. A zero expiresAt means the signing date or
+// lifetime couldn't be parsed.
+func parseAuthToken(token string) (info authTokenInfo, ok bool) {
+	u, err := url.Parse("https://" + token)
+	if err != nil {
+		return info, false
+	}
+	q := u.Query()
+	info.action = q.Get("Action")
+	if info.action == "" || q.Get("X-Amz-Signature") == "" {
+		return info, false
+	}
+
+	// The credential scope is <access key>/<date>/<region>/dsql/aws4_request
+	if scope := strings.Split(q.Get("X-Amz-Credential"), "/"); len(scope) >= 3 {
+		info.region = scope[2]
+	}
+	signed, dateErr := time.Parse("20060102T150405Z", q.Get("X-Amz-Date"))
+	seconds, expiresErr := strconv.Atoi(q.Get("X-Amz-Expires"))
+	if dateErr == nil && expiresErr == nil {
+		info.expiresAt = signed.Add(time.Duration(seconds) * time.Second)
+	}
+	return info, true
+}
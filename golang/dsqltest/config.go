@@ -0,0 +1,445 @@
+// Package dsqltest connects to Aurora DSQL clusters through SSH or SSM
+// tunnels. It dials the tunnel address while presenting the real cluster
+// hostname for TLS SNI, which DSQL requires to accept the connection.
+package dsqltest
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/net/proxy"
+)
+
+// Defaults used when the corresponding Config field is empty.
+const (
+	DefaultPort     = 5432
+	DefaultUser     = "admin"
+	DefaultDatabase = "postgres"
+	DefaultSSLMode  = "require"
+)
+
+// Config describes how to reach a DSQL cluster. Field names follow the
+// environment variables the CLI reads.
+type Config struct {
+	Hostname string // DSQL endpoint, used for SNI (HOSTNAME)
+	HostAddr string // tunnel address actually dialed, or a comma-separated list tried in order (PGHOSTADDR)
+	Port     int
+	User     string
+	Database string
+	SSLMode  string // require, verify-ca or verify-full (PGSSLMODE)
+	Password string // password or DSQL auth token (PGPASSWORD)
+
+	SSLRootCert string // PEM bundle used to verify the server certificate (PGSSLROOTCERT)
+	SSLCert     string // PEM client certificate, for proxies that require mTLS (PGSSLCERT)
+	SSLKey      string // private key of SSLCert (PGSSLKEY)
+
+	// SNIHostname, if set, is sent as the TLS server name in place of
+	// Hostname, for proxies and split-horizon DNS where the name the
+	// certificate expects differs from the one used to identify the cluster.
+	SNIHostname string
+
+	// VerifyNames, if set, are checked against the server certificate in
+	// place of the TLS server name, which is still sent for SNI. The
+	// certificate must be valid for at least one of them. It needs sslmode
+	// verify-full, for proxies whose certificate covers several names where
+	// the one to trust isn't the one the handshake is routed by.
+	VerifyNames []string
+
+	// NoSNIOverride leaves the TLS server name to pgx, which uses the
+	// address being dialed, instead of sending the DSQL hostname. DSQL
+	// rejects connections without its hostname as SNI, so this is only for
+	// telling SNI problems apart from other TLS failures.
+	NoSNIOverride bool
+
+	// InsecureSkipVerify accepts any server certificate, even with
+	// SSLRootCert set, while still sending the SNI name. It's a
+	// troubleshooting aid for broken certificate chains and can't be
+	// combined with verify-ca or verify-full.
+	InsecureSkipVerify bool
+
+	// TLS version bounds, e.g. tls.VersionTLS13. A zero minimum means TLS 1.2
+	// and a zero maximum means the highest version Go supports.
+	TLSMinVersion uint16
+	TLSMaxVersion uint16
+
+	// ApplicationName, if set, is sent as application_name so the session
+	// can be identified server-side (PGAPPNAME).
+	ApplicationName string
+
+	// SOCKS5Proxy, if set, is the host:port of a SOCKS5 proxy that every
+	// connection is dialed through. The proxy resolves the tunnel address,
+	// and TLS with the SNI override runs over the proxied connection.
+	SOCKS5Proxy string
+
+	// TCPKeepAlive, if positive, is the idle time before TCP keepalive probes
+	// start and the interval between them; negative disables keepalives and
+	// zero keeps pgx's default of 5 minutes. Keepalives stop idle tunnel and
+	// NAT connections being dropped, but DSQL still ends every connection
+	// at its maximum duration.
+	TCPKeepAlive time.Duration
+
+	// ConnectTimeout, if positive, bounds each connection attempt (TCP,
+	// TLS and authentication) separately from the caller's context, so a
+	// stalled attempt can be abandoned and retried (PGCONNECT_TIMEOUT).
+	ConnectTimeout time.Duration
+
+	// QueryExecMode, if set, replaces pgx's default of preparing and caching
+	// every statement, e.g. pgx.QueryExecModeSimpleProtocol.
+	QueryExecMode pgx.QueryExecMode
+
+	// ReadOnly starts the session with default_transaction_read_only on, so
+	// every transaction rejects writes.
+	ReadOnly bool
+
+	// SearchPath, if set, is sent as the session's search_path, e.g.
+	// "myschema,public", so unqualified names resolve in those schemas.
+	SearchPath string
+
+	// RuntimeParams are extra session parameters sent in the startup
+	// message, e.g. statement_timeout. They're applied last, so they
+	// override ApplicationName, ReadOnly and SearchPath.
+	RuntimeParams map[string]string
+
+	// Tokens, if set, supplies IAM auth tokens in place of Password.
+	Tokens *TokenProvider
+
+	// Demo relaxes the DSQL requirements for a local Postgres: sslmode
+	// disable is accepted and no password or token is needed, as with trust
+	// authentication.
+	Demo bool
+}
+
+// withDefaults returns c with empty fields set to their defaults.
+func (c Config) withDefaults() Config {
+	if c.Port == 0 {
+		c.Port = DefaultPort
+	}
+	if c.User == "" {
+		c.User = DefaultUser
+	}
+	if c.Database == "" {
+		c.Database = DefaultDatabase
+	}
+	if c.SSLMode == "" {
+		c.SSLMode = DefaultSSLMode
+	}
+	return c
+}
+
+// Validate reports the first setting that would prevent a connection.
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	if c.Hostname == "" {
+		return errors.New("hostname is required")
+	}
+	if len(c.HostAddrs()) == 0 {
+		return errors.New("hostaddr is required")
+	}
+	if c.Password == "" && c.Tokens == nil && !c.Demo {
+		return errors.New("a password or IAM token provider is required")
+	}
+	if err := ValidatePort(c.Port); err != nil {
+		return fmt.Errorf("invalid port %d: %w", c.Port, err)
+	}
+	return c.CheckSSLMode()
+}
+
+// CheckSSLMode validates c.SSLMode with ValidateSSLMode, except that Demo
+// also accepts disable.
+func (c Config) CheckSSLMode() error {
+	if c.Demo && c.SSLMode == "disable" {
+		return nil
+	}
+	return ValidateSSLMode(c.SSLMode)
+}
+
+// ParsePort parses a port given as text, such as PGPORT, ignoring
+// surrounding whitespace. Zero is rejected: unlike an unset Config.Port, a
+// port spelled out as 0 is a mistake rather than a request for the default.
+func ParsePort(s string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.New("not a number")
+	}
+	return port, ValidatePort(port)
+}
+
+// ValidatePort rejects a port outside the TCP range, which the uint16
+// conversion in ConnConfig would otherwise wrap into some other port.
+func ValidatePort(port int) error {
+	if port < 1 || port > 65535 {
+		return errors.New("must be a TCP port between 1 and 65535")
+	}
+	return nil
+}
+
+// ValidateSSLMode rejects sslmode values that can't work against DSQL,
+// which only accepts TLS-encrypted connections.
+func ValidateSSLMode(mode string) error {
+	switch mode {
+	case "require", "verify-ca", "verify-full":
+		return nil
+	case "disable", "allow", "prefer":
+		return fmt.Errorf("sslmode %q is not supported: DSQL mandates encrypted connections, use require, verify-ca or verify-full", mode)
+	default:
+		return fmt.Errorf("unknown sslmode %q: use require, verify-ca or verify-full", mode)
+	}
+}
+
+// ServerName returns the name sent for TLS SNI and, with verify-full,
+// checked against the server certificate. It's empty with NoSNIOverride,
+// when each address dialed is its own server name.
+func (c Config) ServerName() string {
+	if c.NoSNIOverride {
+		return ""
+	}
+	if c.SNIHostname != "" {
+		return c.SNIHostname
+	}
+	return c.Hostname
+}
+
+// queryExecModes maps --exec-mode names to pgx query execution modes.
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"simple":         pgx.QueryExecModeSimpleProtocol,
+	"exec":           pgx.QueryExecModeExec,
+	"prepared":       pgx.QueryExecModeDescribeExec,
+	"cache":          pgx.QueryExecModeCacheStatement,
+	"cache-describe": pgx.QueryExecModeCacheDescribe,
+}
+
+// ParseQueryExecMode converts simple, exec, prepared, cache or
+// cache-describe to the pgx mode. An empty name returns zero, which keeps
+// pgx's default.
+func ParseQueryExecMode(s string) (pgx.QueryExecMode, error) {
+	if s == "" {
+		return 0, nil
+	}
+	mode, ok := queryExecModes[s]
+	if !ok {
+		return 0, fmt.Errorf("unsupported query exec mode %q: use simple, exec, prepared, cache or cache-describe", s)
+	}
+	return mode, nil
+}
+
+// QueryExecModeName returns the name ParseQueryExecMode accepts for mode,
+// with zero reported as pgx's default.
+func QueryExecModeName(mode pgx.QueryExecMode) string {
+	for name, m := range queryExecModes {
+		if m == mode {
+			return name
+		}
+	}
+	return "cache (pgx default)"
+}
+
+// Address returns the host:port the tunnel is expected to listen on, or a
+// comma-separated list of them when HostAddr lists several. IPv6 literals
+// are bracketed, e.g. [::1]:5432.
+func (c Config) Address() string {
+	port := strconv.Itoa(c.withDefaults().Port)
+	addrs := c.HostAddrs()
+	for i, host := range addrs {
+		addrs[i] = net.JoinHostPort(host, port)
+	}
+	return strings.Join(addrs, ",")
+}
+
+// HostAddrs splits HostAddr into the addresses to try in order, without the
+// brackets of an already-bracketed IPv6 literal such as [::1].
+func (c Config) HostAddrs() []string {
+	var addrs []string
+	for _, a := range strings.Split(c.HostAddr, ",") {
+		a = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(a), "["), "]")
+		if a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// apply sets the connection fields of a pgx-parsed config directly from c,
+// replacing whatever pgx derived from its defaults and the libpq environment.
+// Nothing is interpolated into a connection string, so passwords and IAM
+// tokens containing /, + or = need no escaping.
+//
+// The TLS config is always built here rather than adjusted from the one pgx
+// parsed, which is nil when PGSSLMODE or a service file says disable. Every
+// sslmode this package accepts requires TLS, so the SNI name can't be lost
+// to a missing config; only Demo with sslmode disable connects in plaintext.
+func (c Config) apply(config *pgconn.Config) error {
+	var tlsConfig *tls.Config
+	if !c.Demo || c.SSLMode != "disable" {
+		var err error
+		if tlsConfig, err = newTLSConfig(c); err != nil {
+			return err
+		}
+	}
+	addrs := c.HostAddrs()
+	if len(addrs) == 0 {
+		return errors.New("hostaddr is required")
+	}
+	// Without the override each address gets the server name pgx itself
+	// would derive from it; Go sends none for an IP address
+	tlsFor := func(host string) *tls.Config {
+		if !c.NoSNIOverride || tlsConfig == nil {
+			return tlsConfig
+		}
+		cfg := tlsConfig.Clone()
+		cfg.ServerName = host
+		return cfg
+	}
+	config.Host = addrs[0]
+	config.Port = uint16(c.Port)
+	config.User = c.User
+	config.Password = c.Password
+	config.Database = c.Database
+	config.TLSConfig = tlsFor(addrs[0])
+	config.ConnectTimeout = c.ConnectTimeout
+	if err := c.applyDialer(config); err != nil {
+		return err
+	}
+	if config.RuntimeParams == nil {
+		config.RuntimeParams = make(map[string]string)
+	}
+	if c.ApplicationName != "" {
+		config.RuntimeParams["application_name"] = c.ApplicationName
+	}
+	if c.ReadOnly {
+		config.RuntimeParams["default_transaction_read_only"] = "on"
+	}
+	if c.SearchPath != "" {
+		config.RuntimeParams["search_path"] = c.SearchPath
+	}
+	maps.Copy(config.RuntimeParams, c.RuntimeParams)
+	// The only fallbacks are the other tunnel addresses, over TLS with the
+	// same SNI name; pgx's plaintext fallback for sslmode=prefer never applies
+	config.Fallbacks = nil
+	for _, host := range addrs[1:] {
+		config.Fallbacks = append(config.Fallbacks, &pgconn.FallbackConfig{Host: host, Port: config.Port, TLSConfig: tlsFor(host)})
+	}
+	return nil
+}
+
+// pgxKeepAlive is the keepalive of pgx's own dialer, kept for proxied
+// connections when TCPKeepAlive is zero.
+const pgxKeepAlive = 5 * time.Minute
+
+// applyDialer replaces pgx's dialer when c sets a keepalive or a SOCKS5
+// proxy. Through a proxy, host names are passed on unresolved so the proxy
+// looks them up on its side.
+func (c Config) applyDialer(config *pgconn.Config) error {
+	if c.SOCKS5Proxy == "" {
+		if c.TCPKeepAlive != 0 {
+			config.DialFunc = keepAliveDialer(c.TCPKeepAlive).DialContext
+		}
+		return nil
+	}
+	forward := &net.Dialer{KeepAlive: pgxKeepAlive}
+	if c.TCPKeepAlive != 0 {
+		forward = keepAliveDialer(c.TCPKeepAlive)
+	}
+	dialer, err := proxy.SOCKS5("tcp", c.SOCKS5Proxy, nil, forward)
+	if err != nil {
+		return fmt.Errorf("invalid SOCKS5 proxy %q: %w", c.SOCKS5Proxy, err)
+	}
+	config.DialFunc = dialer.(proxy.ContextDialer).DialContext
+	config.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+		return []string{host}, nil
+	}
+	return nil
+}
+
+// keepAliveDialer returns a dialer that probes idle connections every d,
+// or never when d is negative.
+func keepAliveDialer(d time.Duration) *net.Dialer {
+	if d < 0 {
+		return &net.Dialer{KeepAlive: -1}
+	}
+	return &net.Dialer{KeepAliveConfig: net.KeepAliveConfig{Enable: true, Idle: d, Interval: d}}
+}
+
+// ConnConfig builds a pgx config for the tunnel address with the DSQL TLS
+// overrides and, if a token provider is set, a current IAM token applied.
+func (c Config) ConnConfig(ctx context.Context) (*pgx.ConnConfig, error) {
+	c = c.withDefaults()
+	if err := c.CheckSSLMode(); err != nil {
+		return nil, err
+	}
+
+	// pgx requires configs to come from ParseConfig; start from an empty one
+	start := time.Now()
+	config, err := pgx.ParseConfig("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection config: %w", err)
+	}
+	logPhase(ctx, "parse_config", c.HostAddr, start)
+
+	// Set the tunnel address, credentials and the SNI hostname
+	start = time.Now()
+	if err := c.apply(&config.Config); err != nil {
+		return nil, err
+	}
+	logPhase(ctx, "set_sni", c.HostAddr, start, "server_name", c.ServerName(), "sni_override", !c.NoSNIOverride)
+	if c.QueryExecMode != 0 {
+		config.DefaultQueryExecMode = c.QueryExecMode
+	}
+
+	// Fill in a current IAM auth token as the password
+	if c.Tokens != nil {
+		if err := c.Tokens.BeforeConnect(ctx, config); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
+
+// logPhase emits a debug event for a completed connection phase.
+func logPhase(ctx context.Context, phase, hostaddr string, start time.Time, attrs ...any) {
+	args := append([]any{
+		"phase", phase,
+		"hostaddr", hostaddr,
+		"duration_ms", float64(time.Since(start).Microseconds()) / 1000,
+	}, attrs...)
+	slog.DebugContext(ctx, "connection phase complete", args...)
+}
+
+// Connect opens a single connection to the cluster described by cfg.
+func Connect(ctx context.Context, cfg Config) (*pgx.Conn, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	config, err := cfg.ConnConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return pgx.ConnectConfig(ctx, config)
+}
+
+// Ping connects to the cluster described by cfg, pings it and closes the
+// connection: a cheap check that the cluster is reachable and accepts the
+// credentials. A connect failure is returned as is; a failure after
+// connecting wraps ErrPingFailed.
+func Ping(ctx context.Context, cfg Config) error {
+	conn, err := Connect(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	if err := conn.Ping(ctx); err != nil {
+		return fmt.Errorf("%w: %w", ErrPingFailed, err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package dsqltest
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// testHostname is a cluster endpoint for tests that never connect.
+const testHostname = "abcdefghijklmnopqrstuvwxyz.dsql.us-east-1.on.aws"
+
+// baseConfig returns a Config that passes Validate, for tests to adjust.
+func baseConfig() Config {
+	return Config{Hostname: testHostname, HostAddr: "127.0.0.1", Password: "secret"}
+}
+
+// appliedConfig returns the pgx config c.apply produces from an empty
+// parsed one.
+func appliedConfig(t *testing.T, c Config) *pgconn.Config {
+	t.Helper()
+	parsed, err := pgx.ParseConfig("")
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if err := c.withDefaults().apply(&parsed.Config); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	return &parsed.Config
+}
+
+func TestValidateSSLMode(t *testing.T) {
+	tests := []struct {
+		mode    string
+		wantErr string // empty for a mode that's accepted
+	}{
+		{mode: "require"},
+		{mode: "verify-ca"},
+		{mode: "verify-full"},
+		{mode: "disable", wantErr: "DSQL mandates encrypted connections"},
+		{mode: "allow", wantErr: "DSQL mandates encrypted connections"},
+		{mode: "prefer", wantErr: "DSQL mandates encrypted connections"},
+		{mode: "", wantErr: "unknown sslmode"},
+		{mode: "verify_full", wantErr: "unknown sslmode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			err := ValidateSSLMode(tt.mode)
+			checkErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCheckSSLModeDemo(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{name: "demo disable", config: Config{SSLMode: "disable", Demo: true}},
+		{name: "demo require", config: Config{SSLMode: "require", Demo: true}},
+		{name: "demo prefer", config: Config{SSLMode: "prefer", Demo: true}, wantErr: "not supported"},
+		{name: "disable without demo", config: Config{SSLMode: "disable"}, wantErr: "not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkErr(t, tt.config.CheckSSLMode(), tt.wantErr)
+		})
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr string
+	}{
+		{in: "5432", want: 5432},
+		{in: " 5433 ", want: 5433},
+		{in: "65535", want: 65535},
+		{in: "0", wantErr: "between 1 and 65535"},
+		{in: "-1", wantErr: "between 1 and 65535"},
+		{in: "65536", wantErr: "between 1 and 65535"},
+		{in: "abc", wantErr: "not a number"},
+		{in: "", wantErr: "not a number"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePort(tt.in)
+			checkErr(t, err, tt.wantErr)
+			if tt.wantErr == "" && got != tt.want {
+				t.Errorf("ParsePort(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePort(t *testing.T) {
+	for port, valid := range map[int]bool{1: true, 5432: true, 65535: true, 0: false, -1: false, 65536: false} {
+		if err := ValidatePort(port); (err == nil) != valid {
+			t.Errorf("ValidatePort(%d) = %v, want valid %t", port, err, valid)
+		}
+	}
+}
+
+func TestAddressIPv6(t *testing.T) {
+	tests := []struct {
+		hostAddr  string
+		address   string
+		host      string
+		fallbacks []string
+	}{
+		{hostAddr: "::1", address: "[::1]:5432", host: "::1"},
+		{hostAddr: "[::1]", address: "[::1]:5432", host: "::1"},
+		{hostAddr: "2001:db8::1", address: "[2001:db8::1]:5432", host: "2001:db8::1"},
+		{hostAddr: "fe80::1%eth0", address: "[fe80::1%eth0]:5432", host: "fe80::1%eth0"},
+		{
+			hostAddr:  "127.0.0.1, [::1],2001:db8::1",
+			address:   "127.0.0.1:5432,[::1]:5432,[2001:db8::1]:5432",
+			host:      "127.0.0.1",
+			fallbacks: []string{"::1", "2001:db8::1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hostAddr, func(t *testing.T) {
+			c := baseConfig()
+			c.HostAddr = tt.hostAddr
+			if got := c.Address(); got != tt.address {
+				t.Errorf("Address() = %q, want %q", got, tt.address)
+			}
+			config := appliedConfig(t, c)
+			if config.Host != tt.host {
+				t.Errorf("config.Host = %q, want %q", config.Host, tt.host)
+			}
+			var fallbacks []string
+			for _, fb := range config.Fallbacks {
+				fallbacks = append(fallbacks, fb.Host)
+			}
+			if !slices.Equal(fallbacks, tt.fallbacks) {
+				t.Errorf("fallback hosts = %q, want %q", fallbacks, tt.fallbacks)
+			}
+		})
+	}
+}
+
+func TestConnConfigPasswordVerbatim(t *testing.T) {
+	for name, password := range map[string]string{
+		"base64 characters": "a+b/c=d==",
+		"percent-encoded":   "already%2Fencoded%2Btoken%3D",
+		"mixed":             "mixed+/=%2F%25 quote' backslash\\",
+		"presigned token":   testToken,
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := baseConfig()
+			c.Password = password
+			config, err := c.ConnConfig(context.Background())
+			if err != nil {
+				t.Fatalf("ConnConfig: %v", err)
+			}
+			if config.Password != password {
+				t.Errorf("config.Password = %q, want %q byte for byte", config.Password, password)
+			}
+		})
+	}
+}
+
+// checkErr fails the test unless err contains wantErr, or is nil when
+// wantErr is empty.
+func checkErr(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr == "" && err != nil:
+		t.Fatalf("unexpected error: %v", err)
+	case wantErr != "" && err == nil:
+		t.Fatalf("expected an error containing %q, got nil", wantErr)
+	case wantErr != "" && !strings.Contains(err.Error(), wantErr):
+		t.Fatalf("error %q does not contain %q", err, wantErr)
+	}
+}
@@ -0,0 +1,111 @@
+package dsqltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dsql"
+	"github.com/aws/aws-sdk-go-v2/service/dsql/types"
+)
+
+// ErrListDenied wraps a ListClusters call the caller's credentials aren't
+// allowed to make.
+var ErrListDenied = errors.New("not authorized to list DSQL clusters")
+
+// ClusterHostname returns the public endpoint of the cluster with the given
+// identifier in region, which is also the name its certificate covers.
+func ClusterHostname(identifier, region string) string {
+	return identifier + ".dsql." + region + ".on.aws"
+}
+
+// ValidateClusterID rejects strings that can't be a DSQL cluster
+// identifier, which is 26 lowercase letters and digits.
+func ValidateClusterID(id string) error {
+	if strings.Contains(id, ".") {
+		return fmt.Errorf("cluster id %q looks like a hostname; pass just the identifier before .dsql", id)
+	}
+	if len(id) != clusterIDLength {
+		return fmt.Errorf("cluster id %q should be %d characters long, got %d", id, clusterIDLength, len(id))
+	}
+	for _, r := range id {
+		if !('a' <= r && r <= 'z' || '0' <= r && r <= '9') {
+			return fmt.Errorf("cluster id %q should contain only lowercase letters and digits", id)
+		}
+	}
+	return nil
+}
+
+// clusterIDLength is the length of every DSQL cluster identifier.
+const clusterIDLength = 26
+
+// RegionFromHostname returns the region of a cluster endpoint named like
+// ClusterHostname, or "" when hostname isn't one.
+func RegionFromHostname(hostname string) string {
+	rest, ok := strings.CutSuffix(strings.TrimSuffix(hostname, "."), ".on.aws")
+	if !ok {
+		return ""
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[1] != "dsql" {
+		return ""
+	}
+	return parts[2]
+}
+
+// ListClusters returns the identifiers of every cluster in awsCfg's region,
+// following ListClusters pagination to the end.
+func ListClusters(ctx context.Context, awsCfg aws.Config) ([]string, error) {
+	client := dsql.NewFromConfig(awsCfg)
+	paginator := dsql.NewListClustersPaginator(client, &dsql.ListClustersInput{}, func(o *dsql.ListClustersPaginatorOptions) {
+		o.StopOnDuplicateToken = true
+	})
+
+	var ids []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			var denied *types.AccessDeniedException
+			if errors.As(err, &denied) {
+				return nil, fmt.Errorf("%w in %s (needs dsql:ListClusters): %w", ErrListDenied, awsCfg.Region, err)
+			}
+			return nil, fmt.Errorf("failed to list DSQL clusters in %s: %w", awsCfg.Region, err)
+		}
+		for _, c := range page.Clusters {
+			ids = append(ids, aws.ToString(c.Identifier))
+		}
+	}
+	return ids, nil
+}
+
+// ClusterIDFromHostname returns the identifier of a cluster endpoint named
+// like ClusterHostname, or "" when hostname isn't one.
+func ClusterIDFromHostname(hostname string) string {
+	if RegionFromHostname(hostname) == "" {
+		return ""
+	}
+	id, _, _ := strings.Cut(hostname, ".")
+	return id
+}
+
+// ClusterStatus returns the control-plane status of the cluster with the
+// given identifier, such as CREATING or ACTIVE. It needs dsql:GetCluster.
+func ClusterStatus(ctx context.Context, awsCfg aws.Config, identifier string) (string, error) {
+	out, err := dsql.NewFromConfig(awsCfg).GetCluster(ctx, &dsql.GetClusterInput{Identifier: aws.String(identifier)})
+	if err != nil {
+		return "", fmt.Errorf("failed to get status of DSQL cluster %s: %w", identifier, err)
+	}
+	return string(out.Status), nil
+}
+
+// IsProvisioningStatus reports whether status belongs to a cluster that is
+// still being set up and isn't expected to accept connections yet.
+func IsProvisioningStatus(status string) bool {
+	switch types.ClusterStatus(status) {
+	case types.ClusterStatusCreating, types.ClusterStatusPendingSetup:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package dsqltest
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials/ssocreds"
+	"github.com/aws/smithy-go"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrAuthToken wraps failures to generate an IAM auth token, such as missing
+// AWS credentials or region.
+var ErrAuthToken = errors.New("failed to generate IAM auth token")
+
+// ErrTokenTimeout wraps a token generation that ran past the provider's
+// timeout, typically while fetching credentials from SSO, STS or IMDS.
+var ErrTokenTimeout = errors.New("token generation timed out")
+
+// ErrCredentialsRejected wraps a token generation that failed because AWS
+// turned down the credentials themselves, which retrying can't fix.
+var ErrCredentialsRejected = errors.New("AWS credentials are expired or invalid")
+
+// ErrPingFailed wraps a ping that failed after the connection was
+// established, as opposed to a failure to connect at all.
+var ErrPingFailed = errors.New("connected but ping failed")
+
+// IsAuthError reports whether err is an authentication failure: either the
+// auth token couldn't be generated or the server rejected the credentials
+// (SQLSTATE class 28, invalid authorization specification).
+func IsAuthError(err error) bool {
+	if errors.Is(err, ErrAuthToken) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "28")
+}
+
+// AuthError is the server rejecting the credentials a connection presented,
+// along with what could be read back from the password when it was a DSQL
+// auth token. Expiry, region and action are the usual reasons DSQL turns a
+// token down, and none of them appear in the server's message.
+type AuthError struct {
+	Err      error
+	User     string
+	Hostname string
+
+	// Token reports whether the password parsed as a DSQL auth token; the
+	// fields below are only set when it did.
+	Token     bool
+	Action    string // DbConnect or DbConnectAdmin
+	Region    string // region the token was signed for
+	ExpiresAt time.Time
+}
+
+func (e *AuthError) Error() string { return e.Err.Error() }
+func (e *AuthError) Unwrap() error { return e.Err }
+
+// WrapAuthError returns err as an *AuthError when the server rejected the
+// credentials (SQLSTATE class 28), describing the token c presented. Other
+// errors, including failures to generate a token, are returned unchanged.
+func (c Config) WrapAuthError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || !strings.HasPrefix(pgErr.Code, "28") {
+		return err
+	}
+	var authErr *AuthError
+	if errors.As(err, &authErr) {
+		return err
+	}
+
+	password := c.Password
+	if c.Tokens != nil {
+		password = c.Tokens.cachedToken()
+	}
+	authErr = &AuthError{Err: err, User: c.User, Hostname: c.Hostname}
+	if t, ok := parseAuthToken(password); ok {
+		authErr.Token = true
+		authErr.Action = t.action
+		authErr.Region = t.region
+		authErr.ExpiresAt = t.expiresAt
+	}
+	return authErr
+}
+
+// IsConnLimitExceeded reports whether the server refused the session
+// because it already has as many connections as it allows: SQLSTATE 53300
+// (too_many_connections) or 53400 (configuration_limit_exceeded).
+func IsConnLimitExceeded(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && (pgErr.Code == "53300" || pgErr.Code == "53400") {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "too many connections") ||
+		strings.Contains(msg, "connection limit")
+}
+
+// IsThrottled reports whether the server refused the session because a
+// connection or rate limit was reached, as happens while a cluster scales.
+func IsThrottled(err error) bool {
+	if IsConnLimitExceeded(err) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "rate exceeded") ||
+		strings.Contains(msg, "throttl")
+}
+
+// rejectedCredentialCodes are the AWS error codes for credentials that are
+// expired, malformed or unknown to IAM.
+var rejectedCredentialCodes = map[string]bool{
+	"ExpiredToken":                true,
+	"ExpiredTokenException":       true,
+	"InvalidClientTokenId":        true,
+	"UnrecognizedClientException": true,
+	"SignatureDoesNotMatch":       true,
+	"IncompleteSignature":         true,
+	"InvalidIdentityToken":        true,
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+}
+
+// isCredentialRejected reports whether err is AWS refusing the credentials
+// being resolved, including an SSO session that has to be logged in again,
+// as opposed to a request that was throttled or failed along the way.
+func isCredentialRejected(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && rejectedCredentialCodes[apiErr.ErrorCode()] {
+		return true
+	}
+	var ssoErr *ssocreds.InvalidTokenError
+	return errors.As(err, &ssoErr)
+}
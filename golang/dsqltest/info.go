@@ -0,0 +1,110 @@
+package dsqltest
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ConnectionInfo holds the values returned by the connection info query.
+// Unavailable lists the fields the server couldn't provide, by the names in
+// InfoFields; those are left empty.
+type ConnectionInfo struct {
+	Database        string
+	User            string
+	ServerVersion   string
+	ApplicationName string
+
+	Unavailable []string
+}
+
+// RowQuerier is satisfied by *pgx.Conn, *pgxpool.Conn, *pgxpool.Pool and
+// pgx.Tx.
+type RowQuerier interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// ConnectionInfoSQL is the DSQL-compatible connection info query. DSQL
+// doesn't support inet_server_addr(), inet_server_port() or ssl_is_used().
+const ConnectionInfoSQL = `
+		SELECT
+			current_database() as database,
+			current_user as user,
+			version() as server_version,
+			current_setting('application_name') as application_name
+	`
+
+// InfoFields are the columns of ConnectionInfoSQL, each with a query that
+// fetches it alone.
+var InfoFields = []struct {
+	Name string
+	SQL  string
+}{
+	{"database", "SELECT current_database()"},
+	{"user", "SELECT current_user"},
+	{"server_version", "SELECT version()"},
+	{"application_name", "SELECT current_setting('application_name')"},
+}
+
+// fields returns pointers to the info values in InfoFields order.
+func (info *ConnectionInfo) fields() []*string {
+	return []*string{&info.Database, &info.User, &info.ServerVersion, &info.ApplicationName}
+}
+
+// QueryConnectionInfo runs the connection info query. A NULL column is
+// reported as unavailable. If the server rejects the query, or a column
+// can't be read, each field is fetched on its own instead, so one
+// unsupported function doesn't hide the rest. An error is returned only
+// when nothing could be fetched or the connection itself failed. The
+// fallback needs a connection, not a transaction, which the first failure
+// would abort.
+func QueryConnectionInfo(ctx context.Context, q RowQuerier) (ConnectionInfo, error) {
+	var info ConnectionInfo
+	values := make([]*string, len(InfoFields))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err := q.QueryRow(ctx, ConnectionInfoSQL).Scan(dest...)
+	if err == nil {
+		for i, field := range info.fields() {
+			if values[i] == nil {
+				info.Unavailable = append(info.Unavailable, InfoFields[i].Name)
+				continue
+			}
+			*field = *values[i]
+		}
+		return info, nil
+	}
+	if !isFieldError(err) {
+		return info, err
+	}
+
+	firstErr := err
+	for i, field := range info.fields() {
+		var v *string
+		err := q.QueryRow(ctx, InfoFields[i].SQL).Scan(&v)
+		if err != nil && !isFieldError(err) {
+			return info, err
+		}
+		if err != nil || v == nil {
+			info.Unavailable = append(info.Unavailable, InfoFields[i].Name)
+			continue
+		}
+		*field = *v
+	}
+	if len(info.Unavailable) == len(InfoFields) {
+		return info, firstErr
+	}
+	return info, nil
+}
+
+// isFieldError reports whether err is the server rejecting a query or a
+// value that couldn't be scanned, rather than a broken connection.
+func isFieldError(err error) bool {
+	var pgErr *pgconn.PgError
+	var scanErr pgx.ScanArgError
+	return errors.As(err, &pgErr) || errors.As(err, &scanErr)
+}
@@ -0,0 +1,121 @@
+package dsqltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// DefaultPoolMaxConnLifetime recycles pooled connections before DSQL's
+// 60-minute connection-duration cap closes them server-side.
+const DefaultPoolMaxConnLifetime = 55 * time.Minute
+
+// PoolOptions are the pool sizing settings. Zero values leave the pgxpool
+// defaults in place.
+type PoolOptions struct {
+	MaxConns        int
+	MinConns        int
+	MaxConnLifetime time.Duration
+}
+
+// PoolConfig builds a pgxpool config with the DSQL SNI override applied to
+// every pooled connection and, if cfg has a token provider, a fresh IAM
+// token fetched before each new connection.
+func PoolConfig(cfg Config, opts PoolOptions) (*pgxpool.Config, error) {
+	cfg = cfg.withDefaults()
+	if err := cfg.CheckSSLMode(); err != nil {
+		return nil, err
+	}
+	poolConfig, err := pgxpool.ParseConfig("")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse pool config: %w", err)
+	}
+
+	// Set the tunnel address, credentials and SNI hostname for pool connections
+	if err := cfg.apply(&poolConfig.ConnConfig.Config); err != nil {
+		return nil, err
+	}
+
+	if cfg.QueryExecMode != 0 {
+		poolConfig.ConnConfig.DefaultQueryExecMode = cfg.QueryExecMode
+	}
+
+	// Each new pooled connection gets a current IAM auth token
+	if cfg.Tokens != nil {
+		poolConfig.BeforeConnect = cfg.Tokens.BeforeConnect
+	}
+
+	if opts.MaxConns > 0 {
+		poolConfig.MaxConns = int32(opts.MaxConns)
+	}
+	if opts.MinConns > 0 {
+		poolConfig.MinConns = int32(opts.MinConns)
+	}
+	if opts.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if poolConfig.MinConns > poolConfig.MaxConns {
+		return nil, fmt.Errorf("pool min conns (%d) exceeds max conns (%d)", poolConfig.MinConns, poolConfig.MaxConns)
+	}
+
+	return poolConfig, nil
+}
+
+// ConnectPool creates a connection pool for the cluster described by cfg.
+func ConnectPool(ctx context.Context, cfg Config, opts PoolOptions) (*pgxpool.Pool, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	poolConfig, err := PoolConfig(cfg, opts)
+	if err != nil {
+		return nil, err
+	}
+	return pgxpool.NewWithConfig(ctx, poolConfig)
+}
+
+// PrewarmResult is how many of the pool's MinConns connections PrewarmPool
+// established, and how long it took.
+type PrewarmResult struct {
+	Requested   int
+	Established int
+	Duration    time.Duration
+}
+
+// PrewarmPool opens the pool's MinConns connections before it's used, so a
+// measurement doesn't include cold connects. All of them are acquired at
+// once, since acquiring one at a time would keep reusing the first, and then
+// released back to the pool as idle connections. Failed acquires are
+// joined into the returned error.
+func PrewarmPool(ctx context.Context, pool *pgxpool.Pool) (PrewarmResult, error) {
+	n := int(pool.Config().MinConns)
+	result := PrewarmResult{Requested: n}
+	start := time.Now()
+
+	conns := make([]*pgxpool.Conn, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], errs[i] = pool.Acquire(ctx)
+		}(i)
+	}
+	wg.Wait()
+	result.Duration = time.Since(start)
+
+	for _, conn := range conns {
+		if conn != nil {
+			result.Established++
+			conn.Release()
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return result, fmt.Errorf("established %d of %d pool connections: %w", result.Established, n, err)
+	}
+	return result, nil
+}
@@ -0,0 +1,170 @@
+package dsqltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Default retry policy for ConnectWithRetry.
+const (
+	DefaultRetries        = 3
+	DefaultRetryBaseDelay = 500 * time.Millisecond
+	DefaultMaxBackoff     = 20 * time.Second
+)
+
+// throttleBackoffFactor stretches the base delay after a throttled attempt:
+// a cluster that is scaling needs longer to accept sessions than a tunnel
+// needs to recover from a dropped packet.
+const throttleBackoffFactor = 4
+
+// Retry categories logged with each retry.
+const (
+	retryNetwork   = "network"
+	retryThrottled = "throttled"
+)
+
+// RetryPolicy controls how ConnectWithRetry retries failed connects.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts, at least 1
+	BaseDelay   time.Duration // delay after the first network failure, doubled per attempt
+	MaxDelay    time.Duration // cap on any single delay (default DefaultMaxBackoff)
+
+	// OnRetry, if set, is called before waiting delay to retry the failed
+	// attempt (1-based), e.g. to count retries across a run.
+	OnRetry func(attempt int, delay time.Duration, err error)
+}
+
+// ConnectWithRetry connects using config, retrying transient network and TLS
+// failures with exponential backoff and jitter. Throttling responses from a
+// cluster at its connection or rate limit are retried with a longer backoff.
+// Authentication failures are returned immediately since retrying them
+// cannot succeed. The returned error wraps the error from every attempt.
+func ConnectWithRetry(ctx context.Context, config *pgx.ConnConfig, policy RetryPolicy) (*pgx.Conn, error) {
+	maxAttempts := max(policy.MaxAttempts, 1)
+	maxDelay := policy.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxBackoff
+	}
+
+	var attemptErrs []error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		conn, err := pgx.ConnectConfig(ctx, config)
+		if err == nil {
+			return conn, nil
+		}
+		attemptErrs = append(attemptErrs, fmt.Errorf("attempt %d: %w", attempt, err))
+
+		category := retryCategory(err)
+		if attempt == maxAttempts || category == "" || ctx.Err() != nil {
+			break
+		}
+
+		base := policy.BaseDelay
+		if category == retryThrottled {
+			base *= throttleBackoffFactor
+		}
+		delay := backoffDelay(base, maxDelay, attempt)
+		slog.Warn("connect attempt failed",
+			"attempt", attempt, "max_attempts", maxAttempts, "category", category,
+			"retry_in", delay.Round(time.Millisecond).String(), "error", err)
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, delay, err)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			attemptErrs = append(attemptErrs, ctx.Err())
+			return nil, fmt.Errorf("connect failed after %d attempt(s): %w", attempt, errors.Join(attemptErrs...))
+		}
+	}
+
+	return nil, fmt.Errorf("connect failed after %d attempt(s): %w", len(attemptErrs), errors.Join(attemptErrs...))
+}
+
+// Backoff returns the delay p waits before the given attempt (1-based):
+// BaseDelay doubled per attempt up to MaxDelay, with jitter.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DefaultMaxBackoff
+	}
+	return backoffDelay(p.BaseDelay, maxDelay, attempt)
+}
+
+// backoffDelay returns an exponentially growing delay for the given attempt
+// (1-based), capped at maxDelay, with jitter so that parallel clients don't
+// retry in lockstep.
+func backoffDelay(base, maxDelay time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+	// Pick uniformly from [delay/2, delay)
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + rand.N(half)
+}
+
+// retryCategory returns retryThrottled or retryNetwork for an error worth
+// retrying, or "" for a permanent one.
+func retryCategory(err error) string {
+	// Rejected credentials won't be accepted on a second try, and a token
+	// that couldn't be generated was already retried by the SDK
+	if IsAuthError(err) {
+		return ""
+	}
+	if IsThrottled(err) {
+		return retryThrottled
+	}
+	if isRetryableConnectError(err) {
+		return retryNetwork
+	}
+	return ""
+}
+
+// isRetryableConnectError reports whether err looks like a transient
+// connection problem (refused, DNS, timeouts, dropped tunnel) rather than a
+// permanent one such as bad credentials.
+func isRetryableConnectError(err error) bool {
+	// The server answered: auth and other SQL errors won't fix themselves
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+
+	// Covers dial and TLS handshake timeouts
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// A tunnel that accepts the TCP connection but can't reach DSQL closes it immediately
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,44 @@
+package dsqltest
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// redactedPassword replaces credentials in anything that may be logged.
+const redactedPassword = "****"
+
+// passwordParamRe matches a password=... URL query parameter, which ends
+// at the next &.
+var passwordParamRe = regexp.MustCompile(`(?i)(password=)('(?:[^'\\]|\\.)*'|[^\s&]*)`)
+
+// passwordKeywordRe matches password=... in a keyword/value connection
+// string, where an unquoted value runs to the next space: an IAM token
+// pasted there unescaped has & of its own.
+var passwordKeywordRe = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// userinfoPasswordRe matches the password in a URL's user:password@ part,
+// for strings url.Parse rejects.
+var userinfoPasswordRe = regexp.MustCompile(`(://[^:/@\s]*:)[^@\s]*@`)
+
+// SanitizeConnString returns connStr with any password replaced by ****.
+// DSQL passwords are IAM tokens that can be replayed until they expire, so
+// they must never reach logs.
+func SanitizeConnString(connStr string) string {
+	if u, err := url.Parse(connStr); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			// Rebuild without the password, then splice in the placeholder
+			// so it isn't percent-encoded
+			u.User = url.User(u.User.Username())
+			user := u.User.String()
+			connStr = strings.Replace(u.String(), user+"@", user+":"+redactedPassword+"@", 1)
+		}
+	} else if err != nil {
+		connStr = userinfoPasswordRe.ReplaceAllString(connStr, "${1}"+redactedPassword+"@")
+	}
+	if !strings.Contains(connStr, "://") {
+		return passwordKeywordRe.ReplaceAllString(connStr, "${1}"+redactedPassword)
+	}
+	return passwordParamRe.ReplaceAllString(connStr, "${1}"+redactedPassword)
+}
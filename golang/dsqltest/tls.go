@@ -0,0 +1,183 @@
+package dsqltest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// newTLSConfig builds the TLS config for opts.SSLMode the way libpq and pgx
+// interpret it, with the SNI server name set to the DSQL hostname (or its
+// override) rather than the tunnel address.
+func newTLSConfig(opts Config) (*tls.Config, error) {
+	// Set the SNI hostname to the actual DSQL hostname - this is crucial for DSQL
+	cfg := &tls.Config{
+		ServerName: opts.ServerName(),
+		MinVersion: max(opts.TLSMinVersion, tls.VersionTLS12),
+		MaxVersion: opts.TLSMaxVersion,
+	}
+
+	// A client certificate is offered whenever the server asks for one,
+	// whatever the verification mode
+	if opts.SSLCert != "" || opts.SSLKey != "" {
+		cert, err := loadClientCert(opts.SSLCert, opts.SSLKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(opts.VerifyNames) > 0 && opts.SSLMode != "verify-full" {
+		return nil, fmt.Errorf("verifying the certificate against specific names needs sslmode verify-full, not %s", opts.SSLMode)
+	}
+	if opts.InsecureSkipVerify {
+		if opts.SSLMode != "require" {
+			return nil, fmt.Errorf("skipping certificate verification contradicts sslmode %s: use require", opts.SSLMode)
+		}
+		cfg.InsecureSkipVerify = true
+		return cfg, nil
+	}
+
+	if opts.SSLRootCert != "" {
+		pool, err := loadCertPool(opts.SSLRootCert)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	switch opts.SSLMode {
+	case "require":
+		// As in libpq, require with a root CA bundle behaves like verify-ca
+		if cfg.RootCAs == nil {
+			cfg.InsecureSkipVerify = true
+			break
+		}
+		fallthrough
+	case "verify-ca":
+		// Check the chain ourselves so the hostname isn't compared
+		cfg.InsecureSkipVerify = true
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyChain(rawCerts, cfg.RootCAs)
+		}
+	case "verify-full":
+		// Standard verification checks the chain and that the certificate
+		// matches the server name set above, unless other names are to be
+		// checked instead
+		if len(opts.VerifyNames) > 0 {
+			cfg.InsecureSkipVerify = true
+			cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if err := verifyChain(rawCerts, cfg.RootCAs); err != nil {
+					return err
+				}
+				leaf, err := x509.ParseCertificate(rawCerts[0])
+				if err != nil {
+					return fmt.Errorf("failed to parse certificate from server: %w", err)
+				}
+				_, err = MatchVerifyName(leaf, opts.VerifyNames)
+				return err
+			}
+		}
+	default:
+		return nil, ValidateSSLMode(opts.SSLMode)
+	}
+
+	return cfg, nil
+}
+
+// ParseTLSVersion converts "1.2" or "1.3" to the crypto/tls version
+// constant.
+func ParseTLSVersion(s string) (uint16, error) {
+	switch s {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version %q: use 1.2 or 1.3", s)
+	}
+}
+
+// IsTLSVersionError reports whether err is a handshake that failed because
+// client and server share no TLS version, e.g. a TLS 1.3 floor the server
+// won't meet.
+func IsTLSVersionError(err error) bool {
+	// Covers the server's protocol_version alert and Go rejecting the
+	// version the server selected
+	return err != nil && strings.Contains(err.Error(), "protocol version")
+}
+
+// IsHostnameMismatch reports whether err is a verify-full handshake that
+// failed because the certificate isn't valid for the SNI server name.
+func IsHostnameMismatch(err error) bool {
+	var hostErr x509.HostnameError
+	return errors.As(err, &hostErr)
+}
+
+// MatchVerifyName returns the first of names that cert is valid for. If
+// there's none, the error wraps the x509.HostnameError for the first name.
+func MatchVerifyName(cert *x509.Certificate, names []string) (string, error) {
+	var firstErr error
+	for _, name := range names {
+		err := cert.VerifyHostname(name)
+		if err == nil {
+			return name, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	return "", fmt.Errorf("server certificate is not valid for any of %s: %w", strings.Join(names, ", "), firstErr)
+}
+
+// verifyChain verifies the server's certificate chain against roots (the
+// system pool when nil) without checking the hostname.
+func verifyChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("server presented no certificate")
+	}
+	verifyOpts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+	var leaf *x509.Certificate
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate from server: %w", err)
+		}
+		if i == 0 {
+			leaf = cert
+			continue
+		}
+		verifyOpts.Intermediates.AddCert(cert)
+	}
+	_, err := leaf.Verify(verifyOpts)
+	return err
+}
+
+// loadCertPool reads a PEM bundle such as the Amazon root CAs.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sslrootcert: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("sslrootcert contains no valid PEM certificates")
+	}
+	return pool, nil
+}
+
+// loadClientCert reads the PEM certificate and key, which must be given
+// together and belong to each other.
+func loadClientCert(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return tls.Certificate{}, errors.New("sslcert and sslkey must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	return cert, nil
+}
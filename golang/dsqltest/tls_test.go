@@ -0,0 +1,214 @@
+package dsqltest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// writeTestCA writes a self-signed CA certificate as PEM and returns its
+// path, for tests that need an sslrootcert.
+func writeTestCA(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dsqltest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "root.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+	return path
+}
+
+func TestConnConfigTLS(t *testing.T) {
+	rootCert := writeTestCA(t)
+	tests := []struct {
+		name        string
+		sslMode     string
+		rootCert    bool
+		sniHostname string
+
+		wantServerName string
+		wantInsecure   bool // crypto/tls skips its own verification
+		wantRootCAs    bool // a custom pool rather than the system roots
+		wantVerifier   bool // the chain is checked in VerifyPeerCertificate
+	}{
+		{name: "require", sslMode: "require", wantServerName: testHostname, wantInsecure: true},
+		{name: "require with root cert", sslMode: "require", rootCert: true, wantServerName: testHostname, wantInsecure: true, wantRootCAs: true, wantVerifier: true},
+		{name: "verify-ca", sslMode: "verify-ca", wantServerName: testHostname, wantInsecure: true, wantVerifier: true},
+		{name: "verify-ca with root cert", sslMode: "verify-ca", rootCert: true, wantServerName: testHostname, wantInsecure: true, wantRootCAs: true, wantVerifier: true},
+		{name: "verify-full", sslMode: "verify-full", wantServerName: testHostname},
+		{name: "verify-full with root cert", sslMode: "verify-full", rootCert: true, wantServerName: testHostname, wantRootCAs: true},
+		{name: "verify-full with sni override", sslMode: "verify-full", sniHostname: "proxy.example.com", wantServerName: "proxy.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := baseConfig()
+			c.SSLMode = tt.sslMode
+			c.SNIHostname = tt.sniHostname
+			if tt.rootCert {
+				c.SSLRootCert = rootCert
+			}
+			config, err := c.ConnConfig(context.Background())
+			if err != nil {
+				t.Fatalf("ConnConfig: %v", err)
+			}
+			tlsConfig := config.TLSConfig
+			if tlsConfig == nil {
+				t.Fatal("TLSConfig is nil")
+			}
+			if tlsConfig.ServerName != tt.wantServerName {
+				t.Errorf("ServerName = %q, want %q", tlsConfig.ServerName, tt.wantServerName)
+			}
+			if tlsConfig.InsecureSkipVerify != tt.wantInsecure {
+				t.Errorf("InsecureSkipVerify = %t, want %t", tlsConfig.InsecureSkipVerify, tt.wantInsecure)
+			}
+			if got := tlsConfig.RootCAs != nil; got != tt.wantRootCAs {
+				t.Errorf("RootCAs set = %t, want %t", got, tt.wantRootCAs)
+			}
+			if got := tlsConfig.VerifyPeerCertificate != nil; got != tt.wantVerifier {
+				t.Errorf("VerifyPeerCertificate set = %t, want %t", got, tt.wantVerifier)
+			}
+		})
+	}
+}
+
+func TestConnConfigRejectsDisable(t *testing.T) {
+	if err := ValidateSSLMode("disable"); err == nil {
+		t.Fatal("ValidateSSLMode(\"disable\") = nil, want an error")
+	}
+	c := baseConfig()
+	c.SSLMode = "disable"
+	if _, err := c.ConnConfig(context.Background()); err == nil {
+		t.Fatal("ConnConfig with sslmode disable succeeded, want an error")
+	}
+}
+
+// TestConnConfigTLSWithPGSSLModeDisable checks the SNI name survives when
+// the environment makes pgx parse a nil TLS config.
+func TestConnConfigTLSWithPGSSLModeDisable(t *testing.T) {
+	t.Setenv("PGSSLMODE", "disable")
+	parsed, err := pgx.ParseConfig("")
+	if err != nil {
+		t.Fatalf("ParseConfig: %v", err)
+	}
+	if parsed.TLSConfig != nil {
+		t.Fatal("pgx parsed a TLS config with PGSSLMODE=disable; the test no longer covers the nil case")
+	}
+
+	c := baseConfig()
+	c.SSLMode = "require"
+	c.HostAddr = "127.0.0.1,127.0.0.2,::1"
+	config, err := c.ConnConfig(context.Background())
+	if err != nil {
+		t.Fatalf("ConnConfig: %v", err)
+	}
+	if len(config.Fallbacks) != 2 {
+		t.Fatalf("got %d fallbacks, want 2", len(config.Fallbacks))
+	}
+	configs := []*tls.Config{config.TLSConfig}
+	for _, fb := range config.Fallbacks {
+		configs = append(configs, fb.TLSConfig)
+	}
+	for i, cfg := range configs {
+		if cfg == nil {
+			t.Errorf("TLS config %d is nil", i)
+			continue
+		}
+		if cfg.ServerName != testHostname {
+			t.Errorf("TLS config %d: ServerName = %q, want %q", i, cfg.ServerName, testHostname)
+		}
+	}
+}
+
+func TestConnConfigTLSVersion(t *testing.T) {
+	tests := []struct {
+		name          string
+		minVersion    uint16
+		maxVersion    uint16
+		noSNIOverride bool
+		wantMin       uint16
+		wantMax       uint16
+	}{
+		{name: "default", wantMin: tls.VersionTLS12},
+		{name: "explicit 1.2", minVersion: tls.VersionTLS12, wantMin: tls.VersionTLS12},
+		{name: "minimum 1.3", minVersion: tls.VersionTLS13, wantMin: tls.VersionTLS13},
+		{name: "tls13 only", minVersion: tls.VersionTLS13, maxVersion: tls.VersionTLS13, wantMin: tls.VersionTLS13, wantMax: tls.VersionTLS13},
+		{name: "tls13 only without sni override", minVersion: tls.VersionTLS13, maxVersion: tls.VersionTLS13, noSNIOverride: true, wantMin: tls.VersionTLS13, wantMax: tls.VersionTLS13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := baseConfig()
+			c.HostAddr = "127.0.0.1,127.0.0.2,::1"
+			c.TLSMinVersion = tt.minVersion
+			c.TLSMaxVersion = tt.maxVersion
+			c.NoSNIOverride = tt.noSNIOverride
+			config, err := c.ConnConfig(context.Background())
+			if err != nil {
+				t.Fatalf("ConnConfig: %v", err)
+			}
+			if len(config.Fallbacks) != 2 {
+				t.Fatalf("got %d fallbacks, want 2", len(config.Fallbacks))
+			}
+			configs := []*tls.Config{config.TLSConfig}
+			for _, fb := range config.Fallbacks {
+				configs = append(configs, fb.TLSConfig)
+			}
+			for i, cfg := range configs {
+				if cfg == nil {
+					t.Fatalf("TLS config %d is nil", i)
+				}
+				if cfg.MinVersion != tt.wantMin || cfg.MaxVersion != tt.wantMax {
+					t.Errorf("TLS config %d: versions %s-%s, want %s-%s", i,
+						tls.VersionName(cfg.MinVersion), tls.VersionName(cfg.MaxVersion),
+						tls.VersionName(tt.wantMin), tls.VersionName(tt.wantMax))
+				}
+			}
+		})
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint16
+		wantErr bool
+	}{
+		{in: "1.2", want: tls.VersionTLS12},
+		{in: "1.3", want: tls.VersionTLS13},
+		{in: "1.1", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTLSVersion(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseTLSVersion(%q) = %d, %v; want %d, error %t", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package dsqltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/jackc/pgx/v5"
+)
+
+// DefaultTokenRefreshSkew is how long before expiry a cached token is
+// considered stale and regenerated.
+const DefaultTokenRefreshSkew = 60 * time.Second
+
+// Default retry policy for token generation, separate from the connect one.
+const (
+	DefaultTokenRetries        = 2
+	DefaultTokenRetryBaseDelay = 200 * time.Millisecond
+)
+
+// TokenProvider caches a DSQL IAM auth token and regenerates it once it is
+// within skew of its expiry. It is safe for concurrent use.
+type TokenProvider struct {
+	hostname string
+	awsCfg   aws.Config
+	admin    bool
+	skew     time.Duration
+	timeout  time.Duration
+	retry    RetryPolicy
+
+	// now and sign are the clock and the token signer, replaced in tests
+	now  func() time.Time
+	sign func(ctx context.Context) (string, error)
+
+	mu        sync.Mutex
+	token     string
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+// NewTokenProvider returns a provider for the given cluster hostname that
+// signs with the region and credentials in awsCfg (see LoadAWSConfig). Set
+// admin when connecting as the admin user. A positive timeout bounds each
+// token generation, including any credential fetch it triggers.
+func NewTokenProvider(hostname string, awsCfg aws.Config, admin bool, skew, timeout time.Duration) *TokenProvider {
+	return &TokenProvider{
+		hostname: hostname,
+		awsCfg:   awsCfg,
+		admin:    admin,
+		skew:     skew,
+		timeout:  timeout,
+		now:      time.Now,
+		sign: func(ctx context.Context) (string, error) {
+			return GenerateAuthToken(ctx, awsCfg, hostname, admin)
+		},
+	}
+}
+
+// SetRetry makes the provider retry a failed token generation with
+// policy's backoff, up to policy.MaxAttempts attempts in all. Without it a
+// generation is tried once. It must be called before the provider is used.
+func (p *TokenProvider) SetRetry(policy RetryPolicy) {
+	p.retry = policy
+}
+
+// Token returns the cached token, generating a fresh one if none is cached
+// or the cached one expires within the refresh skew. A failed generation is
+// retried as SetRetry allows, except when AWS rejected the credentials.
+func (p *TokenProvider) Token(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	if p.token != "" && now.Add(p.skew).Before(p.expiresAt) {
+		return p.token, nil
+	}
+
+	maxAttempts := max(p.retry.MaxAttempts, 1)
+	var token string
+	var err error
+	for attempt := 1; ; attempt++ {
+		if token, err = p.generate(ctx); err == nil {
+			break
+		}
+		if isCredentialRejected(err) {
+			return "", fmt.Errorf("%w, not retrying: %w", ErrCredentialsRejected, err)
+		}
+		if attempt == maxAttempts || ctx.Err() != nil {
+			if attempt > 1 {
+				err = fmt.Errorf("after %d attempts: %w", attempt, err)
+			}
+			return "", err
+		}
+		delay := p.retry.Backoff(attempt)
+		slog.Warn("token generation failed",
+			"attempt", attempt, "max_attempts", maxAttempts,
+			"retry_in", delay.Round(time.Millisecond).String(), "error", err)
+		if p.retry.OnRetry != nil {
+			p.retry.OnRetry(attempt, delay, err)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", fmt.Errorf("after %d attempts: %w", attempt, errors.Join(err, ctx.Err()))
+		}
+	}
+	now = p.now()
+
+	p.token = token
+	p.issuedAt = now
+	p.expiresAt = now.Add(tokenLifetime)
+
+	slog.Debug("generated DSQL auth token",
+		"hostname", p.hostname,
+		"issued_at", p.issuedAt.Format(time.RFC3339),
+		"expires_at", p.expiresAt.Format(time.RFC3339))
+
+	return p.token, nil
+}
+
+// generate signs one token, within the provider's timeout if it has one.
+func (p *TokenProvider) generate(ctx context.Context) (string, error) {
+	genCtx := ctx
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		genCtx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	token, err := p.sign(genCtx)
+	// Only blame the token timeout when the caller's context is still live
+	if err != nil && genCtx.Err() != nil && ctx.Err() == nil {
+		return "", fmt.Errorf("%w after %s: %w", ErrTokenTimeout, p.timeout, err)
+	}
+	return token, err
+}
+
+// Invalidate drops the cached token, so the next Token call signs a new one
+// however long the old one had left.
+func (p *TokenProvider) Invalidate() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.token = ""
+	p.expiresAt = time.Time{}
+}
+
+// ExpiresAt returns when the cached token expires, or the zero time if no
+// token has been generated yet.
+func (p *TokenProvider) ExpiresAt() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.expiresAt
+}
+
+// cachedToken returns the most recently generated token without refreshing
+// it, or "" if none has been generated.
+func (p *TokenProvider) cachedToken() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.token
+}
+
+// BeforeConnect sets a valid token as the connection password. Its signature
+// matches pgxpool.Config.BeforeConnect so the same hook serves pooled
+// connections.
+func (p *TokenProvider) BeforeConnect(ctx context.Context, config *pgx.ConnConfig) error {
+	token, err := p.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuthToken, err)
+	}
+	config.Password = token
+	return nil
+}
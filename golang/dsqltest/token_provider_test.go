@@ -0,0 +1,133 @@
+package dsqltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/jackc/pgx/v5"
+)
+
+// fakeSigner hands out numbered tokens and counts how many it signed.
+type fakeSigner struct {
+	signed int
+	err    error
+}
+
+func (s *fakeSigner) sign(context.Context) (string, error) {
+	if s.err != nil {
+		return "", s.err
+	}
+	s.signed++
+	return fmt.Sprintf("token-%d", s.signed), nil
+}
+
+// testProvider returns a provider with skew whose clock reads *now and
+// whose tokens come from signer.
+func testProvider(now *time.Time, signer *fakeSigner, skew time.Duration) *TokenProvider {
+	p := NewTokenProvider(testHostname, aws.Config{Region: "us-east-1"}, true, skew, 0)
+	p.now = func() time.Time { return *now }
+	p.sign = signer.sign
+	return p
+}
+
+func TestTokenProviderCachesUntilExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	signer := &fakeSigner{}
+	p := testProvider(&now, signer, time.Minute)
+	ctx := context.Background()
+
+	token := func() string {
+		t.Helper()
+		tok, err := p.Token(ctx)
+		if err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+		return tok
+	}
+	if got := token(); got != "token-1" {
+		t.Fatalf("first Token = %q, want token-1", got)
+	}
+	if want := now.Add(tokenLifetime); !p.ExpiresAt().Equal(want) {
+		t.Errorf("ExpiresAt = %s, want %s", p.ExpiresAt(), want)
+	}
+
+	// Just outside the skew the cached token is still good
+	now = now.Add(tokenLifetime - time.Minute - time.Second)
+	if got := token(); got != "token-1" {
+		t.Errorf("Token before expiry = %q, want the cached token-1", got)
+	}
+
+	// Within the skew of expiry it counts as expired
+	now = now.Add(2 * time.Second)
+	if got := token(); got != "token-2" {
+		t.Errorf("Token within the skew = %q, want a fresh token-2", got)
+	}
+
+	// Long past expiry, as after a laptop sleep
+	now = now.Add(time.Hour)
+	if got := token(); got != "token-3" {
+		t.Errorf("Token after expiry = %q, want a fresh token-3", got)
+	}
+	if signer.signed != 3 {
+		t.Errorf("signed %d tokens, want 3", signer.signed)
+	}
+}
+
+func TestTokenProviderInvalidate(t *testing.T) {
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	signer := &fakeSigner{}
+	p := testProvider(&now, signer, time.Minute)
+	ctx := context.Background()
+
+	if _, err := p.Token(ctx); err != nil {
+		t.Fatal(err)
+	}
+	p.Invalidate()
+	if !p.ExpiresAt().IsZero() {
+		t.Errorf("ExpiresAt after Invalidate = %s, want zero", p.ExpiresAt())
+	}
+	got, err := p.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "token-2" {
+		t.Errorf("Token after Invalidate = %q, want a fresh token-2", got)
+	}
+}
+
+func TestTokenProviderBeforeConnect(t *testing.T) {
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	p := testProvider(&now, &fakeSigner{}, time.Minute)
+	config, err := pgx.ParseConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.BeforeConnect(context.Background(), config); err != nil {
+		t.Fatalf("BeforeConnect: %v", err)
+	}
+	if config.Password != "token-1" {
+		t.Errorf("Password = %q, want token-1", config.Password)
+	}
+}
+
+func TestTokenProviderBeforeConnectError(t *testing.T) {
+	now := time.Date(2025, 1, 15, 10, 0, 0, 0, time.UTC)
+	signErr := errors.New("no credentials")
+	p := testProvider(&now, &fakeSigner{err: signErr}, time.Minute)
+	config, err := pgx.ParseConfig("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Password = "unchanged"
+	err = p.BeforeConnect(context.Background(), config)
+	if !errors.Is(err, ErrAuthToken) || !errors.Is(err, signErr) {
+		t.Errorf("BeforeConnect error = %v, want ErrAuthToken wrapping the signer's error", err)
+	}
+	if config.Password != "unchanged" {
+		t.Errorf("Password = %q after a failed generation, want it unchanged", config.Password)
+	}
+}
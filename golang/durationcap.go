@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"syscall"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// defaultCapMaxWait bounds --duration-cap-test a little past DSQL's
+// documented 60-minute connection cap.
+const defaultCapMaxWait = 70 * time.Minute
+
+// capProgressEvery is how often --duration-cap-test reports that the
+// connection is still open.
+const capProgressEvery = 5 * time.Minute
+
+// How a --duration-cap-test connection ended. A FATAL error is the server
+// ending the session; a plain close or reset may equally be the tunnel's
+// doing.
+const (
+	capServerFatal  = "server_fatal"      // the server sent a FATAL error first
+	capServerClosed = "connection_closed" // the socket closed without an error
+	capNetwork      = "network"           // reset or timed out
+	capOther        = "other"
+)
+
+// durationCapReport is the outcome of a --duration-cap-test run.
+type durationCapReport struct {
+	Terminated      bool    `json:"terminated"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Pings           int     `json:"pings"`
+	Termination     string  `json:"termination,omitempty"`
+	SQLState        string  `json:"sqlstate,omitempty"`
+	Error           string  `json:"error,omitempty"`
+	MaxWaitSeconds  float64 `json:"max_wait_seconds"`
+	Interrupted     bool    `json:"interrupted,omitempty"`
+
+	elapsed time.Duration
+}
+
+// runDurationCap opens one connection and pings it every interval until the
+// server closes it, maxWait passes or rootCtx is cancelled, then reports how
+// long the connection survived and how it ended. Any termination exits 0,
+// since the classification can't prove who closed it; a connection still
+// open at maxWait is a check failure. With jsonOutput
+// the report is written to stdout.
+func runDurationCap(rootCtx context.Context, cfg testConfig, interval, maxWait time.Duration, out, stdout io.Writer, jsonOutput bool) int {
+	dialCtx, cancel := context.WithTimeout(rootCtx, cfg.timeout)
+	conn, err := connectForCapTest(dialCtx, cfg)
+	cancel()
+	if err != nil {
+		err = interruptedError(rootCtx, err)
+		slog.Error("failed to open connection", "error", err)
+		return exitCodeOf(err)
+	}
+	defer closeConn(conn)
+	// The warning only marks the expected cap; this mode waits past it
+	defer watchLifetime(cfg, cfg.conn.HostAddr).stop()
+
+	fmt.Fprintf(out, "Holding an idle connection to %s via %s, pinging every %s for up to %s\n",
+		cfg.conn.Hostname, cfg.conn.Address(), interval, maxWait)
+
+	report := &durationCapReport{MaxWaitSeconds: maxWait.Seconds()}
+	started := time.Now()
+	deadline := time.NewTimer(maxWait)
+	defer deadline.Stop()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	nextProgress := capProgressEvery
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+		case <-rootCtx.Done():
+		}
+		if rootCtx.Err() != nil {
+			report.Interrupted = true
+			break
+		}
+		elapsed := time.Since(started)
+		if elapsed >= maxWait {
+			break
+		}
+
+		pingCtx, cancel := context.WithTimeout(rootCtx, cfg.timeout)
+		err := conn.Ping(pingCtx)
+		cancel()
+		if rootCtx.Err() != nil {
+			report.Interrupted = true
+			break
+		}
+		report.Pings++
+		if err != nil {
+			report.Terminated = true
+			report.Termination = classifyTermination(conn, err)
+			report.Error = err.Error()
+			report.SQLState = sqlState(err)
+			break
+		}
+		slog.Debug("connection still open", "elapsed", elapsed.Round(time.Second).String(), "pings", report.Pings)
+		if elapsed >= nextProgress {
+			fmt.Fprintf(out, "Still open after %s (%d pings)\n", elapsed.Round(time.Second), report.Pings)
+			nextProgress += capProgressEvery
+		}
+	}
+	report.elapsed = time.Since(started)
+	report.DurationSeconds = report.elapsed.Seconds()
+
+	code := exitOK
+	switch {
+	case report.Interrupted:
+		code = exitInterrupted
+	case !report.Terminated:
+		code = exitQuery
+		slog.Error("connection was not closed within --max-wait", "max_wait", maxWait.String())
+	}
+
+	return writeReport(stdout, out, report, jsonOutput, code)
+}
+
+// connectForCapTest opens the connection held by --duration-cap-test.
+func connectForCapTest(ctx context.Context, cfg testConfig) (*pgx.Conn, error) {
+	config, err := newConnConfig(ctx, cfg, nil)
+	if err != nil {
+		return nil, configFailure(err)
+	}
+	conn, err := dsqltest.ConnectWithRetry(ctx, config, cfg.retry)
+	if err != nil {
+		return nil, connectFailure(phaseError(ctx, "connect", cfg.timeout, err))
+	}
+	return conn, nil
+}
+
+// classifyTermination names how a held connection ended.
+func classifyTermination(conn *pgx.Conn, err error) string {
+	var pgErr *pgconn.PgError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &pgErr) && pgErr.Severity == "FATAL":
+		return capServerFatal
+	case errors.Is(err, syscall.ECONNRESET), errors.As(err, &netErr) && netErr.Timeout():
+		return capNetwork
+	case isServerClose(conn, err):
+		return capServerClosed
+	default:
+		return capOther
+	}
+}
+
+// writeText prints how long the connection survived and how it ended.
+func (r *durationCapReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nConnection Duration Cap:")
+	fmt.Fprintln(w, "========================")
+	survived := r.elapsed.Round(time.Second)
+	switch {
+	case r.Interrupted:
+		fmt.Fprintf(w, "Interrupted after %s; the connection was still open (%d pings)\n", survived, r.Pings)
+	case !r.Terminated:
+		fmt.Fprintf(w, "%s: still open after %s (%d pings)\n", colorize(colorRed, "Not closed"), survived, r.Pings)
+	default:
+		fmt.Fprintf(w, "Closed after %s (%d pings)\n", survived, r.Pings)
+		fmt.Fprintf(w, "Termination: %s\n", r.Termination)
+		if r.SQLState != "" {
+			fmt.Fprintf(w, "SQLSTATE: %s\n", r.SQLState)
+		}
+		fmt.Fprintf(w, "Error: %s\n", r.Error)
+	}
+}
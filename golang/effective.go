@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// effectiveConfig is the resolved configuration shown by --print-config,
+// with the password reduced to whether one is set.
+type effectiveConfig struct {
+	Hostname    string `json:"hostname"`
+	SNIHostname string `json:"sni_hostname,omitempty"`
+	NoSNI       bool   `json:"no_sni_override,omitempty"`
+	HostAddr    string `json:"hostaddr"`
+	Port        int    `json:"port"`
+	User        string `json:"user"`
+	Database    string `json:"database"`
+	SSLMode     string `json:"sslmode"`
+	SSLRootCert string `json:"sslrootcert,omitempty"`
+	SSLCert     string `json:"sslcert,omitempty"`
+	SSLKey      string `json:"sslkey,omitempty"`
+	SkipVerify  bool   `json:"insecure_skip_tls_verify,omitempty"`
+	VerifyNames string `json:"tls_verify_names,omitempty"`
+	AppName     string `json:"application_name"`
+	TLSVersions string `json:"tls_versions"`
+	ReadOnly    bool   `json:"read_only"`
+	SearchPath  string `json:"search_path,omitempty"`
+	SOCKS5Proxy string `json:"socks5_proxy,omitempty"`
+	KeepAlive   string `json:"tcp_keepalive"`
+	ConnTimeout string `json:"connect_timeout"`
+	ExecMode    string `json:"exec_mode"`
+	Password    string `json:"password"`
+	IAMAuth     bool   `json:"iam_auth"`
+	IAMAction   string `json:"iam_action,omitempty"`
+	Region      string `json:"region,omitempty"`
+	Profile     string `json:"profile,omitempty"`
+	AssumeRole  string `json:"assume_role_arn,omitempty"`
+	Pool        bool   `json:"pool"`
+	Retries     int    `json:"retries"`
+	Timeout     string `json:"timeout"`
+	ConfigFile  string `json:"config_file,omitempty"`
+
+	// RuntimeParams are the --set session parameters sent at connect time
+	RuntimeParams map[string]string `json:"runtime_params,omitempty"`
+
+	// TokenExpires is set by --dry-run once an IAM token was generated
+	TokenExpires string `json:"token_expires_at,omitempty"`
+}
+
+// newEffectiveConfig captures cfg as resolved from flags, environment and
+// defaults. Region and profile are the explicit values; empty ones are left
+// to the AWS SDK's own resolution.
+func newEffectiveConfig(cfg testConfig, useIAM bool, region, profile, roleARN, configFile string) effectiveConfig {
+	var action string
+	if useIAM {
+		action = iamAction(cfg.conn.User)
+	}
+	password := "(not set)"
+	if cfg.conn.Password != "" {
+		password = "(set, redacted)"
+	}
+	return effectiveConfig{
+		Hostname:    cfg.conn.Hostname,
+		SNIHostname: cfg.conn.SNIHostname,
+		NoSNI:       cfg.conn.NoSNIOverride,
+		SkipVerify:  cfg.conn.InsecureSkipVerify,
+		VerifyNames: strings.Join(cfg.conn.VerifyNames, ", "),
+		HostAddr:    cfg.conn.HostAddr,
+		Port:        cfg.conn.Port,
+		User:        cfg.conn.User,
+		Database:    cfg.conn.Database,
+		SSLMode:     cfg.conn.SSLMode,
+		SSLRootCert: cfg.conn.SSLRootCert,
+		SSLCert:     cfg.conn.SSLCert,
+		SSLKey:      cfg.conn.SSLKey,
+		AppName:     cfg.conn.ApplicationName,
+		TLSVersions: tlsVersionRange(cfg.conn),
+		ReadOnly:    cfg.conn.ReadOnly,
+		SearchPath:  cfg.conn.SearchPath,
+		SOCKS5Proxy: cfg.conn.SOCKS5Proxy,
+		KeepAlive:   keepAliveSetting(cfg.conn.TCPKeepAlive),
+		ConnTimeout: connectTimeoutSetting(cfg.conn.ConnectTimeout),
+		ExecMode:    dsqltest.QueryExecModeName(cfg.conn.QueryExecMode),
+		Password:    password,
+		IAMAuth:     useIAM,
+		IAMAction:   action,
+		Region:      region,
+		Profile:     profile,
+		AssumeRole:  roleARN,
+		Pool:        cfg.usePool,
+		Retries:     cfg.retry.MaxAttempts,
+		Timeout:     cfg.timeout.String(),
+		ConfigFile:  configFile,
+
+		RuntimeParams: cfg.conn.RuntimeParams,
+	}
+}
+
+// log emits the configuration as a debug event.
+func (c effectiveConfig) log() {
+	slog.Debug("effective configuration",
+		"hostname", c.Hostname, "sni_hostname", c.SNIHostname, "no_sni_override", c.NoSNI, "hostaddr", c.HostAddr, "port", c.Port,
+		"user", c.User, "database", c.Database, "sslmode", c.SSLMode,
+		"sslrootcert", c.SSLRootCert, "sslcert", c.SSLCert, "sslkey", c.SSLKey, "insecure_skip_tls_verify", c.SkipVerify, "tls_verify_names", c.VerifyNames, "application_name", c.AppName, "tls_versions", c.TLSVersions, "read_only", c.ReadOnly, "search_path", c.SearchPath, "socks5_proxy", c.SOCKS5Proxy, "tcp_keepalive", c.KeepAlive, "connect_timeout", c.ConnTimeout, "exec_mode", c.ExecMode, "password", c.Password,
+		"iam_auth", c.IAMAuth, "iam_action", c.IAMAction, "region", c.Region, "profile", c.Profile, "assume_role_arn", c.AssumeRole,
+		"pool", c.Pool, "retries", c.Retries, "timeout", c.Timeout,
+		"config_file", c.ConfigFile, "runtime_params", runtimeParams(c.RuntimeParams).String())
+}
+
+// writeText prints the configuration as aligned key/value lines.
+func (c effectiveConfig) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nEffective Configuration:")
+	fmt.Fprintln(w, "========================")
+	switch {
+	case c.NoSNI:
+		fmt.Fprintf(w, "Hostname: %s\n", valueOrUnset(c.Hostname))
+		fmt.Fprintln(w, "SNI Hostname: (not overridden, pgx uses the host address)")
+	case c.SNIHostname != "":
+		fmt.Fprintf(w, "Hostname: %s\n", c.Hostname)
+		fmt.Fprintf(w, "SNI Hostname: %s\n", c.SNIHostname)
+	default:
+		fmt.Fprintf(w, "Hostname (SNI): %s\n", valueOrUnset(c.Hostname))
+	}
+	fmt.Fprintf(w, "Host Address: %s\n", valueOrUnset(c.HostAddr))
+	fmt.Fprintf(w, "Port: %d\n", c.Port)
+	fmt.Fprintf(w, "User: %s\n", c.User)
+	fmt.Fprintf(w, "Database: %s\n", c.Database)
+	fmt.Fprintf(w, "SSL Mode: %s\n", c.SSLMode)
+	fmt.Fprintf(w, "SSL Root Cert: %s\n", valueOrUnset(c.SSLRootCert))
+	if c.SSLCert != "" {
+		fmt.Fprintf(w, "Client Certificate: %s (key %s)\n", c.SSLCert, c.SSLKey)
+	}
+	if c.SkipVerify {
+		fmt.Fprintln(w, "Certificate Verification: DISABLED (--insecure-skip-tls-verify)")
+	}
+	if c.VerifyNames != "" {
+		fmt.Fprintf(w, "Certificate Names: %s\n", c.VerifyNames)
+	}
+	fmt.Fprintf(w, "Application Name: %s\n", c.AppName)
+	fmt.Fprintf(w, "TLS Versions: %s\n", c.TLSVersions)
+	fmt.Fprintf(w, "Read Only: %t\n", c.ReadOnly)
+	if c.SearchPath != "" {
+		fmt.Fprintf(w, "Search Path: %s\n", c.SearchPath)
+	}
+	if c.SOCKS5Proxy != "" {
+		fmt.Fprintf(w, "SOCKS5 Proxy: %s\n", c.SOCKS5Proxy)
+	}
+	fmt.Fprintf(w, "TCP Keepalive: %s\n", c.KeepAlive)
+	fmt.Fprintf(w, "Connect Timeout: %s\n", c.ConnTimeout)
+	fmt.Fprintf(w, "Query Exec Mode: %s\n", c.ExecMode)
+	if len(c.RuntimeParams) > 0 {
+		fmt.Fprintf(w, "Session Parameters: %s\n", runtimeParams(c.RuntimeParams))
+	}
+	fmt.Fprintf(w, "Password: %s\n", c.Password)
+	fmt.Fprintf(w, "IAM Auth: %t\n", c.IAMAuth)
+	if c.IAMAuth {
+		fmt.Fprintf(w, "IAM Action: %s\n", c.IAMAction)
+		fmt.Fprintf(w, "Region: %s\n", firstNonEmpty(c.Region, "(from AWS_REGION or profile)"))
+		fmt.Fprintf(w, "Profile: %s\n", firstNonEmpty(c.Profile, "(from AWS_PROFILE or default)"))
+		if c.AssumeRole != "" {
+			fmt.Fprintf(w, "Assume Role: %s\n", c.AssumeRole)
+		}
+	}
+	fmt.Fprintf(w, "Pool: %t\n", c.Pool)
+	fmt.Fprintf(w, "Retries: %d\n", c.Retries)
+	fmt.Fprintf(w, "Timeout: %s\n", c.Timeout)
+	if c.ConfigFile != "" {
+		fmt.Fprintf(w, "Config File: %s\n", c.ConfigFile)
+	}
+}
+
+// writeJSON prints the configuration as an indented JSON object.
+func (c effectiveConfig) writeJSON(w io.Writer) error {
+	return writeJSONReport(w, c)
+}
+
+// valueOrUnset substitutes "(not set)" for empty settings.
+func valueOrUnset(v string) string {
+	if v == "" {
+		return "(not set)"
+	}
+	return v
+}
+
+// tlsVersionRange describes the TLS versions opts allows, e.g. "TLS 1.2+".
+func tlsVersionRange(opts dsqltest.Config) string {
+	minVersion := max(opts.TLSMinVersion, tls.VersionTLS12)
+	if opts.TLSMaxVersion == minVersion {
+		return tls.VersionName(minVersion) + " only"
+	}
+	return tls.VersionName(minVersion) + "+"
+}
+
+// keepAliveSetting describes the --tcp-keepalive value.
+func keepAliveSetting(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "disabled"
+	case d == 0:
+		return "5m0s (pgx default)"
+	default:
+		return d.String()
+	}
+}
+
+// connectTimeoutSetting describes the --connect-timeout value.
+func connectTimeoutSetting(d time.Duration) string {
+	if d == 0 {
+		return "none (bounded by --timeout)"
+	}
+	return d.String()
+}
+
+// iamAction names the IAM action tokens for user are signed with.
+func iamAction(user string) string {
+	if user == dsqltest.DefaultUser {
+		return "dsql:DbConnectAdmin"
+	}
+	return "dsql:DbConnect"
+}
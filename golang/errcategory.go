@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// errorCategory is the stable kind of failure reported as error_category,
+// so automation can decide whether to retry without parsing messages.
+type errorCategory string
+
+// Error categories. The values are part of the JSON output and must not
+// change.
+const (
+	categoryConfig      errorCategory = "ConfigError"
+	categoryDNS         errorCategory = "DNSError"
+	categoryTCP         errorCategory = "TCPError"
+	categoryTLS         errorCategory = "TLSError"
+	categoryAuth        errorCategory = "AuthError"
+	categoryQuery       errorCategory = "QueryError"
+	categoryTimeout     errorCategory = "TimeoutError"
+	categoryConnLimit   errorCategory = "ConnLimitError"
+	categoryConcurrency errorCategory = "ConcurrencyError"
+)
+
+// errorCategories lists every category, for the JSON Schema.
+var errorCategories = []errorCategory{
+	categoryConfig, categoryDNS, categoryTCP, categoryTLS, categoryAuth,
+	categoryQuery, categoryTimeout, categoryConnLimit, categoryConcurrency,
+}
+
+// sqlStateSerializationFailure is serialization_failure, Postgres' own
+// retry-the-transaction code.
+const sqlStateSerializationFailure = "40001"
+
+// sqlStateInsufficientPrivilege is insufficient_privilege, which a role
+// not allowed to connect gets at startup.
+const sqlStateInsufficientPrivilege = "42501"
+
+// classifyError sorts err into an errorCategory by the errors it wraps,
+// most specific first, then by its exit code. It returns "" for nil and
+// for failures that fit no category, such as an interrupted run.
+func classifyError(err error) errorCategory {
+	if err == nil {
+		return ""
+	}
+	if dsqltest.IsConnLimitExceeded(err) {
+		return categoryConnLimit
+	}
+	if dsqltest.IsAuthError(err) {
+		return categoryAuth
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case isOCCConflict(err) || pgErr.Code == sqlStateSerializationFailure:
+			return categoryConcurrency
+		case pgErr.Code == sqlStateQueryCanceled:
+			return categoryTimeout
+		case strings.Contains(strings.ToLower(pgErr.Message), "sni"):
+			// DSQL refuses a handshake without its hostname as SNI
+			return categoryTLS
+		case exitCodeOf(err) == exitConnect:
+			// The server answered but refused the session at startup:
+			// a role without access, or settings such as an unknown
+			// database
+			if pgErr.Code == sqlStateInsufficientPrivilege {
+				return categoryAuth
+			}
+			return categoryConfig
+		}
+		return categoryQuery
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return categoryDNS
+	}
+	if isTLSError(err) {
+		return categoryTLS
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, dsqltest.ErrTokenTimeout) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return categoryTimeout
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return categoryTCP
+	}
+
+	return exitCodeCategory(exitCodeOf(err))
+}
+
+// exitCodeCategory is the category of a failure known only by its exit
+// code.
+func exitCodeCategory(code int) errorCategory {
+	switch code {
+	case exitConfig:
+		return categoryConfig
+	case exitConnect:
+		return categoryTCP
+	case exitAuth:
+		return categoryAuth
+	case exitQuery:
+		return categoryQuery
+	}
+	return ""
+}
+
+// isTLSError reports whether err came from the TLS handshake or the
+// certificate checks rather than the network or the server.
+func isTLSError(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		hostErr      x509.HostnameError
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+	)
+	return errors.As(err, &recordErr) || errors.As(err, &alertErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &hostErr) || errors.As(err, &authorityErr) || errors.As(err, &invalidErr) ||
+		errors.Is(err, errNoTLS) || dsqltest.IsTLSVersionError(err) ||
+		strings.Contains(err.Error(), "server refused TLS connection")
+}
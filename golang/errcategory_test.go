@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestClassifyError(t *testing.T) {
+	pgErr := func(code string) error {
+		return &pgconn.PgError{Severity: "ERROR", Code: code, Message: "server error " + code}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want errorCategory
+	}{
+		{name: "nil", err: nil, want: ""},
+		{name: "occ data conflict", err: withExitCode(exitQuery, pgErr("OC000")), want: categoryConcurrency},
+		{name: "occ schema conflict", err: withExitCode(exitQuery, pgErr("OC001")), want: categoryConcurrency},
+		{name: "serialization failure", err: pgErr("40001"), want: categoryConcurrency},
+		{name: "query canceled", err: pgErr("57014"), want: categoryTimeout},
+		{name: "too many connections", err: connectFailure(pgErr("53300")), want: categoryConnLimit},
+		{name: "password rejected", err: connectFailure(&pgconn.PgError{Severity: "FATAL", Code: "28P01", Message: "password authentication failed"}), want: categoryAuth},
+		{name: "token generation failed", err: fmt.Errorf("%w: no credentials", dsqltest.ErrAuthToken), want: categoryAuth},
+		{name: "sni rejected", err: connectFailure(&pgconn.PgError{Severity: "FATAL", Code: "08004", Message: "SNI hostname is required"}), want: categoryTLS},
+		{name: "unknown database at startup", err: connectFailure(&pgconn.PgError{Severity: "FATAL", Code: "3D000", Message: "database does not exist"}), want: categoryConfig},
+		{name: "no connect privilege at startup", err: connectFailure(&pgconn.PgError{Severity: "FATAL", Code: "42501", Message: "permission denied for database"}), want: categoryAuth},
+		{name: "query error", err: withExitCode(exitQuery, pgErr("42P01")), want: categoryQuery},
+		{name: "dns", err: connectFailure(&net.DNSError{Err: "no such host", Name: "abc.dsql.us-east-1.on.aws", IsNotFound: true}), want: categoryDNS},
+		{name: "unknown authority", err: connectFailure(fmt.Errorf("tls: %w", x509.UnknownAuthorityError{})), want: categoryTLS},
+		{name: "hostname mismatch", err: connectFailure(x509.HostnameError{Certificate: &x509.Certificate{}, Host: "abc.dsql.us-east-1.on.aws"}), want: categoryTLS},
+		{name: "deadline", err: connectFailure(fmt.Errorf("connect: %w", context.DeadlineExceeded)), want: categoryTimeout},
+		{name: "token timeout", err: fmt.Errorf("%w after 5s", dsqltest.ErrTokenTimeout), want: categoryTimeout},
+		{name: "connection refused", err: connectFailure(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), want: categoryTCP},
+		{name: "config", err: withExitCode(exitConfig, errors.New("invalid port")), want: categoryConfig},
+		{name: "unclassified", err: errors.New("something else"), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// pgErrorDetail is the structured part of a server error, kept apart from
+// the message so automation can branch on the SQLSTATE code (e.g. DSQL's
+// OC000 and OC001 optimistic concurrency conflicts).
+type pgErrorDetail struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity,omitempty"`
+	Message  string `json:"message"`
+	Detail   string `json:"detail,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+}
+
+// newPGErrorDetail returns the server error wrapped in err, or nil if err
+// didn't come from the server.
+func newPGErrorDetail(err error) *pgErrorDetail {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+	return &pgErrorDetail{
+		Code:     pgErr.Code,
+		Severity: pgErr.Severity,
+		Message:  pgErr.Message,
+		Detail:   pgErr.Detail,
+		Hint:     pgErr.Hint,
+	}
+}
+
+// errorChain splits err into the message each wrapping layer added,
+// outermost first. Unwrapping stops at a joined error, whose message
+// already lists every error it holds.
+func errorChain(err error) []string {
+	var chain []string
+	for err != nil {
+		msg := err.Error()
+		inner := errors.Unwrap(err)
+		if inner != nil {
+			msg = strings.TrimSuffix(strings.TrimSuffix(msg, inner.Error()), ": ")
+		}
+		if msg != "" {
+			chain = append(chain, msg)
+		}
+		err = inner
+	}
+	return chain
+}
+
+// writeErrorDetails prints the server error fields of err, or the chain of
+// wrapped errors when it didn't come from the server.
+func writeErrorDetails(w io.Writer, err error) {
+	fmt.Fprintln(w, "\nError Details:")
+	fmt.Fprintln(w, "==============")
+	if d := newPGErrorDetail(err); d != nil {
+		fmt.Fprintf(w, "SQLSTATE: %s\n", d.Code)
+		fmt.Fprintf(w, "Severity: %s\n", valueOrUnknown(d.Severity))
+		fmt.Fprintf(w, "Message: %s\n", d.Message)
+		if d.Detail != "" {
+			fmt.Fprintf(w, "Detail: %s\n", d.Detail)
+		}
+		if d.Hint != "" {
+			fmt.Fprintf(w, "Hint: %s\n", d.Hint)
+		}
+		writeAuthDetails(w, err)
+		return
+	}
+	for i, msg := range errorChain(err) {
+		if i == 0 {
+			fmt.Fprintf(w, "Error: %s\n", msg)
+			continue
+		}
+		fmt.Fprintf(w, "%sCaused by: %s\n", strings.Repeat("  ", i-1), msg)
+	}
+}
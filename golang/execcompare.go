@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// execCompareCheck runs the same query s.cfg.compareN times over the simple
+// protocol, which sends the SQL text every time, and as a cached
+// server-prepared statement, then reports each side's latency distribution.
+var execCompareCheck = check{name: "compare-prepared", run: runExecCompare}
+
+func runExecCompare(ctx context.Context, s *session, r *checkResult) error {
+	sql := s.cfg.query
+	if sql == "" {
+		sql = dsqltest.ConnectionInfoSQL
+	}
+	n := s.cfg.compareN
+	r.detail("iterations", n)
+
+	// The two modes alternate so drift in network or server load over the
+	// run affects both equally. The first prepared execution pays for the
+	// Parse and Describe round trip, which is what preparing amortizes
+	simple := make([]time.Duration, 0, n)
+	prepared := make([]time.Duration, 0, n)
+	for i := 0; i < n; i++ {
+		d, err := timeQuery(ctx, s.conn, sql, pgx.QueryExecModeSimpleProtocol)
+		if err != nil {
+			return r.step("simple protocol", fmt.Errorf("iteration %d: %w", i+1, err))
+		}
+		simple = append(simple, d)
+		d, err = timeQuery(ctx, s.conn, sql, pgx.QueryExecModeCacheStatement)
+		if err != nil {
+			return r.step("prepared statement", fmt.Errorf("iteration %d: %w", i+1, err))
+		}
+		prepared = append(prepared, d)
+	}
+	r.step("simple protocol", nil)
+	r.step("prepared statement", nil)
+
+	simpleP50 := recordPercentiles(r, "simple", simple)
+	preparedP50 := recordPercentiles(r, "prepared", prepared)
+	r.detail("prepared_speedup_p50", fmt.Sprintf("%.2fx", simpleP50.Seconds()/preparedP50.Seconds()))
+	return nil
+}
+
+// timeQuery runs sql in mode and reads every row, returning how long the
+// round trip took.
+func timeQuery(ctx context.Context, conn *pgx.Conn, sql string, mode pgx.QueryExecMode) (time.Duration, error) {
+	start := time.Now()
+	rows, err := conn.Query(ctx, sql, mode)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	return time.Since(start), rows.Err()
+}
+
+// recordPercentiles adds the p50, p95 and p99 of latencies as details named
+// after prefix, returning the p50.
+func recordPercentiles(r *checkResult, prefix string, latencies []time.Duration) time.Duration {
+	slices.Sort(latencies)
+	p50 := percentile(latencies, 50)
+	r.detail(prefix+"_p50_ms", durationMs(p50))
+	r.detail(prefix+"_p95_ms", durationMs(percentile(latencies, 95)))
+	r.detail(prefix+"_p99_ms", durationMs(percentile(latencies, 99)))
+	return p50
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// Process exit codes. CI scripts use these to tell a bad invocation from a
+// tunnel that is down, rejected credentials, or a broken query.
+const (
+	exitOK           = 0
+	exitFailure      = 1 // anything not covered below, e.g. a failed output write
+	exitConfig       = 2 // invalid flags, environment or config values
+	exitConnect      = 3 // tunnel, DNS, TLS or timeout failure while connecting
+	exitAuth         = 4 // auth token generation failed or credentials rejected
+	exitQuery        = 5 // connected, but a query or check failed
+	exitProvisioning = 6 // --wait-for-ready ran out while the cluster was still being created
+
+	exitInterrupted = 130 // stopped by SIGINT or SIGTERM, as shells report it
+)
+
+// exitCodeHelp is appended to the --help output.
+const exitCodeHelp = `
+Exit codes:
+  0  success
+  1  unclassified failure
+  2  configuration or validation error
+  3  connection failure (tunnel, DNS, TLS, timeout)
+  4  authentication failure
+  5  query or check failure
+  6  cluster still provisioning when --wait-for-ready timed out
+  130  interrupted by SIGINT or SIGTERM before finishing
+`
+
+// exitError attaches a process exit code to an error.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode tags err with code; a nil err stays nil.
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// connectFailure tags a failed connection attempt as an auth or connection
+// failure.
+func connectFailure(err error) error {
+	if dsqltest.IsAuthError(err) {
+		return withExitCode(exitAuth, err)
+	}
+	return withExitCode(exitConnect, err)
+}
+
+// configFailure tags a failure to build the connection config, which is an
+// auth failure when the IAM token couldn't be generated in time or at all.
+func configFailure(err error) error {
+	err = tokenPhaseError(err)
+	if dsqltest.IsAuthError(err) {
+		return withExitCode(exitAuth, err)
+	}
+	return withExitCode(exitConfig, err)
+}
+
+// exitCodeOf returns the exit code attached to err, or exitFailure.
+func exitCodeOf(err error) int {
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
+	}
+	return exitFailure
+}
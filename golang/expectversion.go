@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// expectVersionCheck matches the server's version() string against
+// --expect-version, so a managed cluster moving to a different release
+// fails the run instead of going unnoticed.
+var expectVersionCheck = check{name: "expect-version", run: runExpectVersion}
+
+func runExpectVersion(ctx context.Context, s *session, r *checkResult) error {
+	r.detail("pattern", s.cfg.expectVersion.String())
+	var version string
+	if err := r.step("read version()", s.conn.QueryRow(ctx, "SELECT version()").Scan(&version)); err != nil {
+		return err
+	}
+	r.detail("version", version)
+
+	var mismatch error
+	if !s.cfg.expectVersion.MatchString(version) {
+		mismatch = fmt.Errorf("server version %q does not match %q", version, s.cfg.expectVersion)
+	}
+	return r.step("version matches", mismatch)
+}
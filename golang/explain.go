@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// explainedSetting is one resolved setting shown by --explain, with where
+// its value came from.
+type explainedSetting struct {
+	name   string
+	value  string
+	source string
+}
+
+// settingSource names the first of the flag, the service keyword and the
+// environment variables that resolve would take a value from, mirroring its
+// firstNonEmpty order, or "default" when none is set. An empty serviceKey
+// means the setting can't come from a service.
+func (f *connFlags) settingSource(flagName, flagValue, serviceKey string, envs ...string) string {
+	if flagValue != "" {
+		return "flag --" + flagName
+	}
+	if serviceKey != "" && f.svc.get(serviceKey) != "" {
+		return f.serviceSource(serviceKey)
+	}
+	for _, env := range envs {
+		if os.Getenv(env) != "" {
+			return "env " + env
+		}
+	}
+	return "default"
+}
+
+// serviceSource describes a value read from the loaded service.
+func (f *connFlags) serviceSource(key string) string {
+	return fmt.Sprintf("service %s, %s", f.svc, key)
+}
+
+// explain lists each connection setting in opts alongside its source. The
+// password is only reported as set or not; sshDest is the --ssh-tunnel
+// bastion, if any.
+func (f *connFlags) explain(opts dsqltest.Config, useIAM, discover bool, sshDest string) []explainedSetting {
+	hostSource := f.settingSource("host", f.host, "host", f.hostnameEnvs()...)
+	addrSource := f.settingSource("hostaddr", f.hostaddr, "hostaddr", "PGHOSTADDR", "PGHOST")
+	if f.hostaddr == "" && f.svc.get("hostaddr") == "" && f.svc.get("host") != "" {
+		addrSource = f.serviceSource("host")
+	}
+	if f.clusterID != "" {
+		hostSource = "flag --cluster-id"
+		if addrSource == "default" {
+			addrSource = "flag --cluster-id (the cluster endpoint)"
+		}
+	}
+	portSource := "default"
+	switch {
+	case f.port != 0:
+		portSource = "flag --port"
+	case f.svc.get("port") != "":
+		portSource = f.serviceSource("port")
+	case os.Getenv("PGPORT") != "":
+		portSource = "env PGPORT"
+	}
+
+	settings := []explainedSetting{
+		{"Hostname", valueOrUnset(opts.Hostname), hostSource},
+		{"Host Address", valueOrUnset(opts.HostAddr), addrSource},
+		{"Port", strconv.Itoa(opts.Port), portSource},
+		{"User", opts.User, f.settingSource("user", f.user, "user", "PGUSER")},
+		{"Database", opts.Database, f.settingSource("database", f.database, "dbname", "PGDATABASE")},
+	}
+	switch {
+	case sshDest != "":
+		settings = append(settings, explainedSetting{"Dial Via", "SSH tunnel through " + sshDest, "flag --ssh-tunnel"})
+	case opts.SOCKS5Proxy != "":
+		settings = append(settings, explainedSetting{"Dial Via", "SOCKS5 proxy " + opts.SOCKS5Proxy, "flag --socks5"})
+	default:
+		settings = append(settings, explainedSetting{"Dial Via", "direct TCP connection", "default"})
+	}
+
+	settings = append(settings,
+		explainedSetting{"SSL Mode", opts.SSLMode, f.settingSource("sslmode", f.sslmode, "sslmode", "PGSSLMODE")},
+		explainedSetting{"SSL Root Cert", valueOrUnset(opts.SSLRootCert), f.settingSource("sslrootcert", f.sslrootcert, "sslrootcert", "PGSSLROOTCERT")},
+		explainedSetting{"Client Cert", valueOrUnset(opts.SSLCert), f.settingSource("sslcert", f.sslcert, "sslcert", "PGSSLCERT")},
+		explainedSetting{"Client Key", valueOrUnset(opts.SSLKey), f.settingSource("sslkey", f.sslkey, "sslkey", "PGSSLKEY")},
+		f.explainSNI(opts),
+		explainVerification(opts),
+		f.explainTLSVersions(opts),
+		explainedSetting{"Application Name", opts.ApplicationName, f.settingSource("app-name", f.appName, "application_name", "PGAPPNAME") + ", with the correlation ID appended"},
+		explainedSetting{"Search Path", valueOrUnset(opts.SearchPath), f.settingSource("search-path", f.searchPath, "")},
+		f.explainConnectTimeout(opts),
+		f.explainPassword(opts, useIAM),
+	)
+
+	iamSource := "default"
+	switch {
+	case discover:
+		iamSource = "flag --discover"
+	case os.Getenv("DSQL_USE_IAM") == "true":
+		iamSource = "env DSQL_USE_IAM"
+	}
+	return append(settings, explainedSetting{"IAM Auth", strconv.FormatBool(useIAM), iamSource})
+}
+
+// explainSNI describes the TLS server name and which flag, if any, chose it.
+func (f *connFlags) explainSNI(opts dsqltest.Config) explainedSetting {
+	switch {
+	case opts.NoSNIOverride:
+		return explainedSetting{"TLS Server Name", "(none, pgx uses the dialed address)", "flag --no-sni-override"}
+	case opts.SNIHostname != "":
+		return explainedSetting{"TLS Server Name", opts.SNIHostname, "flag --sni-hostname"}
+	default:
+		return explainedSetting{"TLS Server Name", valueOrUnset(opts.ServerName()), "the hostname"}
+	}
+}
+
+// explainVerification describes how much of the server certificate is
+// checked, following the same rules as the TLS config.
+func explainVerification(opts dsqltest.Config) explainedSetting {
+	switch {
+	case opts.InsecureSkipVerify:
+		return explainedSetting{"Certificate Check", "DISABLED", "flag --insecure-skip-tls-verify"}
+	case opts.SSLMode == "verify-full" && len(opts.VerifyNames) > 0:
+		return explainedSetting{"Certificate Check", "chain and any of " + strings.Join(opts.VerifyNames, ", "), "flag --tls-verify-name"}
+	case opts.SSLMode == "verify-full":
+		return explainedSetting{"Certificate Check", "chain and server name", "sslmode verify-full"}
+	case opts.SSLMode == "verify-ca" || opts.SSLRootCert != "":
+		return explainedSetting{"Certificate Check", "chain only", "sslmode " + opts.SSLMode + " with a root CA bundle"}
+	default:
+		return explainedSetting{"Certificate Check", "none (encryption only)", "sslmode " + opts.SSLMode + " without --sslrootcert"}
+	}
+}
+
+// explainTLSVersions describes the allowed TLS versions.
+func (f *connFlags) explainTLSVersions(opts dsqltest.Config) explainedSetting {
+	source := "default"
+	switch {
+	case f.tls13Only:
+		source = "flag --tls13-only"
+	case flagSet("tls-min-version"):
+		source = "flag --tls-min-version"
+	}
+	return explainedSetting{"TLS Versions", tlsVersionRange(opts), source}
+}
+
+// explainConnectTimeout describes the per-attempt connect deadline.
+func (f *connFlags) explainConnectTimeout(opts dsqltest.Config) explainedSetting {
+	source := "default"
+	switch {
+	case f.connectTimeout != 0:
+		source = "flag --connect-timeout"
+	case f.svc.get("connect_timeout") != "":
+		source = f.serviceSource("connect_timeout")
+	case os.Getenv("PGCONNECT_TIMEOUT") != "":
+		source = "env PGCONNECT_TIMEOUT"
+	}
+	return explainedSetting{"Connect Timeout", connectTimeoutSetting(opts.ConnectTimeout), source}
+}
+
+// explainPassword reports whether a password is set and where it was read
+// from, never the password itself. IAM tokens replace it at connect time.
+func (f *connFlags) explainPassword(opts dsqltest.Config, useIAM bool) explainedSetting {
+	if useIAM {
+		return explainedSetting{"Password", "(IAM auth token, generated at connect time)", "IAM auth"}
+	}
+	if opts.Password == "" {
+		return explainedSetting{"Password", "(not set)", "default"}
+	}
+	source := "env PGPASSWORD"
+	switch {
+	case f.password != "":
+		source = "flag --password"
+	case f.passwordFile != "":
+		source = "flag --password-file " + f.passwordFile
+	case f.passwordStdin:
+		source = "flag --password-stdin"
+	case f.svc.get("password") != "":
+		source = f.serviceSource("password")
+	}
+	return explainedSetting{"Password", "(set, redacted)", source}
+}
+
+// explainConnString assembles opts as a libpq keyword/value connection
+// string, with the password shown as a placeholder.
+func explainConnString(opts dsqltest.Config, useIAM bool) string {
+	parts := []string{
+		"host=" + quoteConnValue(opts.Hostname),
+		"hostaddr=" + quoteConnValue(opts.HostAddr),
+		"port=" + strconv.Itoa(opts.Port),
+		"user=" + quoteConnValue(opts.User),
+		"dbname=" + quoteConnValue(opts.Database),
+		"sslmode=" + opts.SSLMode,
+	}
+	if opts.SSLRootCert != "" {
+		parts = append(parts, "sslrootcert="+quoteConnValue(opts.SSLRootCert))
+	}
+	if opts.SSLCert != "" {
+		parts = append(parts, "sslcert="+quoteConnValue(opts.SSLCert), "sslkey="+quoteConnValue(opts.SSLKey))
+	}
+	// libpq has no search_path keyword; it goes in the startup options
+	if opts.SearchPath != "" {
+		parts = append(parts, "options="+quoteConnValue("-c search_path="+opts.SearchPath))
+	}
+	if opts.Password != "" || useIAM {
+		parts = append(parts, "password=****")
+	}
+	return strings.Join(append(parts, "application_name="+quoteConnValue(opts.ApplicationName)), " ")
+}
+
+// quoteConnValue single-quotes a connection string value when it's empty or
+// contains spaces or quotes, escaping as libpq expects.
+func quoteConnValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+// writeExplain prints each setting with its source in brackets, followed by
+// the assembled connection string.
+func writeExplain(w io.Writer, settings []explainedSetting, connString string) {
+	fmt.Fprintln(w, "\nConfiguration Sources:")
+	fmt.Fprintln(w, "======================")
+	width := 0
+	for _, s := range settings {
+		width = max(width, len(s.name)+1)
+	}
+	for _, s := range settings {
+		fmt.Fprintf(w, "%-*s %s [%s]\n", width, s.name+":", s.value, s.source)
+	}
+	fmt.Fprintf(w, "\nConnection String: %s\n", connString)
+}
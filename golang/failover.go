@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultFailoverWait bounds how long --failover polls the secondary for
+// the row written on the primary.
+const defaultFailoverWait = 30 * time.Second
+
+// failoverPollInterval is the delay between reads of the secondary.
+const failoverPollInterval = 100 * time.Millisecond
+
+// sqlStateUndefinedTable is returned while the test table hasn't reached
+// the secondary yet.
+const sqlStateUndefinedTable = "42P01"
+
+// failoverEndpoints holds the --failover primary=<addr> and secondary=<addr>
+// pairs, each an endpoint address as parseEndpoint reads it.
+type failoverEndpoints struct {
+	primary, secondary string
+}
+
+// String lists the endpoints that are set.
+func (f *failoverEndpoints) String() string {
+	var pairs []string
+	if f.primary != "" {
+		pairs = append(pairs, "primary="+f.primary)
+	}
+	if f.secondary != "" {
+		pairs = append(pairs, "secondary="+f.secondary)
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set parses one or more role=addr pairs separated by spaces or commas, so
+// both endpoints fit in one quoted value or take a flag each.
+func (f *failoverEndpoints) Set(s string) error {
+	for _, pair := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == ' ' }) {
+		role, addr, ok := strings.Cut(pair, "=")
+		if !ok || addr == "" {
+			return fmt.Errorf("expected primary=<addr> or secondary=<addr>, got %q", pair)
+		}
+		switch role {
+		case "primary":
+			f.primary = addr
+		case "secondary":
+			f.secondary = addr
+		default:
+			return fmt.Errorf("unknown endpoint %q: use primary or secondary", role)
+		}
+	}
+	return nil
+}
+
+// set reports whether either endpoint was given.
+func (f *failoverEndpoints) set() bool {
+	return f.primary != "" || f.secondary != ""
+}
+
+// parseEndpoint turns the endpoint address of a --failover or --compare
+// flag into a cluster entry. An address is hostaddr[:port], prefixed with
+// hostname@ when the endpoint is a different cluster from --host; a DSQL
+// endpoint name on its own is both. The region comes from the hostname when
+// that's a DSQL endpoint.
+func parseEndpoint(name, addr string) (clusterEntry, error) {
+	hostname, hostAddr, ok := strings.Cut(addr, "@")
+	if !ok {
+		hostname, hostAddr = "", addr
+	}
+	e := clusterEntry{Name: name, HostAddr: hostAddr}
+	if host, port, err := net.SplitHostPort(hostAddr); err == nil {
+		e.HostAddr = host
+		if e.Port, err = strconv.Atoi(port); err != nil {
+			return e, fmt.Errorf("invalid port in %s endpoint %q", name, addr)
+		}
+	}
+	if hostname == "" && dsqltest.RegionFromHostname(e.HostAddr) != "" {
+		hostname = e.HostAddr
+	}
+	e.Hostname = hostname
+	e.Region = dsqltest.RegionFromHostname(hostname)
+	return e, nil
+}
+
+// runFailover writes a row on the primary, polls the secondary until the
+// row is readable or wait passes, and reports how long it took to show up.
+// A read-only secondary must then reject a write, and a writable one accept
+// it. --timeout bounds each connection and statement apart from the poll.
+// With jsonOutput the report is written to stdout.
+func runFailover(rootCtx context.Context, base testConfig, endpoints failoverEndpoints, d clusterDefaults, wait time.Duration, out, stdout io.Writer, jsonOutput bool) int {
+	r := &checkResult{Name: "failover", out: out, mark: time.Now()}
+	start := time.Now()
+	err := failoverTest(rootCtx, base, endpoints, d, wait, r)
+	r.DurationMs = durationMs(time.Since(start))
+	r.Success = err == nil
+
+	code := exitOK
+	if err != nil {
+		err = interruptedError(rootCtx, err)
+		if exitCodeOf(err) == exitFailure {
+			err = withExitCode(exitQuery, err)
+		}
+		code = exitCodeOf(err)
+		r.Error = err.Error()
+		r.ErrorCategory = classifyError(err)
+		r.SQLState = sqlState(err)
+		slog.Error("failover test failed", "error", err, "exit_code", code)
+	}
+
+	if jsonOutput {
+		if err := writeJSONReport(stdout, r); err != nil {
+			slog.Error("failed to write JSON report", "error", err)
+			return exitFailure
+		}
+		return code
+	}
+	if err == nil {
+		fmt.Fprintln(out, "\n"+colorize(colorGreen, "Failover test completed successfully!"))
+	}
+	return code
+}
+
+// failoverTest runs the steps of runFailover, recording them in r.
+func failoverTest(rootCtx context.Context, base testConfig, endpoints failoverEndpoints, d clusterDefaults, wait time.Duration, r *checkResult) (err error) {
+	ctx, cancel := context.WithTimeout(rootCtx, base.timeout+wait)
+	defer cancel()
+
+	primary, err := connectFailoverEndpoint(ctx, base, "primary", endpoints.primary, d)
+	if err := r.step("connect primary", err); err != nil {
+		return err
+	}
+	defer closeConn(primary)
+
+	table := pgx.Identifier{newTestTableName("failover")}.Sanitize()
+	if err := r.step("create table on primary", execStmt(ctx, primary,
+		"CREATE TABLE "+table+" (id uuid PRIMARY KEY, note text NOT NULL)")); err != nil {
+		return err
+	}
+	defer func() {
+		dropCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if dropErr := r.step("drop table", execStmt(dropCtx, primary, "DROP TABLE "+table)); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+
+	id := newUUID()
+	note := "dsql connectivity failover"
+	_, err = retryOnConflict(ctx, base.retries, func(ctx context.Context) error {
+		return execStmt(ctx, primary, "INSERT INTO "+table+" (id, note) VALUES ($1, $2)", id, note)
+	}, conflictRetries)
+	if err := r.step("write on primary", err); err != nil {
+		return err
+	}
+	written := time.Now()
+
+	secondary, err := connectFailoverEndpoint(ctx, base, "secondary", endpoints.secondary, d)
+	if err := r.step("connect secondary", err); err != nil {
+		return err
+	}
+	defer closeConn(secondary)
+
+	polls, err := pollForRow(ctx, secondary, table, id, note, wait)
+	r.detail("polls", polls)
+	if err := r.step("row visible on secondary", err); err != nil {
+		return err
+	}
+	// Propagation includes connecting to the secondary, so it's an upper
+	// bound when that's slower than replication
+	r.detail("propagation_ms", durationMs(time.Since(written)))
+
+	var readOnly string
+	err = secondary.QueryRow(ctx, "SELECT current_setting('transaction_read_only')").Scan(&readOnly)
+	if err := r.step("secondary session mode", err); err != nil {
+		return err
+	}
+	r.detail("secondary_read_only", readOnly == "on")
+
+	err = execStmt(ctx, secondary, "INSERT INTO "+table+" (id, note) VALUES ($1, $2)", newUUID(), note)
+	if readOnly != "on" {
+		return r.step("write on secondary", err)
+	}
+	var rejected error
+	switch code := sqlState(err); {
+	case err == nil:
+		rejected = errors.New("write succeeded on a read-only secondary")
+	case code != sqlStateReadOnly:
+		rejected = fmt.Errorf("write failed with %v, want SQLSTATE %s", err, sqlStateReadOnly)
+	}
+	return r.step("write rejected on secondary", rejected)
+}
+
+// endpointConfig returns base pointed at the endpoint addr, with its own
+// IAM token provider when the endpoint names another cluster.
+func endpointConfig(ctx context.Context, base testConfig, name, addr string, d clusterDefaults) (testConfig, error) {
+	e, err := parseEndpoint(name, addr)
+	if err != nil {
+		return base, withExitCode(exitConfig, err)
+	}
+	// The SNI override belongs to --host, so it only applies to endpoints
+	// that keep that hostname
+	if e.Hostname == "" {
+		e.SNIHostname = base.conn.SNIHostname
+	}
+	cfg, err := e.testConfig(ctx, base, d)
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.conn.Validate(); err != nil {
+		return cfg, withExitCode(exitConfig, fmt.Errorf("%s endpoint: %w", name, err))
+	}
+	return cfg, nil
+}
+
+// connectFailoverEndpoint opens a connection to one --failover endpoint.
+func connectFailoverEndpoint(ctx context.Context, base testConfig, name, addr string, d clusterDefaults) (*pgx.Conn, error) {
+	cfg, err := endpointConfig(ctx, base, name, addr, d)
+	if err != nil {
+		return nil, err
+	}
+
+	connectCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	config, err := newConnConfig(connectCtx, cfg, nil)
+	if err != nil {
+		return nil, configFailure(err)
+	}
+	conn, err := dsqltest.ConnectWithRetry(connectCtx, config, cfg.retry)
+	if err != nil {
+		return nil, connectFailure(connectPhaseError(connectCtx, cfg, err))
+	}
+	if err := requireTLS(conn); err != nil {
+		closeConn(conn)
+		return nil, err
+	}
+	slog.Debug("connected to failover endpoint", "endpoint", name, "hostname", cfg.conn.Hostname, "hostaddr", cfg.conn.Address())
+	return conn, nil
+}
+
+// pollForRow reads the row from conn every failoverPollInterval until it's
+// there, returning how many reads it took. A missing table counts as not
+// yet replicated; any other error ends the poll.
+func pollForRow(ctx context.Context, conn *pgx.Conn, table, id, note string, wait time.Duration) (int, error) {
+	pollCtx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	for polls := 1; ; polls++ {
+		var got string
+		err := conn.QueryRow(pollCtx, "SELECT note FROM "+table+" WHERE id = $1", id).Scan(&got)
+		switch {
+		case err == nil && got != note:
+			return polls, fmt.Errorf("note mismatch: wrote %q, read %q", note, got)
+		case err == nil:
+			return polls, nil
+		case pollCtx.Err() != nil:
+			if ctx.Err() == nil {
+				return polls, fmt.Errorf("row not visible after %s (%d reads, see --failover-wait)", wait, polls)
+			}
+			return polls, err
+		case !errors.Is(err, pgx.ErrNoRows) && sqlState(err) != sqlStateUndefinedTable:
+			return polls, err
+		}
+
+		select {
+		case <-time.After(failoverPollInterval):
+		case <-pollCtx.Done():
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// fakeServer is a minimal Postgres server for tests: it accepts TLS and any
+// password, and answers every query with an error after recording which
+// protocol it arrived over. Modes can be driven against it without a
+// database.
+type fakeServer struct {
+	addr string // host the listener is bound to
+	port int
+
+	mu       sync.Mutex
+	messages []string // "simple" for a Query, "extended" for a Parse
+}
+
+// startFakeServer listens on host:0 until the test ends.
+func startFakeServer(t *testing.T, host string) *fakeServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Skipf("cannot listen on %s: %v", host, err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{selfSignedCert(t)}}
+	s := &fakeServer{addr: host, port: ln.Addr().(*net.TCPAddr).Port}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, tlsConfig)
+		}
+	}()
+	return s
+}
+
+// config returns connection settings for the fake server.
+func (s *fakeServer) config() dsqltest.Config {
+	return dsqltest.Config{
+		Hostname: "abcdefghijklmnopqrstuvwxyz.dsql.us-east-1.on.aws",
+		HostAddr: s.addr,
+		Port:     s.port,
+		Password: "secret",
+	}
+}
+
+// protocols returns the protocol of every query received so far.
+func (s *fakeServer) protocols() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *fakeServer) record(protocol string) {
+	s.mu.Lock()
+	s.messages = append(s.messages, protocol)
+	s.mu.Unlock()
+}
+
+func (s *fakeServer) serve(raw net.Conn, tlsConfig *tls.Config) {
+	defer raw.Close()
+	raw.SetDeadline(time.Now().Add(10 * time.Second))
+	msg, err := pgproto3.NewBackend(raw, raw).ReceiveStartupMessage()
+	if err != nil {
+		return
+	}
+	if _, ok := msg.(*pgproto3.SSLRequest); !ok {
+		return
+	}
+	if _, err := raw.Write([]byte{'S'}); err != nil {
+		return
+	}
+	conn := tls.Server(raw, tlsConfig)
+	backend := pgproto3.NewBackend(conn, conn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.ParameterStatus{Name: "server_version", Value: "16.0"})
+	// pgx only sends simple protocol queries to servers that report these
+	backend.Send(&pgproto3.ParameterStatus{Name: "standard_conforming_strings", Value: "on"})
+	backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 2})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if backend.Flush() != nil {
+		return
+	}
+
+	queryErr := &pgproto3.ErrorResponse{Severity: "ERROR", Code: "42883", Message: "not supported by the fake server"}
+	failed := false // in the extended protocol, skip to the Sync
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		switch msg.(type) {
+		case *pgproto3.Query:
+			s.record("simple")
+			backend.Send(queryErr)
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto3.Parse:
+			s.record("extended")
+			if !failed {
+				backend.Send(queryErr)
+				failed = true
+			}
+		case *pgproto3.Sync:
+			failed = false
+			backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		case *pgproto3.Terminate:
+			return
+		}
+		if backend.Flush() != nil {
+			return
+		}
+	}
+}
+
+// selfSignedCert returns a throwaway server certificate, which sslmode
+// require accepts without verifying.
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fake server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// healthGoal ends a --watch run once threshold consecutive probes pass
+// (--until-healthy), or fails it when maxAttempts probes have run without
+// getting there (--max-attempts, 0 for no cap). It turns watch mode into a
+// gate that waits for a newly provisioned cluster to become reachable.
+type healthGoal struct {
+	threshold   int
+	maxAttempts int
+}
+
+// newHealthGoal returns a goal, or nil when threshold is zero and the watch
+// runs until it's stopped.
+func newHealthGoal(threshold, maxAttempts int) *healthGoal {
+	if threshold <= 0 {
+		return nil
+	}
+	return &healthGoal{threshold: threshold, maxAttempts: maxAttempts}
+}
+
+// healthReport is the --until-healthy outcome in the watch summary.
+type healthReport struct {
+	Threshold            int     `json:"threshold"`
+	MaxAttempts          int     `json:"max_attempts,omitempty"`
+	Healthy              bool    `json:"healthy"`
+	Probes               int     `json:"probes"`
+	TimeToHealthySeconds float64 `json:"time_to_healthy_seconds,omitempty"`
+
+	timeToHealthy time.Duration
+}
+
+// check is called after every probe with the summary so far. It reports
+// whether the run is over and, if so, its outcome and exit code: exitOK once
+// healthy, or the last failure's code when the attempts ran out.
+func (g *healthGoal) check(s *watchSummary, elapsed time.Duration, err error) (*healthReport, int, bool) {
+	if g == nil {
+		return nil, exitOK, false
+	}
+	report := &healthReport{Threshold: g.threshold, MaxAttempts: g.maxAttempts, Probes: s.Probes}
+	if s.ConsecutiveSuccesses >= g.threshold {
+		report.Healthy = true
+		report.timeToHealthy = elapsed
+		report.TimeToHealthySeconds = elapsed.Seconds()
+		return report, exitOK, true
+	}
+	if g.maxAttempts > 0 && s.Probes >= g.maxAttempts {
+		code := exitFailure
+		if err != nil {
+			code = exitCodeOf(err)
+		}
+		return report, code, true
+	}
+	return report, exitOK, false
+}
+
+// writeText prints whether the threshold was reached, and how quickly.
+func (r *healthReport) writeText(w io.Writer) {
+	if r.Healthy {
+		fmt.Fprintf(w, "Healthy: %d consecutive OK after %s (%d probes)\n",
+			r.Threshold, r.timeToHealthy.Round(time.Millisecond), r.Probes)
+		return
+	}
+	if r.MaxAttempts > 0 && r.Probes >= r.MaxAttempts {
+		fmt.Fprintf(w, "Not healthy: %d consecutive OK not reached in %d probes (--max-attempts)\n", r.Threshold, r.Probes)
+		return
+	}
+	fmt.Fprintf(w, "Not healthy: %d consecutive OK not reached in %d probes\n", r.Threshold, r.Probes)
+}
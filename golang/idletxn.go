@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// idleTxnTimeoutTest is the idle_in_transaction_session_timeout the idle
+// transaction check sets. It's given in whole seconds so SHOW reports it
+// back in the same form.
+const idleTxnTimeoutTest = 2 * time.Second
+
+// idleTxnGrace is how long past the timeout the check waits for the server
+// to end the session before concluding it won't.
+const idleTxnGrace = 10 * time.Second
+
+// sqlStateIdleInTxnTimeout is idle_in_transaction_session_timeout, sent as
+// a FATAL error when the server ends a session left idle in a transaction.
+const sqlStateIdleInTxnTimeout = "25P03"
+
+// idleTxnCheck opens a session with a small
+// idle_in_transaction_session_timeout, leaves a transaction open and
+// verifies the server terminates the session with SQLSTATE 25P03 once the
+// timeout passes.
+var idleTxnCheck = check{name: "idle-txn-test", run: runIdleTxnTest}
+
+func runIdleTxnTest(ctx context.Context, s *session, r *checkResult) error {
+	timeout := fmt.Sprintf("%ds", int(idleTxnTimeoutTest.Seconds()))
+	r.detail("idle_in_transaction_session_timeout", timeout)
+
+	cfg := s.cfg
+	cfg.conn.RuntimeParams = maps.Clone(cfg.conn.RuntimeParams)
+	if cfg.conn.RuntimeParams == nil {
+		cfg.conn.RuntimeParams = make(map[string]string)
+	}
+	cfg.conn.RuntimeParams["idle_in_transaction_session_timeout"] = timeout
+	conn, err := (&session{cfg: cfg}).connect(ctx)
+	if err := r.step("connect with idle_in_transaction_session_timeout", err); err != nil {
+		return err
+	}
+	defer closeConn(conn)
+
+	var reported string
+	err = conn.QueryRow(ctx, "SHOW idle_in_transaction_session_timeout").Scan(&reported)
+	if err == nil && reported != timeout {
+		err = fmt.Errorf("idle_in_transaction_session_timeout is %q, want %q", reported, timeout)
+	}
+	if err := r.step("session reports idle_in_transaction_session_timeout", err); err != nil {
+		return err
+	}
+
+	// Nothing is committed, so the transaction needs no cleanup when the
+	// server ends it
+	tx, err := conn.Begin(ctx)
+	if err == nil {
+		var one int
+		err = tx.QueryRow(ctx, "SELECT 1").Scan(&one)
+	}
+	if err := r.step("open transaction", err); err != nil {
+		return err
+	}
+
+	// The server sends its FATAL error unprompted when the timeout fires;
+	// waiting for a notification reads it without sending anything that
+	// would count as activity
+	idleStart := time.Now()
+	waitCtx, cancel := context.WithTimeout(ctx, idleTxnTimeoutTest+idleTxnGrace)
+	defer cancel()
+	err = conn.PgConn().WaitForNotification(waitCtx)
+	elapsed := time.Since(idleStart)
+
+	var terminated error
+	var pgErr *pgconn.PgError
+	switch {
+	case ctx.Err() != nil:
+		return r.step("server terminated idle session", ctx.Err())
+	case errors.As(err, &pgErr):
+		r.detail("sqlstate", pgErr.Code)
+		r.detail("terminated_after_ms", durationMs(elapsed))
+		if pgErr.Code != sqlStateIdleInTxnTimeout {
+			terminated = fmt.Errorf("session ended with %w, want SQLSTATE %s", err, sqlStateIdleInTxnTimeout)
+		}
+	case waitCtx.Err() != nil:
+		terminated = fmt.Errorf("session still open after %s idle in a transaction", elapsed.Round(time.Millisecond))
+	case err != nil:
+		r.detail("terminated_after_ms", durationMs(elapsed))
+		terminated = fmt.Errorf("connection closed without SQLSTATE %s: %w", sqlStateIdleInTxnTimeout, err)
+	default:
+		terminated = errors.New("received a notification instead of the session being terminated")
+	}
+	return r.step("server terminated idle session", terminated)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// insertBenchPayloadBytes is the size of each --insert-bench row's text
+// column, so throughput reflects rows of a realistic width.
+const insertBenchPayloadBytes = 100
+
+// insertBenchCheck inserts s.cfg.insertRows rows into a test table in
+// pgx.Batch round trips of s.cfg.insertBatch single-row INSERTs, reporting
+// ingestion throughput and the latency of each batch. COPY isn't used since
+// DSQL doesn't support it.
+var insertBenchCheck = check{name: "insert-bench", run: runInsertBench}
+
+func runInsertBench(ctx context.Context, s *session, r *checkResult) (err error) {
+	rows, size := s.cfg.insertRows, s.cfg.insertBatch
+	r.detail("rows", rows)
+	r.detail("batch_size", size)
+	r.detail("payload_bytes_per_row", insertBenchPayloadBytes)
+
+	table := pgx.Identifier{newTestTableName("insertbench")}.Sanitize()
+	if err := r.step("create table", execStmt(ctx, s.conn,
+		"CREATE TABLE "+table+" (id int PRIMARY KEY, payload text NOT NULL)")); err != nil {
+		return err
+	}
+	defer func() {
+		dropCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if dropErr := r.step("drop table", execStmt(dropCtx, s.conn, "DROP TABLE "+table)); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+
+	insert := "INSERT INTO " + table + " (id, payload) VALUES ($1, $2)"
+	payload := strings.Repeat("x", insertBenchPayloadBytes)
+	latencies := make([]time.Duration, 0, (rows+size-1)/size)
+	inserted := 0
+	start := time.Now()
+	for inserted < rows {
+		n := min(size, rows-inserted)
+		batch := &pgx.Batch{}
+		for id := inserted + 1; id <= inserted+n; id++ {
+			batch.Queue(insert, id, payload)
+		}
+		batchStart := time.Now()
+		if err := s.conn.SendBatch(ctx, batch).Close(); err != nil {
+			r.detail("rows_inserted", inserted)
+			return r.step("insert batches", insertBatchError(r, n, len(latencies)+1, err))
+		}
+		latencies = append(latencies, time.Since(batchStart))
+		inserted += n
+	}
+	elapsed := time.Since(start)
+	r.step("insert batches", nil)
+
+	r.detail("rows_inserted", inserted)
+	r.detail("batches", len(latencies))
+	r.detail("total_ms", durationMs(elapsed))
+	r.detail("rows_per_sec", int(math.Round(float64(inserted)/elapsed.Seconds())))
+	recordPercentiles(r, "batch", latencies)
+	return nil
+}
+
+// insertBatchError describes a failed batch. A batch runs as one implicit
+// transaction, so a server error usually means it exceeded DSQL's
+// per-transaction limits; the rejected size is recorded so a smaller
+// --insert-batch can be chosen.
+func insertBatchError(r *checkResult, size, number int, err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return fmt.Errorf("batch %d: %w", number, err)
+	}
+	r.detail("rejected_batch_size", size)
+	r.detail("sqlstate", pgErr.Code)
+	r.detail("message", pgErr.Message)
+	return fmt.Errorf("server rejected batch %d of %d rows (try a smaller --insert-batch): %w", number, size, err)
+}
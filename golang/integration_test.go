@@ -0,0 +1,380 @@
+//go:build integration
+
+// The integration suite runs the connection paths against a TLS-enabled
+// Postgres container, the closest local stand-in for DSQL. It needs Docker:
+//
+//	go test -tags integration ./...
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const (
+	// integrationHostname stands in for the cluster endpoint: it's sent as
+	// SNI and is one of the names the server certificate covers
+	integrationHostname = "abcdefghijklmnopqrstuvwxyz.dsql.us-east-1.on.aws"
+	integrationPassword = "integration-secret"
+	integrationImage    = "postgres:16-alpine"
+)
+
+// integrationConn reaches the container over TLS, with the SNI override,
+// in sslmode require. Tests copy it and adjust the TLS settings.
+var integrationConn dsqltest.Config
+
+// integrationCA is the PEM file of the CA that signed the server
+// certificate.
+var integrationCA string
+
+func TestMain(m *testing.M) {
+	os.Exit(runIntegration(m))
+}
+
+// runIntegration starts the container, runs the tests and removes the
+// container again.
+func runIntegration(m *testing.M) int {
+	ctx := context.Background()
+	dir, err := os.MkdirTemp("", "dsql-integration")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "integration:", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+
+	ca, err := writeServerCerts(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "integration: failed to create certificates:", err)
+		return 1
+	}
+	integrationCA = ca
+	conf := filepath.Join(dir, "postgresql.conf")
+	if err := os.WriteFile(conf, []byte(sslPostgresConf), 0o644); err != nil {
+		fmt.Fprintln(os.Stderr, "integration:", err)
+		return 1
+	}
+
+	ctr, err := postgres.Run(ctx, integrationImage,
+		postgres.WithUsername(dsqltest.DefaultUser),
+		postgres.WithPassword(integrationPassword),
+		postgres.WithDatabase(dsqltest.DefaultDatabase),
+		postgres.WithConfigFile(conf),
+		postgres.WithSSLCert(ca, filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")),
+		// The init run restarts the server, so it's ready the second time
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if ctr != nil {
+		defer func() {
+			if err := testcontainers.TerminateContainer(ctr); err != nil {
+				fmt.Fprintln(os.Stderr, "integration: failed to remove container:", err)
+			}
+		}()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "integration: failed to start Postgres:", err)
+		return 1
+	}
+	host, err := ctr.Host(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "integration:", err)
+		return 1
+	}
+	port, err := ctr.MappedPort(ctx, "5432/tcp")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "integration:", err)
+		return 1
+	}
+	integrationConn = dsqltest.Config{
+		Hostname:        integrationHostname,
+		HostAddr:        host,
+		Port:            port.Int(),
+		User:            dsqltest.DefaultUser,
+		Database:        dsqltest.DefaultDatabase,
+		SSLMode:         "require",
+		Password:        integrationPassword,
+		ApplicationName: "dsql-integration",
+	}
+	return m.Run()
+}
+
+// sslPostgresConf turns on TLS with the files WithSSLCert copies in.
+const sslPostgresConf = `listen_addresses = '*'
+ssl = on
+ssl_ca_file = '/tmp/testcontainers-go/postgres/ca_cert.pem'
+ssl_cert_file = '/tmp/testcontainers-go/postgres/server.cert'
+ssl_key_file = '/tmp/testcontainers-go/postgres/server.key'
+`
+
+// writeServerCerts writes a CA and a server certificate it signed, valid
+// for integrationHostname and localhost, to dir. It returns the CA's path.
+func writeServerCerts(dir string) (string, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "dsql integration CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return "", err
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: integrationHostname},
+		DNSNames:     []string{integrationHostname, "localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return "", err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	caPath := filepath.Join(dir, "ca.pem")
+	files := []struct {
+		path  string
+		block *pem.Block
+	}{
+		{caPath, &pem.Block{Type: "CERTIFICATE", Bytes: caDER}},
+		{filepath.Join(dir, "server.crt"), &pem.Block{Type: "CERTIFICATE", Bytes: der}},
+		{filepath.Join(dir, "server.key"), &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(f.path, pem.EncodeToMemory(f.block), 0o600); err != nil {
+			return "", err
+		}
+	}
+	return caPath, nil
+}
+
+// integrationContext bounds one test's work against the container.
+func integrationContext(t *testing.T) context.Context {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	t.Cleanup(cancel)
+	return ctx
+}
+
+// connectIntegration connects with c and closes the connection when the
+// test ends.
+func connectIntegration(t *testing.T, c dsqltest.Config) *pgx.Conn {
+	t.Helper()
+	ctx := integrationContext(t)
+	conn, err := dsqltest.Connect(ctx, c)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { closeConn(conn) })
+	return conn
+}
+
+func TestIntegrationSSLModes(t *testing.T) {
+	tests := []struct {
+		name        string
+		sslMode     string
+		rootCert    bool
+		sniHostname string
+	}{
+		{name: "require", sslMode: "require"},
+		{name: "require with root cert", sslMode: "require", rootCert: true},
+		{name: "verify-ca", sslMode: "verify-ca", rootCert: true},
+		{name: "verify-full", sslMode: "verify-full", rootCert: true},
+		{name: "verify-full with sni override", sslMode: "verify-full", rootCert: true, sniHostname: "localhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := integrationConn
+			c.SSLMode = tt.sslMode
+			c.SNIHostname = tt.sniHostname
+			if tt.rootCert {
+				c.SSLRootCert = integrationCA
+			}
+			conn := connectIntegration(t, c)
+			if err := requireTLS(conn); err != nil {
+				t.Fatalf("connection isn't encrypted: %v", err)
+			}
+		})
+	}
+}
+
+func TestIntegrationSSLModeFailures(t *testing.T) {
+	otherCA := filepath.Join(t.TempDir(), "other")
+	if err := os.Mkdir(otherCA, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	otherCAPath, err := writeServerCerts(otherCA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		adjust  func(c *dsqltest.Config)
+		matches func(err error) bool
+	}{
+		{
+			name:    "disable",
+			adjust:  func(c *dsqltest.Config) { c.SSLMode = "disable" },
+			matches: func(err error) bool { return strings.Contains(err.Error(), "DSQL mandates encrypted connections") },
+		},
+		{
+			name: "verify-full with a name the certificate doesn't cover",
+			adjust: func(c *dsqltest.Config) {
+				c.SSLMode, c.SSLRootCert, c.SNIHostname = "verify-full", integrationCA, "wrong.example.com"
+			},
+			matches: dsqltest.IsHostnameMismatch,
+		},
+		{
+			name:    "verify-ca with another CA",
+			adjust:  func(c *dsqltest.Config) { c.SSLMode, c.SSLRootCert = "verify-ca", otherCAPath },
+			matches: isTLSError,
+		},
+		{
+			name:    "wrong password",
+			adjust:  func(c *dsqltest.Config) { c.Password = "not-the-password" },
+			matches: dsqltest.IsAuthError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := integrationConn
+			tt.adjust(&c)
+			conn, err := dsqltest.Connect(integrationContext(t), c)
+			if err == nil {
+				closeConn(conn)
+				t.Fatal("Connect succeeded, want an error")
+			}
+			if !tt.matches(err) {
+				t.Fatalf("Connect failed with an unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestIntegrationInfoQuery(t *testing.T) {
+	conn := connectIntegration(t, integrationConn)
+	for _, simple := range []bool{false, true} {
+		t.Run(fmt.Sprintf("simple protocol %t", simple), func(t *testing.T) {
+			cfg := testConfig{simpleProtocol: simple}
+			info, err := dsqltest.QueryConnectionInfo(integrationContext(t), cfg.infoQuerier(conn))
+			if err != nil {
+				t.Fatalf("QueryConnectionInfo: %v", err)
+			}
+			if info.Database != dsqltest.DefaultDatabase || info.User != dsqltest.DefaultUser {
+				t.Errorf("connected to %s as %s, want %s as %s", info.Database, info.User, dsqltest.DefaultDatabase, dsqltest.DefaultUser)
+			}
+			if !strings.HasPrefix(info.ServerVersion, "PostgreSQL") {
+				t.Errorf("ServerVersion = %q, want a PostgreSQL version", info.ServerVersion)
+			}
+			if info.ApplicationName != integrationConn.ApplicationName {
+				t.Errorf("ApplicationName = %q, want %q", info.ApplicationName, integrationConn.ApplicationName)
+			}
+			if len(info.Unavailable) > 0 {
+				t.Errorf("fields unavailable: %v", info.Unavailable)
+			}
+		})
+	}
+}
+
+func TestIntegrationRoundTrip(t *testing.T) {
+	cfg := testConfig{
+		conn:    integrationConn,
+		retries: newRetryBudget(),
+		samples: 1,
+		timeout: 30 * time.Second,
+		checks:  []check{roundTripCheck},
+	}
+	result := &ConnectionResult{}
+	if err := runConnectivityTest(integrationContext(t), cfg, io.Discard, result); err != nil {
+		t.Fatalf("connectivity test failed: %v", err)
+	}
+	if !result.Success || !result.SSL {
+		t.Errorf("Success = %t, SSL = %t, want both true", result.Success, result.SSL)
+	}
+	var ran bool
+	for _, sub := range result.Report.Tests {
+		if sub.Name == roundTripCheck.name {
+			ran = true
+			if sub.Status != statusPass {
+				t.Errorf("roundtrip %s: %s", sub.Status, sub.Error)
+			}
+		}
+	}
+	if !ran {
+		t.Errorf("roundtrip check missing from the report: %+v", result.Report.Tests)
+	}
+}
+
+func TestIntegrationCancelMidQuery(t *testing.T) {
+	cfg := testConfig{conn: integrationConn, timeout: 30 * time.Second}
+	rc, err := newReconnectingConn(integrationContext(t), cfg)
+	if err != nil {
+		t.Fatalf("newReconnectingConn: %v", err)
+	}
+	defer rc.close()
+
+	// Cancel while pg_sleep is running on the server
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err = rc.do(ctx, func(conn *pgx.Conn) error {
+		return execStmt(ctx, conn, "SELECT pg_sleep(10)")
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("query error = %v, want a deadline exceeded", err)
+	}
+
+	// The interrupted connection must not be handed out again as is: the
+	// next call gets a usable one, reconnecting if pgx closed it
+	var one int
+	_, err = rc.do(integrationContext(t), func(conn *pgx.Conn) error {
+		return conn.QueryRow(integrationContext(t), "SELECT 1").Scan(&one)
+	})
+	if err != nil || one != 1 {
+		t.Fatalf("query after the cancel = %d, %v; want 1, nil", one, err)
+	}
+}
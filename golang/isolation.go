@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// isolationCheck reports the isolation level sessions get and runs the
+// classic write-skew scenario: two concurrent transactions each read both
+// rows of an on-call table, find two doctors on call, and take a different
+// one off. Serializable isolation must abort one of them; snapshot
+// isolation, which DSQL's REPEATABLE READ provides, only detects writes to
+// the same row and lets both commit.
+var isolationCheck = check{name: "isolation-test", run: runIsolationTest}
+
+func runIsolationTest(ctx context.Context, s *session, r *checkResult) (err error) {
+	var defaultLevel string
+	err = s.conn.QueryRow(ctx, "SHOW default_transaction_isolation").Scan(&defaultLevel)
+	if err := r.step("read default_transaction_isolation", err); err != nil {
+		return err
+	}
+	r.detail("default_isolation", defaultLevel)
+
+	// Ask for serializable explicitly, falling back to repeatable read if
+	// the server won't run it, and see what the transaction really gets
+	level, requested, err := beginIsolated(ctx, s.conn, r)
+	if err != nil {
+		return err
+	}
+	r.detail("requested_isolation", string(requested))
+	r.detail("effective_isolation", level)
+
+	table := pgx.Identifier{newTestTableName("isolation")}.Sanitize()
+	if err := r.step("create table", execStmt(ctx, s.conn,
+		"CREATE TABLE "+table+" (id int PRIMARY KEY, on_call bool NOT NULL)")); err != nil {
+		return err
+	}
+	defer func() {
+		dropCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if dropErr := r.step("drop table", execStmt(dropCtx, s.conn, "DROP TABLE "+table)); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+	// The insert can hit a schema conflict while the new table propagates
+	_, err = retryOnConflict(ctx, s.cfg.retries, func(ctx context.Context) error {
+		return execStmt(ctx, s.conn, "INSERT INTO "+table+" (id, on_call) VALUES (1, true), (2, true)")
+	}, conflictRetries)
+	if err := r.step("insert rows", err); err != nil {
+		return err
+	}
+
+	other, err := s.connect(ctx)
+	if err := r.step("open second connection", err); err != nil {
+		return err
+	}
+	defer closeConn(other)
+
+	opts := pgx.TxOptions{IsoLevel: requested}
+	txA, err := s.conn.BeginTx(ctx, opts)
+	if err := r.step("begin transaction A", err); err != nil {
+		return err
+	}
+	defer txA.Rollback(context.Background())
+	txB, err := other.BeginTx(ctx, opts)
+	if err := r.step("begin transaction B", err); err != nil {
+		return err
+	}
+	defer txB.Rollback(context.Background())
+
+	// Both read before either writes, so each decides from the same state
+	count := "SELECT count(*) FROM " + table + " WHERE on_call"
+	var seenA, seenB int
+	err = txA.QueryRow(ctx, count).Scan(&seenA)
+	if err == nil {
+		err = txB.QueryRow(ctx, count).Scan(&seenB)
+	}
+	if err == nil && (seenA != 2 || seenB != 2) {
+		err = fmt.Errorf("transactions saw %d and %d rows on call, expected 2", seenA, seenB)
+	}
+	if err := r.step("read on-call rows in A and B", err); err != nil {
+		return err
+	}
+	if err := r.step("take row 1 off call in A", execTx(ctx, txA, "UPDATE "+table+" SET on_call = false WHERE id = 1")); err != nil {
+		return err
+	}
+	if err := r.step("take row 2 off call in B", execTx(ctx, txB, "UPDATE "+table+" SET on_call = false WHERE id = 2")); err != nil {
+		return err
+	}
+	if err := r.step("commit A", txA.Commit(ctx)); err != nil {
+		return err
+	}
+
+	// DSQL reports a lost race as an OCC conflict, Postgres as a
+	// serialization failure
+	commitErr := txB.Commit(ctx)
+	switch code := sqlState(commitErr); {
+	case commitErr == nil:
+		r.step("commit B", nil)
+		r.detail("write_skew_prevented", false)
+	case isOCCConflict(commitErr) || code == sqlStateSerializationFailure:
+		r.step("commit B rejected with SQLSTATE "+code, nil)
+		r.detail("write_skew_prevented", true)
+		r.detail("sqlstate", code)
+	default:
+		return r.step("commit B", commitErr)
+	}
+
+	var remaining int
+	err = s.conn.QueryRow(ctx, count).Scan(&remaining)
+	if err == nil && (commitErr == nil) != (remaining == 0) {
+		err = fmt.Errorf("%d rows left on call after commit B returned %v", remaining, commitErr)
+	}
+	if err := r.step("verify committed state", err); err != nil {
+		return err
+	}
+
+	// Only a serializable transaction promises to prevent write skew
+	if level == "serializable" && commitErr == nil {
+		return r.step("write skew prevented", errors.New("both transactions committed under serializable isolation, leaving no row on call"))
+	}
+	return nil
+}
+
+// beginIsolated opens a transaction at serializable isolation, or at
+// repeatable read if the server rejects serializable, and returns the level
+// it asked for and the one the transaction reported.
+func beginIsolated(ctx context.Context, conn *pgx.Conn, r *checkResult) (level string, requested pgx.TxIsoLevel, err error) {
+	requested = pgx.Serializable
+	level, err = transactionIsolation(ctx, conn, requested)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && !isConnectionClass(pgErr.Code) {
+		r.detail("serializable", fmt.Sprintf("rejected (%s: %s)", pgErr.Code, pgErr.Message))
+		requested = pgx.RepeatableRead
+		level, err = transactionIsolation(ctx, conn, requested)
+	}
+	return level, requested, r.step("begin "+string(requested)+" transaction", err)
+}
+
+// transactionIsolation returns the transaction_isolation a transaction
+// begun at iso reports. The transaction is rolled back.
+func transactionIsolation(ctx context.Context, conn *pgx.Conn, iso pgx.TxIsoLevel) (string, error) {
+	tx, err := conn.BeginTx(ctx, pgx.TxOptions{IsoLevel: iso})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(context.Background())
+	var level string
+	err = tx.QueryRow(ctx, "SHOW transaction_isolation").Scan(&level)
+	return level, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"time"
+)
+
+// latencySummary describes the distribution of a set of latency samples.
+type latencySummary struct {
+	Count  int     `json:"count"`
+	MinMs  float64 `json:"min_ms"`
+	MaxMs  float64 `json:"max_ms"`
+	MeanMs float64 `json:"mean_ms"`
+	P95Ms  float64 `json:"p95_ms"`
+}
+
+// summarizeLatencies computes min/max/mean/p95 over samples.
+func summarizeLatencies(samples []time.Duration) *latencySummary {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+
+	var total time.Duration
+	for _, s := range sorted {
+		total += s
+	}
+
+	return &latencySummary{
+		Count:  len(sorted),
+		MinMs:  durationMs(sorted[0]),
+		MaxMs:  durationMs(sorted[len(sorted)-1]),
+		MeanMs: durationMs(total / time.Duration(len(sorted))),
+		P95Ms:  durationMs(percentile(sorted, 95)),
+	}
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of sorted samples.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	rank = max(1, min(rank, len(sorted)))
+	return sorted[rank-1]
+}
+
+// writeText prints the summary on a single line.
+func (s *latencySummary) writeText(w io.Writer, label string) {
+	fmt.Fprintf(w, "%s (%d samples): min %.2fms, max %.2fms, mean %.2fms, p95 %.2fms\n",
+		label, s.Count, s.MinMs, s.MaxMs, s.MeanMs, s.P95Ms)
+}
+
+// durationMs converts d to fractional milliseconds for reporting.
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// latencyLimits are the --max-connect-latency and --max-query-latency
+// bounds. A zero bound isn't enforced.
+type latencyLimits struct {
+	connect time.Duration
+	query   time.Duration
+}
+
+// latencyThreshold is one enforced bound and the latency it was held to.
+type latencyThreshold struct {
+	Phase       string  `json:"phase"`
+	MeasuredMs  float64 `json:"measured_ms"`
+	ThresholdMs float64 `json:"threshold_ms"`
+	Exceeded    bool    `json:"exceeded"`
+}
+
+// set reports whether any bound is enforced.
+func (l latencyLimits) set() bool {
+	return l.connect > 0 || l.query > 0
+}
+
+// enforce compares result's connect and query latencies against the bounds,
+// recording each in result. A connection that was slower than allowed is
+// an error even though it worked: the run is held to a response time, not
+// just reachability.
+func (l latencyLimits) enforce(result *ConnectionResult) error {
+	result.LatencyThresholds = nil
+	var exceeded []string
+	measure := func(phase, flag string, measured float64, limit time.Duration) {
+		if limit <= 0 {
+			return
+		}
+		t := latencyThreshold{Phase: phase, MeasuredMs: measured, ThresholdMs: durationMs(limit)}
+		t.Exceeded = t.MeasuredMs > t.ThresholdMs
+		if t.Exceeded {
+			exceeded = append(exceeded, fmt.Sprintf("%s latency %.2fms exceeds --%s %s", phase, measured, flag, limit))
+		}
+		result.LatencyThresholds = append(result.LatencyThresholds, t)
+	}
+	measure("connect", "max-connect-latency", result.ConnectLatencyMs, l.connect)
+	measure("query", "max-query-latency", result.QueryLatencyMs, l.query)
+	if len(exceeded) == 0 {
+		return nil
+	}
+	return withExitCode(exitQuery, errors.New(strings.Join(exceeded, "; ")))
+}
+
+// writeLatencyThresholds prints each enforced bound next to the latency it
+// was held to.
+func writeLatencyThresholds(w io.Writer, thresholds []latencyThreshold) {
+	for _, t := range thresholds {
+		verdict := okOrFail(true, "within")
+		if t.Exceeded {
+			verdict = okOrFail(false, "exceeded")
+		}
+		fmt.Fprintf(w, "Max %s Latency: %.2fms (measured %.2fms, %s)\n", strings.ToUpper(t.Phase[:1])+t.Phase[1:], t.ThresholdMs, t.MeasuredMs, verdict)
+	}
+}
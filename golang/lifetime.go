@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMaxConnLifetime is DSQL's documented cap on how long a connection
+// stays open, however active it is.
+const defaultMaxConnLifetime = 60 * time.Minute
+
+// defaultLifetimeWarn is the fraction of --max-conn-lifetime after which a
+// held connection is reported as about to be closed.
+const defaultLifetimeWarn = 0.9
+
+// errLifetimeNearing is the reconnect cause for a connection replaced before
+// the server closes it.
+var errLifetimeNearing = errors.New("connection is nearing --max-conn-lifetime")
+
+// connLifetime counts down to the expected end of one held connection and
+// logs a warning once it has used up cfg.lifetimeWarn of it. A nil
+// connLifetime never warns.
+type connLifetime struct {
+	timer   *time.Timer
+	nearing atomic.Bool
+}
+
+// watchLifetime starts the countdown for a connection to hostaddr opened
+// now. It returns nil when cfg.maxConnLifetime is zero.
+func watchLifetime(cfg testConfig, hostaddr string) *connLifetime {
+	if cfg.maxConnLifetime <= 0 {
+		return nil
+	}
+	l := &connLifetime{}
+	opened := time.Now()
+	warnAfter := time.Duration(float64(cfg.maxConnLifetime) * cfg.lifetimeWarn)
+	l.timer = time.AfterFunc(warnAfter, func() {
+		l.nearing.Store(true)
+		age := time.Since(opened)
+		slog.Warn("connection is nearing DSQL's maximum lifetime, reconnect to avoid a mid-operation close",
+			"hostaddr", hostaddr, "age", age.Round(time.Second).String(),
+			"max_conn_lifetime", cfg.maxConnLifetime.String(), "remaining", (cfg.maxConnLifetime - age).Round(time.Second).String())
+	})
+	return l
+}
+
+// isNearing reports whether the warning has fired.
+func (l *connLifetime) isNearing() bool {
+	return l != nil && l.nearing.Load()
+}
+
+// stop ends the countdown once the connection is closed.
+func (l *connLifetime) stop() {
+	if l != nil {
+		l.timer.Stop()
+	}
+}
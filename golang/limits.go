@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// --limits-probe sizing. Rows are added in batches so the threshold is
+// found within limitsBatchRows; the probe stops at limitsMaxRows so it
+// terminates against a server without per-transaction limits.
+const (
+	limitsBatchRows    = 500
+	limitsMaxRows      = 100000
+	limitsPayloadBytes = 100
+)
+
+// limitsCheck inserts rows into one transaction until DSQL rejects it for
+// exceeding its per-transaction row or size limit, then reports roughly
+// where the limit was and the exact error returned.
+var limitsCheck = check{name: "limits-probe", run: runLimitsProbe}
+
+func runLimitsProbe(ctx context.Context, s *session, r *checkResult) (err error) {
+	table := pgx.Identifier{newTestTableName("limits")}.Sanitize()
+
+	if err := r.step("create table", execStmt(ctx, s.conn,
+		"CREATE TABLE "+table+" (id int PRIMARY KEY, payload text NOT NULL)")); err != nil {
+		return err
+	}
+	defer func() {
+		dropCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if dropErr := r.step("drop table", execStmt(dropCtx, s.conn, "DROP TABLE "+table)); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+
+	tx, err := s.conn.Begin(ctx)
+	if err := r.step("begin transaction", err); err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+
+	insert := "INSERT INTO " + table + " (id, payload) SELECT g, repeat('x', $3) FROM generate_series($1::int, $2::int) g"
+	inserted := 0
+	var limitErr error
+	for inserted < limitsMaxRows {
+		if err := execTx(ctx, tx, insert, inserted+1, inserted+limitsBatchRows, limitsPayloadBytes); err != nil {
+			limitErr = err
+			break
+		}
+		inserted += limitsBatchRows
+	}
+
+	// Some limits are only enforced when the transaction commits
+	if limitErr == nil {
+		if err := tx.Commit(ctx); err != nil {
+			limitErr = err
+		}
+	}
+
+	r.detail("rows_accepted", inserted)
+	r.detail("payload_bytes_per_row", limitsPayloadBytes)
+	if limitErr == nil {
+		r.detail("result", fmt.Sprintf("no limit reached within %d rows", limitsMaxRows))
+		return r.step("transaction committed", nil)
+	}
+
+	// A context or connection error isn't a limit
+	var pgErr *pgconn.PgError
+	if !errors.As(limitErr, &pgErr) {
+		return r.step("hit transaction limit", limitErr)
+	}
+	r.detail("threshold", fmt.Sprintf("between %d and %d rows", inserted, inserted+limitsBatchRows))
+	r.detail("sqlstate", pgErr.Code)
+	r.detail("message", pgErr.Message)
+	if pgErr.Detail != "" {
+		r.detail("error_detail", pgErr.Detail)
+	}
+	return r.step("hit transaction limit", nil)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// newLogger builds the process logger for --log-level and --log-format.
+// Logs go to w (stderr) so they never mix with the report on stdout.
+func newLogger(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	switch strings.ToLower(level) {
+	case "debug":
+		lvl = slog.LevelDebug
+	case "info":
+		lvl = slog.LevelInfo
+	case "warn":
+		lvl = slog.LevelWarn
+	case "error":
+		lvl = slog.LevelError
+	default:
+		return nil, fmt.Errorf("unsupported --log-level %q (expected debug, info, warn or error)", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unsupported --log-format %q (expected text or json)", format)
+	}
+}
+
+// defaultLogLevel keeps DSQL_DEBUG=true working as a shorthand for
+// --log-level debug.
+func defaultLogLevel() string {
+	if os.Getenv("DSQL_DEBUG") == "true" {
+		return "debug"
+	}
+	return "info"
+}
@@ -1,95 +1,1095 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"net/url"
+	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
 
-	"github.com/jackc/pgx/v5"
+	"dsql-connectivity-experiment/dsqltest"
 )
 
 func main() {
-	fmt.Println("DSQL Connectivity Test - Golang")
-	fmt.Println("================================")
+	os.Exit(run())
+}
+
+// run parses flags, runs the connectivity test and returns the process exit
+// code. Keeping this separate from main lets deferred cleanup run before exit.
+func run() (code int) {
+	// Subcommands come before any flags and parse their own
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		return runTokenCommand(os.Args[2:], os.Stdout)
+	}
+
+	region := flag.String("region", "", "AWS region of the DSQL cluster, used for IAM auth (default: AWS_REGION or the profile's region)")
+	profile := flag.String("profile", "", "AWS shared config profile used for IAM auth (default: AWS_PROFILE)")
+	assumeRoleARN := flag.String("assume-role-arn", "", "IAM role to assume through STS before signing auth tokens")
+	externalID := flag.String("external-id", "", "External ID required by the --assume-role-arn trust policy")
+	tokenSkew := flag.Duration("token-refresh-skew", dsqltest.DefaultTokenRefreshSkew, "Regenerate IAM auth tokens this long before they expire")
+	poolFlag := flag.Bool("pool", false, "Use a pgxpool connection pool instead of a single connection (or set DSQL_USE_POOL=true)")
+	poolOpts := dsqltest.PoolOptions{}
+	flag.IntVar(&poolOpts.MaxConns, "pool-max-conns", 0, "Maximum connections in the pool (default: pgxpool default)")
+	flag.IntVar(&poolOpts.MinConns, "pool-min-conns", 0, "Minimum idle connections kept open by the pool")
+	prewarmPool := flag.Bool("prewarm-pool", false, "Open all --pool-min-conns connections before the test starts and report how long it took")
+	flag.DurationVar(&poolOpts.MaxConnLifetime, "pool-max-conn-lifetime", dsqltest.DefaultPoolMaxConnLifetime, "Maximum lifetime of a pooled connection (keep below DSQL's 60-minute cap)")
+	retry := dsqltest.RetryPolicy{}
+	flag.IntVar(&retry.MaxAttempts, "retries", dsqltest.DefaultRetries, "Maximum connection attempts for transient failures")
+	flag.DurationVar(&retry.BaseDelay, "retry-base-delay", dsqltest.DefaultRetryBaseDelay, "Initial delay between connection attempts, doubled on each retry (longer when throttled)")
+	flag.DurationVar(&retry.MaxDelay, "max-backoff", dsqltest.DefaultMaxBackoff, "Maximum delay between connection attempts")
+	showVersion := flag.Bool("version", false, "Print the version, commit, build date and pgx version, then exit")
+	showSchema := flag.Bool("json-schema", false, "Print the JSON Schema of --format json output, then exit")
+	colorMode := flag.String("color", "auto", "Color human output: auto (only on a terminal without NO_COLOR), always or never")
+	quiet := flag.Bool("quiet", false, "Print nothing on success; on failure print the usual output and the error")
+	verbose := flag.Bool("v", false, "Add each sub-test's steps and details below the test report")
+	veryVerbose := flag.Bool("vv", false, "Like -v, with step and connect phase timings and every retry made")
+	format := flag.String("format", "text", "Output format: text, json, jsonl (one JSON record per probe with --watch) or csv (latency samples)")
+	templateText := flag.String("template", "", "Go text/template rendering the result in place of the text output, e.g. '{{.Database}} {{.QueryLatencyMs}}ms'")
+	outputPath := flag.String("output", "", "Write the report to this file instead of stdout, replacing it atomically when the run ends")
+	appendOutput := flag.Bool("append", false, "Append to --output instead of replacing it (--format jsonl only)")
+	samples := flag.Int("samples", 1, "Number of times to run the info query for latency statistics")
+	timeout := flag.Duration("timeout", defaultTimeout, "Deadline for the whole connect and query attempt")
+	queryTimeout := flag.Duration("query-timeout", 0, "Deadline for the query phase alone, within --timeout (0: only --timeout applies)")
+	tokenRetries := flag.Int("token-retries", dsqltest.DefaultTokenRetries, "Retries of a failed IAM auth token generation, with exponential backoff; expired or invalid credentials fail at once")
+	tokenTimeout := flag.Duration("token-timeout", 0, "Deadline for generating each IAM auth token, including fetching credentials (0: only --timeout applies)")
+	watch := flag.Bool("watch", false, "Probe the cluster repeatedly until interrupted")
+	interval := flag.Duration("interval", defaultWatchInterval, "Delay between probes in --watch and --wait-for-ready mode, or pings in --duration-cap-test")
+	query := flag.String("query", "", "SQL to run in place of the built-in connection info query")
+	maxRows := flag.Int("max-rows", defaultMaxRows, "Rows of a --query result to keep and print, reading and discarding the rest; 0 keeps every row")
+	verifyQuery := flag.String("verify-query", "", "Query that must return a single true boolean, e.g. \"SELECT current_user = 'admin'\", run as a check after connecting")
+	baselinePath := flag.String("baseline", "", "JSON file of server settings to compare the cluster's current_setting values against, run as a check after connecting")
+	saveBaselineFlag := flag.Bool("save-baseline", false, "Write the cluster's current settings to the --baseline file instead of comparing")
+	expectVersion := flag.String("expect-version", "", "Regular expression the server's version() string must match, e.g. \"^PostgreSQL 16\\.\", run as a check after connecting")
+	var queryFile queryFiles
+	flag.Var(&queryFile, "query-file", "File containing SQL to run in place of the built-in connection info query; repeat it to run each file's statements in sequence as a check")
+	maxConnectLatency := flag.Duration("max-connect-latency", 0, "Fail the run if connecting takes longer than this, even though it succeeded (0 disables)")
+	maxQueryLatency := flag.Duration("max-query-latency", 0, "Fail the run if the info query, or --query, takes longer than this on its first sample (0 disables)")
+	continueOnError := flag.Bool("continue-on-error", false, "Run every --query-file even after one fails, instead of stopping at the first failure")
+	concurrency := flag.Int("concurrency", 0, "Open this many connections at once and report how many the cluster accepts (workers with --bench)")
+	reuseVsFresh := flag.Bool("reuse-vs-fresh", false, "Run --iterations queries on one reused connection and as many on a fresh connection each, and compare the latency")
+	iterations := flag.Int("iterations", defaultReuseIterations, "Queries each strategy runs in --reuse-vs-fresh")
+	tokenBench := flag.Int("token-bench", 0, "Generate this many IAM auth tokens without connecting and report the latency of token generation alone")
+	reconnectTest := flag.Int("reconnect-test", 0, "Connect and close this many times in a row, without a pool, and report the connect latency and IAM token reuse")
+	bench := flag.Bool("bench", false, "Measure sustained query throughput for --duration")
+	benchDuration := flag.Duration("duration", defaultBenchDuration, "How long --bench runs")
+	durationCapTest := flag.Bool("duration-cap-test", false, "Hold an idle connection, pinging every --interval, and report how long DSQL keeps it open")
+	maxWait := flag.Duration("max-wait", defaultCapMaxWait, "Give up on --duration-cap-test if the connection is still open after this long")
+	rateLimit := flag.Float64("rate", 0, "Limit connects (and --bench queries) to this many per second in --watch, --bench and --concurrency")
+	roundRobin := flag.Bool("round-robin", false, "Rotate connects across the comma-separated --hostaddr list in --watch, --bench and --concurrency instead of trying it in order")
+	warmup := flag.Int("warmup", 0, "Discarded connect and query cycles to run before --bench starts measuring")
+	configFile := flag.String("config", "", "YAML, JSON or TOML file (by extension) listing clusters to test in one run")
+	discover := flag.Bool("discover", false, "Test every cluster the DSQL ListClusters API returns in --region (comma-separated for several) using IAM auth")
+	parallel := flag.Int("parallel", 1, "Test up to this many --config clusters at once")
+	breakerThreshold := flag.Int("breaker-threshold", 0, "In --watch mode, back off to --breaker-interval after this many consecutive failures (0 disables)")
+	breakerInterval := flag.Duration("breaker-interval", defaultBreakerInterval, "Delay between --watch probes while the circuit breaker is open")
+	dedupWindow := flag.Duration("dedup-window", 0, "In --watch mode, print a passing probe only after a failure, and roll the rest up into one line per window (e.g. 5m)")
+	untilHealthy := flag.Int("until-healthy", 0, "In --watch mode, exit 0 after this many consecutive passing probes instead of running until stopped")
+	maxAttempts := flag.Int("max-attempts", 0, "With --until-healthy, exit non-zero after this many probes without becoming healthy (0 means no cap)")
+	waitForReady := flag.Duration("wait-for-ready", 0, "Run the connectivity test every --interval until it passes or this long has passed, reporting a cluster still being created as PROVISIONING rather than DOWN")
+	reuseConn := flag.Bool("reuse-conn", false, "In --watch mode, keep one connection open and reconnect when DSQL closes it")
+	maxConnLifetime := flag.Duration("max-conn-lifetime", defaultMaxConnLifetime, "How long DSQL is expected to keep a connection open, for warnings about held connections (0 disables)")
+	lifetimeWarn := flag.Float64("lifetime-warn", defaultLifetimeWarn, "Warn when a held connection reaches this fraction of --max-conn-lifetime, and reconnect --reuse-conn and --bench connections")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "Export OpenTelemetry traces to this OTLP/HTTP endpoint (e.g. http://localhost:4318)")
+	ping := flag.Bool("ping", false, "Only connect and ping the server, for health checks (no retries, no query)")
+	pingTimeout := flag.Duration("ping-timeout", defaultPingTimeout, "Deadline for the connect and ping in --ping mode")
+	preflight := flag.Bool("preflight", false, "Check DNS resolution and TCP reachability of --hostaddr before connecting")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration (password redacted) before connecting")
+	explain := flag.Bool("explain", false, "Print where each connection setting came from (flag, environment or default), password redacted, before connecting")
+	demo := flag.Bool("demo", false, "Walk through the connect and info query against a local Postgres instead of DSQL, with sslmode disable and no password allowed (requires DSQL_DEMO=true)")
+	dumpPgxConfig := flag.Bool("dump-pgx-config", false, "Print the pgx connection config as resolved after all overrides (password redacted) right before connecting, for debugging pgx behavior")
+	dryRun := flag.Bool("dry-run", false, "Print the effective configuration, validate it and exit without connecting")
+	statsdAddr := flag.String("statsd-addr", "", "Send connect and query latency and success/failure counts to this DogStatsD address over UDP (e.g. 127.0.0.1:8125)")
+	metricsAddr := flag.String("metrics-addr", "", "Serve Prometheus metrics at /metrics, and the latest probe result at /healthz, on this address in --watch mode (e.g. :9100)")
+	roundtrip := flag.Bool("roundtrip", false, "Run an insert/select round-trip check against a temporary table")
+	typesTest := flag.Bool("types-test", false, "Write and read back a row of common column types and verify each value")
+	prepared := flag.Bool("prepared", false, "Prepare a parameterized statement and execute it with several arguments")
+	batchSize := flag.Int("batch", 0, "Run this many queries one at a time and then as a single pipelined batch, and compare")
+	simpleProtocol := flag.Bool("simple-protocol", false, "Run the built-in info query over the simple query protocol, whatever --exec-mode says")
+	comparePrepared := flag.Int("compare-prepared", 0, "Run the info query (or --query) this many times each over the simple protocol and as a prepared statement, and compare latency")
+	insertBench := flag.Bool("insert-bench", false, "Insert --rows rows into a test table in batches of --insert-batch and report rows/sec and per-batch latency")
+	insertRows := flag.Int("rows", 1000, "Rows the --insert-bench check inserts")
+	insertBatch := flag.Int("insert-batch", 100, "Rows per pipelined batch, and so per transaction, in the --insert-bench check")
+	copyTest := flag.Bool("copy-test", false, "Try pgx's CopyFrom (the COPY protocol) into a test table and report whether the server accepts it")
+	limitsProbe := flag.Bool("limits-probe", false, "Insert rows in one transaction until DSQL's per-transaction limit rejects it")
+	capabilities := flag.Bool("capabilities", false, "Report server settings and probe which Postgres features DSQL supports")
+	unsupportedProbe := flag.Bool("unsupported-probe", false, "Run statements DSQL is known to restrict (VACUUM, TRUNCATE, CREATE EXTENSION, ...) and report whether each was accepted or its exact error")
+	occTest := flag.Bool("occ-test", false, "Demonstrate DSQL optimistic concurrency with two conflicting transactions")
+	writeContention := flag.Int("write-contention", 0, "Commit this many concurrent transactions updating the same row and report how many conflict")
+	contentionRetry := flag.Bool("contention-retry", false, "Retry each conflicting --write-contention writer until it commits, and report the attempts needed")
+	cleanup := flag.Bool("cleanup", false, "Drop the "+testTablePrefix+"* tables interrupted runs left behind, and report how many were dropped")
+	cleanupMinAge := flag.Duration("cleanup-min-age", time.Hour, "Keep --cleanup tables created more recently than this, as a concurrent run may still be using them (0 drops all)")
+	timeoutTest := flag.Bool("timeout-test", false, "Set a small statement_timeout at connect time and verify the server cancels a slow query")
+	cancelTest := flag.Bool("cancel-test", false, "Cancel a slow query through a cancel request and verify the server stops it promptly")
+	isolationTest := flag.Bool("isolation-test", false, "Report the transaction isolation level and check whether a write-skew scenario across two transactions is prevented (creates and drops a test table)")
+	idleTxnTest := flag.Bool("idle-txn-test", false, "Set a small idle_in_transaction_session_timeout at connect time and verify the server ends a session left idle in a transaction")
+	failFast := flag.Bool("fail-fast", false, "Stop at the first failing check and report the rest as skipped, instead of running every check")
+	var failover failoverEndpoints
+	flag.Var(&failover, "failover", "Write on one endpoint and time until another can read it: primary=<addr> and secondary=<addr>, each [hostname@]hostaddr[:port]")
+	var sshOpts sshTunnelOptions
+	flag.StringVar(&sshOpts.dest, "ssh-tunnel", "", "Forward a local port through this SSH bastion, user@host[:port], to --hostaddr (or --host) and connect through it")
+	flag.StringVar(&sshOpts.keyFile, "ssh-key", "", "Private key for --ssh-tunnel (default: ssh-agent, then ~/.ssh/id_ed25519, id_ecdsa or id_rsa)")
+	flag.StringVar(&sshOpts.knownHosts, "ssh-known-hosts", "", "known_hosts file the --ssh-tunnel bastion's host key must be listed in (default ~/.ssh/known_hosts)")
+	var compare compareEndpoints
+	flag.Var(&compare, "compare", "Run the --bench benchmark against two endpoints and compare p50/p95: addrA=<addr> and addrB=<addr>, each [hostname@]hostaddr[:port]")
+	failoverWait := flag.Duration("failover-wait", defaultFailoverWait, "How long --failover polls the secondary for the row written on the primary")
+	correlationID := flag.String("correlation-id", "", "Identifier for this run, appended to application_name and logged with every message (default: a random UUID)")
+	logLevel := flag.String("log-level", defaultLogLevel(), "Log level: debug, info, warn or error (DSQL_DEBUG=true defaults to debug)")
+	logFormat := flag.String("log-format", "text", "Log format on stderr: text or json")
+	envFile := flag.String("env-file", "", "Load KEY=VALUE environment variables from this file (the real environment wins)")
+	trace := flag.Bool("trace", false, "Log every query pgx sends, with its arguments and timing (implies --log-level debug)")
+	connFlags := registerConnFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s token [flags]: print an IAM auth token and exit (see %s token -h)\n", os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), exitCodeHelp)
+	}
+	flag.Parse()
 
-	// Parse environment variables
-	hostname := os.Getenv("HOSTNAME")
-	hostaddr := os.Getenv("PGHOSTADDR")
-	password := os.Getenv("PGPASSWORD")
-	sslmode := os.Getenv("PGSSLMODE")
+	// A run does one thing: the connectivity test or one of the modes that
+	// replace it. A --compare is its own --bench, and without --bench
+	// --concurrency is a mode rather than the number of workers. A conflict
+	// is reported once the output is set up
+	mode, modeErr := selectMode([]modeFlag{
+		{modeConfig, *configFile != ""},
+		{modeDiscover, *discover},
+		{modeCompare, compare.set()},
+		{modeFailover, failover.set()},
+		{modeTokenBench, *tokenBench > 0},
+		{modePing, *ping},
+		{modeBench, *bench && !compare.set()},
+		{modeReuseVsFresh, *reuseVsFresh},
+		{modeReconnectTest, *reconnectTest > 0},
+		{modeDurationCap, *durationCapTest},
+		{modeWaitForReady, *waitForReady > 0},
+		{modeWatch, *watch},
+		{modeCleanup, *cleanup},
+		{modeWriteContention, *writeContention > 0},
+		{modeConcurrency, *concurrency > 0 && !*bench && !compare.set()},
+	})
+	multiCluster := mode == modeConfig || mode == modeDiscover
+	benchMode := mode == modeBench || mode == modeCompare
+
+	// The protocol trace is logged at debug level, so it needs that level on
+	if *trace {
+		*logLevel = "debug"
+	}
+	// Quiet runs log only errors unless a level was asked for explicitly
+	if *quiet && !*trace && !flagSet("log-level") {
+		*logLevel = "error"
+	}
+	logger, err := newLogger(os.Stderr, *logLevel, *logFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	// Every log line carries the run's correlation ID, so it can be joined
+	// with the server-side sessions that carry it in application_name
+	runID, err := resolveCorrelationID(*correlationID)
+	if err != nil {
+		logger.Error("invalid --correlation-id", "error", err)
+		return exitConfig
+	}
+	slog.SetDefault(logger.With("correlation_id", runID))
 
-	// Validate required environment variables
-	if hostname == "" {
-		log.Fatal("HOSTNAME environment variable is required")
+	if *format != "text" && *format != "json" && *format != "jsonl" && *format != "csv" {
+		slog.Error("unsupported output format", "format", *format, "expected", "text, json, jsonl or csv")
+		return exitConfig
+	}
+	if *format == "jsonl" && mode != modeWatch {
+		slog.Error("--format jsonl requires --watch")
+		return exitConfig
+	}
+	if *format == "csv" && (*showVersion || onlyModes("--format csv", mode, modeSingle, modeBench) != nil) {
+		slog.Error("--format csv only supports a single test run and --bench")
+		return exitConfig
+	}
+	var resultTemplate *template.Template
+	if *templateText != "" {
+		if *format != "text" {
+			slog.Error("--template replaces the text output and cannot be combined with --format " + *format)
+			return exitConfig
+		}
+		if *showVersion || onlyModes("--template", mode, templateModes...) != nil {
+			slog.Error("--template only supports a single test run and --ping")
+			return exitConfig
+		}
+		if resultTemplate, err = parseResultTemplate(*templateText); err != nil {
+			slog.Error("invalid --template", "error", err)
+			return exitConfig
+		}
 	}
-	if hostaddr == "" {
-		log.Fatal("PGHOSTADDR environment variable is required")
+	if *showVersion {
+		if *format == "json" {
+			if err := buildInfo().writeJSON(os.Stdout); err != nil {
+				slog.Error("failed to write JSON version", "error", err)
+				return exitFailure
+			}
+			return exitOK
+		}
+		buildInfo().writeText(os.Stdout)
+		return exitOK
 	}
-	if password == "" {
-		log.Fatal("PGPASSWORD environment variable is required")
+	if *showSchema {
+		if err := writeResultSchema(os.Stdout); err != nil {
+			slog.Error("failed to write JSON schema", "error", err)
+			return exitFailure
+		}
+		return exitOK
 	}
-	if sslmode == "" {
-		sslmode = "require" // Default to require SSL
+	if *appendOutput && (*outputPath == "" || *format != "jsonl") {
+		slog.Error("--append requires --output and --format jsonl")
+		return exitConfig
 	}
 
-	// URL encode the password to handle special characters
-	encodedPassword := url.QueryEscape(password)
+	// The report goes to --output when set. The file is committed however
+	// the run ends, so a failed probe still leaves its report behind.
+	dest := os.Stdout
+	if *outputPath != "" {
+		f, err := openOutput(*outputPath, *appendOutput)
+		if err != nil {
+			slog.Error("invalid --output", "error", err)
+			return exitConfig
+		}
+		dest = f.File
+		defer func() {
+			if err := f.commit(); err != nil {
+				slog.Error("failed to write --output", "error", err)
+				if code == exitOK {
+					code = exitFailure
+				}
+			}
+		}()
+	}
 
-	// Construct connection string
-	connStr := fmt.Sprintf("postgres://admin:%s@%s:5432/postgres?sslmode=%s",
-		encodedPassword, hostaddr, sslmode)
+	// jsonl streams watch probes; everything else it prints is plain JSON
+	jsonOutput := *format == "json" || *format == "jsonl"
+	csvOutput := *format == "csv"
+	// A --template renders the result itself, like the JSON and CSV output
+	textOutput := !jsonOutput && !csvOutput && resultTemplate == nil
+	verbosity := verbositySummary
+	if *veryVerbose {
+		verbosity = verbosityTimings
+	} else if *verbose {
+		verbosity = verbosityDetail
+	}
+	if err := setColorMode(*colorMode, !textOutput, dest); err != nil {
+		slog.Error("invalid --color", "error", err)
+		return exitConfig
+	}
 
-	fmt.Printf("Connecting to DSQL cluster: %s\n", hostname)
-	fmt.Printf("Through tunnel address: %s:5432\n", hostaddr)
+	// With --quiet everything meant for stdout is held back and only
+	// written if the run fails
+	stdout := io.Writer(dest)
+	if *quiet {
+		held := &bytes.Buffer{}
+		stdout = held
+		defer func() {
+			if code != exitOK {
+				dest.Write(held.Bytes())
+			}
+		}()
+	}
+
+	// Progress lines are only shown in text mode; JSON mode prints a single
+	// object, CSV mode only its rows and --template only what it renders
+	out := stdout
+	if !textOutput {
+		out = io.Discard
+	}
+
+	fmt.Fprintln(out, "DSQL Connectivity Test - Golang")
+	fmt.Fprintln(out, "================================")
+
+	// The env file only fills in variables the real environment leaves unset
+	if *envFile != "" {
+		if connFlags.envFile, err = loadEnvFile(*envFile); err != nil {
+			slog.Error("failed to load env file", "error", err)
+			return exitConfig
+		}
+	}
+
+	// Resolve flags, falling back to environment variables
+	opts, err := connFlags.resolve()
+	if err == nil {
+		err = connFlags.applyClusterID(&opts, *region)
+	}
+	if err == nil && *demo {
+		err = applyDemo(&opts)
+	}
+	opts.ApplicationName = withCorrelationID(opts.ApplicationName, runID)
+	budget := newRetryBudget()
+	retry.OnRetry = budget.connectHook
+	cfg := testConfig{
+		conn:      opts,
+		usePool:   *poolFlag || os.Getenv("DSQL_USE_POOL") == "true",
+		reuseConn: *reuseConn,
+		preflight: *preflight,
+		trace:     *trace,
+		pool:      poolOpts,
+		retry:     retry,
+		retries:   budget,
+		samples:   *samples,
+		batchSize: *batchSize,
+		compareN:  *comparePrepared,
+		timeout:   *timeout,
+		failFast:  *failFast,
+		runID:     runID,
+		prewarm:   *prewarmPool,
+
+		queryTimeout: *queryTimeout,
+
+		maxConnLifetime: *maxConnLifetime,
+		lifetimeWarn:    *lifetimeWarn,
+
+		simpleProtocol: *simpleProtocol,
+
+		insertRows:  *insertRows,
+		insertBatch: *insertBatch,
+	}
+	if opts.ReadOnly {
+		cfg.checks = append(cfg.checks, readOnlyCheck)
+	}
+	if *roundtrip {
+		cfg.checks = append(cfg.checks, roundTripCheck)
+	}
+	if *typesTest {
+		cfg.checks = append(cfg.checks, typesCheck)
+	}
+	if *capabilities {
+		cfg.checks = append(cfg.checks, capabilitiesCheck)
+	}
+	if *unsupportedProbe {
+		cfg.checks = append(cfg.checks, unsupportedProbeCheck)
+	}
+	if *occTest {
+		cfg.checks = append(cfg.checks, occCheck)
+	}
+	if *prepared {
+		cfg.checks = append(cfg.checks, preparedCheck)
+	}
+	if *limitsProbe {
+		cfg.checks = append(cfg.checks, limitsCheck)
+	}
+	if *insertBench {
+		cfg.checks = append(cfg.checks, insertBenchCheck)
+	}
+	if *copyTest {
+		cfg.checks = append(cfg.checks, copyCheck)
+	}
+	if *batchSize > 0 {
+		cfg.checks = append(cfg.checks, batchCheck)
+	}
+	if *comparePrepared > 0 {
+		cfg.checks = append(cfg.checks, execCompareCheck)
+	}
+	if *timeoutTest {
+		cfg.checks = append(cfg.checks, statementTimeoutCheck)
+	}
+	if *cancelTest {
+		cfg.checks = append(cfg.checks, cancelCheck)
+	}
+	if *idleTxnTest {
+		cfg.checks = append(cfg.checks, idleTxnCheck)
+	}
+	if *isolationTest {
+		cfg.checks = append(cfg.checks, isolationCheck)
+	}
+	if cfg.verifyQuery = strings.TrimSpace(*verifyQuery); cfg.verifyQuery != "" {
+		cfg.checks = append(cfg.checks, verifyQueryCheck)
+	}
+	if *expectVersion != "" {
+		cfg.checks = append(cfg.checks, expectVersionCheck)
+	}
+	if cfg.baselinePath = *baselinePath; cfg.baselinePath != "" {
+		cfg.checks = append(cfg.checks, baselineCheck)
+	}
+	// Discovered clusters each need their own token, so a password can't work
+	useIAM := os.Getenv("DSQL_USE_IAM") == "true" || *discover || *tokenBench > 0
+
+	result := &ConnectionResult{CorrelationID: runID, Host: opts.HostAddr, Port: opts.Port, SSLMode: opts.SSLMode}
+
+	// exitWithError is the single failure path: it reports err and returns
+	// code as the process exit status. In JSON and CSV mode the error is
+	// part of the result
+	exitWithError := func(code int, err error) int {
+		err = cfg.conn.WrapAuthError(err)
+		result.setError(err, code)
+		result.Retries = budget.summary()
+		if jsonOutput {
+			if err := result.writeJSON(stdout); err != nil {
+				slog.Error("failed to write JSON result", "error", err)
+			}
+			return code
+		}
+		if csvOutput {
+			if err := result.writeCSV(stdout); err != nil {
+				slog.Error("failed to write CSV result", "error", err)
+			}
+			return code
+		}
+		if resultTemplate != nil {
+			if err := writeTemplate(stdout, resultTemplate, result); err != nil {
+				slog.Error("failed to render --template", "error", err)
+			}
+			return code
+		}
+		if code != exitConfig {
+			writeErrorDetails(out, err)
+			if result.Retries.Total > 0 {
+				fmt.Fprintf(out, "Retries: %s\n", result.Retries)
+			}
+		}
+		attrs := []any{"error", err, "exit_code", code}
+		if result.SQLState != "" {
+			attrs = append(attrs, "sqlstate", result.SQLState)
+		}
+		slog.Error("connectivity test failed", attrs...)
+		return code
+	}
 
-	// Parse config and set SNI hostname
-	config, err := pgx.ParseConfig(connStr)
 	if err != nil {
-		log.Fatalf("Failed to parse connection config: %v", err)
+		return exitWithError(exitConfig, fmt.Errorf("invalid configuration: %w", err))
+	}
+	if opts.Demo {
+		writeDemoBanner(out, opts)
+	}
+	if opts.NoSNIOverride {
+		slog.Warn("--no-sni-override is set: the DSQL hostname is NOT sent as the TLS server name, so real DSQL endpoints will likely reject the connection; use this only to diagnose TLS failures",
+			"hostname", opts.Hostname, "hostaddr", opts.HostAddr)
+	}
+	// Printed even with --quiet, so a skipped check can't go unnoticed in
+	// CI logs
+	if opts.InsecureSkipVerify {
+		fmt.Fprintln(os.Stderr, "WARNING: TLS certificate verification is DISABLED (--insecure-skip-tls-verify). Any server, including an impostor, will be trusted. Use this only to debug certificate problems.")
+		slog.Warn("--insecure-skip-tls-verify is set: the server certificate is not verified", "hostname", opts.Hostname, "sslmode", opts.SSLMode)
 	}
 
-	// Set the SNI hostname to the actual DSQL hostname - this is crucial for DSQL
-	if config.TLSConfig != nil {
-		config.TLSConfig.ServerName = hostname
+	// Show what was resolved before anything can fail on the network
+	effective := newEffectiveConfig(cfg, useIAM, *region, *profile, *assumeRoleARN, *configFile)
+	effective.log()
+	// In JSON mode stdout stays a single object: a dry run prints the
+	// configuration once it validates, otherwise it goes to stderr. CSV
+	// mode always sends it to stderr
+	if *printConfig || *dryRun {
+		if textOutput {
+			effective.writeText(out)
+		} else if !*dryRun {
+			effective.writeText(os.Stderr)
+		}
+	}
+	if *explain {
+		w := out
+		if !textOutput {
+			w = os.Stderr
+		}
+		writeExplain(w, connFlags.explain(opts, useIAM, *discover, sshOpts.dest), explainConnString(opts, useIAM))
+	}
+	if *dumpPgxConfig {
+		cfg.dumpConfig = out
+		if !textOutput {
+			cfg.dumpConfig = os.Stderr
+		}
+	}
+	// dryRunExit reports a validated configuration without connecting
+	dryRunExit := func() int {
+		if jsonOutput {
+			if err := effective.writeJSON(stdout); err != nil {
+				slog.Error("failed to write JSON configuration", "error", err)
+				return exitFailure
+			}
+			return exitOK
+		}
+		fmt.Fprintln(out, "\nDry run: configuration is valid, not connecting.")
+		return exitOK
 	}
 
-	// Connect to database
-	ctx := context.Background()
-	conn, err := pgx.ConnectConfig(ctx, config)
+	// Validate required settings
+	if modeErr != nil {
+		return exitWithError(exitConfig, modeErr)
+	}
+	if opts.Password == "" && !useIAM && !opts.Demo {
+		return exitWithError(exitConfig, errors.New("--password or PGPASSWORD environment variable is required (or set DSQL_USE_IAM=true)"))
+	}
+	if *samples < 1 {
+		return exitWithError(exitConfig, errors.New("--samples must be at least 1"))
+	}
+	if *batchSize < 0 || *comparePrepared < 0 {
+		return exitWithError(exitConfig, errors.New("--batch and --compare-prepared must not be negative"))
+	}
+	if *insertRows < 1 || *insertBatch < 1 {
+		return exitWithError(exitConfig, errors.New("--rows and --insert-batch must be at least 1"))
+	}
+	if (flagSet("rows") || flagSet("insert-batch")) && !*insertBench {
+		return exitWithError(exitConfig, errors.New("--rows and --insert-batch require --insert-bench"))
+	}
+	if *timeout <= 0 {
+		return exitWithError(exitConfig, errors.New("--timeout must be positive"))
+	}
+	if *queryTimeout < 0 || *tokenTimeout < 0 {
+		return exitWithError(exitConfig, errors.New("--query-timeout and --token-timeout must not be negative"))
+	}
+	if *tokenRetries < 0 {
+		return exitWithError(exitConfig, errors.New("--token-retries must not be negative"))
+	}
+	if *maxConnLifetime < 0 || *lifetimeWarn <= 0 || *lifetimeWarn > 1 {
+		return exitWithError(exitConfig, errors.New("--max-conn-lifetime must not be negative and --lifetime-warn must be above 0 and at most 1"))
+	}
+	if (mode == modeWatch || mode == modeWaitForReady) && *interval <= 0 {
+		return exitWithError(exitConfig, errors.New("--interval must be positive"))
+	}
+	if *breakerThreshold < 0 || *breakerInterval <= 0 {
+		return exitWithError(exitConfig, errors.New("--breaker-threshold must not be negative and --breaker-interval must be positive"))
+	}
+	if *expectVersion != "" {
+		if cfg.expectVersion, err = regexp.Compile(*expectVersion); err != nil {
+			return exitWithError(exitConfig, fmt.Errorf("invalid --expect-version: %w", err))
+		}
+	}
+	if *saveBaselineFlag && cfg.baselinePath == "" {
+		return exitWithError(exitConfig, errors.New("--save-baseline requires --baseline"))
+	}
+	if cfg.baselinePath != "" && !*saveBaselineFlag {
+		if cfg.baseline, err = loadBaseline(cfg.baselinePath); err != nil {
+			return exitWithError(exitConfig, err)
+		}
+	}
+	if *dedupWindow < 0 {
+		return exitWithError(exitConfig, errors.New("--dedup-window must not be negative"))
+	}
+	if *untilHealthy < 0 || *maxAttempts < 0 {
+		return exitWithError(exitConfig, errors.New("--until-healthy and --max-attempts must not be negative"))
+	}
+	if *maxAttempts > 0 && *untilHealthy == 0 {
+		return exitWithError(exitConfig, errors.New("--max-attempts requires --until-healthy"))
+	}
+	if *maxAttempts > 0 && *maxAttempts < *untilHealthy {
+		return exitWithError(exitConfig, fmt.Errorf("--max-attempts %d can never see %d consecutive passing probes (--until-healthy)", *maxAttempts, *untilHealthy))
+	}
+	// A --database list gets a connection per database
+	databases, err := splitDatabases(opts.Database)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		return exitWithError(exitConfig, fmt.Errorf("invalid --database: %w", err))
+	}
+	if len(databases) > 1 && (csvOutput || resultTemplate != nil || *statsdAddr != "") {
+		return exitWithError(exitConfig, errors.New("a --database list only supports text or JSON output, without --statsd-addr"))
+	}
+	if *demo && useIAM {
+		return exitWithError(exitConfig, errors.New("--demo cannot be combined with IAM auth"))
+	}
+	if *waitForReady < 0 {
+		return exitWithError(exitConfig, errors.New("--wait-for-ready must not be negative"))
+	}
+	if *query != "" && len(queryFile) > 0 {
+		return exitWithError(exitConfig, errors.New("--query and --query-file are mutually exclusive"))
+	}
+	if *continueOnError && len(queryFile) < 2 {
+		return exitWithError(exitConfig, errors.New("--continue-on-error requires more than one --query-file"))
+	}
+	switch {
+	case len(queryFile) > 1:
+		// Several files run as a script, leaving the info query in place
+		cfg.scripts, err = loadScripts(queryFile)
+		if err != nil {
+			return exitWithError(exitConfig, err)
+		}
+		cfg.continueOnError = *continueOnError
+		cfg.checks = append(cfg.checks, queryFilesCheck)
+	case len(queryFile) == 1:
+		data, err := os.ReadFile(queryFile[0])
+		if err != nil {
+			return exitWithError(exitConfig, fmt.Errorf("failed to read query file: %w", err))
+		}
+		cfg.query = strings.TrimSpace(string(data))
+		if cfg.query == "" {
+			return exitWithError(exitConfig, fmt.Errorf("query file %s is empty", queryFile[0]))
+		}
+	default:
+		cfg.query = strings.TrimSpace(*query)
+	}
+	if *maxRows < 0 {
+		return exitWithError(exitConfig, errors.New("--max-rows must not be negative"))
+	}
+	if flagSet("max-rows") && cfg.query == "" {
+		return exitWithError(exitConfig, errors.New("--max-rows requires --query or a single --query-file"))
+	}
+	cfg.maxRows = *maxRows
+	if cfg.simpleProtocol && cfg.query != "" {
+		return exitWithError(exitConfig, errors.New("--simple-protocol applies to the built-in info query; use --exec-mode simple to run --query over the simple protocol"))
+	}
+	if *reuseConn && cfg.usePool {
+		return exitWithError(exitConfig, errors.New("--reuse-conn cannot be combined with --pool"))
+	}
+	if opts.ReadOnly && (*roundtrip || *typesTest || *capabilities || *unsupportedProbe || *occTest || *isolationTest || *limitsProbe || *insertBench || *copyTest) {
+		return exitWithError(exitConfig, errors.New("--read-only cannot be combined with checks that write: --roundtrip, --types-test, --capabilities, --unsupported-probe, --occ-test, --isolation-test, --limits-probe, --insert-bench or --copy-test"))
+	}
+	if *maxConnectLatency < 0 || *maxQueryLatency < 0 {
+		return exitWithError(exitConfig, errors.New("--max-connect-latency and --max-query-latency must not be negative"))
+	}
+	cfg.latency = latencyLimits{connect: *maxConnectLatency, query: *maxQueryLatency}
+	// Only the connectivity test, which watch probes repeat without --pool
+	// or --reuse-conn, and --ping measure both latencies
+	if cfg.latency.set() && mode == modeWatch && (cfg.usePool || *reuseConn) {
+		return exitWithError(exitConfig, errors.New("--max-connect-latency and --max-query-latency apply to --watch only without --pool or --reuse-conn"))
+	}
+	if mode == modePing && *pingTimeout <= 0 {
+		return exitWithError(exitConfig, errors.New("--ping-timeout must be positive"))
+	}
+	if *externalID != "" && *assumeRoleARN == "" {
+		return exitWithError(exitConfig, errors.New("--external-id requires --assume-role-arn"))
+	}
+	if retry.MaxDelay < 0 {
+		return exitWithError(exitConfig, errors.New("--max-backoff must not be negative"))
+	}
+	if *parallel < 1 {
+		return exitWithError(exitConfig, errors.New("--parallel must be at least 1"))
+	}
+	if connFlags.clusterID != "" && multiCluster {
+		return exitWithError(exitConfig, errors.New("--cluster-id cannot be combined with --config or --discover"))
+	}
+	if strings.Contains(*region, ",") && mode != modeDiscover {
+		return exitWithError(exitConfig, errors.New("--region takes a comma-separated list only with --discover"))
+	}
+	if *concurrency < 0 {
+		return exitWithError(exitConfig, errors.New("--concurrency must not be negative"))
+	}
+	if mode == modeDurationCap && (*interval <= 0 || *maxWait <= 0) {
+		return exitWithError(exitConfig, errors.New("--interval and --max-wait must be positive"))
+	}
+	if mode == modeFailover {
+		if failover.primary == "" || failover.secondary == "" {
+			return exitWithError(exitConfig, errors.New("--failover needs both primary=<addr> and secondary=<addr>"))
+		}
+		if *failoverWait <= 0 {
+			return exitWithError(exitConfig, errors.New("--failover-wait must be positive"))
+		}
+	}
+	if cfg.prewarm && (!cfg.usePool || poolOpts.MinConns < 1) {
+		return exitWithError(exitConfig, errors.New("--prewarm-pool requires --pool and a positive --pool-min-conns"))
+	}
+	if *writeContention < 0 {
+		return exitWithError(exitConfig, errors.New("--write-contention must not be negative"))
+	}
+	if *cleanupMinAge < 0 {
+		return exitWithError(exitConfig, errors.New("--cleanup-min-age must not be negative"))
+	}
+	if mode == modeCompare && (compare.a == "" || compare.b == "") {
+		return exitWithError(exitConfig, errors.New("--compare needs both addrA=<addr> and addrB=<addr>"))
+	}
+	// The proxy, not this host, is what reaches the tunnel address
+	if cfg.preflight && opts.SOCKS5Proxy != "" {
+		return exitWithError(exitConfig, errors.New("--preflight cannot be combined with --socks5"))
+	}
+	if sshOpts.dest != "" {
+		if opts.SOCKS5Proxy != "" {
+			return exitWithError(exitConfig, errors.New("--ssh-tunnel cannot be combined with --socks5"))
+		}
+		// These modes dial their own endpoints, or none
+		if multiCluster || mode == modeFailover || mode == modeCompare || mode == modeTokenBench {
+			return exitWithError(exitConfig, fmt.Errorf("--ssh-tunnel cannot be combined with %s", mode))
+		}
+		if len(opts.HostAddrs()) > 1 {
+			return exitWithError(exitConfig, errors.New("--ssh-tunnel forwards to a single --hostaddr"))
+		}
+		if sshOpts.knownHosts == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return exitWithError(exitConfig, fmt.Errorf("--ssh-known-hosts is required: %w", err))
+			}
+			sshOpts.knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		// The bastion dials the cluster, so --hostaddr is optional and is
+		// resolved on its side
+		sshOpts.remote = sshRemote(opts.HostAddr, opts.Hostname, opts.Port)
+	} else if sshOpts.keyFile != "" || sshOpts.knownHosts != "" {
+		return exitWithError(exitConfig, errors.New("--ssh-key and --ssh-known-hosts require --ssh-tunnel"))
+	}
+	if *quiet && (mode == modeWatch || benchMode) {
+		return exitWithError(exitConfig, fmt.Errorf("--quiet cannot be combined with %s", mode))
+	}
+	if benchMode {
+		if *benchDuration <= 0 {
+			return exitWithError(exitConfig, errors.New("--duration must be positive"))
+		}
+		if *warmup < 0 {
+			return exitWithError(exitConfig, errors.New("--warmup must not be negative"))
+		}
+	}
+	if *reconnectTest < 0 {
+		return exitWithError(exitConfig, errors.New("--reconnect-test must not be negative"))
+	}
+	if mode == modeReuseVsFresh && *iterations < 1 {
+		return exitWithError(exitConfig, errors.New("--iterations must be at least 1"))
+	}
+	if *tokenBench < 0 {
+		return exitWithError(exitConfig, errors.New("--token-bench must not be negative"))
+	}
+	if *rateLimit < 0 {
+		return exitWithError(exitConfig, errors.New("--rate must not be negative"))
+	}
+	if *roundRobin && len(opts.HostAddrs()) < 2 {
+		return exitWithError(exitConfig, errors.New("--round-robin needs at least two comma-separated --hostaddr addresses"))
 	}
-	defer conn.Close(ctx)
 
-	fmt.Println("Connection established successfully!")
+	// Every mode-specific setting is checked against the one mode selected
+	latencyFlag := "--max-connect-latency"
+	if *maxConnectLatency == 0 {
+		latencyFlag = "--max-query-latency"
+	}
+	if err := mode.check(cfg.usePool, cfg.query != "", len(cfg.checks) > 0); err != nil {
+		return exitWithError(exitConfig, err)
+	}
+	for _, opt := range []struct {
+		name  string
+		set   bool
+		modes []runMode
+	}{
+		{"--breaker-threshold", *breakerThreshold > 0, []runMode{modeWatch}},
+		{"--dedup-window", *dedupWindow > 0, []runMode{modeWatch}},
+		{"--until-healthy", *untilHealthy > 0, []runMode{modeWatch}},
+		{"--metrics-addr", *metricsAddr != "", []runMode{modeWatch}},
+		{"--reuse-conn", *reuseConn, []runMode{modeWatch}},
+		{"--statsd-addr", *statsdAddr != "", []runMode{modeSingle, modeWatch}},
+		{"--demo", *demo, []runMode{modeSingle}},
+		{"a --database list", len(databases) > 1, []runMode{modeSingle}},
+		{"--dump-pgx-config", *dumpPgxConfig, []runMode{modeSingle}},
+		{latencyFlag, cfg.latency.set(), latencyModes},
+		{"--parallel", *parallel > 1, []runMode{modeConfig, modeDiscover}},
+		{"--contention-retry", *contentionRetry, []runMode{modeWriteContention}},
+		{"--cleanup-min-age", flagSet("cleanup-min-age"), []runMode{modeCleanup}},
+		{"--warmup", *warmup != 0, []runMode{modeBench, modeCompare}},
+		{"--iterations", flagSet("iterations"), []runMode{modeReuseVsFresh}},
+		{"--rate", *rateLimit > 0, []runMode{modeWatch, modeBench, modeCompare, modeConcurrency, modeReconnectTest}},
+		{"--round-robin", *roundRobin, []runMode{modeWatch, modeBench, modeConcurrency}},
+	} {
+		if opt.set {
+			if err := onlyModes(opt.name, mode, opt.modes...); err != nil {
+				return exitWithError(exitConfig, err)
+			}
+		}
+	}
+	// A dry run validates and exits, so whatever only happens once
+	// connected would silently do nothing
+	if *dryRun {
+		for _, opt := range []struct {
+			name string
+			set  bool
+		}{
+			{string(mode), mode == modeTokenBench || mode == modeWaitForReady},
+			{"--format csv", csvOutput},
+			{"--template", resultTemplate != nil},
+			{"--statsd-addr", *statsdAddr != ""},
+			{"--dump-pgx-config", *dumpPgxConfig},
+		} {
+			if opt.set {
+				return exitWithError(exitConfig, fmt.Errorf("%s cannot be combined with --dry-run", opt.name))
+			}
+		}
+	}
+	cfg.rate = newRateGate(*rateLimit)
+	cfg.rotation = newRoundRobin(*roundRobin)
 
-	// Execute DSQL-compatible connection info query
-	query := `
-		SELECT 
-			current_database() as database,
-			current_user as user,
-			version() as server_version
-	`
+	statsd, err := newStatsdClient(*statsdAddr, opts.Hostname)
+	if err != nil {
+		return exitWithError(exitConfig, err)
+	}
+	defer statsd.close()
+	cfg.statsd = statsd
 
-	row := conn.QueryRow(ctx, query)
+	// Ctrl-C or SIGTERM cancels ctx so every mode can close its connections
+	rootCtx, stopSignals := signalContext()
+	defer stopSignals()
 
-	var database, user, serverVersion string
-	err = row.Scan(&database, &user, &serverVersion)
+	shutdownTracing, err := setupTracing(context.Background(), *otlpEndpoint)
 	if err != nil {
-		log.Fatalf("Failed to execute connection info query: %v", err)
+		return exitWithError(exitConfig, err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("failed to flush traces", "error", err)
+		}
+	}()
+
+	defaults := clusterDefaults{
+		region: *region, profile: *profile, useIAM: useIAM, tokenSkew: *tokenSkew, tokenTimeout: *tokenTimeout, tokenRetries: *tokenRetries,
+		roleARN: *assumeRoleARN, externalID: *externalID,
+	}
+
+	// Each cluster in a config file, or found by --discover, is validated
+	// and tested independently. Compare and failover endpoints may be
+	// separate clusters too, each with its own token
+	switch mode {
+	case modeConfig, modeDiscover:
+		// Each cluster has its own certificate, so one override can't fit all
+		if opts.SNIHostname != "" {
+			return exitWithError(exitConfig, errors.New("--sni-hostname cannot be combined with --config or --discover; set sni_hostname per cluster"))
+		}
+		var clusters []clusterEntry
+		if mode == modeDiscover {
+			// Every discovered cluster carries its own region
+			defaults.region = ""
+			discoverCtx, cancel := context.WithTimeout(rootCtx, cfg.timeout)
+			clusters, err = discoverClusters(discoverCtx, parseRegions(*region), opts.HostAddr, defaults, out)
+			cancel()
+			if err != nil {
+				err = interruptedError(rootCtx, err)
+				return exitWithError(exitCodeOf(err), err)
+			}
+		} else if clusters, err = loadClusterFile(*configFile); err != nil {
+			return exitWithError(exitConfig, err)
+		}
+		if *dryRun {
+			for _, c := range clusters {
+				fmt.Fprintf(out, "Cluster %s: %s via %s\n", c.Name, c.Hostname, c.HostAddr)
+			}
+			return dryRunExit()
+		}
+		return runClusters(rootCtx, cfg, clusters, defaults, *parallel, out, stdout, jsonOutput)
+	case modeCompare:
+		if *dryRun {
+			fmt.Fprintf(out, "Compare: %s\n", compare.String())
+			return dryRunExit()
+		}
+		return runCompare(rootCtx, cfg, compare, defaults, max(*concurrency, 1), *warmup, *benchDuration, out, stdout, jsonOutput)
+	case modeFailover:
+		if *dryRun {
+			fmt.Fprintf(out, "Failover: %s\n", failover.String())
+			return dryRunExit()
+		}
+		fmt.Fprintf(out, "Running failover test from %s to %s:\n", failover.primary, failover.secondary)
+		return runFailover(rootCtx, cfg, failover, defaults, *failoverWait, out, stdout, jsonOutput)
+	}
+
+	if opts.Hostname == "" {
+		return exitWithError(exitConfig, errors.New("--host, HOSTNAME or PGHOST environment variable is required"))
+	}
+	if opts.HostAddr == "" && sshOpts.dest == "" && mode != modeTokenBench {
+		return exitWithError(exitConfig, errors.New("--hostaddr, PGHOSTADDR or PGHOST environment variable is required"))
+	}
+	if err := opts.CheckSSLMode(); err != nil {
+		return exitWithError(exitConfig, err)
+	}
+	if err := dsqltest.ValidatePort(opts.Port); err != nil {
+		return exitWithError(exitConfig, fmt.Errorf("invalid port %d: %w", opts.Port, err))
+	}
+
+	// modeExitCode logs the error a mode failed with, if any, and returns
+	// the exit code for it
+	modeExitCode := func(failure string, err error) int {
+		if err = interruptedError(rootCtx, err); err == nil {
+			return exitOK
+		}
+		code := exitCodeOf(err)
+		slog.Error(failure, "error", err, "exit_code", code)
+		return code
+	}
+
+	// With IAM credentials, --wait-for-ready also asks the control plane
+	// whether a failing cluster is still being created
+	var clusterStatus clusterStatusFunc
+
+	// IAM auth tokens replace PGPASSWORD and are refreshed before they expire
+	if useIAM {
+		loadCtx, cancel := context.WithTimeout(rootCtx, cfg.timeout)
+		awsCfg, err := loadAWSConfig(loadCtx, *region, *profile, *assumeRoleARN, *externalID, *tokenTimeout, cfg.retries)
+		cancel()
+		if err != nil {
+			if rootCtx.Err() != nil {
+				return exitWithError(exitInterrupted, interruptedError(rootCtx, err))
+			}
+			return exitWithError(exitAuth, err)
+		}
+		fmt.Fprintf(out, "Using IAM auth tokens (region: %s)\n", awsCfg.Region)
+		if *assumeRoleARN != "" {
+			fmt.Fprintf(out, "Signing tokens as assumed role %s\n", *assumeRoleARN)
+		}
+		cfg.conn.Tokens = dsqltest.NewTokenProvider(opts.Hostname, awsCfg, opts.User == dsqltest.DefaultUser, *tokenSkew, *tokenTimeout)
+		cfg.conn.Tokens.SetRetry(budget.tokenRetry(*tokenRetries))
+		if mode == modeWaitForReady {
+			clusterStatus = newClusterStatusFunc(awsCfg, opts.Hostname)
+		}
+
+		if mode == modeTokenBench {
+			timeout := cfg.timeout
+			if *tokenTimeout > 0 {
+				timeout = *tokenTimeout
+			}
+			report, err := runTokenBench(rootCtx, awsCfg, opts.Hostname, opts.User, *tokenBench, timeout, out)
+			return writeReport(stdout, out, report, jsonOutput, modeExitCode("token benchmark failed", err))
+		}
+	}
+
+	// A dry run assembles the full connection config, including the auth
+	// token, so credential problems show up without touching the network
+	if *dryRun {
+		buildCtx, cancel := context.WithTimeout(rootCtx, cfg.timeout)
+		_, err := cfg.conn.ConnConfig(buildCtx)
+		cancel()
+		if err != nil {
+			err = configFailure(err)
+			return exitWithError(exitCodeOf(err), err)
+		}
+		if cfg.conn.Tokens != nil {
+			effective.TokenExpires = cfg.conn.Tokens.ExpiresAt().UTC().Format(time.RFC3339)
+			fmt.Fprintf(out, "IAM auth token generated (redacted), expires %s\n", effective.TokenExpires)
+		}
+		return dryRunExit()
+	}
+
+	// The tunnel replaces the tunnel address with its local port, and stays
+	// up until every mode below has finished
+	if sshOpts.dest != "" {
+		tunnelCtx, cancel := context.WithTimeout(rootCtx, cfg.timeout)
+		tunnel, err := openSSHTunnel(tunnelCtx, sshOpts)
+		cancel()
+		if err != nil {
+			err = interruptedError(rootCtx, err)
+			return exitWithError(exitCodeOf(err), err)
+		}
+		defer tunnel.close()
+		host, port := tunnel.addr()
+		fmt.Fprintf(out, "SSH tunnel through %s: %s:%d forwards to %s\n", sshOpts.dest, host, port, sshOpts.remote)
+		cfg.conn.HostAddr, cfg.conn.Port = host, port
+		result.Host, result.Port = host, port
+	}
+
+	// The single test run and the one-shot modes share the --timeout
+	// deadline; the others run until they finish or are interrupted
+	ctx, cancel := context.WithTimeout(rootCtx, cfg.timeout)
+	defer cancel()
+
+	switch mode {
+	case modePing:
+		ctx, cancel := context.WithTimeout(rootCtx, *pingTimeout)
+		defer cancel()
+		if err := runPing(ctx, cfg, *pingTimeout, result); err != nil {
+			err = interruptedError(rootCtx, err)
+			return exitWithError(exitCodeOf(err), err)
+		}
+		result.Retries = budget.summary()
+		if err := cfg.latency.enforce(result); err != nil {
+			return exitWithError(exitCodeOf(err), err)
+		}
+		if jsonOutput {
+			if err := result.writeJSON(stdout); err != nil {
+				slog.Error("failed to write JSON result", "error", err)
+				return exitFailure
+			}
+			return exitOK
+		}
+		if resultTemplate != nil {
+			if err := writeTemplate(stdout, resultTemplate, result); err != nil {
+				slog.Error("failed to render --template", "error", err)
+				return exitFailure
+			}
+			return exitOK
+		}
+		fmt.Fprintf(out, "Ping %s: connect=%.2fms ping=%.2fms\n", colorize(colorGreen, "OK"), result.ConnectLatencyMs, result.QueryLatencyMs)
+		return exitOK
+	case modeBench:
+		return runBench(rootCtx, cfg, max(*concurrency, 1), *warmup, *benchDuration, out, stdout, *format)
+	case modeReuseVsFresh:
+		report, err := runReuseVsFresh(rootCtx, cfg, *iterations, out)
+		return writeReport(stdout, out, report, jsonOutput, modeExitCode("reuse vs fresh comparison failed", err))
+	case modeReconnectTest:
+		report, err := runReconnectTest(rootCtx, cfg, *reconnectTest, out)
+		return writeReport(stdout, out, report, jsonOutput, modeExitCode("reconnect test failed", err))
+	case modeDurationCap:
+		return runDurationCap(rootCtx, cfg, *interval, *maxWait, out, stdout, jsonOutput)
+	case modeWaitForReady:
+		return runWaitForReady(rootCtx, cfg, *waitForReady, *interval, clusterStatus, out, stdout, jsonOutput)
+	case modeWatch:
+		return runWatch(rootCtx, cfg, *interval, newCircuitBreaker(*breakerThreshold, *breakerInterval), newHealthGoal(*untilHealthy, *maxAttempts), newProbeDedup(*dedupWindow), out, stdout, *format, *metricsAddr)
+	case modeCleanup:
+		report, err := runCleanup(ctx, cfg, *cleanupMinAge, out)
+		code := modeExitCode("cleanup failed", err)
+		if report == nil {
+			return code
+		}
+		return writeReport(stdout, out, report, jsonOutput, code)
+	case modeWriteContention:
+		report, err := runWriteContention(ctx, cfg, *writeContention, *contentionRetry, out)
+		code := modeExitCode("write contention test failed", err)
+		if report == nil {
+			return code
+		}
+		return writeReport(stdout, out, report, jsonOutput, code)
+	case modeConcurrency:
+		report, err := runConcurrency(ctx, cfg, *concurrency, out)
+		return writeReport(stdout, out, report, jsonOutput, modeExitCode("concurrency test failed", err))
+	}
+
+	if len(databases) > 1 {
+		return runDatabases(rootCtx, cfg, databases, out, stdout, jsonOutput)
+	}
+
+	if err := runConnectivityTest(ctx, cfg, out, result); err != nil {
+		if rootCtx.Err() == nil {
+			cfg.statsd.observe(result, err)
+		}
+		err = interruptedError(rootCtx, err)
+		if !jsonOutput {
+			result.Report.writeText(out)
+			result.Retries = budget.summary()
+			writeReportDetails(out, result, verbosity)
+		}
+		return exitWithError(exitCodeOf(err), err)
+	}
+	result.Retries = budget.summary()
+	cfg.statsd.observe(result, nil)
+
+	if csvOutput {
+		if err := result.writeCSV(stdout); err != nil {
+			slog.Error("failed to write CSV result", "error", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	if jsonOutput {
+		if err := result.writeJSON(stdout); err != nil {
+			slog.Error("failed to write JSON result", "error", err)
+			return exitFailure
+		}
+		return exitOK
+	}
+	if resultTemplate != nil {
+		if err := writeTemplate(stdout, resultTemplate, result); err != nil {
+			slog.Error("failed to render --template", "error", err)
+			return exitFailure
+		}
+		return exitOK
 	}
 
 	// Display connection information
-	fmt.Println("\nConnection Information:")
-	fmt.Println("======================")
-	fmt.Printf("Database: %s\n", database)
-	fmt.Printf("User: %s\n", user)
-	fmt.Printf("Host: %s (via tunnel to %s)\n", hostaddr, hostname)
-	fmt.Printf("Port: 5432\n")
-	fmt.Printf("SSL Status: SSL connection (required by DSQL)\n")
-	fmt.Printf("Server Version: %s\n", serverVersion)
-
-	fmt.Println("\nConnection test completed successfully!")
+	result.writeText(out, opts.Hostname)
+	result.Report.writeText(out)
+	writeReportDetails(out, result, verbosity)
+
+	fmt.Fprintln(out, "\n"+colorize(colorGreen, "Connection test completed successfully!"))
+	return exitOK
+}
+
+// defaultTimeout bounds the whole connect and query attempt so a broken
+// tunnel can't hang the program.
+const defaultTimeout = 30 * time.Second
+
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeMetrics are the Prometheus metrics updated by each --watch probe,
+// along with the latest probe's result for /healthz.
+type probeMetrics struct {
+	registry *prometheus.Registry
+	success  prometheus.Gauge
+	latency  *prometheus.HistogramVec
+	failures *prometheus.CounterVec
+
+	// latest is swapped in whole by the watch loop and read by /healthz
+	// requests, so a reader never sees a half-updated result
+	latest atomic.Pointer[ConnectionResult]
+}
+
+// newProbeMetrics registers the probe metrics on a dedicated registry so
+// only DSQL probe results are exposed.
+func newProbeMetrics() *probeMetrics {
+	m := &probeMetrics{
+		registry: prometheus.NewRegistry(),
+		success: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "dsql_probe_success",
+			Help: "Whether the last connectivity probe succeeded (1) or failed (0).",
+		}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "dsql_probe_latency_seconds",
+			Help:    "Latency of successful probes by phase (connect, query).",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		}, []string{"phase"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "dsql_probe_failures_total",
+			Help: "Failed probes by category (config, connect, auth, query, other).",
+		}, []string{"category"}),
+	}
+	m.registry.MustRegister(m.success, m.latency, m.failures)
+	return m
+}
+
+// observe records one probe outcome.
+func (m *probeMetrics) observe(result *ConnectionResult, err error) {
+	m.storeLatest(result, err)
+	if err != nil {
+		m.success.Set(0)
+		m.failures.WithLabelValues(failureCategory(exitCodeOf(err))).Inc()
+		return
+	}
+	m.success.Set(1)
+	m.latency.WithLabelValues("connect").Observe(result.ConnectLatencyMs / 1000)
+	m.latency.WithLabelValues("query").Observe(result.QueryLatencyMs / 1000)
+}
+
+// storeLatest publishes a copy of result for /healthz, with err filled in
+// as it would be in --format json output.
+func (m *probeMetrics) storeLatest(result *ConnectionResult, err error) {
+	var latest ConnectionResult
+	if result != nil {
+		latest = *result
+	}
+	if err != nil {
+		latest.setError(err, exitCodeOf(err))
+	}
+	m.latest.Store(&latest)
+}
+
+// healthz serves the latest probe's result as JSON, with status 200 if it
+// succeeded and 503 if it failed or no probe has finished yet.
+func (m *probeMetrics) healthz(w http.ResponseWriter, _ *http.Request) {
+	result := m.latest.Load()
+	if result == nil {
+		result = &ConnectionResult{Error: "no probe has completed yet"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if result.Success {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := result.writeJSON(w); err != nil {
+		slog.Debug("failed to write health response", "error", err)
+	}
+}
+
+// serve exposes /metrics and /healthz on addr until ctx is done. The
+// listener is opened before returning so a bad or busy address is reported
+// immediately.
+func (m *probeMetrics) serve(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", m.healthz)
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("metrics server stopped", "error", err)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	slog.Info("serving Prometheus metrics", "addr", ln.Addr().String(), "path", "/metrics", "health_path", "/healthz")
+	return nil
+}
+
+// failureCategory maps an exit code to the failures_total category label.
+func failureCategory(code int) string {
+	switch code {
+	case exitConfig:
+		return "config"
+	case exitConnect:
+		return "connect"
+	case exitAuth:
+		return "auth"
+	case exitQuery:
+		return "query"
+	default:
+		return "other"
+	}
+}
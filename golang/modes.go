@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// runMode is what a run does: the single connectivity test, or one of the
+// modes that replace it. Each mode is named after the flag selecting it.
+type runMode string
+
+const (
+	modeSingle          runMode = ""
+	modeConfig          runMode = "--config"
+	modeDiscover        runMode = "--discover"
+	modeCompare         runMode = "--compare"
+	modeFailover        runMode = "--failover"
+	modeTokenBench      runMode = "--token-bench"
+	modePing            runMode = "--ping"
+	modeBench           runMode = "--bench"
+	modeReuseVsFresh    runMode = "--reuse-vs-fresh"
+	modeReconnectTest   runMode = "--reconnect-test"
+	modeDurationCap     runMode = "--duration-cap-test"
+	modeWaitForReady    runMode = "--wait-for-ready"
+	modeWatch           runMode = "--watch"
+	modeCleanup         runMode = "--cleanup"
+	modeWriteContention runMode = "--write-contention"
+	modeConcurrency     runMode = "--concurrency"
+)
+
+// modeSpec is what a mode accepts besides the connection settings. Modes
+// that don't run the connectivity test have their own workload, which
+// would silently ignore a pool, a custom query or the checks.
+type modeSpec struct {
+	pool   bool // --pool
+	query  bool // --query or --query-file
+	checks bool // checks such as --roundtrip, and --read-only
+}
+
+var modeSpecs = map[runMode]modeSpec{
+	modeSingle:          {pool: true, query: true, checks: true},
+	modeConfig:          {pool: true, query: true, checks: true},
+	modeDiscover:        {pool: true, query: true, checks: true},
+	modeCompare:         {query: true},
+	modeFailover:        {},
+	modeTokenBench:      {},
+	modePing:            {},
+	modeBench:           {query: true},
+	modeReuseVsFresh:    {query: true},
+	modeReconnectTest:   {},
+	modeDurationCap:     {},
+	modeWaitForReady:    {pool: true, query: true, checks: true},
+	modeWatch:           {pool: true, query: true, checks: true},
+	modeCleanup:         {},
+	modeWriteContention: {},
+	modeConcurrency:     {},
+}
+
+// templateModes are the modes whose result --template can render; the
+// others have reports of their own.
+var templateModes = []runMode{modeSingle, modePing}
+
+// latencyModes are the modes that hold their connects and queries to
+// --max-connect-latency and --max-query-latency.
+var latencyModes = []runMode{modeSingle, modeConfig, modeDiscover, modePing, modeWaitForReady, modeWatch}
+
+// modeFlag is a mode and whether its flag was given.
+type modeFlag struct {
+	mode runMode
+	set  bool
+}
+
+// selectMode returns the mode selected among flags, or modeSingle when none
+// is set. More than one is an error, returned with the first of them.
+func selectMode(flags []modeFlag) (runMode, error) {
+	var selected []string
+	for _, f := range flags {
+		if f.set {
+			selected = append(selected, string(f.mode))
+		}
+	}
+	switch len(selected) {
+	case 0:
+		return modeSingle, nil
+	case 1:
+		return runMode(selected[0]), nil
+	}
+	return runMode(selected[0]), fmt.Errorf("%s cannot be combined with %s", selected[0], joinList(selected[1:], "or"))
+}
+
+// check reports the first of the pool, query and checks settings the mode
+// doesn't accept.
+func (m runMode) check(usePool, query, checks bool) error {
+	spec := modeSpecs[m]
+	switch {
+	case usePool && !spec.pool:
+		return fmt.Errorf("%s cannot be combined with --pool", m)
+	case query && !spec.query:
+		return fmt.Errorf("%s cannot be combined with --query or --query-file", m)
+	case checks && !spec.checks:
+		return fmt.Errorf("%s cannot be combined with --read-only or checks such as --roundtrip", m)
+	}
+	return nil
+}
+
+// onlyModes returns an error unless mode is one of the modes option
+// supports.
+func onlyModes(option string, mode runMode, supported ...runMode) error {
+	if slices.Contains(supported, mode) {
+		return nil
+	}
+	var names []string
+	for _, m := range supported {
+		if m != modeSingle {
+			names = append(names, string(m))
+		}
+	}
+	if len(names) < len(supported) {
+		return fmt.Errorf("%s only supports %s", option, joinList(append([]string{"a single test run"}, names...), "and"))
+	}
+	return fmt.Errorf("%s requires %s", option, joinList(names, "or"))
+}
+
+// joinList joins items as an English list, with conj before the last.
+func joinList(items []string, conj string) string {
+	if len(items) < 2 {
+		return strings.Join(items, "")
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " " + conj + " " + items[len(items)-1]
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSelectMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   []modeFlag
+		want    runMode
+		wantErr string
+	}{
+		{name: "none", flags: []modeFlag{{modeWatch, false}, {modePing, false}}, want: modeSingle},
+		{name: "one", flags: []modeFlag{{modeWatch, false}, {modePing, true}}, want: modePing},
+		{name: "two", flags: []modeFlag{{modePing, true}, {modeWatch, true}}, want: modePing, wantErr: "--ping cannot be combined with --watch"},
+		{
+			name:    "three",
+			flags:   []modeFlag{{modeConfig, true}, {modeBench, true}, {modeWatch, true}},
+			want:    modeConfig,
+			wantErr: "--config cannot be combined with --bench or --watch",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectMode(tt.flags)
+			if got != tt.want {
+				t.Errorf("mode = %q, want %q", got, tt.want)
+			}
+			checkModeErr(t, err, tt.wantErr)
+		})
+	}
+}
+
+// TestModeSpecsComplete makes sure every mode says what it accepts; one
+// missing from modeSpecs would reject --pool, --query and checks.
+func TestModeSpecsComplete(t *testing.T) {
+	for _, m := range []runMode{
+		modeSingle, modeConfig, modeDiscover, modeCompare, modeFailover, modeTokenBench, modePing, modeBench,
+		modeReuseVsFresh, modeReconnectTest, modeDurationCap, modeWaitForReady, modeWatch, modeCleanup,
+		modeWriteContention, modeConcurrency,
+	} {
+		if _, ok := modeSpecs[m]; !ok {
+			t.Errorf("modeSpecs has no entry for %q", m)
+		}
+	}
+	if err := modeSingle.check(true, true, true); err != nil {
+		t.Errorf("the single test run rejected a setting: %v", err)
+	}
+}
+
+func TestModeCheck(t *testing.T) {
+	tests := []struct {
+		mode                  runMode
+		usePool, query, check bool
+		wantErr               string
+	}{
+		{mode: modeWatch, usePool: true, query: true, check: true},
+		{mode: modeBench, query: true},
+		{mode: modeBench, usePool: true, wantErr: "--bench cannot be combined with --pool"},
+		{mode: modeBench, check: true, wantErr: "--bench cannot be combined with --read-only or checks"},
+		{mode: modePing, query: true, wantErr: "--ping cannot be combined with --query"},
+		{mode: modeCleanup, check: true, wantErr: "--cleanup cannot be combined with --read-only or checks"},
+		{mode: modeConcurrency, usePool: true, wantErr: "--concurrency cannot be combined with --pool"},
+		{mode: modeConcurrency, query: true, wantErr: "--concurrency cannot be combined with --query"},
+		{mode: modeConcurrency, check: true, wantErr: "--concurrency cannot be combined with --read-only or checks"},
+	}
+	for _, tt := range tests {
+		checkModeErr(t, tt.mode.check(tt.usePool, tt.query, tt.check), tt.wantErr)
+	}
+}
+
+func TestOnlyModes(t *testing.T) {
+	tests := []struct {
+		mode      runMode
+		supported []runMode
+		wantErr   string
+	}{
+		{mode: modeWatch, supported: []runMode{modeSingle, modeWatch}},
+		{mode: modeSingle, supported: []runMode{modeSingle}},
+		{mode: modeBench, supported: []runMode{modeSingle}, wantErr: "--opt only supports a single test run"},
+		{mode: modeBench, supported: []runMode{modeSingle, modePing}, wantErr: "--opt only supports a single test run and --ping"},
+		{mode: modeSingle, supported: []runMode{modeWatch}, wantErr: "--opt requires --watch"},
+		{mode: modePing, supported: []runMode{modeWatch, modeBench, modeConcurrency}, wantErr: "--opt requires --watch, --bench or --concurrency"},
+	}
+	for _, tt := range tests {
+		checkModeErr(t, onlyModes("--opt", tt.mode, tt.supported...), tt.wantErr)
+	}
+}
+
+func TestTemplateModes(t *testing.T) {
+	for _, tt := range []struct {
+		mode    runMode
+		wantErr string
+	}{
+		{mode: modeSingle},
+		{mode: modePing},
+		{mode: modeTokenBench, wantErr: "--template only supports a single test run and --ping"},
+		{mode: modeWatch, wantErr: "--template only supports a single test run and --ping"},
+	} {
+		checkModeErr(t, onlyModes("--template", tt.mode, templateModes...), tt.wantErr)
+	}
+}
+
+func TestLatencyModes(t *testing.T) {
+	for _, tt := range []struct {
+		mode    runMode
+		wantErr string
+	}{
+		{mode: modeSingle},
+		{mode: modeWatch},
+		{mode: modeTokenBench, wantErr: "--max-connect-latency only supports a single test run"},
+		{mode: modeBench, wantErr: "--max-connect-latency only supports a single test run"},
+	} {
+		checkModeErr(t, onlyModes("--max-connect-latency", tt.mode, latencyModes...), tt.wantErr)
+	}
+}
+
+// checkModeErr fails the test unless err contains wantErr, or is nil when
+// wantErr is empty.
+func checkModeErr(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	switch {
+	case wantErr == "" && err != nil:
+		t.Errorf("unexpected error: %v", err)
+	case wantErr != "" && err == nil:
+		t.Errorf("expected an error containing %q, got nil", wantErr)
+	case wantErr != "" && !strings.Contains(err.Error(), wantErr):
+		t.Errorf("error %q does not contain %q", err, wantErr)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// DSQL's SQLSTATEs for optimistic concurrency conflicts: OC000 for data
+// conflicts and OC001 for schema (catalog) conflicts. Both mean the
+// transaction lost the race and can be retried.
+const (
+	sqlStateOCCData   = "OC000"
+	sqlStateOCCSchema = "OC001"
+)
+
+// Write tests retry a transaction that loses an optimistic concurrency race
+// up to conflictRetries times, waiting conflictRetryDelay before the first
+// retry and doubling it after each.
+const (
+	conflictRetries    = 5
+	conflictRetryDelay = 25 * time.Millisecond
+)
+
+// occCheck demonstrates DSQL's optimistic concurrency control: two
+// transactions update the same row, one commits and the other is rejected at
+// commit time instead of blocking on a lock.
+var occCheck = check{name: "occ-test", run: runOCCTest}
+
+func runOCCTest(ctx context.Context, s *session, r *checkResult) (err error) {
+	table := pgx.Identifier{newTestTableName("occ")}.Sanitize()
+
+	if err := r.step("create table", execStmt(ctx, s.conn,
+		"CREATE TABLE "+table+" (id int PRIMARY KEY, counter int NOT NULL)")); err != nil {
+		return err
+	}
+	defer func() {
+		dropCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if dropErr := r.step("drop table", execStmt(dropCtx, s.conn, "DROP TABLE "+table)); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+
+	if err := r.step("insert row", execStmt(ctx, s.conn,
+		"INSERT INTO "+table+" (id, counter) VALUES (1, 0)")); err != nil {
+		return err
+	}
+
+	// The second transaction needs its own session
+	other, err := s.connect(ctx)
+	if err := r.step("open second connection", err); err != nil {
+		return err
+	}
+	defer closeConn(other)
+
+	update := "UPDATE " + table + " SET counter = counter + 1 WHERE id = 1"
+
+	txA, err := s.conn.Begin(ctx)
+	if err := r.step("begin transaction A", err); err != nil {
+		return err
+	}
+	defer txA.Rollback(context.Background())
+
+	txB, err := other.Begin(ctx)
+	if err := r.step("begin transaction B", err); err != nil {
+		return err
+	}
+	defer txB.Rollback(context.Background())
+
+	// Both updates succeed: DSQL doesn't take row locks, conflicts are
+	// detected at commit
+	if err := r.step("update in A", execTx(ctx, txA, update)); err != nil {
+		return err
+	}
+	if err := r.step("update in B", execTx(ctx, txB, update)); err != nil {
+		return err
+	}
+
+	if err := r.step("commit A", txA.Commit(ctx)); err != nil {
+		return err
+	}
+
+	commitErr := txB.Commit(ctx)
+	if commitErr == nil {
+		return r.step("commit B rejected", errors.New("second commit succeeded; expected an optimistic concurrency conflict"))
+	}
+	code := sqlState(commitErr)
+	if !isOCCConflict(commitErr) {
+		return r.step("commit B rejected", fmt.Errorf("expected SQLSTATE %s or %s, got %q: %w", sqlStateOCCData, sqlStateOCCSchema, code, commitErr))
+	}
+	r.step(fmt.Sprintf("commit B rejected with SQLSTATE %s", code), nil)
+
+	// Retrying the losing transaction against the new row version succeeds
+	retries, err := retryOnConflict(ctx, s.cfg.retries, func(ctx context.Context) error {
+		return pgx.BeginFunc(ctx, other, func(tx pgx.Tx) error {
+			return execTx(ctx, tx, update)
+		})
+	}, conflictRetries)
+	r.detail("conflict_retries", retries)
+	if err := r.step("retry B", err); err != nil {
+		return err
+	}
+
+	var counter int
+	err = s.conn.QueryRow(ctx, "SELECT counter FROM "+table+" WHERE id = 1").Scan(&counter)
+	if err == nil && counter != 2 {
+		err = fmt.Errorf("counter is %d, expected 2 after both commits", counter)
+	}
+	return r.step("verify both updates applied", err)
+}
+
+// execTx runs a statement inside tx.
+func execTx(ctx context.Context, tx pgx.Tx, sql string, args ...any) error {
+	_, err := tx.Exec(ctx, sql, args...)
+	return err
+}
+
+// retryOnConflict runs fn, which should run one whole transaction, and runs
+// it again while it fails with an optimistic concurrency conflict, up to
+// maxRetries more times with a doubling delay. This is the retry loop DSQL
+// expects of clients. It returns the number of retries that were needed,
+// and counts each against the query phase of budget.
+func retryOnConflict(ctx context.Context, budget *retryBudget, fn func(ctx context.Context) error, maxRetries int) (int, error) {
+	delay := conflictRetryDelay
+	for retries := 0; ; retries++ {
+		err := fn(ctx)
+		if err == nil || !isOCCConflict(err) {
+			return retries, err
+		}
+		if retries == maxRetries {
+			return retries, fmt.Errorf("still conflicting after %d retries: %w", retries, err)
+		}
+		slog.DebugContext(ctx, "retrying transaction after concurrency conflict",
+			"sqlstate", sqlState(err), "retry", retries+1, "delay", delay)
+		budget.record(retryPhaseQuery, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return retries, errors.Join(err, ctx.Err())
+		}
+		delay *= 2
+	}
+}
+
+// sqlState returns the SQLSTATE of a server error, or "" for other errors.
+func sqlState(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// isOCCConflict reports whether err is a DSQL optimistic concurrency
+// conflict that can be resolved by retrying the transaction.
+func isOCCConflict(err error) bool {
+	code := sqlState(err)
+	return code == sqlStateOCCData || code == sqlStateOCCSchema
+}
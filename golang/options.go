@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// connFlags holds the raw flag values before environment fallback.
+type connFlags struct {
+	host, hostaddr, user, database, sslmode, password string
+	port                                              int
+
+	passwordFile   string
+	passwordStdin  bool
+	sslrootcert    string
+	sslcert        string
+	sslkey         string
+	sniHostname    string
+	verifyNames    string
+	noSNIOverride  bool
+	insecureSkip   bool
+	appName        string
+	readOnly       bool
+	tcpKeepAlive   time.Duration
+	connectTimeout time.Duration
+	socks5         string
+
+	tlsMinVersion string
+	tls13Only     bool
+	execMode      string
+	searchPath    string
+	params        runtimeParams
+	clusterID     string
+
+	service string     // --service, falling back to PGSERVICE
+	svc     *pgService // the service resolve loaded, if any
+
+	fs      *flag.FlagSet   // the set the flags are registered on
+	envFile map[string]bool // the variables --env-file set
+}
+
+// registerConnFlags defines the connection flags on fs.
+func registerConnFlags(fs *flag.FlagSet) *connFlags {
+	f := &connFlags{params: make(runtimeParams), fs: fs}
+	fs.StringVar(&f.host, "host", "", "DSQL cluster hostname used for SNI (env: HOSTNAME, then PGHOST)")
+	fs.StringVar(&f.service, "service", "", "Read connection settings from this service in the libpq service file, below flags (env: PGSERVICE)")
+	fs.StringVar(&f.clusterID, "cluster-id", "", "DSQL cluster identifier; with --region (or AWS_REGION) it sets --host to <id>.dsql.<region>.on.aws")
+	fs.StringVar(&f.hostaddr, "hostaddr", "", "Tunnel address to connect to, or a comma-separated list tried in order (env: PGHOSTADDR, then PGHOST)")
+	fs.IntVar(&f.port, "port", 0, "Port to connect to (env: PGPORT, default 5432)")
+	fs.StringVar(&f.user, "user", "", "Database user (env: PGUSER, default admin)")
+	fs.StringVar(&f.database, "database", "", "Database name, or a comma-separated list to test each in turn (env: PGDATABASE, default postgres)")
+	fs.StringVar(&f.sslmode, "sslmode", "", "SSL mode (env: PGSSLMODE, default require)")
+	fs.StringVar(&f.password, "password", "", "Password or DSQL auth token (env: PGPASSWORD)")
+	fs.StringVar(&f.sniHostname, "sni-hostname", "", "TLS server name to send in place of --host, which still identifies the cluster in output")
+	fs.StringVar(&f.verifyNames, "tls-verify-name", "", "With sslmode verify-full, check the server certificate against this name, or any of a comma-separated list, instead of the TLS server name")
+	fs.BoolVar(&f.noSNIOverride, "no-sni-override", false, "Troubleshooting only: don't send --host as the TLS server name, leaving pgx to use the dialed address (DSQL will likely reject the connection)")
+	fs.BoolVar(&f.insecureSkip, "insecure-skip-tls-verify", false, "Troubleshooting only: accept any server certificate, even with --sslrootcert (not with sslmode verify-ca or verify-full)")
+	fs.StringVar(&f.sslrootcert, "sslrootcert", "", "PEM file of root CAs used to verify the server certificate (env: PGSSLROOTCERT)")
+	fs.StringVar(&f.sslcert, "sslcert", "", "PEM client certificate to present to proxies that require mTLS, with --sslkey (env: PGSSLCERT)")
+	fs.StringVar(&f.sslkey, "sslkey", "", "Private key of --sslcert (env: PGSSLKEY)")
+	fs.StringVar(&f.appName, "app-name", "", "application_name reported to the server (env: PGAPPNAME, default "+defaultAppName()+")")
+	fs.StringVar(&f.socks5, "socks5", "", "Dial through the SOCKS5 proxy at host:port instead of a local tunnel; --hostaddr is resolved by the proxy")
+	fs.DurationVar(&f.tcpKeepAlive, "tcp-keepalive", 0, "TCP keepalive idle time and probe interval, e.g. 30s (negative disables, default pgx's 5m)")
+	fs.DurationVar(&f.connectTimeout, "connect-timeout", 0, "Deadline for each connection attempt's TCP, TLS and auth, apart from --timeout (env: PGCONNECT_TIMEOUT in seconds)")
+	fs.BoolVar(&f.readOnly, "read-only", false, "Open read-only sessions and verify that writes are rejected")
+	fs.StringVar(&f.tlsMinVersion, "tls-min-version", "1.2", "Minimum TLS version to negotiate: 1.2 or 1.3")
+	fs.BoolVar(&f.tls13Only, "tls13-only", false, "Negotiate TLS 1.3 only (pins the minimum and maximum version)")
+	fs.StringVar(&f.execMode, "exec-mode", "", "pgx query execution mode: simple, exec, prepared, cache or cache-describe (default pgx's cache)")
+	fs.StringVar(&f.searchPath, "search-path", "", "Comma-separated schemas to set as the session's search_path, e.g. myschema,public, verified with SHOW search_path")
+	fs.Var(f.params, "set", "Session parameter to send at connect time, as key=value (repeatable), e.g. statement_timeout=5s")
+	fs.StringVar(&f.passwordFile, "password-file", "", "Read the password or auth token from a file")
+	fs.BoolVar(&f.passwordStdin, "password-stdin", false, "Read the password or auth token from standard input")
+	return f
+}
+
+// resolve applies the environment and default fallbacks to unset flags,
+// producing the library's connection config. Each setting uses the flag,
+// then the --service (or PGSERVICE) entry, then its specific environment
+// variable, then the default, the order libpq applies them in. The standard
+// libpq variables are honored; PGHOST fills in both the SNI hostname and
+// the dial address, but HOSTNAME wins for SNI (see hostnameEnvs) and
+// PGHOSTADDR for dialing.
+// A password read from --password-file or --password-stdin takes
+// precedence over the service and PGPASSWORD.
+func (f *connFlags) resolve() (dsqltest.Config, error) {
+	secret, err := f.readPassword()
+	if err != nil {
+		return dsqltest.Config{}, err
+	}
+	f.svc, err = loadPGService(firstNonEmpty(f.service, os.Getenv("PGSERVICE")))
+	if err != nil {
+		return dsqltest.Config{}, err
+	}
+	// pgx's ParseConfig("") reads PGSERVICE too, but only from its own file
+	// location and above flags; the service is applied here instead
+	os.Unsetenv("PGSERVICE")
+	os.Unsetenv("PGSERVICEFILE")
+
+	// --port 0 is rejected like PGPORT=0 rather than taken as unset
+	port := f.port
+	if f.isSet("port") {
+		if err := dsqltest.ValidatePort(port); err != nil {
+			return dsqltest.Config{}, fmt.Errorf("invalid --port %d: %w", port, err)
+		}
+	} else {
+		if v, name := f.fromServiceOrEnv("port", "PGPORT"); v != "" {
+			port, err = dsqltest.ParsePort(v)
+			if err != nil {
+				return dsqltest.Config{}, fmt.Errorf("invalid %s %q: %w", name, v, err)
+			}
+			// ParseConfig("") parses PGPORT again and doesn't trim it
+			if name == "PGPORT" {
+				os.Setenv("PGPORT", strconv.Itoa(port))
+			}
+		}
+	}
+
+	connectTimeout := f.connectTimeout
+	if connectTimeout == 0 {
+		if v, name := f.fromServiceOrEnv("connect_timeout", "PGCONNECT_TIMEOUT"); v != "" {
+			seconds, err := strconv.Atoi(v)
+			if err != nil {
+				return dsqltest.Config{}, fmt.Errorf("invalid %s %q: expected whole seconds", name, v)
+			}
+			connectTimeout = time.Duration(seconds) * time.Second
+		}
+	}
+	if connectTimeout < 0 {
+		return dsqltest.Config{}, fmt.Errorf("connect timeout must be positive, got %s", connectTimeout)
+	}
+
+	minVersion, err := dsqltest.ParseTLSVersion(f.tlsMinVersion)
+	if err != nil {
+		return dsqltest.Config{}, fmt.Errorf("invalid --tls-min-version: %w", err)
+	}
+	var maxVersion uint16
+	if f.tls13Only {
+		minVersion, maxVersion = tls.VersionTLS13, tls.VersionTLS13
+	}
+
+	// Go never sends an IP literal as SNI, so one would silently send none
+	if f.sniHostname != "" && net.ParseIP(strings.Trim(f.sniHostname, "[]")) != nil {
+		return dsqltest.Config{}, fmt.Errorf("--sni-hostname must be a DNS name, got IP address %s", f.sniHostname)
+	}
+	if f.noSNIOverride && f.sniHostname != "" {
+		return dsqltest.Config{}, errors.New("--no-sni-override and --sni-hostname are mutually exclusive")
+	}
+
+	var verifyNames []string
+	for _, name := range strings.Split(f.verifyNames, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			verifyNames = append(verifyNames, name)
+		}
+	}
+
+	if f.socks5 != "" {
+		if _, _, err := net.SplitHostPort(f.socks5); err != nil {
+			return dsqltest.Config{}, fmt.Errorf("--socks5 must be host:port: %w", err)
+		}
+	}
+
+	execMode, err := dsqltest.ParseQueryExecMode(f.execMode)
+	if err != nil {
+		return dsqltest.Config{}, fmt.Errorf("invalid --exec-mode: %w", err)
+	}
+
+	// A service's host, like PGHOST, is both the SNI name and the address
+	pghost := os.Getenv("PGHOST")
+	hostEnvs := f.hostnameEnvs()
+	svc := f.svc
+	opts := dsqltest.Config{
+		Hostname: firstNonEmpty(f.host, svc.get("host"), os.Getenv(hostEnvs[0]), os.Getenv(hostEnvs[1])),
+		HostAddr: firstNonEmpty(f.hostaddr, svc.get("hostaddr"), svc.get("host"), os.Getenv("PGHOSTADDR"), pghost),
+		Port:     port,
+		User:     firstNonEmpty(f.user, svc.get("user"), os.Getenv("PGUSER"), dsqltest.DefaultUser),
+		Database: firstNonEmpty(f.database, svc.get("dbname"), os.Getenv("PGDATABASE"), dsqltest.DefaultDatabase),
+		SSLMode:  firstNonEmpty(f.sslmode, svc.get("sslmode"), os.Getenv("PGSSLMODE"), dsqltest.DefaultSSLMode),
+		Password: firstNonEmpty(f.password, secret, svc.get("password"), os.Getenv("PGPASSWORD")),
+
+		SSLRootCert: firstNonEmpty(f.sslrootcert, svc.get("sslrootcert"), os.Getenv("PGSSLROOTCERT")),
+		SSLCert:     firstNonEmpty(f.sslcert, svc.get("sslcert"), os.Getenv("PGSSLCERT")),
+		SSLKey:      firstNonEmpty(f.sslkey, svc.get("sslkey"), os.Getenv("PGSSLKEY")),
+		SNIHostname: f.sniHostname,
+		VerifyNames: verifyNames,
+
+		NoSNIOverride:      f.noSNIOverride,
+		InsecureSkipVerify: f.insecureSkip,
+
+		ApplicationName: firstNonEmpty(f.appName, svc.get("application_name"), os.Getenv("PGAPPNAME"), defaultAppName()),
+		ReadOnly:        f.readOnly,
+		SearchPath:      strings.TrimSpace(f.searchPath),
+		SOCKS5Proxy:     f.socks5,
+		TCPKeepAlive:    f.tcpKeepAlive,
+		ConnectTimeout:  connectTimeout,
+		QueryExecMode:   execMode,
+		RuntimeParams:   f.params,
+
+		TLSMinVersion: minVersion,
+		TLSMaxVersion: maxVersion,
+	}
+	if opts.Port == 0 {
+		opts.Port = dsqltest.DefaultPort
+	}
+	if _, ok := opts.RuntimeParams["search_path"]; ok && opts.SearchPath != "" {
+		return dsqltest.Config{}, errors.New("--search-path and --set search_path are mutually exclusive")
+	}
+	if (opts.SSLCert == "") != (opts.SSLKey == "") {
+		return dsqltest.Config{}, errors.New("--sslcert and --sslkey (or PGSSLCERT and PGSSLKEY) must be set together")
+	}
+	// PGSSLMODE counts too, so this waits until the mode is resolved
+	if opts.InsecureSkipVerify && (opts.SSLMode == "verify-ca" || opts.SSLMode == "verify-full") {
+		return dsqltest.Config{}, fmt.Errorf("--insecure-skip-tls-verify cannot be combined with sslmode %s", opts.SSLMode)
+	}
+	if len(opts.VerifyNames) > 0 && opts.SSLMode != "verify-full" {
+		return dsqltest.Config{}, fmt.Errorf("--tls-verify-name requires sslmode verify-full, got %s", opts.SSLMode)
+	}
+	return opts, nil
+}
+
+// applyClusterID replaces the hostname with the endpoint of --cluster-id in
+// region, falling back to AWS_REGION. The endpoint is also dialed directly
+// when no tunnel address is set. It's a no-op without --cluster-id.
+func (f *connFlags) applyClusterID(opts *dsqltest.Config, region string) error {
+	if f.clusterID == "" {
+		return nil
+	}
+	if f.host != "" {
+		return errors.New("--cluster-id and --host are mutually exclusive")
+	}
+	if err := dsqltest.ValidateClusterID(f.clusterID); err != nil {
+		return fmt.Errorf("invalid --cluster-id: %w", err)
+	}
+	region = firstNonEmpty(region, os.Getenv("AWS_REGION"))
+	if region == "" {
+		return errors.New("--cluster-id needs --region or AWS_REGION to build the hostname")
+	}
+	if strings.Contains(region, ",") {
+		return errors.New("--cluster-id takes a single --region")
+	}
+	opts.Hostname = dsqltest.ClusterHostname(f.clusterID, region)
+	opts.HostAddr = firstNonEmpty(f.hostaddr, os.Getenv("PGHOSTADDR"), opts.Hostname)
+	return nil
+}
+
+// runtimeParams collects repeated --set key=value flags.
+type runtimeParams map[string]string
+
+// String lists the parameters as sorted key=value pairs.
+func (p runtimeParams) String() string {
+	pairs := make([]string, 0, len(p))
+	for _, k := range slices.Sorted(maps.Keys(p)) {
+		pairs = append(pairs, k+"="+p[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set parses one key=value pair. The key must be a plain parameter name,
+// and user and database have their own flags since pgx sends them itself.
+func (p runtimeParams) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	key = strings.TrimSpace(key)
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", s)
+	}
+	if !isParamName(key) {
+		return fmt.Errorf("invalid parameter name %q", key)
+	}
+	if key == "user" || key == "database" {
+		return fmt.Errorf("%s is set with --%s", key, key)
+	}
+	p[key] = value
+	return nil
+}
+
+// isParamName reports whether s looks like a Postgres setting name, such as
+// statement_timeout or a dotted custom setting like app.tenant.
+func isParamName(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case i > 0 && (r == '.' || '0' <= r && r <= '9'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// hostnameEnvs returns the variables the SNI hostname falls back to, in
+// order. HOSTNAME normally ranks first, but many shells export it as the
+// machine name and --env-file never overrides it, so a PGHOST from the env
+// file ranks above an inherited HOSTNAME.
+func (f *connFlags) hostnameEnvs() []string {
+	if f.envFile["PGHOST"] && !f.envFile["HOSTNAME"] {
+		return []string{"PGHOST", "HOSTNAME"}
+	}
+	return []string{"HOSTNAME", "PGHOST"}
+}
+
+// isSet reports whether the flag name was given on the command line.
+func (f *connFlags) isSet(name string) bool {
+	set := false
+	f.fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// fromServiceOrEnv returns the service's value for key, falling back to
+// the environment variable env, along with where the value came from for
+// error messages.
+func (f *connFlags) fromServiceOrEnv(key, env string) (string, string) {
+	if v := f.svc.get(key); v != "" {
+		return v, fmt.Sprintf("%s in service %s", key, f.svc)
+	}
+	return os.Getenv(env), env
+}
+
+// readPassword returns the credential from --password-file or
+// --password-stdin, with trailing newlines trimmed, or "" if neither is set.
+func (f *connFlags) readPassword() (string, error) {
+	var data []byte
+	var err error
+	switch {
+	case f.passwordFile != "" && f.passwordStdin:
+		return "", errors.New("--password-file and --password-stdin are mutually exclusive")
+	case f.passwordFile != "":
+		data, err = os.ReadFile(f.passwordFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password file: %w", err)
+		}
+	case f.passwordStdin:
+		data, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+	default:
+		return "", nil
+	}
+
+	password := strings.TrimRight(string(data), "\r\n")
+	if password == "" {
+		return "", errors.New("password input is empty")
+	}
+	return password, nil
+}
+
+// defaultAppName identifies this tool and its build in server-side session
+// views.
+func defaultAppName() string {
+	return "dsql-conn-test/" + buildInfo().Version
+}
+
+// firstNonEmpty returns the first non-empty value, or "" if all are empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
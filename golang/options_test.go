@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolvePort(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		pgport  string
+		want    int
+		wantErr string
+	}{
+		{name: "flag", args: []string{"--port", "5433"}, pgport: "15480", want: 5433},
+		{name: "flag zero", args: []string{"--port", "0"}, pgport: "15480", wantErr: "invalid --port 0"},
+		{name: "flag negative", args: []string{"--port", "-1"}, wantErr: "invalid --port -1"},
+		{name: "flag too large", args: []string{"--port", "65536"}, wantErr: "invalid --port 65536"},
+		{name: "env", pgport: " 5433 ", want: 5433},
+		{name: "env zero", pgport: "0", wantErr: `invalid PGPORT "0"`},
+		{name: "unset", want: 5432},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PGSERVICE", "")
+			t.Setenv("PGPORT", tt.pgport)
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			f := registerConnFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("parse %q: %v", tt.args, err)
+			}
+			opts, err := f.resolve()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("resolve: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("resolve error = %v, want one containing %q", err, tt.wantErr)
+			case tt.wantErr == "" && opts.Port != tt.want:
+				t.Errorf("Port = %d, want %d", opts.Port, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveHostnameWithEnvFile(t *testing.T) {
+	const cluster = "abcdefghijklmnopqrstuvwxyz.dsql.us-east-1.on.aws"
+	tests := []struct {
+		name     string
+		hostname string // inherited HOSTNAME
+		pghost   string // inherited PGHOST
+		envFile  string
+		want     string
+	}{
+		{name: "env file PGHOST over machine HOSTNAME", hostname: "build-container", envFile: "PGHOST=" + cluster, want: cluster},
+		{name: "env file HOSTNAME over PGHOST", envFile: "HOSTNAME=" + cluster + "\nPGHOST=127.0.0.1", want: cluster},
+		{name: "inherited HOSTNAME over inherited PGHOST", hostname: cluster, pghost: "127.0.0.1", want: cluster},
+		{name: "inherited PGHOST alone", pghost: cluster, want: cluster},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PGSERVICE", "")
+			for env, value := range map[string]string{"HOSTNAME": tt.hostname, "PGHOST": tt.pghost} {
+				t.Setenv(env, value)
+				if value == "" {
+					os.Unsetenv(env)
+				}
+			}
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			f := registerConnFlags(fs)
+			if err := fs.Parse(nil); err != nil {
+				t.Fatal(err)
+			}
+			if tt.envFile != "" {
+				path := filepath.Join(t.TempDir(), ".env")
+				if err := os.WriteFile(path, []byte(tt.envFile+"\n"), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				var err error
+				if f.envFile, err = loadEnvFile(path); err != nil {
+					t.Fatalf("loadEnvFile: %v", err)
+				}
+			}
+			opts, err := f.resolve()
+			if err != nil {
+				t.Fatalf("resolve: %v", err)
+			}
+			if opts.Hostname != tt.want {
+				t.Errorf("Hostname = %q, want %q", opts.Hostname, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// textReport is the report a mode prints, which renders itself as text.
+type textReport interface {
+	writeText(w io.Writer)
+}
+
+// writeReport prints a mode's report: as indented JSON on stdout in JSON
+// mode, otherwise as text on out. It returns code, or exitFailure if the
+// JSON can't be written.
+func writeReport(stdout, out io.Writer, report textReport, jsonOutput bool, code int) int {
+	if !jsonOutput {
+		report.writeText(out)
+		return code
+	}
+	if err := writeJSONReport(stdout, report); err != nil {
+		slog.Error("failed to write JSON report", "error", err)
+		return exitFailure
+	}
+	return code
+}
+
+// writeJSONReport encodes v as indented JSON, the layout of every JSON
+// report.
+func writeJSONReport(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// outputFile is the --output destination. Unless appending, results are
+// written to a temporary file in the same directory and renamed over path
+// on commit, so readers never see a partial report.
+type outputFile struct {
+	*os.File
+	path   string
+	append bool
+}
+
+// openOutput creates the temporary file for path, or opens path for
+// appending when appendMode is set.
+func openOutput(path string, appendMode bool) (*outputFile, error) {
+	if appendMode {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open output file: %w", err)
+		}
+		return &outputFile{File: f, path: path, append: true}, nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	// CreateTemp's 0600 would make archived reports unreadable to others
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	return &outputFile{File: f, path: path}, nil
+}
+
+// commit flushes the file to disk and, unless appending, moves it into
+// place. The temporary file is removed if that fails.
+func (f *outputFile) commit() error {
+	err := f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if f.append {
+		if err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+		return nil
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to write output file %s: %w", f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// serviceKeys are the libpq connection keywords a service can set that map
+// onto connection flags. Other valid libpq keywords are ignored with a
+// warning, so a service file shared with psql still loads.
+var serviceKeys = map[string]bool{
+	"host": true, "hostaddr": true, "port": true, "user": true, "dbname": true, "password": true,
+	"sslmode": true, "sslrootcert": true, "sslcert": true, "sslkey": true,
+	"application_name": true, "connect_timeout": true,
+}
+
+// pgService is a named section of a libpq connection service file.
+type pgService struct {
+	name   string
+	file   string
+	params map[string]string
+}
+
+// get returns the service's value for a libpq keyword, or "" for a nil
+// service or a keyword it doesn't set.
+func (s *pgService) get(key string) string {
+	if s == nil {
+		return ""
+	}
+	return s.params[key]
+}
+
+// String names the service and the file it was read from.
+func (s *pgService) String() string {
+	return fmt.Sprintf("%s (%s)", s.name, s.file)
+}
+
+// serviceFiles returns the service files libpq searches, in order: the
+// per-user file, PGSERVICEFILE or ~/.pg_service.conf, then the system-wide
+// pg_service.conf in PGSYSCONFDIR when that's set.
+func serviceFiles() []string {
+	var files []string
+	if file := os.Getenv("PGSERVICEFILE"); file != "" {
+		files = append(files, file)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".pg_service.conf"))
+	}
+	if dir := os.Getenv("PGSYSCONFDIR"); dir != "" {
+		files = append(files, filepath.Join(dir, "pg_service.conf"))
+	}
+	return files
+}
+
+// loadPGService reads the service called name from the first service file
+// that defines it, as libpq does, or returns nil when name is empty.
+// Missing files are skipped; a service defined in none of them is an error.
+func loadPGService(name string) (*pgService, error) {
+	if name == "" {
+		return nil, nil
+	}
+	files := serviceFiles()
+	for _, file := range files {
+		params, found, err := readServiceSection(file, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return &pgService{name: name, file: file, params: params}, nil
+		}
+	}
+	return nil, fmt.Errorf("service %q not found in %s", name, strings.Join(files, " or "))
+}
+
+// readServiceSection parses the [name] section of the INI-style service
+// file at path. Blank lines and lines starting with # are skipped, and
+// values are taken literally up to the end of the line.
+func readServiceSection(path, name string) (map[string]string, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	var params map[string]string
+	inSection := false
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") {
+				return nil, false, fmt.Errorf("%s:%d: unterminated section header %q", path, lineNo, line)
+			}
+			// The first section with the name is the one libpq uses
+			if inSection {
+				break
+			}
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == name
+			if inSection {
+				params = make(map[string]string)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, false, fmt.Errorf("%s:%d: expected keyword=value, got %q", path, lineNo, line)
+		}
+		if !inSection {
+			continue
+		}
+		if !serviceKeys[key] {
+			slog.Warn("ignoring unsupported service file keyword", "file", path, "line", lineNo, "service", name, "keyword", key)
+			continue
+		}
+		params[key] = strings.TrimSpace(value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read service file %s: %w", path, err)
+	}
+	return params, params != nil, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// writePgxConfig prints the fields pgx will connect with, after
+// ParseConfig and the dsqltest overrides, for --dump-pgx-config. The
+// password is only reported as set or not, and so is any runtime parameter
+// that happens to hold it. A pool config's password is usually empty, since
+// IAM tokens are set on each connection as it's opened.
+func writePgxConfig(w io.Writer, config *pgconn.Config) {
+	fmt.Fprintln(w, "\npgx Connection Config:")
+	fmt.Fprintln(w, "======================")
+	fmt.Fprintf(w, "Host: %s\n", config.Host)
+	fmt.Fprintf(w, "Port: %d\n", config.Port)
+	fmt.Fprintf(w, "User: %s\n", config.User)
+	fmt.Fprintf(w, "Database: %s\n", config.Database)
+	fmt.Fprintf(w, "Password: %s\n", maskedSecret(config.Password))
+	if config.ConnectTimeout > 0 {
+		fmt.Fprintf(w, "ConnectTimeout: %s\n", config.ConnectTimeout)
+	} else {
+		fmt.Fprintln(w, "ConnectTimeout: none (bounded by the context)")
+	}
+	fmt.Fprintln(w, "RuntimeParams:")
+	for _, key := range slices.Sorted(maps.Keys(config.RuntimeParams)) {
+		value := config.RuntimeParams[key]
+		if config.Password != "" && value == config.Password {
+			value = maskedSecret(value)
+		}
+		fmt.Fprintf(w, "  %s=%s\n", key, value)
+	}
+	writePgxTLS(w, "TLS", config.TLSConfig)
+	for i, fb := range config.Fallbacks {
+		fmt.Fprintf(w, "Fallback %d: %s:%d\n", i+1, fb.Host, fb.Port)
+		writePgxTLS(w, "  TLS", fb.TLSConfig)
+	}
+}
+
+// writePgxTLS prints the TLS settings that decide what the server is sent
+// and how its certificate is checked. InsecureSkipVerify is also true for
+// verify-ca, which checks the chain in a callback instead.
+func writePgxTLS(w io.Writer, label string, cfg *tls.Config) {
+	if cfg == nil {
+		fmt.Fprintf(w, "%s: none (plaintext)\n", label)
+		return
+	}
+	fmt.Fprintf(w, "%s: ServerName=%q MinVersion=%s InsecureSkipVerify=%t", label, cfg.ServerName, tls.VersionName(cfg.MinVersion), cfg.InsecureSkipVerify)
+	if cfg.VerifyPeerCertificate != nil {
+		fmt.Fprint(w, " (certificate checked by a custom verifier)")
+	}
+	fmt.Fprintln(w)
+}
+
+// maskedSecret reports whether a secret is set without revealing it.
+func maskedSecret(s string) string {
+	if s == "" {
+		return "(not set)"
+	}
+	return "(set, redacted)"
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/tracelog"
+)
+
+// credentialMarkers are substrings of DSQL IAM auth tokens, which are
+// presigned URLs.
+var credentialMarkers = []string{"Action=DbConnect", "X-Amz-Credential=", "X-Amz-Signature=", "X-Amz-Security-Token="}
+
+// redactingTracer is pgx's tracelog with query arguments that look like
+// credentials replaced before they're logged.
+type redactingTracer struct {
+	*tracelog.TraceLog
+	password string
+}
+
+// newQueryTracer returns the --trace tracer. It logs each connect, prepare,
+// query and batch with its arguments and timing as slog debug events.
+// password, if non-empty, is redacted wherever it appears as an argument.
+func newQueryTracer(password string) *redactingTracer {
+	return &redactingTracer{
+		TraceLog: &tracelog.TraceLog{
+			Logger:   tracelog.LoggerFunc(logTraceEvent),
+			LogLevel: tracelog.LogLevelTrace,
+		},
+		password: password,
+	}
+}
+
+// TraceQueryStart redacts the arguments before tracelog records them for
+// the end-of-query event.
+func (t *redactingTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	data.Args = t.redactArgs(data.Args)
+	return t.TraceLog.TraceQueryStart(ctx, conn, data)
+}
+
+// TraceBatchQuery redacts the arguments of each queued batch query.
+func (t *redactingTracer) TraceBatchQuery(ctx context.Context, conn *pgx.Conn, data pgx.TraceBatchQueryData) {
+	data.Args = t.redactArgs(data.Args)
+	t.TraceLog.TraceBatchQuery(ctx, conn, data)
+}
+
+// redactArgs returns a copy of args with credential-like values masked.
+// The query itself still receives the original slice.
+func (t *redactingTracer) redactArgs(args []any) []any {
+	redacted := make([]any, len(args))
+	for i, arg := range args {
+		redacted[i] = arg
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		default:
+			continue
+		}
+		if t.looksLikeCredential(s) {
+			redacted[i] = "****"
+		}
+	}
+	return redacted
+}
+
+// looksLikeCredential reports whether s is the configured password or
+// contains part of an IAM auth token.
+func (t *redactingTracer) looksLikeCredential(s string) bool {
+	if t.password != "" && s == t.password {
+		return true
+	}
+	for _, marker := range credentialMarkers {
+		if strings.Contains(s, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// logTraceEvent forwards a tracelog event to slog at debug level, whatever
+// level pgx assigned it, with the event data as sorted attributes.
+func logTraceEvent(ctx context.Context, level tracelog.LogLevel, msg string, data map[string]any) {
+	attrs := make([]any, 0, 2*len(data)+2)
+	attrs = append(attrs, "pgx_level", level.String())
+	for _, key := range slices.Sorted(maps.Keys(data)) {
+		attrs = append(attrs, key, data[key])
+	}
+	slog.DebugContext(ctx, "pgx "+msg, attrs...)
+}
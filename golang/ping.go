@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultPingTimeout bounds --ping, which health checks run often and
+// expect to answer quickly.
+const defaultPingTimeout = 5 * time.Second
+
+// runPing connects once and pings the server, filling in result's connect
+// and ping latencies. The connect isn't retried, so a health check sees the
+// first failure. Failing to connect is a connect or auth failure; a ping
+// that fails on an established connection is tagged exitQuery.
+func runPing(ctx context.Context, cfg testConfig, timeout time.Duration, result *ConnectionResult) error {
+	tlsObs := &tlsObserver{}
+	config, err := newConnConfig(ctx, cfg, tlsObs)
+	if err != nil {
+		return configFailure(err)
+	}
+
+	connectStart := time.Now()
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return connectFailure(phaseError(ctx, "connect", timeout,
+			tlsVersionFailure(cfg.conn, fmt.Errorf("could not connect: %w", err))))
+	}
+	defer closeConn(conn)
+	result.ConnectLatencyMs = durationMs(time.Since(connectStart))
+	result.BackendPID = conn.PgConn().PID()
+	if err := requireTLS(conn); err != nil {
+		return err
+	}
+	result.SSL = true
+
+	pingStart := time.Now()
+	if err := conn.Ping(ctx); err != nil {
+		return withExitCode(exitQuery, phaseError(ctx, "ping", timeout, fmt.Errorf("%w: %w", dsqltest.ErrPingFailed, err)))
+	}
+	result.QueryLatencyMs = durationMs(time.Since(pingStart))
+	result.LatencyMs = result.ConnectLatencyMs + result.QueryLatencyMs
+	result.TLSVersion = tlsObs.version()
+	result.TLSCipher = tlsObs.cipherSuite()
+	result.ClientCert = tlsObs.clientCert(cfg.conn)
+	result.VerifiedName = tlsObs.verifiedName(cfg.conn)
+	result.Success = true
+	return nil
+}
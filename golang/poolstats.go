@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// poolStats is a snapshot of pgxpool's counters. NewConns and
+// LifetimeDestroyed are cumulative, so comparing snapshots shows how often
+// the pool replaced connections that reached their max lifetime.
+type poolStats struct {
+	AcquiredConns     int32 `json:"acquired_conns"`
+	IdleConns         int32 `json:"idle_conns"`
+	TotalConns        int32 `json:"total_conns"`
+	MaxConns          int32 `json:"max_conns"`
+	NewConns          int64 `json:"new_conns"`
+	LifetimeDestroyed int64 `json:"max_lifetime_destroyed"`
+}
+
+// newPoolStats captures pool's current statistics.
+func newPoolStats(pool *pgxpool.Pool) *poolStats {
+	stat := pool.Stat()
+	return &poolStats{
+		AcquiredConns:     stat.AcquiredConns(),
+		IdleConns:         stat.IdleConns(),
+		TotalConns:        stat.TotalConns(),
+		MaxConns:          stat.MaxConns(),
+		NewConns:          stat.NewConnsCount(),
+		LifetimeDestroyed: stat.MaxLifetimeDestroyCount(),
+	}
+}
+
+// writeText prints the statistics as a block of the connection output.
+func (s *poolStats) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Pool Connections: %d total (%d acquired, %d idle, max %d)\n",
+		s.TotalConns, s.AcquiredConns, s.IdleConns, s.MaxConns)
+	fmt.Fprintf(w, "Pool Connections Opened: %d\n", s.NewConns)
+	fmt.Fprintf(w, "Pool Max Lifetime Closes: %d\n", s.LifetimeDestroyed)
+}
+
+// String formats the statistics for a single watch line.
+func (s *poolStats) String() string {
+	return fmt.Sprintf("pool=%d/%d acquired/total idle=%d opened=%d lifetime_closed=%d",
+		s.AcquiredConns, s.TotalConns, s.IdleConns, s.NewConns, s.LifetimeDestroyed)
+}
+
+// prewarmReport is the outcome of --prewarm-pool.
+type prewarmReport struct {
+	Requested   int     `json:"requested"`
+	Established int     `json:"established"`
+	DurationMs  float64 `json:"duration_ms"`
+}
+
+// newPrewarmReport converts the library's result for output.
+func newPrewarmReport(r dsqltest.PrewarmResult) *prewarmReport {
+	return &prewarmReport{Requested: r.Requested, Established: r.Established, DurationMs: durationMs(r.Duration)}
+}
+
+// String formats the outcome for a single progress line.
+func (r *prewarmReport) String() string {
+	return fmt.Sprintf("%d/%d connections established in %.2fms", r.Established, r.Requested, r.DurationMs)
+}
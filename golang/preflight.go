@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// preflightDialTimeout bounds the plain TCP dial so a dead tunnel is
+// reported quickly.
+const preflightDialTimeout = 3 * time.Second
+
+// preflightStep is the outcome of one reachability check.
+type preflightStep struct {
+	Name       string  `json:"name"`
+	Success    bool    `json:"success"`
+	Detail     string  `json:"detail,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// runPreflight checks, before any TLS or auth, that each of hostaddrs
+// resolves and that something accepts TCP connections on port. It separates
+// DNS and tunnel problems from TLS and credential ones. Since the addresses
+// are fallbacks for each other, one that passes is enough. Each step is
+// printed to out.
+func runPreflight(ctx context.Context, hostaddrs []string, port int, out io.Writer) ([]preflightStep, error) {
+	fmt.Fprintln(out, "\nRunning preflight checks:")
+	var steps []preflightStep
+	var errs []error
+	for _, hostaddr := range hostaddrs {
+		s, err := preflightAddr(ctx, hostaddr, port, out)
+		steps = append(steps, s...)
+		if err == nil {
+			return steps, nil
+		}
+		errs = append(errs, err)
+	}
+	return steps, errors.Join(errs...)
+}
+
+// preflightAddr runs the preflight steps for one address.
+func preflightAddr(ctx context.Context, hostaddr string, port int, out io.Writer) ([]preflightStep, error) {
+	var steps []preflightStep
+	record := func(s preflightStep, start time.Time, err error) error {
+		s.DurationMs = durationMs(time.Since(start))
+		s.Success = err == nil
+		if err != nil {
+			s.Error = err.Error()
+			fmt.Fprintf(out, "  %s %s: %v\n", okOrFail(false, "[FAIL]"), s.Name, err)
+		} else {
+			fmt.Fprintf(out, "  %s %s (%s)\n", okOrFail(true, "[PASS]"), s.Name, s.Detail)
+		}
+		steps = append(steps, s)
+		return err
+	}
+
+	start := time.Now()
+	resolve := preflightStep{Name: "resolve " + hostaddr}
+	var err error
+	if ip := net.ParseIP(hostaddr); ip != nil {
+		resolve.Detail = "already an IP address"
+	} else {
+		var addrs []string
+		addrs, err = net.DefaultResolver.LookupHost(ctx, hostaddr)
+		resolve.Detail = strings.Join(addrs, ", ")
+	}
+	if err := record(resolve, start, err); err != nil {
+		return steps, fmt.Errorf("preflight: DNS lookup of %s failed: %w", hostaddr, err)
+	}
+
+	addr := net.JoinHostPort(hostaddr, strconv.Itoa(port))
+	start = time.Now()
+	dial := preflightStep{Name: "tcp connect " + addr}
+	dialCtx, cancel := context.WithTimeout(ctx, preflightDialTimeout)
+	conn, err := (&net.Dialer{}).DialContext(dialCtx, "tcp", addr)
+	cancel()
+	if err == nil {
+		dial.Detail = "connected from " + conn.LocalAddr().String()
+		conn.Close()
+	}
+	if err := record(dial, start, err); err != nil {
+		return steps, fmt.Errorf("preflight: nothing accepting TCP connections on %s (is the tunnel up?): %w", addr, err)
+	}
+	return steps, nil
+}
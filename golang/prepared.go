@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// preparedStmtName is the server-side name of the --prepared test statement.
+const preparedStmtName = "dsql_conntest_prepared"
+
+// preparedArgs are the values bound to $1 on successive executions.
+var preparedArgs = []int64{1, 42, -7, 1 << 40, 0}
+
+// preparedCheck verifies the extended query protocol: a statement with a $1
+// placeholder is prepared once and executed with different arguments.
+var preparedCheck = check{name: "prepared", run: runPreparedTest}
+
+func runPreparedTest(ctx context.Context, s *session, r *checkResult) error {
+	prepareStart := time.Now()
+	sd, err := s.conn.Prepare(ctx, preparedStmtName, "SELECT $1::bigint * 2, $1::bigint::text")
+	if err := r.step("prepare statement", err); err != nil {
+		return err
+	}
+	r.detail("prepare_ms", durationMs(time.Since(prepareStart)))
+	defer s.conn.Deallocate(context.Background(), preparedStmtName)
+
+	if err := r.step("describe parameters", expectParamCount(len(sd.ParamOIDs), 1)); err != nil {
+		return err
+	}
+
+	latencies := make([]time.Duration, 0, len(preparedArgs))
+	for i, arg := range preparedArgs {
+		execStart := time.Now()
+		var doubled int64
+		var text string
+		err := s.conn.QueryRow(ctx, preparedStmtName, arg).Scan(&doubled, &text)
+		latencies = append(latencies, time.Since(execStart))
+		if err == nil && (doubled != arg*2 || text != strconv.FormatInt(arg, 10)) {
+			err = fmt.Errorf("got (%d, %q), want (%d, %q)", doubled, text, arg*2, strconv.FormatInt(arg, 10))
+		}
+		if err := r.step(fmt.Sprintf("execute #%d ($1 = %d)", i+1, arg), err); err != nil {
+			return err
+		}
+	}
+
+	// The first execution may include plan setup the later ones reuse
+	r.detail("first_execute_ms", durationMs(latencies[0]))
+	if rest := summarizeLatencies(latencies[1:]); rest != nil {
+		r.detail("subsequent_execute_mean_ms", rest.MeanMs)
+	}
+	return nil
+}
+
+// expectParamCount reports a mismatch in the described placeholder count.
+func expectParamCount(got, want int) error {
+	if got != want {
+		return fmt.Errorf("statement describes %d parameters, want %d", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// defaultMaxRows caps how many rows of a --query result are kept, so a
+// statement that returns millions can't flood the terminal or memory.
+const defaultMaxRows = 1000
+
+// queryResult is the result set of a --query or --query-file statement,
+// with the column types the server described it with and the command tag,
+// which carries the affected row count for DML.
+type queryResult struct {
+	Columns     []string      `json:"columns"`
+	Rows        [][]any       `json:"rows"`
+	RowCount    int           `json:"row_count"`
+	ColumnTypes []queryColumn `json:"column_types"`
+	CommandTag  string        `json:"command_tag"`
+
+	// Truncated is set when rows remained past MaxRows. They were read
+	// and discarded, so the command tag still counts every row
+	Truncated bool `json:"truncated,omitempty"`
+	MaxRows   int  `json:"max_rows,omitempty"`
+
+	firstRow time.Duration // from sending the query to the first row
+}
+
+// queryColumn is one column's field description. The OID is reported as
+// sent, since an unexpected one is the usual sign of an encoding mismatch.
+type queryColumn struct {
+	Name    string `json:"name"`
+	TypeOID uint32 `json:"type_oid"`
+	Type    string `json:"type"`
+}
+
+// runQuery executes sql and collects up to maxRows rows, or every row when
+// maxRows is 0, using the field descriptions for the column headers.
+func runQuery(ctx context.Context, conn *pgx.Conn, sql string, maxRows int) (*queryResult, error) {
+	start := time.Now()
+	rows, err := conn.Query(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := &queryResult{Rows: [][]any{}, ColumnTypes: []queryColumn{}}
+	for _, fd := range rows.FieldDescriptions() {
+		result.Columns = append(result.Columns, fd.Name)
+		result.ColumnTypes = append(result.ColumnTypes, queryColumn{
+			Name:    fd.Name,
+			TypeOID: fd.DataTypeOID,
+			Type:    typeName(conn.TypeMap(), fd.DataTypeOID),
+		})
+	}
+	for rows.Next() {
+		if result.firstRow == 0 {
+			result.firstRow = time.Since(start)
+		}
+		if maxRows > 0 && len(result.Rows) == maxRows {
+			result.Truncated, result.MaxRows = true, maxRows
+			break
+		}
+		values, err := rows.Values()
+		if err != nil {
+			return nil, err
+		}
+		for i, v := range values {
+			values[i] = normalizeValue(v)
+		}
+		result.Rows = append(result.Rows, values)
+	}
+	// Close drains what's left of the result set, which sets the tag
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	result.RowCount = len(result.Rows)
+	result.CommandTag = rows.CommandTag().String()
+	return result, nil
+}
+
+// typeName is the name pgx knows a type OID by, or the bare OID for types
+// it doesn't, such as extension types.
+func typeName(m *pgtype.Map, oid uint32) string {
+	if t, ok := m.TypeForOID(oid); ok {
+		return t.Name
+	}
+	return fmt.Sprintf("OID %d", oid)
+}
+
+// sampleQuery runs sql n times, returning the first result set and the
+// latency of every run, along with the time to its first row. Each result
+// set is capped at maxRows.
+func sampleQuery(ctx context.Context, conn *pgx.Conn, sql string, n, maxRows int) (*queryResult, []latencySample, error) {
+	var result *queryResult
+	samples := make([]latencySample, 0, n)
+	for i := 0; i < n; i++ {
+		queryStart := time.Now()
+		sample, err := runQuery(ctx, conn, sql, maxRows)
+		if err != nil {
+			return result, samples, err
+		}
+		samples = append(samples, latencySample{queryStart, time.Since(queryStart), sample.firstRow})
+		if i == 0 {
+			result = sample
+		}
+	}
+	return result, samples, nil
+}
+
+// normalizeValue converts values pgx decodes into types with an unhelpful
+// JSON or text form: UUIDs arrive as [16]byte and bytea as []byte.
+func normalizeValue(v any) any {
+	switch v := v.(type) {
+	case [16]byte:
+		return fmt.Sprintf("%x-%x-%x-%x-%x", v[0:4], v[4:6], v[6:8], v[8:10], v[10:16])
+	case []byte:
+		return fmt.Sprintf("\\x%x", v)
+	}
+	return v
+}
+
+// writeText prints the result set as an aligned table, followed by the
+// row count, command tag and column types. A statement without a result
+// set, such as DML, gets only the row count and command tag.
+func (q *queryResult) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nQuery Result:")
+	fmt.Fprintln(w, "=============")
+
+	if len(q.Columns) > 0 {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(q.Columns, "\t"))
+		seps := make([]string, len(q.Columns))
+		for i, c := range q.Columns {
+			seps[i] = strings.Repeat("-", max(len(c), 3))
+		}
+		fmt.Fprintln(tw, strings.Join(seps, "\t"))
+		for _, row := range q.Rows {
+			cells := make([]string, len(row))
+			for i, v := range row {
+				if v == nil {
+					cells[i] = "NULL"
+				} else {
+					cells[i] = fmt.Sprint(v)
+				}
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+		tw.Flush()
+	}
+
+	rowWord := "rows"
+	if q.RowCount == 1 {
+		rowWord = "row"
+	}
+	fmt.Fprintf(w, "(%d %s)\n", q.RowCount, rowWord)
+	if q.Truncated {
+		fmt.Fprintln(w, colorize(colorYellow, fmt.Sprintf("Output truncated at --max-rows %d: more rows remained, see the command tag for the full count", q.MaxRows)))
+	}
+	if q.CommandTag != "" {
+		fmt.Fprintf(w, "Command Tag: %s\n", q.CommandTag)
+	}
+	if len(q.ColumnTypes) > 0 {
+		fmt.Fprintln(w, "Column Types:")
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, c := range q.ColumnTypes {
+			fmt.Fprintf(tw, "  %s\t%s\t(OID %d)\n", c.Name, c.Type, c.TypeOID)
+		}
+		tw.Flush()
+	}
+}
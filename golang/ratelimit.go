@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/time/rate"
+)
+
+// rateGate throttles connection attempts and benchmark queries to --rate
+// per second, so the tool itself can't trip DSQL's connection throttling.
+// A nil gate lets everything through.
+type rateGate struct {
+	limiter *rate.Limiter
+	perSec  float64
+	first   atomic.Int64 // UnixNano of the first event
+	events  atomic.Int64
+}
+
+// rateReport is the configured and achieved rate shown in a summary.
+type rateReport struct {
+	LimitPerSec    float64 `json:"limit_per_sec"`
+	AchievedPerSec float64 `json:"achieved_per_sec"`
+	Events         int64   `json:"events"`
+}
+
+// newRateGate returns a gate for perSec events per second with no
+// bursting, or nil when perSec is zero.
+func newRateGate(perSec float64) *rateGate {
+	if perSec <= 0 {
+		return nil
+	}
+	return &rateGate{limiter: rate.NewLimiter(rate.Limit(perSec), 1), perSec: perSec}
+}
+
+// wait blocks until the next event is allowed or ctx is done.
+func (g *rateGate) wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+	if err := g.limiter.Wait(ctx); err != nil {
+		return fmt.Errorf("waiting for --rate: %w", err)
+	}
+	g.first.CompareAndSwap(0, time.Now().UnixNano())
+	g.events.Add(1)
+	return nil
+}
+
+// wrapDial makes every connection attempt through config wait its turn,
+// including the retries ConnectWithRetry makes.
+func (g *rateGate) wrapDial(config *pgconn.Config) {
+	if g == nil {
+		return
+	}
+	dial := config.DialFunc
+	config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if err := g.wait(ctx); err != nil {
+			return nil, err
+		}
+		return dial(ctx, network, addr)
+	}
+}
+
+// report returns the rate achieved since the first event, or nil for a nil
+// gate. The first event goes through at once, so it only starts the clock.
+func (g *rateGate) report() *rateReport {
+	if g == nil {
+		return nil
+	}
+	r := &rateReport{LimitPerSec: g.perSec, Events: g.events.Load()}
+	if r.Events > 1 {
+		elapsed := time.Since(time.Unix(0, g.first.Load()))
+		r.AchievedPerSec = float64(r.Events-1) / elapsed.Seconds()
+	}
+	return r
+}
+
+// writeText prints the limit and achieved rate on one line.
+func (r *rateReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "Rate: %.2f/s achieved (limit %.2f/s, %d rate-limited operations)\n",
+		r.AchievedPerSec, r.LimitPerSec, r.Events)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Readiness states reported by --wait-for-ready.
+const (
+	stateReady        = "READY"
+	stateProvisioning = "PROVISIONING"
+	stateDown         = "DOWN"
+)
+
+// clusterStatusFunc asks the DSQL control plane for the cluster's status.
+type clusterStatusFunc func(ctx context.Context) (string, error)
+
+// newClusterStatusFunc returns a status lookup for the cluster behind
+// hostname, or nil when hostname isn't a cluster endpoint and only the
+// connect errors can tell a provisioning cluster from a down one.
+func newClusterStatusFunc(awsCfg aws.Config, hostname string) clusterStatusFunc {
+	id := dsqltest.ClusterIDFromHostname(hostname)
+	if id == "" {
+		return nil
+	}
+	return func(ctx context.Context) (string, error) {
+		return dsqltest.ClusterStatus(ctx, awsCfg, id)
+	}
+}
+
+// readinessReport is the outcome of a --wait-for-ready run.
+type readinessReport struct {
+	State          string  `json:"state"`
+	Ready          bool    `json:"ready"`
+	Probes         int     `json:"probes"`
+	WaitedSeconds  float64 `json:"waited_seconds"`
+	TimeoutSeconds float64 `json:"timeout_seconds"`
+	ClusterStatus  string  `json:"cluster_status,omitempty"`
+	Error          string  `json:"error,omitempty"`
+	ErrorCategory  string  `json:"error_category,omitempty"`
+	ExitCode       int     `json:"exit_code"`
+
+	Result *ConnectionResult `json:"result,omitempty"`
+
+	waited time.Duration
+}
+
+// runWaitForReady runs the connectivity test every interval until it
+// passes or timeout has elapsed, so a deploy script can wait out a newly
+// created cluster. Each failed probe is reported as PROVISIONING or DOWN
+// (see readinessState); only the last one decides the exit code when time
+// runs out: exitProvisioning for a cluster still being created, or the
+// failure's own code for one that is down. With jsonOutput the report is
+// written to stdout.
+func runWaitForReady(rootCtx context.Context, cfg testConfig, timeout, interval time.Duration, status clusterStatusFunc, out, stdout io.Writer, jsonOutput bool) int {
+	ctx, cancel := context.WithTimeout(rootCtx, timeout)
+	defer cancel()
+
+	fmt.Fprintf(out, "Waiting up to %s for DSQL cluster %s via %s to accept connections, probing every %s\n",
+		timeout, cfg.conn.Hostname, cfg.conn.Address(), interval)
+
+	report := &readinessReport{TimeoutSeconds: timeout.Seconds()}
+	started := time.Now()
+	var lastErr error
+	for {
+		probeCtx, cancelProbe := context.WithTimeout(ctx, cfg.timeout)
+		result := &ConnectionResult{CorrelationID: cfg.runID, Host: cfg.conn.HostAddr, Port: cfg.conn.Port, SSLMode: cfg.conn.SSLMode}
+		err := runConnectivityTest(probeCtx, cfg, io.Discard, result)
+		cancelProbe()
+
+		// A probe cut short by shutdown or the deadline says nothing new,
+		// unless it's the only one there is
+		if rootCtx.Err() != nil || (ctx.Err() != nil && report.Probes > 0) {
+			break
+		}
+
+		report.Probes++
+		timestamp := time.Now().UTC().Format(time.RFC3339)
+		if err == nil {
+			report.State = stateReady
+			report.Ready = true
+			report.ClusterStatus = ""
+			report.Result = result
+			lastErr = nil
+			fmt.Fprintf(out, "%s %s connect=%.2fms query=%.2fms\n", timestamp, colorize(colorGreen, stateReady), result.ConnectLatencyMs, result.QueryLatencyMs)
+			break
+		}
+		lastErr = err
+		report.State, report.ClusterStatus = readinessState(ctx, cfg.timeout, err, status)
+		if report.ClusterStatus != "" {
+			fmt.Fprintf(out, "%s %s (cluster status %s) %v\n", timestamp, colorize(readinessColor(report.State), report.State), report.ClusterStatus, err)
+		} else {
+			fmt.Fprintf(out, "%s %s %v\n", timestamp, colorize(readinessColor(report.State), report.State), err)
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	report.waited = time.Since(started)
+	report.WaitedSeconds = report.waited.Seconds()
+
+	switch {
+	case report.Ready:
+		report.ExitCode = exitOK
+	case rootCtx.Err() != nil:
+		report.ExitCode = exitInterrupted
+	case report.State == stateProvisioning:
+		report.ExitCode = exitProvisioning
+	default:
+		report.ExitCode = exitCodeOf(lastErr)
+	}
+	if lastErr != nil {
+		report.Error = lastErr.Error()
+		report.ErrorCategory = string(classifyError(lastErr))
+		slog.Error("cluster not ready", "state", report.State, "error", lastErr, "exit_code", report.ExitCode)
+	}
+
+	return writeReport(stdout, out, report, jsonOutput, report.ExitCode)
+}
+
+// readinessState decides whether a failed probe means the cluster is still
+// being created or is down. The control plane's status, when it can be
+// read within timeout, is taken at its word. Without it, a cluster endpoint
+// whose name doesn't resolve yet counts as provisioning, since a new
+// cluster's DNS record is published a while after CreateCluster returns;
+// every other failure counts as down.
+func readinessState(ctx context.Context, timeout time.Duration, err error, status clusterStatusFunc) (state, clusterStatus string) {
+	if status != nil {
+		statusCtx, cancel := context.WithTimeout(ctx, timeout)
+		current, statusErr := status(statusCtx)
+		cancel()
+		if statusErr == nil {
+			if dsqltest.IsProvisioningStatus(current) {
+				return stateProvisioning, current
+			}
+			return stateDown, current
+		}
+		slog.Debug("cluster status unavailable, judging readiness by the connect error", "error", statusErr)
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound && dsqltest.ClusterIDFromHostname(dnsErr.Name) != "" {
+		return stateProvisioning, ""
+	}
+	return stateDown, ""
+}
+
+// readinessColor is green once ready, yellow while provisioning and red
+// when down.
+func readinessColor(state string) string {
+	switch state {
+	case stateReady:
+		return colorGreen
+	case stateProvisioning:
+		return colorYellow
+	default:
+		return colorRed
+	}
+}
+
+// writeText prints the final state and how long the wait took.
+func (r *readinessReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nReadiness:")
+	fmt.Fprintln(w, "==========")
+	switch {
+	case r.Ready:
+		fmt.Fprintf(w, "%s after %s (%d probes)\n", colorize(colorGreen, stateReady), r.waited.Round(time.Millisecond), r.Probes)
+	case r.State == "":
+		fmt.Fprintf(w, "Stopped after %s before any probe finished\n", r.waited.Round(time.Second))
+	default:
+		fmt.Fprintf(w, "%s: not ready after %s (%d probes)\n", colorize(readinessColor(r.State), r.State), r.waited.Round(time.Second), r.Probes)
+		if r.ClusterStatus != "" {
+			fmt.Fprintf(w, "Cluster status: %s\n", r.ClusterStatus)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// sqlStateReadOnly is read_only_sql_transaction, returned for a write in a
+// read-only transaction.
+const sqlStateReadOnly = "25006"
+
+// readOnlyCheck verifies a --read-only session: the server reports it as
+// read-only, reads work, and a write is rejected with SQLSTATE 25006.
+var readOnlyCheck = check{name: "read-only", run: runReadOnlyCheck}
+
+func runReadOnlyCheck(ctx context.Context, s *session, r *checkResult) error {
+	var readOnly string
+	err := s.conn.QueryRow(ctx, "SELECT current_setting('transaction_read_only')").Scan(&readOnly)
+	if err == nil && readOnly != "on" {
+		err = fmt.Errorf("transaction_read_only is %q, want \"on\"", readOnly)
+	}
+	if err := r.step("session is read-only", err); err != nil {
+		return err
+	}
+
+	var one int
+	if err := r.step("read query", s.conn.QueryRow(ctx, "SELECT 1").Scan(&one)); err != nil {
+		return err
+	}
+
+	// Creating a table is the simplest write that needs no existing object
+	table := pgx.Identifier{newTestTableName("readonly")}.Sanitize()
+	err = execStmt(ctx, s.conn, "CREATE TABLE "+table+" (id int PRIMARY KEY)")
+	code := sqlState(err)
+	r.detail("write_rejected", err != nil)
+	if code != "" {
+		r.detail("sqlstate", code)
+	}
+
+	var rejected error
+	switch {
+	case err == nil:
+		dropTestObject(s.conn, "TABLE "+table)
+		rejected = errors.New("write succeeded in a read-only session")
+	case code != sqlStateReadOnly:
+		rejected = fmt.Errorf("write failed with %v, want SQLSTATE %s", err, sqlStateReadOnly)
+	}
+	return r.step("write rejected", rejected)
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// reconnectingConn holds one long-lived connection and replaces it when it
+// fails at the connection level, as it does when DSQL closes it at its
+// 60-minute cap. Each new connection goes through newConnConfig, so the SNI
+// override is re-applied and the IAM token refreshed. It is safe for
+// concurrent use, but calls are serialized on the single connection.
+type reconnectingConn struct {
+	cfg testConfig
+
+	mu         sync.Mutex
+	conn       *pgx.Conn
+	reconnects int
+	forced     int   // reconnects caused by a connection error
+	failures   int   // connection errors since fn last succeeded, for backoff
+	dropped    error // why conn was discarded, reported when it's replaced
+	lifetime   *connLifetime
+}
+
+// newReconnectingConn opens the initial connection.
+func newReconnectingConn(ctx context.Context, cfg testConfig) (*reconnectingConn, error) {
+	c := &reconnectingConn{cfg: cfg}
+	if err := c.dial(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// do runs fn on the connection. Every connection-level error is handled
+// the same way, whether it's found before fn runs, raised by fn, or left by
+// a failed reconnect: the connection is discarded, and the next one is
+// dialed after a backoff that grows with each consecutive error, with a
+// freshly signed IAM token. When fn itself hit the error it is run once
+// more on the new connection, unless ctx has ended, in which case the next
+// call reconnects. Errors the server raised for the statement alone are
+// returned with the connection kept. One nearing --max-conn-lifetime is
+// replaced before fn runs, without a backoff. The time spent reconnecting,
+// backoff included, is returned along with the error, so callers can keep
+// it out of fn's latency.
+func (c *reconnectingConn) do(ctx context.Context, fn func(conn *pgx.Conn) error) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var reconnecting time.Duration
+	reconnect := func() error {
+		start := time.Now()
+		defer func() { reconnecting += time.Since(start) }()
+		return c.reconnect(ctx)
+	}
+
+	if c.conn != nil && c.conn.IsClosed() {
+		c.discard(errors.New("connection was found closed"))
+	}
+	if c.conn == nil {
+		if err := reconnect(); err != nil {
+			return reconnecting, err
+		}
+	} else if c.lifetime.isNearing() {
+		c.dropped = errLifetimeNearing
+		if err := reconnect(); err != nil {
+			return reconnecting, err
+		}
+	}
+
+	err := fn(c.conn)
+	if err == nil {
+		c.failures = 0
+		return reconnecting, nil
+	}
+	if !isConnectionError(c.conn, err) {
+		return reconnecting, err
+	}
+	c.discard(err)
+	if ctx.Err() != nil {
+		return reconnecting, fmt.Errorf("%w (connection discarded, the next probe reconnects)", err)
+	}
+	if err := reconnect(); err != nil {
+		return reconnecting, err
+	}
+	if err = fn(c.conn); err == nil {
+		c.failures = 0
+	} else if isConnectionError(c.conn, err) {
+		c.discard(err)
+	}
+	return reconnecting, err
+}
+
+// discard closes the connection after a connection-level error, so the
+// next reconnect backs off and counts as forced. The caller must hold mu.
+func (c *reconnectingConn) discard(cause error) {
+	if c.conn != nil {
+		closeConn(c.conn)
+		c.conn = nil
+	}
+	c.dropped = cause
+	c.failures++
+}
+
+// Reconnects returns how many times the connection has been replaced.
+func (c *reconnectingConn) Reconnects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reconnects
+}
+
+// ForcedReconnects returns how many of the reconnects a connection-level
+// error caused, leaving out those made ahead of --max-conn-lifetime.
+func (c *reconnectingConn) ForcedReconnects() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.forced
+}
+
+// close closes the current connection.
+func (c *reconnectingConn) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		closeConn(c.conn)
+		c.conn = nil
+	}
+	c.lifetime.stop()
+}
+
+// reconnect replaces the connection; c.dropped says why. A failed dial is
+// itself a connection error, so the next call backs off further. The
+// caller must hold mu.
+func (c *reconnectingConn) reconnect(ctx context.Context) error {
+	cause := c.dropped
+	forced := !errors.Is(cause, errLifetimeNearing)
+	if !forced {
+		slog.InfoContext(ctx, "reconnecting ahead of DSQL's connection lifetime limit", "hostaddr", c.cfg.conn.HostAddr, "reconnects", c.reconnects+1)
+	} else {
+		// Back off so a cluster that keeps dropping connections isn't
+		// redialed in a tight loop, and sign a new token in case the old
+		// one was why the connection failed
+		delay := c.cfg.retry.Backoff(c.failures)
+		slog.InfoContext(ctx, "reconnecting after a connection error", "hostaddr", c.cfg.conn.HostAddr,
+			"reconnects", c.reconnects+1, "consecutive_errors", c.failures, "backoff", delay.Round(time.Millisecond).String(), "cause", cause)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return connectFailure(fmt.Errorf("reconnect abandoned during backoff: %w (after %w)", ctx.Err(), cause))
+		}
+		if c.cfg.conn.Tokens != nil {
+			c.cfg.conn.Tokens.Invalidate()
+		}
+	}
+	if c.conn != nil {
+		closeConn(c.conn)
+		c.conn = nil
+	}
+	if err := c.dial(ctx); err != nil {
+		c.discard(fmt.Errorf("reconnect failed: %w", err))
+		return err
+	}
+	c.reconnects++
+	if forced {
+		c.forced++
+	}
+	return nil
+}
+
+// dial opens a new connection with retries. The caller must hold mu, or be
+// constructing c.
+func (c *reconnectingConn) dial(ctx context.Context) error {
+	config, err := newConnConfig(ctx, c.cfg, nil)
+	if err != nil {
+		return configFailure(err)
+	}
+	conn, err := dsqltest.ConnectWithRetry(ctx, config, c.cfg.retry)
+	if err != nil {
+		return connectFailure(err)
+	}
+	c.conn = conn
+	c.dropped = nil
+	c.lifetime.stop()
+	c.lifetime = watchLifetime(c.cfg, c.cfg.conn.HostAddr)
+	return nil
+}
+
+// closedReporter is the part of *pgx.Conn the error classifiers below
+// need, so they can be tested without a server.
+type closedReporter interface {
+	IsClosed() bool
+}
+
+// isConnectionError reports whether err left conn unusable, as opposed to
+// the server rejecting one statement: a server close as isServerClose sees
+// it, including a query interrupted mid-flight, which makes pgx close the
+// connection, or a connection exception (SQLSTATE class 08).
+func isConnectionError(conn closedReporter, err error) bool {
+	if conn.IsClosed() || isServerClose(conn, err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "08")
+}
+
+// isServerClose reports whether err means the server ended the session
+// rather than rejecting a statement: pgx marks the connection closed, the
+// server sent a FATAL error (such as 57P01 admin_shutdown), or the socket
+// hit EOF.
+func isServerClose(conn closedReporter, err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if conn.IsClosed() {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Severity == "FATAL" || pgErr.Code == "57P01"
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeConn reports a fixed connection state.
+type fakeConn bool
+
+func (c fakeConn) IsClosed() bool { return bool(c) }
+
+func TestConnectionErrorClassification(t *testing.T) {
+	tests := []struct {
+		name        string
+		closed      bool
+		err         error
+		serverClose bool
+		connError   bool
+	}{
+		{name: "canceled", err: context.Canceled},
+		{name: "deadline", err: fmt.Errorf("query: %w", context.DeadlineExceeded)},
+		// pgx closes a connection whose query was interrupted mid-flight
+		{name: "canceled on a closed conn", closed: true, err: context.Canceled, connError: true},
+		{name: "closed conn", closed: true, err: errors.New("conn closed"), serverClose: true, connError: true},
+		{name: "fatal", err: &pgconn.PgError{Severity: "FATAL", Code: "XX000"}, serverClose: true, connError: true},
+		{name: "admin shutdown", err: &pgconn.PgError{Severity: "ERROR", Code: "57P01"}, serverClose: true, connError: true},
+		{name: "connection exception", err: &pgconn.PgError{Severity: "ERROR", Code: "08006"}, connError: true},
+		{name: "eof", err: fmt.Errorf("read: %w", io.EOF), serverClose: true, connError: true},
+		{name: "unexpected eof", err: io.ErrUnexpectedEOF, serverClose: true, connError: true},
+		{name: "statement error", err: &pgconn.PgError{Severity: "ERROR", Code: "42P01"}},
+		{name: "serialization failure", err: &pgconn.PgError{Severity: "ERROR", Code: "40001"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := fakeConn(tt.closed)
+			if got := isServerClose(conn, tt.err); got != tt.serverClose {
+				t.Errorf("isServerClose = %t, want %t", got, tt.serverClose)
+			}
+			if got := isConnectionError(conn, tt.err); got != tt.connError {
+				t.Errorf("isConnectionError = %t, want %t", got, tt.connError)
+			}
+		})
+	}
+}
+
+// TestQueryReusedReconnectLatency checks that a --reuse-conn probe which
+// had to reconnect reports the reconnect as its connect latency.
+func TestQueryReusedReconnectLatency(t *testing.T) {
+	server := startFakeServer(t, "127.0.0.1")
+	cfg := testConfig{conn: server.config(), timeout: 5 * time.Second, retry: dsqltest.RetryPolicy{MaxAttempts: 1}}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+	rc, err := newReconnectingConn(ctx, cfg)
+	if err != nil {
+		t.Fatalf("newReconnectingConn: %v", err)
+	}
+	defer rc.close()
+
+	// The fake server rejects the info query, which doesn't matter here
+	result, _ := queryReused(ctx, rc, cfg)
+	if result.ConnectLatencyMs != 0 {
+		t.Errorf("connect latency on the open connection = %.3fms, want 0", result.ConnectLatencyMs)
+	}
+
+	rc.conn.Close(ctx)
+	result, _ = queryReused(ctx, rc, cfg)
+	if rc.Reconnects() != 1 {
+		t.Fatalf("reconnects = %d, want 1", rc.Reconnects())
+	}
+	if result.ConnectLatencyMs <= 0 {
+		t.Errorf("connect latency after a reconnect = %.3fms, want the reconnect's time", result.ConnectLatencyMs)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// How each --reconnect-test connect got its password.
+const (
+	tokenGenerated = "generated"
+	tokenReused    = "reused"
+	tokenPassword  = "password"
+	tokenFailed    = "failed"
+)
+
+// reconnectReport summarizes a --reconnect-test run: the cost of setting up
+// a connection from scratch, repeated.
+type reconnectReport struct {
+	Requested int `json:"requested"`
+	Succeeded int `json:"succeeded"`
+	Failed    int `json:"failed"`
+
+	ConnectLatency  *latencySummary    `json:"connect_latency,omitempty"`
+	TokensGenerated int                `json:"tokens_generated"`
+	TokensReused    int                `json:"tokens_reused"`
+	TokenLatency    *latencySummary    `json:"token_latency,omitempty"`
+	Attempts        []reconnectAttempt `json:"attempts"`
+	Errors          []string           `json:"errors,omitempty"`
+	DurationMs      float64            `json:"duration_ms"`
+	Rate            *rateReport        `json:"rate,omitempty"`
+}
+
+// reconnectAttempt is one connect and close. Token is how the password was
+// obtained: a freshly generated IAM token, the provider's cached one, the
+// static password, or failed when no token could be generated.
+type reconnectAttempt struct {
+	ConnectMs float64 `json:"connect_ms,omitempty"`
+	Token     string  `json:"token"`
+	TokenMs   float64 `json:"token_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+
+	connect, token time.Duration
+}
+
+// runReconnectTest connects and closes n times in a row, without a pool or
+// retries, so every connection pays the full TCP, TLS and authentication
+// setup that a short-lived client would. The connect latency excludes
+// building the config, which is timed separately because that's where an
+// IAM token is generated or taken from the provider's cache. Each connect
+// gets its own --timeout. A failed connect is counted and the run goes on.
+func runReconnectTest(ctx context.Context, cfg testConfig, n int, out io.Writer) (*reconnectReport, error) {
+	fmt.Fprintf(out, "Connecting to %s via %s %d times in a row\n", cfg.conn.Hostname, cfg.conn.Address(), n)
+
+	report := &reconnectReport{Requested: n, Attempts: make([]reconnectAttempt, 0, n)}
+	var connects, tokens []time.Duration
+	seenErrs := make(map[string]bool)
+	var firstErr error
+	start := time.Now()
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		attempt, err := reconnectOnce(ctx, cfg)
+		report.Attempts = append(report.Attempts, attempt)
+		switch attempt.Token {
+		case tokenGenerated:
+			report.TokensGenerated++
+			tokens = append(tokens, attempt.token)
+		case tokenReused:
+			report.TokensReused++
+		}
+		if err != nil {
+			report.Failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			msg := err.Error()
+			if !seenErrs[msg] && len(report.Errors) < maxReportedErrors {
+				seenErrs[msg] = true
+				report.Errors = append(report.Errors, msg)
+			}
+			fmt.Fprintf(out, "  #%d %s %v\n", i+1, okOrFail(false, "FAIL"), err)
+			continue
+		}
+		report.Succeeded++
+		connects = append(connects, attempt.connect)
+		fmt.Fprintf(out, "  #%d %s connect=%.2fms token=%s\n", i+1, okOrFail(true, "OK"), attempt.ConnectMs, attempt.Token)
+	}
+	report.DurationMs = durationMs(time.Since(start))
+	report.ConnectLatency = summarizeLatencies(connects)
+	report.TokenLatency = summarizeLatencies(tokens)
+	report.Rate = cfg.rate.report()
+
+	if firstErr != nil {
+		return report, fmt.Errorf("%d of %d connects failed: %w", report.Failed, len(report.Attempts), firstErr)
+	}
+	return report, nil
+}
+
+// reconnectOnce builds a connection config, connects and closes.
+func reconnectOnce(ctx context.Context, cfg testConfig) (reconnectAttempt, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+
+	var attempt reconnectAttempt
+	var issued time.Time
+	if cfg.conn.Tokens != nil {
+		issued = cfg.conn.Tokens.ExpiresAt()
+	}
+	tokenStart := time.Now()
+	config, err := newConnConfig(ctx, cfg, nil)
+	attempt.token = time.Since(tokenStart)
+	switch {
+	case cfg.conn.Tokens == nil:
+		attempt.Token = tokenPassword
+	case errors.Is(err, dsqltest.ErrAuthToken):
+		attempt.Token = tokenFailed
+	case !cfg.conn.Tokens.ExpiresAt().Equal(issued):
+		// A new expiry means the provider signed a token for this connect
+		attempt.Token = tokenGenerated
+		attempt.TokenMs = durationMs(attempt.token)
+	default:
+		attempt.Token = tokenReused
+	}
+	if err != nil {
+		err = configFailure(err)
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+
+	connectStart := time.Now()
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		err = connectFailure(connectPhaseError(ctx, cfg, fmt.Errorf("failed to connect to database: %w", err)))
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	attempt.connect = time.Since(connectStart)
+	attempt.ConnectMs = durationMs(attempt.connect)
+	closeConn(conn)
+	return attempt, nil
+}
+
+// writeText prints the counts, then the connect and token latency
+// distributions.
+func (r *reconnectReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nReconnect Report:")
+	fmt.Fprintln(w, "=================")
+	fmt.Fprintf(w, "Connects: %d requested, %d OK, %d failed (%.2fms)\n", r.Requested, r.Succeeded, r.Failed, r.DurationMs)
+	if r.ConnectLatency != nil {
+		r.ConnectLatency.writeText(w, "Connect latency")
+	}
+	if r.TokensGenerated+r.TokensReused > 0 {
+		fmt.Fprintf(w, "IAM tokens: %d generated, %d reused from the cache\n", r.TokensGenerated, r.TokensReused)
+	}
+	if r.TokenLatency != nil {
+		r.TokenLatency.writeText(w, "Token generation latency")
+	}
+	if r.Rate != nil {
+		r.Rate.writeText(w)
+	}
+	for _, msg := range r.Errors {
+		fmt.Fprintf(w, "  error: %s\n", msg)
+	}
+}
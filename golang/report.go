@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// Sub-test statuses in a TestReport.
+const (
+	statusPass = "pass"
+	statusFail = "fail"
+	statusSkip = "skip"
+)
+
+// subTest is one row of a TestReport.
+type subTest struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// TestReport aggregates every sub-test selected for a run (preflight,
+// connect, query and each check) into one readiness summary. Sub-tests that
+// couldn't run because an earlier one failed are reported as skipped.
+type TestReport struct {
+	Tests   []subTest `json:"tests"`
+	Passed  int       `json:"passed"`
+	Failed  int       `json:"failed"`
+	Skipped int       `json:"skipped"`
+
+	pending []string  // planned sub-tests not yet recorded, in order
+	mark    time.Time // when the running sub-test started
+}
+
+// newTestReport starts a report for the sub-tests cfg selects.
+func newTestReport(cfg testConfig) *TestReport {
+	var planned []string
+	if cfg.preflight {
+		planned = append(planned, "preflight")
+	}
+	planned = append(planned, "connect", "query")
+	if cfg.latency.set() {
+		planned = append(planned, "latency")
+	}
+	for _, c := range cfg.checks {
+		planned = append(planned, c.name)
+	}
+	return &TestReport{pending: planned, mark: time.Now()}
+}
+
+// record adds the outcome of the planned sub-test name and starts timing
+// the one after it.
+func (r *TestReport) record(name string, ms float64, err error) {
+	t := subTest{Name: name, Status: statusPass, DurationMs: ms}
+	if err != nil {
+		t.Status = statusFail
+		t.Error = err.Error()
+		r.Failed++
+	} else {
+		r.Passed++
+	}
+	r.Tests = append(r.Tests, t)
+	for i, p := range r.pending {
+		if p == name {
+			r.pending = append(r.pending[:i:i], r.pending[i+1:]...)
+			break
+		}
+	}
+	r.mark = time.Now()
+}
+
+// pass records the sub-test that has been running since the last record.
+func (r *TestReport) pass(name string) {
+	r.record(name, durationMs(time.Since(r.mark)), nil)
+}
+
+// finish closes the report when the run ends. If err stopped the run, the
+// sub-test in progress failed with it and the rest are skipped.
+func (r *TestReport) finish(err error) {
+	if err == nil || len(r.pending) == 0 {
+		return
+	}
+	r.record(r.pending[0], durationMs(time.Since(r.mark)), err)
+	r.skipPending()
+}
+
+// skipPending reports every sub-test not yet recorded as skipped.
+func (r *TestReport) skipPending() {
+	for _, name := range r.pending {
+		r.Tests = append(r.Tests, subTest{Name: name, Status: statusSkip})
+		r.Skipped++
+	}
+	r.pending = nil
+}
+
+// writeText prints the report as a table.
+func (r *TestReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nTest Report:")
+	fmt.Fprintln(w, "============")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	// The header is wrapped like the status cells so tabwriter counts the
+	// same escape-sequence width for every row of the column
+	fmt.Fprintf(tw, "TEST\t%s\tDURATION\tERROR\n", colorize(colorDefault, "STATUS"))
+	for _, t := range r.Tests {
+		duration := "-"
+		if t.Status != statusSkip {
+			duration = fmt.Sprintf("%.2fms", t.DurationMs)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, colorize(statusColor(t.Status), t.Status), duration, t.Error)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%d passed, %d failed, %d skipped\n", r.Passed, r.Failed, r.Skipped)
+}
+
+// Verbosity levels of the human-readable report, set with -v and -vv.
+const (
+	verbositySummary = iota // the report table only
+	verbosityDetail         // each sub-test's steps and details
+	verbosityTimings        // step and connect phase timings, every retry
+)
+
+// writeReportDetails prints what each sub-test in result's report found,
+// below the table, at verbosityDetail and above. Only the text report is
+// affected; JSON always carries all of it.
+func writeReportDetails(w io.Writer, result *ConnectionResult, verbosity int) {
+	if verbosity < verbosityDetail || result.Report == nil {
+		return
+	}
+	timings := verbosity >= verbosityTimings
+	fmt.Fprintln(w, "\nSub-test Details:")
+	fmt.Fprintln(w, "=================")
+	for _, t := range result.Report.Tests {
+		if t.Status == statusSkip {
+			continue
+		}
+		fmt.Fprintf(w, "%s:\n", t.Name)
+		// A check's failure is in its steps; the built-in sub-tests have none
+		checkIdx := slices.IndexFunc(result.Checks, func(c checkResult) bool { return c.Name == t.Name })
+		if checkIdx < 0 && t.Error != "" {
+			fmt.Fprintf(w, "  error: %s\n", t.Error)
+		}
+		switch t.Name {
+		case "preflight":
+			for _, p := range result.Preflight {
+				writeStepLine(w, p.Name, p.Success, p.Error, p.DurationMs, timings)
+				if p.Detail != "" {
+					fmt.Fprintf(w, "    %s\n", p.Detail)
+				}
+			}
+		case "connect":
+			if result.ConnectedAddr != "" {
+				fmt.Fprintf(w, "  address: %s\n", result.ConnectedAddr)
+			}
+			if result.TLSVersion != "" {
+				fmt.Fprintf(w, "  tls: %s, %s\n", result.TLSVersion, result.TLSCipher)
+			}
+			if result.BackendPID != 0 {
+				fmt.Fprintf(w, "  backend pid: %d\n", result.BackendPID)
+			}
+			if timings && result.ConnectPhases != nil {
+				fmt.Fprintf(w, "  phases: %s\n", result.ConnectPhases)
+			}
+		case "query":
+			fmt.Fprintf(w, "  exec mode: %s, protocol: %s\n", result.ExecMode, valueOrUnknown(result.QueryProtocol))
+			if timings && result.QuerySamples != nil {
+				result.QuerySamples.writeText(w, "  samples")
+			}
+		case "latency":
+			for _, lt := range result.LatencyThresholds {
+				fmt.Fprintf(w, "  %s: %.2fms, threshold %.2fms\n", lt.Phase, lt.MeasuredMs, lt.ThresholdMs)
+			}
+		default:
+			if checkIdx >= 0 {
+				writeCheckDetails(w, result.Checks[checkIdx], timings)
+			}
+		}
+	}
+	if timings && result.Retries != nil && len(result.Retries.Events) > 0 {
+		fmt.Fprintln(w, "retries:")
+		for i, e := range result.Retries.Events {
+			fmt.Fprintf(w, "  %d. %s after %.2fms: %s\n", i+1, e.Phase, e.DelayMs, valueOrUnknown(e.Error))
+		}
+	}
+}
+
+// writeCheckDetails prints a check's steps, then its details sorted by name.
+func writeCheckDetails(w io.Writer, c checkResult, timings bool) {
+	for _, st := range c.Steps {
+		writeStepLine(w, st.Name, st.Success, st.Error, st.DurationMs, timings)
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Details)) {
+		switch v := c.Details[name].(type) {
+		case float64:
+			fmt.Fprintf(w, "  %s: %.2fms\n", name, v)
+		case detailLister:
+			fmt.Fprintf(w, "  %s:\n", name)
+			for _, line := range v.detailLines() {
+				fmt.Fprintf(w, "    %s\n", line)
+			}
+		default:
+			fmt.Fprintf(w, "  %s: %v\n", name, v)
+		}
+	}
+}
+
+// detailLister is a check detail too long for one line, such as a list,
+// that renders as one line per entry instead.
+type detailLister interface {
+	detailLines() []string
+}
+
+// writeStepLine prints one step as [PASS] or [FAIL], with its duration when
+// timings are shown.
+func writeStepLine(w io.Writer, name string, ok bool, errMsg string, ms float64, timings bool) {
+	status := "[PASS]"
+	if !ok {
+		status = "[FAIL]"
+	}
+	line := fmt.Sprintf("  %s %s", okOrFail(ok, status), name)
+	if timings {
+		line += fmt.Sprintf(" (%.2fms)", ms)
+	}
+	if errMsg != "" {
+		line += ": " + errMsg
+	}
+	fmt.Fprintln(w, line)
+}
+
+// statusColor returns the color of a sub-test status.
+func statusColor(status string) string {
+	switch status {
+	case statusPass:
+		return colorGreen
+	case statusFail:
+		return colorRed
+	default:
+		return colorYellow
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// ConnectionResult is the outcome of a connectivity test, serialized as the
+// single JSON object printed by --format json.
+type ConnectionResult struct {
+	Success       bool    `json:"success"`
+	CorrelationID string  `json:"correlation_id"`
+	Database      string  `json:"database,omitempty"`
+	User          string  `json:"user,omitempty"`
+	ServerVersion string  `json:"server_version,omitempty"`
+	AppName       string  `json:"application_name,omitempty"`
+	Host          string  `json:"host"`
+	Port          int     `json:"port"`
+	ConnectedAddr string  `json:"connected_addr,omitempty"`
+	BackendPID    uint32  `json:"backend_pid,omitempty"`
+	Proxy         string  `json:"proxy,omitempty"`
+	SSLMode       string  `json:"ssl_mode"`
+	SSL           bool    `json:"ssl"`
+	TLSVersion    string  `json:"tls_version,omitempty"`
+	TLSCipher     string  `json:"tls_cipher_suite,omitempty"`
+	ClientCert    string  `json:"client_cert,omitempty"`
+	VerifiedName  string  `json:"tls_verified_name,omitempty"`
+	ExecMode      string  `json:"exec_mode,omitempty"`
+	QueryProtocol string  `json:"query_protocol,omitempty"`
+	SearchPath    string  `json:"search_path,omitempty"`
+	LatencyMs     float64 `json:"latency_ms"`
+
+	// Info query fields the server couldn't provide, left empty above
+	UnavailableFields []string `json:"unavailable_fields,omitempty"`
+
+	ConnectLatencyMs float64         `json:"connect_latency_ms"`
+	ConnectPhases    *connectPhases  `json:"connect_phases,omitempty"`
+	QueryLatencyMs   float64         `json:"query_latency_ms"`
+	QuerySamples     *latencySummary `json:"query_samples,omitempty"`
+	Retries          *retrySummary   `json:"retries,omitempty"`
+
+	// FirstRowMs is how much of the query latency a --query result took to
+	// start arriving, the rest being the time to drain it
+	FirstRowMs      float64         `json:"first_row_ms,omitempty"`
+	FirstRowSamples *latencySummary `json:"first_row_samples,omitempty"`
+
+	Preflight   []preflightStep `json:"preflight,omitempty"`
+	QueryResult *queryResult    `json:"query_result,omitempty"`
+	PoolStats   *poolStats      `json:"pool_stats,omitempty"`
+	PoolPrewarm *prewarmReport  `json:"pool_prewarm,omitempty"`
+	Checks      []checkResult   `json:"checks,omitempty"`
+	Report      *TestReport     `json:"report,omitempty"`
+
+	Error         string         `json:"error,omitempty"`
+	ErrorCategory errorCategory  `json:"error_category,omitempty"`
+	SQLState      string         `json:"sqlstate,omitempty"`
+	PgError       *pgErrorDetail `json:"pg_error,omitempty"`
+	ExitCode      int            `json:"exit_code,omitempty"`
+
+	// AuthError is set when the server rejected the credentials
+	AuthError *authErrorDetail `json:"auth_error,omitempty"`
+
+	// LatencyThresholds holds the --max-connect-latency and
+	// --max-query-latency bounds the latencies were held to
+	LatencyThresholds []latencyThreshold `json:"latency_thresholds,omitempty"`
+
+	samples []latencySample
+}
+
+// setError records a failure and its server error fields, if any.
+func (r *ConnectionResult) setError(err error, code int) {
+	r.Success = false
+	r.Error = err.Error()
+	r.ErrorCategory = classifyError(err)
+	if r.ErrorCategory == "" {
+		r.ErrorCategory = exitCodeCategory(code)
+	}
+	r.ExitCode = code
+	r.PgError = newPGErrorDetail(err)
+	if r.PgError != nil {
+		r.SQLState = r.PgError.Code
+	}
+	r.AuthError = newAuthErrorDetail(err, time.Now())
+}
+
+// writeJSON prints the result as a single indented JSON object.
+func (r *ConnectionResult) writeJSON(w io.Writer) error {
+	return writeJSONReport(w, r)
+}
+
+// infoValue shows an info query field, or that the server couldn't provide
+// it.
+func (r *ConnectionResult) infoValue(name, value string) string {
+	if slices.Contains(r.UnavailableFields, name) {
+		return "(unavailable)"
+	}
+	return value
+}
+
+// writeText prints the human-readable connection information block.
+func (r *ConnectionResult) writeText(w io.Writer, hostname string) {
+	fmt.Fprintln(w, "\nConnection Information:")
+	fmt.Fprintln(w, "======================")
+	fmt.Fprintf(w, "Correlation ID: %s\n", r.CorrelationID)
+	if r.QueryResult == nil {
+		fmt.Fprintf(w, "Database: %s\n", r.infoValue("database", r.Database))
+		fmt.Fprintf(w, "User: %s\n", r.infoValue("user", r.User))
+	}
+	fmt.Fprintf(w, "Host: %s (via tunnel to %s)\n", r.Host, hostname)
+	fmt.Fprintf(w, "Port: %d\n", r.Port)
+	if r.ConnectedAddr != "" {
+		fmt.Fprintf(w, "Connected Address: %s\n", r.ConnectedAddr)
+	}
+	if r.BackendPID != 0 {
+		fmt.Fprintf(w, "Backend PID: %d\n", r.BackendPID)
+	}
+	if r.Proxy != "" {
+		fmt.Fprintf(w, "Proxy: %s\n", r.Proxy)
+	}
+	fmt.Fprintf(w, "SSL Status: %s\n", sslStatus(r.SSL))
+	fmt.Fprintf(w, "TLS Version: %s\n", valueOrUnknown(r.TLSVersion))
+	fmt.Fprintf(w, "TLS Cipher Suite: %s\n", valueOrUnknown(r.TLSCipher))
+	if r.ClientCert != "" {
+		fmt.Fprintf(w, "Client Certificate: %s\n", clientCertStatus(r.ClientCert))
+	}
+	if r.VerifiedName != "" {
+		fmt.Fprintf(w, "Certificate Verified For: %s\n", r.VerifiedName)
+	}
+	if r.QueryResult == nil {
+		fmt.Fprintf(w, "Server Version: %s\n", r.infoValue("server_version", r.ServerVersion))
+		fmt.Fprintf(w, "Application Name: %s\n", r.infoValue("application_name", r.AppName))
+	}
+	if r.SearchPath != "" {
+		fmt.Fprintf(w, "Search Path: %s\n", r.SearchPath)
+	}
+	fmt.Fprintf(w, "Query Exec Mode: %s\n", r.ExecMode)
+	if r.QueryProtocol != "" {
+		fmt.Fprintf(w, "Query Protocol: %s\n", r.QueryProtocol)
+	}
+	fmt.Fprintf(w, "Connect Latency: %.2fms\n", r.ConnectLatencyMs)
+	if r.ConnectPhases != nil {
+		fmt.Fprintf(w, "Connect Phases: %s\n", r.ConnectPhases)
+	}
+	fmt.Fprintf(w, "Query Latency: %.2fms\n", r.QueryLatencyMs)
+	if r.QuerySamples != nil {
+		r.QuerySamples.writeText(w, "Query Latency")
+	}
+	if r.FirstRowMs > 0 {
+		fmt.Fprintf(w, "Time to First Row: %.2fms\n", r.FirstRowMs)
+	}
+	if r.FirstRowSamples != nil {
+		r.FirstRowSamples.writeText(w, "Time to First Row")
+	}
+	writeLatencyThresholds(w, r.LatencyThresholds)
+	if r.Retries != nil {
+		fmt.Fprintf(w, "Retries: %s\n", r.Retries)
+	}
+	if r.PoolStats != nil {
+		r.PoolStats.writeText(w)
+	}
+	if r.PoolPrewarm != nil {
+		fmt.Fprintf(w, "Pool Pre-warm: %s\n", r.PoolPrewarm)
+	}
+	if r.QueryResult != nil {
+		r.QueryResult.writeText(w)
+	}
+}
+
+// clientCertStatus describes a client_cert value for the text report.
+func clientCertStatus(status string) string {
+	switch status {
+	case "not_requested":
+		return "not requested by the server"
+	case "not_accepted":
+		return "not presented (the server asked for a kind this certificate isn't)"
+	default:
+		return status
+	}
+}
+
+// sslStatus describes whether the connection was observed using TLS.
+func sslStatus(ssl bool) string {
+	if ssl {
+		return "SSL connection (verified on the socket)"
+	}
+	return "not using SSL"
+}
+
+// valueOrUnknown substitutes "unknown" for values that couldn't be determined.
+func valueOrUnknown(v string) string {
+	if v == "" {
+		return "unknown"
+	}
+	return v
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+)
+
+// Phases a retry is counted against.
+const (
+	retryPhaseConnect = "connect"
+	retryPhaseToken   = "token"
+	retryPhaseQuery   = "query"
+)
+
+// retryBudget tallies the retries made across a run and the backoff they
+// scheduled, so a cluster that only succeeds after repeated retries can be
+// told apart from a healthy one. It's safe for concurrent use, and a nil
+// budget records nothing.
+type retryBudget struct {
+	mu      sync.Mutex
+	counts  map[string]int
+	backoff time.Duration
+	events  []retryEvent
+}
+
+// retryEvent is one retry, listed in order in the JSON summary and at -vv.
+type retryEvent struct {
+	Phase   string  `json:"phase"`
+	DelayMs float64 `json:"delay_ms"`
+	Error   string  `json:"error,omitempty"`
+}
+
+func newRetryBudget() *retryBudget {
+	return &retryBudget{counts: make(map[string]int)}
+}
+
+// record counts one retry in phase, after a backoff of delay, of an
+// attempt that failed with err.
+func (b *retryBudget) record(phase string, delay time.Duration, err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.counts[phase]++
+	b.backoff += delay
+	e := retryEvent{Phase: phase, DelayMs: durationMs(delay)}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	b.events = append(b.events, e)
+}
+
+// connectHook is the dsqltest.RetryPolicy OnRetry callback counting
+// connect retries.
+func (b *retryBudget) connectHook(_ int, delay time.Duration, err error) {
+	b.record(retryPhaseConnect, delay, err)
+}
+
+// tokenHook is the OnRetry callback of a token provider's retry policy,
+// counting token generations retried after a failure.
+func (b *retryBudget) tokenHook(_ int, delay time.Duration, err error) {
+	b.record(retryPhaseToken, delay, err)
+}
+
+// tokenRetry is the policy a token provider retries failed generations
+// with: up to retries more attempts, counted against the token phase.
+func (b *retryBudget) tokenRetry(retries int) dsqltest.RetryPolicy {
+	return dsqltest.RetryPolicy{MaxAttempts: retries + 1, BaseDelay: dsqltest.DefaultTokenRetryBaseDelay, OnRetry: b.tokenHook}
+}
+
+// awsRetryer returns the SDK's standard retryer, counting each retry the
+// credential chain makes (SSO, STS, instance metadata) against the token
+// phase; signing a token is local and never retried.
+func (b *retryBudget) awsRetryer() aws.Retryer {
+	return &countingRetryer{RetryerV2: retry.NewStandard(), budget: b}
+}
+
+// countingRetryer wraps an SDK retryer, counting every retry the SDK decides
+// to make. Clients such as the instance metadata one wrap the retryer again
+// and compute the delay themselves, so a retry is counted when its quota
+// token is taken and its backoff is the time until the next attempt starts.
+type countingRetryer struct {
+	aws.RetryerV2
+	budget *retryBudget
+
+	mu      sync.Mutex
+	retried time.Time
+	opErr   error // the failure being retried
+}
+
+func (r *countingRetryer) GetRetryToken(ctx context.Context, opErr error) (func(error) error, error) {
+	release, err := r.RetryerV2.GetRetryToken(ctx, opErr)
+	if err == nil {
+		r.mu.Lock()
+		r.retried = time.Now()
+		r.opErr = opErr
+		r.mu.Unlock()
+	}
+	return release, err
+}
+
+func (r *countingRetryer) GetAttemptToken(ctx context.Context) (func(error) error, error) {
+	r.mu.Lock()
+	if !r.retried.IsZero() {
+		r.budget.record(retryPhaseToken, time.Since(r.retried), r.opErr)
+		r.retried, r.opErr = time.Time{}, nil
+	}
+	r.mu.Unlock()
+	return r.RetryerV2.GetAttemptToken(ctx)
+}
+
+// retrySummary is the retries breakdown reported for a run. Query retries
+// are transactions run again after an optimistic concurrency conflict.
+type retrySummary struct {
+	Connect   int     `json:"connect"`
+	Token     int     `json:"token"`
+	Query     int     `json:"query"`
+	Total     int     `json:"total"`
+	BackoffMs float64 `json:"backoff_ms"`
+
+	Events []retryEvent `json:"events,omitempty"`
+}
+
+// summary snapshots the budget, or returns nil for a nil budget.
+func (b *retryBudget) summary() *retrySummary {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &retrySummary{
+		Connect:   b.counts[retryPhaseConnect],
+		Token:     b.counts[retryPhaseToken],
+		Query:     b.counts[retryPhaseQuery],
+		BackoffMs: durationMs(b.backoff),
+		Events:    slices.Clone(b.events),
+	}
+	s.Total = s.Connect + s.Token + s.Query
+	return s
+}
+
+// String formats the breakdown for the text report.
+func (s *retrySummary) String() string {
+	return fmt.Sprintf("connect %d, token %d, query %d (%.2fms in backoff)", s.Connect, s.Token, s.Query, s.BackoffMs)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// defaultReuseIterations is how many queries each --reuse-vs-fresh
+// strategy runs unless --iterations says otherwise.
+const defaultReuseIterations = 20
+
+// reuseFreshReport compares running the same queries on one kept-open
+// connection with running each on a connection of its own.
+type reuseFreshReport struct {
+	Iterations int            `json:"iterations"`
+	Reused     strategyReport `json:"reused"`
+	Fresh      strategyReport `json:"fresh"`
+
+	// OverheadFactor is the fresh total over the reused total, which
+	// includes the reused connection's one connect
+	OverheadFactor float64 `json:"overhead_factor,omitempty"`
+}
+
+// strategyReport is one side of a --reuse-vs-fresh run. ConnectMs is the
+// single connect of the reused strategy; a fresh query's latency includes
+// its own connect.
+type strategyReport struct {
+	Succeeded int             `json:"succeeded"`
+	Failed    int             `json:"failed"`
+	ConnectMs float64         `json:"connect_ms,omitempty"`
+	TotalMs   float64         `json:"total_ms"`
+	Latency   *latencySummary `json:"per_query_latency,omitempty"`
+	Errors    []string        `json:"errors,omitempty"`
+
+	seenErrs map[string]bool
+	firstErr error
+}
+
+// fail counts a failed query, keeping the first few distinct errors.
+func (s *strategyReport) fail(err error) {
+	s.Failed++
+	if s.firstErr == nil {
+		s.firstErr = err
+	}
+	if s.seenErrs == nil {
+		s.seenErrs = make(map[string]bool)
+	}
+	if msg := err.Error(); !s.seenErrs[msg] && len(s.Errors) < maxReportedErrors {
+		s.seenErrs[msg] = true
+		s.Errors = append(s.Errors, msg)
+	}
+}
+
+// runReuseVsFresh runs the info query, or --query, n times on one
+// connection and then n times each on a new connection that is closed
+// afterwards, without retries. A fresh query is timed from building its
+// config, which takes a cached IAM token as a real client would, to the
+// query returning. Every connect and query gets its own --timeout. A
+// failed query is counted and the run goes on.
+func runReuseVsFresh(ctx context.Context, cfg testConfig, n int, out io.Writer) (*reuseFreshReport, error) {
+	report := &reuseFreshReport{Iterations: n}
+	query := func(ctx context.Context, conn *pgx.Conn) error {
+		ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+		defer cancel()
+		if cfg.query != "" {
+			return withExitCode(exitQuery, execStmt(ctx, conn, cfg.query))
+		}
+		_, err := dsqltest.QueryConnectionInfo(ctx, cfg.infoQuerier(conn))
+		return withExitCode(exitQuery, err)
+	}
+
+	fmt.Fprintf(out, "Running %d queries on one connection to %s\n", n, cfg.conn.Hostname)
+	reused := &report.Reused
+	start := time.Now()
+	conn, err := reuseConnect(ctx, cfg)
+	if err != nil {
+		// Without a connection there's nothing to compare against
+		reused.fail(err)
+		return report, fmt.Errorf("failed to open the reused connection: %w", err)
+	}
+	reused.ConnectMs = durationMs(time.Since(start))
+	var latencies []time.Duration
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		queryStart := time.Now()
+		if err := query(ctx, conn); err != nil {
+			reused.fail(err)
+			fmt.Fprintf(out, "  #%d %s %v\n", i+1, okOrFail(false, "FAIL"), err)
+			continue
+		}
+		latencies = append(latencies, time.Since(queryStart))
+		reused.Succeeded++
+	}
+	reused.TotalMs = durationMs(time.Since(start))
+	reused.Latency = summarizeLatencies(latencies)
+	closeConn(conn)
+
+	fmt.Fprintf(out, "Running %d queries on a fresh connection each\n", n)
+	fresh := &report.Fresh
+	latencies = nil
+	start = time.Now()
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		queryStart := time.Now()
+		conn, err := reuseConnect(ctx, cfg)
+		if err == nil {
+			err = query(ctx, conn)
+			elapsed := time.Since(queryStart)
+			closeConn(conn)
+			if err == nil {
+				latencies = append(latencies, elapsed)
+				fresh.Succeeded++
+				continue
+			}
+		}
+		fresh.fail(err)
+		fmt.Fprintf(out, "  #%d %s %v\n", i+1, okOrFail(false, "FAIL"), err)
+	}
+	fresh.TotalMs = durationMs(time.Since(start))
+	fresh.Latency = summarizeLatencies(latencies)
+
+	// Totals only compare when both strategies ran every query
+	if reused.Failed == 0 && fresh.Failed == 0 && reused.TotalMs > 0 {
+		report.OverheadFactor = fresh.TotalMs / reused.TotalMs
+	}
+	if failed := reused.Failed + fresh.Failed; failed > 0 {
+		total := reused.Succeeded + fresh.Succeeded + failed
+		return report, fmt.Errorf("%d of %d queries failed: %w", failed, total, cmp.Or(reused.firstErr, fresh.firstErr))
+	}
+	return report, nil
+}
+
+// reuseConnect opens one connection for --reuse-vs-fresh, with its own
+// --timeout and no retries, so a fresh query pays exactly one connect.
+func reuseConnect(ctx context.Context, cfg testConfig) (*pgx.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	config, err := newConnConfig(ctx, cfg, nil)
+	if err != nil {
+		return nil, configFailure(err)
+	}
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, connectFailure(connectPhaseError(ctx, cfg, fmt.Errorf("failed to connect to database: %w", err)))
+	}
+	return conn, nil
+}
+
+// writeText prints both strategies side by side and how much longer
+// reconnecting per query took.
+func (r *reuseFreshReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nReuse vs Fresh Report:")
+	fmt.Fprintln(w, "======================")
+	for _, s := range []struct {
+		name string
+		r    strategyReport
+	}{{"Reused connection", r.Reused}, {"Fresh connections", r.Fresh}} {
+		fmt.Fprintf(w, "%s: %d OK, %d failed, %.2fms total", s.name, s.r.Succeeded, s.r.Failed, s.r.TotalMs)
+		if s.r.ConnectMs > 0 {
+			fmt.Fprintf(w, " (including a %.2fms connect)", s.r.ConnectMs)
+		}
+		fmt.Fprintln(w)
+		if s.r.Latency != nil {
+			s.r.Latency.writeText(w, "  Per query")
+		}
+		for _, msg := range s.r.Errors {
+			fmt.Fprintf(w, "  error: %s\n", msg)
+		}
+	}
+	if r.OverheadFactor > 0 {
+		fmt.Fprintf(w, "Reconnecting per query took %.1fx as long as reusing one connection\n", r.OverheadFactor)
+	}
+}
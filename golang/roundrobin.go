@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// roundRobin spreads connections across the --hostaddr list for
+// --round-robin. Each new connection dials the next address in turn, with
+// none of the others as fallbacks, so every address takes an even share and
+// a broken one shows up in its failure count instead of being skipped. A nil
+// roundRobin leaves pgx's in-order failover alone.
+type roundRobin struct {
+	next atomic.Uint64
+
+	mu      sync.Mutex
+	order   []string // addresses in --hostaddr order, for the report
+	tallies map[string]*addressTally
+}
+
+// addressTally counts the connects made to one address.
+type addressTally struct {
+	attempts  int
+	latencies []time.Duration // of the attempts that connected
+}
+
+// addressStats is one address's share of the connects in a summary.
+// Attempts that didn't finish connecting, whatever the reason, are failed.
+type addressStats struct {
+	Address        string          `json:"address"`
+	Attempts       int             `json:"attempts"`
+	Connected      int             `json:"connected"`
+	Failed         int             `json:"failed"`
+	ConnectLatency *latencySummary `json:"connect_latency,omitempty"`
+}
+
+// newRoundRobin returns a rotation, or nil when enabled is false.
+func newRoundRobin(enabled bool) *roundRobin {
+	if !enabled {
+		return nil
+	}
+	return &roundRobin{tallies: make(map[string]*addressTally)}
+}
+
+// apply points config, built for the whole --hostaddr list, at the next
+// address alone and times each connection made through it. config must
+// serve a single connection, including the retries ConnectWithRetry makes,
+// which count as further attempts against the same address. The chosen
+// address's own TLS config replaces config's, so a tlsObserver has to be
+// attached to every address's with attachConfig beforehand.
+func (r *roundRobin) apply(config *pgconn.Config) {
+	if r == nil {
+		return
+	}
+	hosts := append([]*pgconn.FallbackConfig{{Host: config.Host, Port: config.Port, TLSConfig: config.TLSConfig}}, config.Fallbacks...)
+	r.mu.Lock()
+	if r.order == nil {
+		for _, h := range hosts {
+			r.order = append(r.order, h.Host)
+			r.tallies[h.Host] = &addressTally{}
+		}
+	}
+	r.mu.Unlock()
+
+	host := hosts[(r.next.Add(1)-1)%uint64(len(hosts))]
+	config.Host, config.Port, config.TLSConfig = host.Host, host.Port, host.TLSConfig
+	config.Fallbacks = nil
+
+	// Cancel requests for the connection are dialed through the same
+	// config, so only dials before it's established count as attempts
+	var dialed time.Time
+	var established atomic.Bool
+	dial := config.DialFunc
+	config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !established.Load() {
+			dialed = time.Now()
+			r.attempt(host.Host)
+		}
+		return dial(ctx, network, addr)
+	}
+	afterConnect := config.AfterConnect
+	config.AfterConnect = func(ctx context.Context, conn *pgconn.PgConn) error {
+		if afterConnect != nil {
+			if err := afterConnect(ctx, conn); err != nil {
+				return err
+			}
+		}
+		established.Store(true)
+		r.connected(host.Host, time.Since(dialed))
+		return nil
+	}
+}
+
+// attempt counts a dial of addr.
+func (r *roundRobin) attempt(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tallies[addr].attempts++
+}
+
+// connected records a connect to addr that took latency from its dial.
+func (r *roundRobin) connected(addr string, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t := r.tallies[addr]
+	t.latencies = append(t.latencies, latency)
+}
+
+// report returns the connects made to each address, in --hostaddr order,
+// or nil for a nil roundRobin.
+func (r *roundRobin) report() []addressStats {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make([]addressStats, 0, len(r.order))
+	for _, addr := range r.order {
+		t := r.tallies[addr]
+		stats = append(stats, addressStats{
+			Address:        addr,
+			Attempts:       t.attempts,
+			Connected:      len(t.latencies),
+			Failed:         t.attempts - len(t.latencies),
+			ConnectLatency: summarizeLatencies(t.latencies),
+		})
+	}
+	return stats
+}
+
+// writeAddressStats prints one line per address of a round-robin report.
+func writeAddressStats(w io.Writer, stats []addressStats) {
+	if len(stats) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Round robin:")
+	for _, s := range stats {
+		line := fmt.Sprintf("  %s: %d attempts, %d connected, %d failed", s.Address, s.Attempts, s.Connected, s.Failed)
+		if l := s.ConnectLatency; l != nil {
+			line += fmt.Sprintf(" (connect min %.2fms, mean %.2fms, p95 %.2fms, max %.2fms)", l.MinMs, l.MeanMs, l.P95Ms, l.MaxMs)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// testTablePrefix marks tables created by this tool so leftovers are easy
+// to identify.
+const testTablePrefix = "dsql_conntest_"
+
+// roundTripCheck creates a uniquely named table, writes a known row, reads
+// it back, verifies it and drops the table.
+var roundTripCheck = check{name: "roundtrip", run: runRoundTrip}
+
+func runRoundTrip(ctx context.Context, s *session, r *checkResult) (err error) {
+	table := pgx.Identifier{newTestTableName("roundtrip")}.Sanitize()
+	id := newUUID()
+	note := "dsql connectivity roundtrip"
+	// Postgres stores microseconds, so compare at that precision
+	createdAt := time.Now().UTC().Truncate(time.Microsecond)
+
+	// DSQL doesn't allow DDL and DML in the same transaction, so each
+	// statement runs in its own implicit transaction
+	if err := r.step("create table", execStmt(ctx, s.conn,
+		"CREATE TABLE "+table+" (id uuid PRIMARY KEY, created_at timestamptz NOT NULL, note text NOT NULL)")); err != nil {
+		return err
+	}
+
+	// Drop the table even if a later step fails; the test context may have
+	// expired by then, so use a fresh one
+	defer func() {
+		dropCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if dropErr := r.step("drop table", execStmt(dropCtx, s.conn, "DROP TABLE "+table)); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+
+	// The insert can conflict with the table's creation while it's still
+	// propagating, so it's retried like any DSQL write
+	retries, err := retryOnConflict(ctx, s.cfg.retries, func(ctx context.Context) error {
+		return execStmt(ctx, s.conn, "INSERT INTO "+table+" (id, created_at, note) VALUES ($1, $2, $3)", id, createdAt, note)
+	}, conflictRetries)
+	r.detail("conflict_retries", retries)
+	if err := r.step("insert row", err); err != nil {
+		return err
+	}
+
+	var gotID, gotNote string
+	var gotCreatedAt time.Time
+	err = s.conn.QueryRow(ctx, "SELECT id::text, created_at, note FROM "+table+" WHERE id = $1", id).
+		Scan(&gotID, &gotCreatedAt, &gotNote)
+	if err := r.step("select row", err); err != nil {
+		return err
+	}
+
+	var mismatch error
+	switch {
+	case gotID != id:
+		mismatch = fmt.Errorf("id mismatch: wrote %s, read %s", id, gotID)
+	case !gotCreatedAt.Equal(createdAt):
+		mismatch = fmt.Errorf("created_at mismatch: wrote %s, read %s", createdAt, gotCreatedAt)
+	case gotNote != note:
+		mismatch = fmt.Errorf("note mismatch: wrote %q, read %q", note, gotNote)
+	}
+	return r.step("verify values", mismatch)
+}
+
+// execStmt runs a statement that returns no rows.
+func execStmt(ctx context.Context, conn *pgx.Conn, sql string, args ...any) error {
+	_, err := conn.Exec(ctx, sql, args...)
+	return err
+}
+
+// newTestTableName returns a unique table name carrying testTablePrefix.
+func newTestTableName(kind string) string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		panic(errors.New("crypto/rand failed: " + err.Error()))
+	}
+	return fmt.Sprintf("%s%s_%d_%s", testTablePrefix, kind, time.Now().Unix(), hex.EncodeToString(suffix))
+}
+
+// newUUID returns a random RFC 4122 version 4 UUID string.
+func newUUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(errors.New("crypto/rand failed: " + err.Error()))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// outputSchemaVersion is reported as schema_version in every JSON
+// ConnectionResult. Bump it when a field is renamed, removed or changes
+// type; adding a field is backwards compatible and doesn't need a bump.
+const outputSchemaVersion = 1
+
+// MarshalJSON emits schema_version ahead of the result's own fields.
+func (r ConnectionResult) MarshalJSON() ([]byte, error) {
+	type plain ConnectionResult
+	return json.Marshal(struct {
+		SchemaVersion int `json:"schema_version"`
+		plain
+	}{outputSchemaVersion, plain(r)})
+}
+
+// writeResultSchema prints the JSON Schema of --format json output. It's
+// derived from ConnectionResult and its json tags, so it can't drift from
+// what the encoder writes.
+func writeResultSchema(w io.Writer) error {
+	g := &schemaGen{defs: make(map[string]any)}
+	root := g.structSchema(reflect.TypeFor[ConnectionResult]())
+	root["properties"].(map[string]any)["schema_version"] = map[string]any{"const": outputSchemaVersion}
+	root["required"] = append([]string{"schema_version"}, root["required"].([]string)...)
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "DSQL connectivity test result"
+	root["$defs"] = g.defs
+
+	return writeJSONReport(w, root)
+}
+
+// schemaGen builds JSON Schema fragments from Go types, collecting named
+// structs under $defs so each is described once.
+type schemaGen struct {
+	defs map[string]any
+}
+
+// structSchema describes t's JSON-visible fields. Fields without omitempty
+// are required, and those that can encode as null allow it.
+func (g *schemaGen) structSchema(t reflect.Type) map[string]any {
+	props := make(map[string]any)
+	required := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+
+		s := g.typeSchema(f.Type)
+		if strings.Contains(opts, "omitempty") {
+			props[name] = s
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.Pointer, reflect.Slice, reflect.Map, reflect.Interface:
+			s = map[string]any{"anyOf": []any{s, map[string]any{"type": "null"}}}
+		}
+		props[name] = s
+		required = append(required, name)
+	}
+	return map[string]any{"type": "object", "properties": props, "required": required}
+}
+
+// typeSchema describes one Go type.
+func (g *schemaGen) typeSchema(t reflect.Type) map[string]any {
+	if t == reflect.TypeFor[errorCategory]() {
+		return map[string]any{"type": "string", "enum": errorCategories}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.typeSchema(t.Elem())
+	case reflect.Struct:
+		if t == reflect.TypeFor[time.Time]() {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		if _, ok := g.defs[t.Name()]; !ok {
+			g.defs[t.Name()] = nil // placeholder, in case the type refers to itself
+			g.defs[t.Name()] = g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		// Interfaces such as query result cells can hold any JSON value
+		return map[string]any{}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// TestResultSchemaUpToDate fails when result.schema.json no longer matches
+// what --json-schema prints, i.e. when ConnectionResult changed without
+// regenerating it with: go run . --json-schema > result.schema.json
+func TestResultSchemaUpToDate(t *testing.T) {
+	committed, err := os.ReadFile("result.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generated bytes.Buffer
+	if err := writeResultSchema(&generated); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(committed, generated.Bytes()) {
+		t.Fatal("result.schema.json is out of date: regenerate it with go run . --json-schema > result.schema.json")
+	}
+}
+
+func TestResultMatchesSchema(t *testing.T) {
+	schema := loadResultSchema(t)
+	for name, result := range map[string]*ConnectionResult{
+		"success": sampleSuccessResult(),
+		"failure": sampleFailureResult(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := result.writeJSON(&buf); err != nil {
+				t.Fatal(err)
+			}
+			var doc any
+			if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range validateSchema(schema, schema, doc, "$") {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+// TestSchemaValidatorRejectsDrift makes sure the validator itself catches a
+// renamed field, a wrong type and a missing required field.
+func TestSchemaValidatorRejectsDrift(t *testing.T) {
+	schema := loadResultSchema(t)
+	var buf bytes.Buffer
+	if err := sampleSuccessResult().writeJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for name, mutate := range map[string]func(doc map[string]any){
+		"renamed field":    func(doc map[string]any) { doc["latency"] = doc["latency_ms"]; delete(doc, "latency_ms") },
+		"wrong type":       func(doc map[string]any) { doc["port"] = "5432" },
+		"missing required": func(doc map[string]any) { delete(doc, "schema_version") },
+		"bad enum":         func(doc map[string]any) { doc["error_category"] = "NetworkError" },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var doc map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+				t.Fatal(err)
+			}
+			mutate(doc)
+			if problems := validateSchema(schema, schema, doc, "$"); len(problems) == 0 {
+				t.Error("the altered result still validated")
+			}
+		})
+	}
+}
+
+// loadResultSchema reads the committed schema.
+func loadResultSchema(t *testing.T) map[string]any {
+	t.Helper()
+	data, err := os.ReadFile("result.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("result.schema.json: %v", err)
+	}
+	return schema
+}
+
+// sampleSuccessResult is a passing run with most optional sections filled
+// in.
+func sampleSuccessResult() *ConnectionResult {
+	report := &TestReport{}
+	report.record("connect", 161.2, nil)
+	report.record("query", 20.9, nil)
+	report.record("roundtrip", 88.4, nil)
+	return &ConnectionResult{
+		Success:          true,
+		CorrelationID:    "5f0c6f8e-7d2a-4d4e-9a61-2f3c1b7a9e10",
+		Database:         "postgres",
+		User:             "admin",
+		ServerVersion:    "PostgreSQL 16",
+		AppName:          "dsql-test",
+		Host:             "127.0.0.1",
+		Port:             5432,
+		ConnectedAddr:    "127.0.0.1:5432",
+		BackendPID:       48213,
+		SSLMode:          "verify-full",
+		SSL:              true,
+		TLSVersion:       "TLS 1.3",
+		TLSCipher:        "TLS_AES_128_GCM_SHA256",
+		ExecMode:         "cache (pgx default)",
+		QueryProtocol:    "extended",
+		LatencyMs:        182.1,
+		ConnectLatencyMs: 161.2,
+		ConnectPhases:    &connectPhases{TCPDialMs: 1.1, SSLRequestMs: 0.4, TLSHandshakeMs: 40.2},
+		QueryLatencyMs:   20.9,
+		QuerySamples:     summarizeLatencies([]time.Duration{20 * time.Millisecond, 22 * time.Millisecond}),
+		Retries:          newRetryBudget().summary(),
+		Preflight:        []preflightStep{{Name: "dns", Success: true, Detail: "127.0.0.1"}},
+		Checks: []checkResult{{
+			Name: "roundtrip", Success: true, DurationMs: 88.4,
+			Steps:   []stepResult{{Name: "create table", Success: true, DurationMs: 30.1}},
+			Details: map[string]any{"conflict_retries": 0},
+		}},
+		Report: report,
+	}
+}
+
+// sampleFailureResult is a run whose credentials were rejected, with the
+// later sub-tests skipped.
+func sampleFailureResult() *ConnectionResult {
+	err := connectFailure(&pgconn.PgError{Severity: "FATAL", Code: "28P01", Message: "password authentication failed"})
+	report := &TestReport{pending: []string{"query"}}
+	report.record("connect", 95.3, err)
+	report.skipPending()
+	result := &ConnectionResult{
+		CorrelationID: "5f0c6f8e-7d2a-4d4e-9a61-2f3c1b7a9e10",
+		Host:          "127.0.0.1",
+		Port:          5432,
+		SSLMode:       "require",
+		Report:        report,
+	}
+	result.setError(err, exitCodeOf(err))
+	return result
+}
+
+// validateSchema checks value against the subset of JSON Schema that
+// writeResultSchema emits and returns every mismatch. An object schema with
+// properties rejects any other key, so a field renamed in the output but
+// not in the schema fails. Keywords it doesn't know are reported too,
+// rather than silently ignored.
+func validateSchema(root, schema map[string]any, value any, path string) []string {
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+	for keyword := range schema {
+		switch keyword {
+		case "$ref", "anyOf", "type", "properties", "required", "items", "additionalProperties",
+			"enum", "const", "format", "$schema", "$defs", "title":
+		default:
+			fail("unsupported schema keyword %q", keyword)
+		}
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		name, found := strings.CutPrefix(ref, "#/$defs/")
+		def, _ := root["$defs"].(map[string]any)[name].(map[string]any)
+		if !found || def == nil {
+			fail("unresolvable $ref %q", ref)
+			return problems
+		}
+		return append(problems, validateSchema(root, def, value, path)...)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		for _, alt := range anyOf {
+			if len(validateSchema(root, alt.(map[string]any), value, path)) == 0 {
+				return problems
+			}
+		}
+		fail("matches none of anyOf")
+		return problems
+	}
+	if c, ok := schema["const"]; ok && value != c {
+		fail("want const %v, got %v", c, value)
+	}
+	if enum, ok := schema["enum"].([]any); ok && !slices.Contains(enum, value) {
+		fail("%v is not one of %v", value, enum)
+	}
+	if typ, ok := schema["type"].(string); ok && !hasJSONType(value, typ) {
+		fail("want %s, got %T", typ, value)
+		return problems
+	}
+	if schema["format"] == "date-time" {
+		if s, _ := value.(string); s != "" {
+			if _, err := time.Parse(time.RFC3339Nano, s); err != nil {
+				fail("not a date-time: %v", err)
+			}
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		required, _ := schema["required"].([]any)
+		for _, name := range required {
+			if _, ok := v[name.(string)]; !ok {
+				fail("missing required field %q", name)
+			}
+		}
+		for key, field := range v {
+			if s, ok := props[key].(map[string]any); ok {
+				problems = append(problems, validateSchema(root, s, field, path+"."+key)...)
+			} else if extra, ok := schema["additionalProperties"].(map[string]any); ok {
+				problems = append(problems, validateSchema(root, extra, field, path+"."+key)...)
+			} else if props != nil {
+				fail("field %q is not in the schema", key)
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range v {
+				problems = append(problems, validateSchema(root, items, item, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return problems
+}
+
+// hasJSONType reports whether a value decoded by encoding/json has the
+// JSON Schema type typ.
+func hasJSONType(value any, typ string) bool {
+	switch v := value.(type) {
+	case nil:
+		return typ == "null"
+	case bool:
+		return typ == "boolean"
+	case string:
+		return typ == "string"
+	case float64:
+		return typ == "number" || (typ == "integer" && v == math.Trunc(v))
+	case []any:
+		return typ == "array"
+	case map[string]any:
+		return typ == "object"
+	}
+	return false
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// queryFiles collects repeated --query-file flags in the order given.
+type queryFiles []string
+
+// String lists the files.
+func (q *queryFiles) String() string {
+	return strings.Join(*q, ",")
+}
+
+// Set adds one file.
+func (q *queryFiles) Set(s string) error {
+	if s == "" {
+		return fmt.Errorf("empty file name")
+	}
+	*q = append(*q, s)
+	return nil
+}
+
+// sqlScript is one --query-file split into its statements.
+type sqlScript struct {
+	path       string
+	statements []string
+}
+
+// scriptResult is how far one file of the query-files check got.
+type scriptResult struct {
+	Path       string  `json:"path"`
+	Statements int     `json:"statements"`
+	Executed   int     `json:"executed"`
+	Success    bool    `json:"success"`
+	Skipped    bool    `json:"skipped,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// String summarizes how far the file got.
+func (r scriptResult) String() string {
+	if r.Skipped {
+		return r.Path + ": skipped"
+	}
+	return fmt.Sprintf("%s: %d of %d statements in %.2fms", r.Path, r.Executed, r.Statements, r.DurationMs)
+}
+
+// scriptResults is the files detail of the query-files check.
+type scriptResults []scriptResult
+
+func (rs scriptResults) detailLines() []string {
+	lines := make([]string, len(rs))
+	for i, r := range rs {
+		lines[i] = r.String()
+	}
+	return lines
+}
+
+// loadScripts reads and splits each file, failing on one that is
+// unreadable or holds no statements.
+func loadScripts(paths []string) ([]sqlScript, error) {
+	scripts := make([]sqlScript, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read query file: %w", err)
+		}
+		statements := splitStatements(string(data))
+		if len(statements) == 0 {
+			return nil, fmt.Errorf("query file %s is empty", path)
+		}
+		scripts = append(scripts, sqlScript{path: path, statements: statements})
+	}
+	return scripts, nil
+}
+
+// splitStatements splits sql on the semicolons that end statements. Those
+// inside string literals, quoted identifiers, dollar-quoted bodies and
+// comments are left alone, and statements holding nothing but whitespace and
+// comments are dropped.
+func splitStatements(sql string) []string {
+	var statements []string
+	start := 0
+	content := false // the current statement has more than comments
+	flush := func(end int) {
+		if stmt := strings.TrimSpace(sql[start:end]); content && stmt != "" {
+			statements = append(statements, stmt)
+		}
+		start, content = end+1, false
+	}
+
+	for i := 0; i < len(sql); i++ {
+		switch c := sql[i]; {
+		case c == ';':
+			flush(i)
+		case c == '-' && strings.HasPrefix(sql[i:], "--"):
+			if end := strings.IndexByte(sql[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(sql)
+			}
+		case c == '/' && strings.HasPrefix(sql[i:], "/*"):
+			i = skipBlockComment(sql, i)
+		case c == '\'':
+			// An E'' string takes backslash escapes; a standard one doesn't
+			escapes := i > 0 && (sql[i-1] == 'E' || sql[i-1] == 'e') && (i < 2 || !isIdentByte(sql[i-2]))
+			i = skipQuoted(sql, i, '\'', escapes)
+			content = true
+		case c == '"':
+			i = skipQuoted(sql, i, '"', false)
+			content = true
+		case c == '$':
+			if tag, ok := dollarTag(sql[i:]); ok && (i == 0 || !isIdentByte(sql[i-1])) {
+				if end := strings.Index(sql[i+len(tag):], tag); end >= 0 {
+					i += len(tag) + end + len(tag) - 1
+				} else {
+					i = len(sql)
+				}
+			}
+			content = true
+		case c != ' ' && c != '\t' && c != '\n' && c != '\r':
+			content = true
+		}
+	}
+	if start < len(sql) {
+		flush(len(sql))
+	}
+	return statements
+}
+
+// skipQuoted returns the index of the quote closing the literal that opens
+// at sql[i], or the end of sql if it's unterminated. A doubled quote is an
+// escaped one, as is a backslashed one when escapes is set.
+func skipQuoted(sql string, i int, quote byte, escapes bool) int {
+	for i++; i < len(sql); i++ {
+		switch sql[i] {
+		case '\\':
+			if escapes {
+				i++
+			}
+		case quote:
+			if i+1 < len(sql) && sql[i+1] == quote {
+				i++
+				continue
+			}
+			return i
+		}
+	}
+	return len(sql)
+}
+
+// skipBlockComment returns the index of the slash closing the comment that
+// opens at sql[i]. Block comments nest in Postgres.
+func skipBlockComment(sql string, i int) int {
+	depth := 0
+	for ; i < len(sql)-1; i++ {
+		switch sql[i : i+2] {
+		case "/*":
+			depth++
+			i++
+		case "*/":
+			depth--
+			i++
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(sql)
+}
+
+// dollarTag returns the $tag$ or $$ that s starts with.
+func dollarTag(s string) (string, bool) {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '$' {
+			return s[:i+1], true
+		}
+		// A tag can't start with a digit, so $1 is a parameter
+		if !isIdentByte(c) || i == 1 && c >= '0' && c <= '9' {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// isIdentByte reports whether c can be part of an unquoted identifier. A $
+// right after one belongs to the identifier rather than opening a tag.
+func isIdentByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// queryFilesCheck runs the statements of each --query-file in turn on the
+// test's connection, stopping at the first file with a failing statement
+// unless --continue-on-error is set.
+var queryFilesCheck = check{name: "query-files", run: runQueryFiles}
+
+func runQueryFiles(ctx context.Context, s *session, r *checkResult) error {
+	results := make(scriptResults, 0, len(s.cfg.scripts))
+	failed := 0
+	var firstErr error
+	for _, script := range s.cfg.scripts {
+		res := scriptResult{Path: script.path, Statements: len(script.statements)}
+		// A closed connection can't run the rest even with --continue-on-error
+		if firstErr != nil && (!s.cfg.continueOnError || s.conn.IsClosed()) {
+			res.Skipped = true
+			results = append(results, res)
+			fmt.Fprintf(r.out, "  %s run %s\n", colorize(colorYellow, "[SKIP]"), script.path)
+			continue
+		}
+
+		start := time.Now()
+		var err error
+		for i, stmt := range script.statements {
+			if err = execStmt(ctx, s.conn, stmt); err != nil {
+				err = fmt.Errorf("statement %d: %w", i+1, err)
+				break
+			}
+			res.Executed++
+		}
+		res.DurationMs = durationMs(time.Since(start))
+		res.Success = err == nil
+		if err != nil {
+			res.Error = err.Error()
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		results = append(results, res)
+		r.step("run "+script.path, err)
+		fmt.Fprintf(r.out, "    %d of %d statements in %.2fms\n", res.Executed, res.Statements, res.DurationMs)
+
+		// A failed statement leaves any transaction the file opened aborted,
+		// which would fail every file after it
+		if err != nil && !s.conn.IsClosed() && s.conn.PgConn().TxStatus() != 'I' {
+			execStmt(ctx, s.conn, "ROLLBACK")
+		}
+	}
+
+	if r.Details == nil {
+		r.Details = make(map[string]any)
+	}
+	r.Details["files"] = results
+	if failed > 1 {
+		return fmt.Errorf("%d of %d query files failed, first: %w", failed, len(s.cfg.scripts), firstErr)
+	}
+	return firstErr
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	tests := []struct {
+		name string
+		sql  string
+		want []string
+	}{
+		{name: "terminated", sql: "SELECT 1; SELECT 2;", want: []string{"SELECT 1", "SELECT 2"}},
+		{name: "trailing statement without terminator", sql: "SELECT 1;\nSELECT 2\n", want: []string{"SELECT 1", "SELECT 2"}},
+		{name: "empty statements", sql: ";; SELECT 1;;\n", want: []string{"SELECT 1"}},
+		{name: "semicolon in string", sql: "SELECT 'a;b'; SELECT 2", want: []string{"SELECT 'a;b'", "SELECT 2"}},
+		{name: "doubled quote in string", sql: "SELECT 'it''s;'; SELECT 2", want: []string{"SELECT 'it''s;'", "SELECT 2"}},
+		{name: "backslash escape in E string", sql: `SELECT E'a\';b'; SELECT 2`, want: []string{`SELECT E'a\';b'`, "SELECT 2"}},
+		{name: "backslash in standard string", sql: `SELECT 'a\'; SELECT 2`, want: []string{`SELECT 'a\'`, "SELECT 2"}},
+		{name: "semicolon in quoted identifier", sql: `SELECT 1 AS "a;b"; SELECT 2`, want: []string{`SELECT 1 AS "a;b"`, "SELECT 2"}},
+		{
+			name: "dollar-quoted body",
+			sql:  "CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql; SELECT f()",
+			want: []string{"CREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql", "SELECT f()"},
+		},
+		{
+			name: "tagged dollar quote",
+			sql:  "SELECT $body$ a; $$ b; $body$; SELECT 2",
+			want: []string{"SELECT $body$ a; $$ b; $body$", "SELECT 2"},
+		},
+		{name: "positional parameter", sql: "SELECT $1; SELECT 2", want: []string{"SELECT $1", "SELECT 2"}},
+		{name: "line comment", sql: "SELECT 1; -- not; a statement\nSELECT 2;", want: []string{"SELECT 1", "-- not; a statement\nSELECT 2"}},
+		{name: "block comment", sql: "SELECT /* a; b */ 1; SELECT 2", want: []string{"SELECT /* a; b */ 1", "SELECT 2"}},
+		{name: "nested block comment", sql: "SELECT /* a /* b; */ c; */ 1; SELECT 2", want: []string{"SELECT /* a /* b; */ c; */ 1", "SELECT 2"}},
+		{name: "only comments", sql: "-- setup;\n/* nothing; here */\n", want: nil},
+		{name: "unterminated string", sql: "SELECT 'a; SELECT 2", want: []string{"SELECT 'a; SELECT 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitStatements(tt.sql); !slices.Equal(got, tt.want) {
+				t.Errorf("splitStatements(%q) = %q, want %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// searchPathEntries splits a search_path into its schemas, so the
+// "myschema,public" given on the command line and a server's normalized
+// "myschema, public" compare equal.
+func searchPathEntries(path string) []string {
+	entries := strings.Split(path, ",")
+	for i, e := range entries {
+		entries[i] = strings.TrimSpace(e)
+	}
+	return entries
+}
+
+// verifySearchPath reads the session's search_path back with SHOW and
+// returns it, failing if it isn't the one --search-path sent. A server that
+// ignores or rewrites the startup parameter would otherwise leave
+// unqualified names resolving in unexpected schemas.
+func verifySearchPath(ctx context.Context, q dsqltest.RowQuerier, want string) (string, error) {
+	var got string
+	if err := q.QueryRow(ctx, "SHOW search_path").Scan(&got); err != nil {
+		return "", fmt.Errorf("failed to read search_path: %w", err)
+	}
+	if !slices.Equal(searchPathEntries(got), searchPathEntries(want)) {
+		return got, fmt.Errorf("search_path is %q, want %q", got, want)
+	}
+	return got, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// shutdownGrace is how long in-flight work may take to drain and close its
+// connections after the first SIGINT or SIGTERM.
+const shutdownGrace = 10 * time.Second
+
+// errInterrupted is the cancellation cause of the root context once a
+// shutdown signal arrives.
+var errInterrupted = errors.New("interrupted by signal")
+
+// signalContext returns a context cancelled with errInterrupted on the first
+// SIGINT or SIGTERM, so every mode stops work, closes its connections and
+// reports what it has. A second signal, or cleanup outlasting shutdownGrace,
+// exits immediately. The returned stop function releases the handler.
+func signalContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-sigs:
+			slog.Warn("received signal, shutting down", "signal", sig.String(), "grace", shutdownGrace.String())
+			cancel(errInterrupted)
+		case <-done:
+			return
+		}
+
+		timer := time.NewTimer(shutdownGrace)
+		defer timer.Stop()
+		select {
+		case <-sigs:
+			slog.Error("received second signal, exiting without cleanup")
+		case <-timer.C:
+			slog.Error("shutdown grace period expired, exiting without cleanup")
+		case <-done:
+			return
+		}
+		os.Exit(exitInterrupted)
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		close(done)
+		cancel(nil)
+	}
+}
+
+// interruptedError tags err with exitInterrupted when ctx was cancelled by a
+// shutdown signal; other errors are returned unchanged.
+func interruptedError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), errInterrupted) {
+		return withExitCode(exitInterrupted, fmt.Errorf("%w: %w", errInterrupted, err))
+	}
+	return err
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshKeepAliveInterval matches the ServerAliveInterval of the example
+// ssh-config, so an idle tunnel isn't dropped by the bastion or a NAT.
+const sshKeepAliveInterval = 30 * time.Second
+
+// defaultSSHKeys are the private keys tried, when present, if --ssh-key
+// isn't set.
+var defaultSSHKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// sshTunnelOptions configures --ssh-tunnel.
+type sshTunnelOptions struct {
+	dest       string // user@bastion[:port]
+	keyFile    string // private key, in place of the defaults
+	knownHosts string // known_hosts file the bastion's host key must be in
+	remote     string // host:port the bastion forwards to
+}
+
+// sshTunnel forwards a local port through an SSH bastion to the cluster,
+// doing what `ssh -L` would otherwise have to be started for first.
+type sshTunnel struct {
+	client   *ssh.Client
+	listener net.Listener
+	remote   string
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// openSSHTunnel connects to the bastion and starts forwarding connections
+// on an ephemeral 127.0.0.1 port to opts.remote, which the bastion
+// resolves. The bastion's host key must be in opts.knownHosts.
+func openSSHTunnel(ctx context.Context, opts sshTunnelOptions) (*sshTunnel, error) {
+	username, addr, err := parseSSHDest(opts.dest)
+	if err != nil {
+		return nil, withExitCode(exitConfig, err)
+	}
+	hostKeys, err := knownhosts.New(opts.knownHosts)
+	if err != nil {
+		return nil, withExitCode(exitConfig, fmt.Errorf("failed to read SSH known hosts: %w", err))
+	}
+	auth, closeAgent, err := sshAuthMethods(opts.keyFile)
+	if err != nil {
+		return nil, withExitCode(exitConfig, err)
+	}
+	// The agent is only asked to sign during the handshake
+	defer closeAgent()
+
+	start := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, withExitCode(exitConnect, fmt.Errorf("failed to reach SSH bastion %s: %w", addr, err))
+	}
+	// The handshake has no context of its own, so it gets the deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeys,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, withExitCode(exitConnect, fmt.Errorf("SSH handshake with %s failed: %w", addr, err))
+	}
+	conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(sshConn, chans, reqs)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to open local tunnel port: %w", err)
+	}
+	t := &sshTunnel{client: client, listener: listener, remote: opts.remote, done: make(chan struct{})}
+	t.wg.Add(2)
+	go t.serve()
+	go t.keepAlive()
+	slog.Debug("SSH tunnel established", "bastion", addr, "user", username, "local", listener.Addr().String(),
+		"remote", opts.remote, "duration_ms", durationMs(time.Since(start)))
+	return t, nil
+}
+
+// parseSSHDest splits user@host[:port], defaulting to the current user and
+// port 22.
+func parseSSHDest(dest string) (username, addr string, err error) {
+	username, host, ok := strings.Cut(dest, "@")
+	if !ok {
+		host = dest
+		u, err := user.Current()
+		if err != nil {
+			return "", "", fmt.Errorf("--ssh-tunnel %q has no user and the current one is unknown: %w", dest, err)
+		}
+		username = u.Username
+	}
+	if host == "" || username == "" {
+		return "", "", fmt.Errorf("--ssh-tunnel must be user@bastion[:port], got %q", dest)
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	return username, host, nil
+}
+
+// sshAuthMethods offers the keys loaded in ssh-agent, then keyFile or the
+// default keys in ~/.ssh. Passphrase-protected keys only work through the
+// agent. The returned func closes the connection to the agent once the
+// methods are no longer needed.
+func sshAuthMethods(keyFile string) ([]ssh.AuthMethod, func(), error) {
+	var methods []ssh.AuthMethod
+	closeAgent := func() {}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			closeAgent = func() { conn.Close() }
+		} else {
+			slog.Debug("ssh-agent unavailable", "error", err)
+		}
+	}
+
+	paths := []string{keyFile}
+	if keyFile == "" {
+		paths = nil
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, name := range defaultSSHKeys {
+				paths = append(paths, filepath.Join(home, ".ssh", name))
+			}
+		}
+	}
+	var signers []ssh.Signer
+	for _, path := range paths {
+		pem, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) && keyFile == "" {
+			continue
+		}
+		if err != nil {
+			closeAgent()
+			return nil, nil, fmt.Errorf("failed to read SSH key: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		var passErr *ssh.PassphraseMissingError
+		if errors.As(err, &passErr) && keyFile == "" {
+			slog.Debug("skipping passphrase-protected SSH key, add it to ssh-agent instead", "path", path)
+			continue
+		}
+		if err != nil {
+			closeAgent()
+			return nil, nil, fmt.Errorf("failed to parse SSH key %s: %w", path, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if len(methods) == 0 {
+		return nil, nil, errors.New("no SSH credentials: start ssh-agent or pass --ssh-key")
+	}
+	return methods, closeAgent, nil
+}
+
+// addr returns the local address connections should be dialed to.
+func (t *sshTunnel) addr() (string, int) {
+	a := t.listener.Addr().(*net.TCPAddr)
+	return a.IP.String(), a.Port
+}
+
+// serve forwards each local connection until the listener is closed.
+func (t *sshTunnel) serve() {
+	defer t.wg.Done()
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.forward(local)
+		}()
+	}
+}
+
+// forward copies between one local connection and a new channel to the
+// remote address, closing both once either side is done.
+func (t *sshTunnel) forward(local net.Conn) {
+	defer local.Close()
+	remote, err := t.client.Dial("tcp", t.remote)
+	if err != nil {
+		slog.Error("SSH tunnel failed to reach the cluster", "remote", t.remote, "error", err)
+		return
+	}
+	defer remote.Close()
+
+	copied := make(chan struct{}, 2)
+	go func() { io.Copy(remote, local); copied <- struct{}{} }()
+	go func() { io.Copy(local, remote); copied <- struct{}{} }()
+	select {
+	case <-copied:
+	case <-t.done:
+	}
+}
+
+// keepAlive pings the bastion every sshKeepAliveInterval until the tunnel
+// is closed.
+func (t *sshTunnel) keepAlive() {
+	defer t.wg.Done()
+	ticker := time.NewTicker(sshKeepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if _, _, err := t.client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				slog.Warn("SSH tunnel keepalive failed", "error", err)
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// close stops accepting connections, ends the forwarded ones and
+// disconnects from the bastion.
+func (t *sshTunnel) close() {
+	close(t.done)
+	t.listener.Close()
+	t.client.Close()
+	t.wg.Wait()
+	slog.Debug("SSH tunnel closed", "remote", t.remote)
+}
+
+// sshRemote is the address the bastion forwards to: the tunnel address
+// when one was set, otherwise the cluster hostname, on the port in use.
+func sshRemote(hostaddr, hostname string, port int) string {
+	return net.JoinHostPort(firstNonEmpty(hostaddr, hostname), strconv.Itoa(port))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// statsdWriteTimeout bounds a metrics send. A UDP write doesn't wait for
+// the receiver, so this only matters if the local socket buffer is full.
+const statsdWriteTimeout = 50 * time.Millisecond
+
+// statsdClient sends each probe's outcome to --statsd-addr as DogStatsD
+// metrics over UDP. Sends are fire-and-forget: a collector that is down or
+// missing never fails or slows the probe. A nil client sends nothing.
+type statsdClient struct {
+	conn net.Conn
+	tags string // the |# suffix shared by every metric
+}
+
+// newStatsdClient resolves addr once, up front, so a typo is reported
+// before the run rather than a lookup delaying each probe. The metrics are
+// tagged with the cluster and region parsed from hostname, or with the
+// hostname itself when it isn't a cluster endpoint.
+func newStatsdClient(addr, hostname string) (*statsdClient, error) {
+	if addr == "" {
+		return nil, nil
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --statsd-addr: %w", err)
+	}
+	tags := []string{"host:" + hostname}
+	if region := dsqltest.RegionFromHostname(hostname); region != "" {
+		cluster, _, _ := strings.Cut(hostname, ".")
+		tags = []string{"cluster:" + cluster, "region:" + region}
+	}
+	slog.Info("sending StatsD metrics", "addr", conn.RemoteAddr().String())
+	return &statsdClient{conn: conn, tags: "|#" + strings.Join(tags, ",")}, nil
+}
+
+// observe sends one probe outcome: connect and query latency timings and a
+// success count when it succeeded, and a failure count by category when it
+// didn't.
+func (c *statsdClient) observe(result *ConnectionResult, err error) {
+	if c == nil {
+		return
+	}
+	// One datagram, one metric per line, as DogStatsD accepts
+	var lines []string
+	if err != nil {
+		lines = append(lines, "dsql.conntest.failure:1|c"+c.tags+",category:"+failureCategory(exitCodeOf(err)))
+	} else {
+		lines = append(lines,
+			fmt.Sprintf("dsql.conntest.connect.latency:%.3f|ms%s", result.ConnectLatencyMs, c.tags),
+			fmt.Sprintf("dsql.conntest.query.latency:%.3f|ms%s", result.QueryLatencyMs, c.tags),
+			"dsql.conntest.success:1|c"+c.tags)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(statsdWriteTimeout))
+	if _, err := c.conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		slog.Debug("failed to send StatsD metrics", "error", err)
+	}
+}
+
+// close releases the socket.
+func (c *statsdClient) close() {
+	if c != nil {
+		c.conn.Close()
+	}
+}
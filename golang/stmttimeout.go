@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"time"
+)
+
+// statementTimeoutTest is the statement_timeout the timeout check sets,
+// short enough to keep the check fast and long enough to exceed any
+// round trip.
+const statementTimeoutTest = 500 * time.Millisecond
+
+// sqlStateQueryCanceled is query_canceled, returned when statement_timeout
+// cancels a statement.
+const sqlStateQueryCanceled = "57014"
+
+// Slow statements for the timeout check, tried in order. pg_sleep is the
+// obvious choice but may not be available, so a series far too long to
+// count within the timeout backs it up.
+var slowStatements = []struct{ name, sql string }{
+	{"pg_sleep", "SELECT pg_sleep(30)"},
+	{"generate_series", "SELECT count(*) FROM generate_series(1, 10000000000)"},
+}
+
+// statementTimeoutCheck opens a session with a small statement_timeout in
+// its startup parameters, runs a query that can't finish in time and
+// verifies the server cancels it with SQLSTATE 57014.
+var statementTimeoutCheck = check{name: "timeout-test", run: runStatementTimeoutTest}
+
+func runStatementTimeoutTest(ctx context.Context, s *session, r *checkResult) error {
+	timeoutMs := fmt.Sprintf("%dms", statementTimeoutTest.Milliseconds())
+	r.detail("statement_timeout", timeoutMs)
+
+	// The setting goes through RuntimeParams like --set, so it's applied in
+	// the startup message rather than with SET
+	cfg := s.cfg
+	cfg.conn.RuntimeParams = maps.Clone(cfg.conn.RuntimeParams)
+	if cfg.conn.RuntimeParams == nil {
+		cfg.conn.RuntimeParams = make(map[string]string)
+	}
+	cfg.conn.RuntimeParams["statement_timeout"] = timeoutMs
+	conn, err := (&session{cfg: cfg}).connect(ctx)
+	if err := r.step("connect with statement_timeout", err); err != nil {
+		return err
+	}
+	defer closeConn(conn)
+
+	var reported string
+	err = conn.QueryRow(ctx, "SHOW statement_timeout").Scan(&reported)
+	if err == nil && reported != timeoutMs {
+		err = fmt.Errorf("statement_timeout is %q, want %q", reported, timeoutMs)
+	}
+	if err := r.step("session reports statement_timeout", err); err != nil {
+		return err
+	}
+
+	// A statement the server doesn't support fails at once with another
+	// code, so the next one is tried
+	var cancelErr error
+	for _, stmt := range slowStatements {
+		start := time.Now()
+		err = execStmt(ctx, conn, stmt.sql)
+		elapsed := time.Since(start)
+		code := sqlState(err)
+		if err != nil && code != sqlStateQueryCanceled && code != "" && ctx.Err() == nil {
+			r.detail(stmt.name+"_error", code)
+			continue
+		}
+
+		r.detail("slow_statement", stmt.name)
+		r.detail("elapsed_ms", durationMs(elapsed))
+		if code != "" {
+			r.detail("sqlstate", code)
+		}
+		switch {
+		case err == nil:
+			cancelErr = fmt.Errorf("%s finished in %s without being cancelled", stmt.name, elapsed.Round(time.Millisecond))
+		case code != sqlStateQueryCanceled:
+			cancelErr = fmt.Errorf("%s failed with %w, want SQLSTATE %s", stmt.name, err, sqlStateQueryCanceled)
+		}
+		if err := r.step("server cancelled slow statement", cancelErr); err != nil {
+			return err
+		}
+
+		var one int
+		return r.step("session usable after cancel", conn.QueryRow(ctx, "SELECT 1").Scan(&one))
+	}
+	return r.step("server cancelled slow statement", errors.New("no slow statement is supported by the server"))
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"text/template"
+)
+
+// parseResultTemplate parses a --template, which renders the
+// ConnectionResult in place of the text output. Fields are referenced by
+// their Go names, such as {{.Database}} or {{.QueryLatencyMs}}. Only the
+// syntax is checked here: a field that doesn't exist fails when the result
+// is rendered.
+func parseResultTemplate(text string) (*template.Template, error) {
+	return template.New("result").Parse(text)
+}
+
+// writeTemplate renders result through tmpl, ending the output with a
+// newline if the template doesn't, so a one-line summary prints as a line.
+func writeTemplate(w io.Writer, tmpl *template.Template, result *ConnectionResult) error {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, result); err != nil {
+		return err
+	}
+	if s := b.String(); !strings.HasSuffix(s, "\n") {
+		b.WriteByte('\n')
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
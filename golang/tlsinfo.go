@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// tlsObserver records the state of the most recent TLS handshake made with
+// a tls.Config it has been attached to.
+type tlsObserver struct {
+	mu    sync.Mutex
+	state *tls.ConnectionState
+
+	// Whether the server asked for a client certificate, and whether one
+	// it accepts was sent; only tracked when one is configured
+	certRequested bool
+	certSent      bool
+}
+
+// attach hooks the observer into cfg, preserving any existing
+// VerifyConnection callback. A configured client certificate is offered
+// through GetClientCertificate instead, so the observer sees whether the
+// server asked for it.
+func (o *tlsObserver) attach(cfg *tls.Config) {
+	if cfg == nil {
+		return
+	}
+	if certs := cfg.Certificates; len(certs) > 0 && cfg.GetClientCertificate == nil {
+		cfg.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			o.mu.Lock()
+			defer o.mu.Unlock()
+			o.certRequested = true
+			// As crypto/tls would with Certificates: the first the server
+			// accepts, or none
+			for i := range certs {
+				if cri.SupportsCertificate(&certs[i]) == nil {
+					o.certSent = true
+					return &certs[i], nil
+				}
+			}
+			o.certSent = false
+			return &tls.Certificate{}, nil
+		}
+	}
+	prev := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if prev != nil {
+			if err := prev(cs); err != nil {
+				return err
+			}
+		}
+		o.mu.Lock()
+		o.state = &cs
+		o.mu.Unlock()
+		return nil
+	}
+}
+
+// attachConfig attaches the observer to config's TLS config and to those
+// of its fallbacks, which are copies of their own with --no-sni-override.
+func (o *tlsObserver) attachConfig(config *pgconn.Config) {
+	o.attach(config.TLSConfig)
+	for _, fb := range config.Fallbacks {
+		// Otherwise they share the primary's, which is already attached
+		if fb.TLSConfig != config.TLSConfig {
+			o.attach(fb.TLSConfig)
+		}
+	}
+}
+
+// version returns the negotiated TLS version name, or "" if no handshake
+// has completed.
+func (o *tlsObserver) version() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.state == nil {
+		return ""
+	}
+	return tls.VersionName(o.state.Version)
+}
+
+// cipherSuite returns the negotiated cipher suite name, or "" if no
+// handshake has completed.
+func (o *tlsObserver) cipherSuite() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.state == nil {
+		return ""
+	}
+	return tls.CipherSuiteName(o.state.CipherSuite)
+}
+
+// clientCert describes what became of the configured client certificate:
+// presented, not_requested when the server didn't ask for one, or
+// not_accepted when it asked for a kind the certificate isn't. It's "" when
+// no certificate is configured or no handshake has completed.
+func (o *tlsObserver) clientCert(opts dsqltest.Config) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	switch {
+	case opts.SSLCert == "" || o.state == nil:
+		return ""
+	case !o.certRequested:
+		return "not_requested"
+	case !o.certSent:
+		return "not_accepted"
+	default:
+		return "presented"
+	}
+}
+
+// verifiedName returns which of opts.VerifyNames the server certificate
+// was accepted for, or "" when none are set or no handshake has completed.
+func (o *tlsObserver) verifiedName(opts dsqltest.Config) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(opts.VerifyNames) == 0 || o.state == nil || len(o.state.PeerCertificates) == 0 {
+		return ""
+	}
+	name, _ := dsqltest.MatchVerifyName(o.state.PeerCertificates[0], opts.VerifyNames)
+	return name
+}
+
+// serverNameFailure explains a verify-full handshake rejected because the
+// certificate doesn't cover the SNI name; other errors are returned
+// unchanged.
+func serverNameFailure(opts dsqltest.Config, err error) error {
+	if opts.SSLMode == "verify-full" && dsqltest.IsHostnameMismatch(err) {
+		if len(opts.VerifyNames) > 0 {
+			return fmt.Errorf("%w (see --tls-verify-name)", err)
+		}
+		if opts.NoSNIOverride {
+			return fmt.Errorf("server certificate is not valid for the address dialed (--no-sni-override is set): %w", err)
+		}
+		return fmt.Errorf("server certificate is not valid for SNI name %s (see --sni-hostname): %w", opts.ServerName(), err)
+	}
+	return err
+}
+
+// tlsVersionFailure explains a handshake that failed because the server
+// wouldn't meet the --tls-min-version or --tls13-only floor; other errors are
+// returned unchanged.
+func tlsVersionFailure(opts dsqltest.Config, err error) error {
+	if opts.TLSMinVersion > tls.VersionTLS12 && dsqltest.IsTLSVersionError(err) {
+		return fmt.Errorf("server would not negotiate %s or later (see --tls-min-version): %w",
+			tls.VersionName(opts.TLSMinVersion), err)
+	}
+	return err
+}
+
+// errNoTLS reports a connection whose transport turned out to be plaintext.
+// Every accepted sslmode requires TLS, so this would mean the driver
+// silently fell back rather than refusing to connect.
+var errNoTLS = errors.New("connection is not using SSL/TLS")
+
+// requireTLS returns errNoTLS tagged exitConnect unless conn's transport is
+// TLS. The answer comes from the socket pgx actually reads from, not from the
+// configured sslmode.
+func requireTLS(conn *pgx.Conn) error {
+	if _, ok := conn.PgConn().Conn().(*tls.Conn); !ok {
+		return withExitCode(exitConnect, errNoTLS)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+)
+
+// defaultTokenDuration matches the lifetime of the tokens the connectivity
+// test signs for itself.
+const defaultTokenDuration = 15 * time.Minute
+
+// runTokenCommand implements `token`: it signs one IAM auth token for the
+// cluster and prints it to stdout for use by another client, then exits.
+func runTokenCommand(args []string, stdout io.Writer) int {
+	fs := flag.NewFlagSet("token", flag.ContinueOnError)
+	host := fs.String("host", "", "DSQL cluster hostname the token is for (env: HOSTNAME, then PGHOST)")
+	region := fs.String("region", "", "AWS region of the DSQL cluster (default: AWS_REGION or the profile's region)")
+	profile := fs.String("profile", "", "AWS shared config profile to sign with (default: AWS_PROFILE)")
+	assumeRoleARN := fs.String("assume-role-arn", "", "IAM role to assume through STS before signing the token")
+	externalID := fs.String("external-id", "", "External ID required by the --assume-role-arn trust policy")
+	user := fs.String("user", "", "Database user the token is for (env: PGUSER, default admin)")
+	admin := fs.Bool("admin", false, "Sign for the DbConnectAdmin action (default: only when --user is admin)")
+	duration := fs.Duration("duration", defaultTokenDuration, "How long the token stays valid, from 1s up to DSQL's maximum of 1 week")
+	format := fs.String("format", "plain", "Output format: plain (the token alone) or env (an export PGPASSWORD=... line)")
+	timeout := fs.Duration("timeout", defaultTimeout, "Deadline for resolving credentials and signing the token")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s token:\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitConfig
+	}
+
+	logger, err := newLogger(os.Stderr, defaultLogLevel(), "text")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+	slog.SetDefault(logger)
+
+	hostname := firstNonEmpty(*host, os.Getenv("HOSTNAME"), os.Getenv("PGHOST"))
+	useAdmin := firstNonEmpty(*user, os.Getenv("PGUSER"), dsqltest.DefaultUser) == dsqltest.DefaultUser
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "admin" {
+			useAdmin = *admin
+		}
+	})
+
+	var cfgErr error
+	switch {
+	case hostname == "":
+		cfgErr = errors.New("--host, HOSTNAME or PGHOST environment variable is required")
+	case *format != "plain" && *format != "env":
+		cfgErr = fmt.Errorf("unsupported token format %q: use plain or env", *format)
+	case *duration < time.Second || *duration > dsqltest.MaxTokenLifetime:
+		cfgErr = fmt.Errorf("--duration must be between 1s and %s", dsqltest.MaxTokenLifetime)
+	case *timeout <= 0:
+		cfgErr = errors.New("--timeout must be positive")
+	case *externalID != "" && *assumeRoleARN == "":
+		cfgErr = errors.New("--external-id requires --assume-role-arn")
+	}
+	if cfgErr != nil {
+		slog.Error("invalid token options", "error", cfgErr, "exit_code", exitConfig)
+		return exitConfig
+	}
+
+	rootCtx, stopSignals := signalContext()
+	defer stopSignals()
+	ctx, cancel := context.WithTimeout(rootCtx, *timeout)
+	defer cancel()
+
+	awsCfg, err := loadAWSConfig(ctx, *region, *profile, *assumeRoleARN, *externalID, 0, nil)
+	var token string
+	if err == nil {
+		token, err = dsqltest.GenerateAuthTokenFor(ctx, awsCfg, hostname, useAdmin, *duration)
+	}
+	if err != nil {
+		err = interruptedError(rootCtx, withExitCode(exitAuth, err))
+		slog.Error("failed to generate auth token", "error", err, "exit_code", exitCodeOf(err))
+		return exitCodeOf(err)
+	}
+	slog.Debug("generated auth token", "hostname", hostname, "region", awsCfg.Region, "admin", useAdmin, "duration", duration.String())
+
+	if *format == "env" {
+		fmt.Fprintf(stdout, "export PGPASSWORD=%s\n", shellQuote(token))
+		return exitOK
+	}
+	fmt.Fprintln(stdout, token)
+	return exitOK
+}
+
+// shellQuote single-quotes s for a POSIX shell. Tokens are presigned URLs,
+// whose & and = would otherwise be interpreted.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
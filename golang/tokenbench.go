@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// tokenBenchReport summarizes a --token-bench run: what signing an IAM auth
+// token costs on its own, without connecting.
+type tokenBenchReport struct {
+	Requested int    `json:"requested"`
+	Generated int    `json:"generated"`
+	Failed    int    `json:"failed"`
+	Region    string `json:"region"`
+	Action    string `json:"action"`
+
+	// FirstMs is broken out because the first token also resolves the
+	// credentials, which the SDK caches for the ones after it
+	FirstMs     float64         `json:"first_ms,omitempty"`
+	Latency     *latencySummary `json:"latency,omitempty"`
+	WarmLatency *latencySummary `json:"warm_latency,omitempty"`
+	PerSecond   float64         `json:"tokens_per_second"`
+	DurationMs  float64         `json:"duration_ms"`
+	Errors      []string        `json:"errors,omitempty"`
+}
+
+// runTokenBench signs n tokens in a row for hostname and user with awsCfg,
+// the same configuration the connect path signs with, and reports the
+// latency of each. Every token gets its own timeout. A failed token is
+// counted and the run goes on.
+func runTokenBench(ctx context.Context, awsCfg aws.Config, hostname, user string, n int, timeout time.Duration, out io.Writer) (*tokenBenchReport, error) {
+	report := &tokenBenchReport{Requested: n, Region: awsCfg.Region, Action: iamAction(user)}
+	admin := user == dsqltest.DefaultUser
+	fmt.Fprintf(out, "Generating %d IAM auth tokens for %s (%s) without connecting\n", n, hostname, report.Action)
+
+	var latencies []time.Duration
+	seenErrs := make(map[string]bool)
+	var firstErr error
+	start := time.Now()
+	for i := 0; i < n && ctx.Err() == nil; i++ {
+		tokenCtx, cancel := context.WithTimeout(ctx, timeout)
+		tokenStart := time.Now()
+		_, err := dsqltest.GenerateAuthToken(tokenCtx, awsCfg, hostname, admin)
+		elapsed := time.Since(tokenStart)
+		cancel()
+		if err != nil {
+			report.Failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			if msg := err.Error(); !seenErrs[msg] && len(report.Errors) < maxReportedErrors {
+				seenErrs[msg] = true
+				report.Errors = append(report.Errors, msg)
+			}
+			fmt.Fprintf(out, "  #%d %s %v\n", i+1, okOrFail(false, "FAIL"), err)
+			continue
+		}
+		report.Generated++
+		latencies = append(latencies, elapsed)
+		fmt.Fprintf(out, "  #%d %s %.3fms\n", i+1, okOrFail(true, "OK"), durationMs(elapsed))
+	}
+	total := time.Since(start)
+	report.DurationMs = durationMs(total)
+	report.Latency = summarizeLatencies(latencies)
+	if len(latencies) > 0 {
+		report.FirstMs = durationMs(latencies[0])
+		report.WarmLatency = summarizeLatencies(latencies[1:])
+		report.PerSecond = float64(len(latencies)) / total.Seconds()
+	}
+
+	if firstErr != nil {
+		return report, withExitCode(exitAuth, fmt.Errorf("%d of %d tokens failed: %w", report.Failed, report.Generated+report.Failed, firstErr))
+	}
+	return report, nil
+}
+
+// writeText prints the counts and the latency distribution, with the first
+// token, which resolved the credentials, apart from the rest.
+func (r *tokenBenchReport) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nToken Benchmark Report:")
+	fmt.Fprintln(w, "=======================")
+	fmt.Fprintf(w, "Tokens: %d requested, %d generated, %d failed (%.2fms, %.1f/s)\n", r.Requested, r.Generated, r.Failed, r.DurationMs, r.PerSecond)
+	fmt.Fprintf(w, "Signed for: %s in %s\n", r.Action, r.Region)
+	if r.Latency != nil {
+		fmt.Fprintf(w, "First token: %.3fms (includes resolving credentials)\n", r.FirstMs)
+		r.Latency.writeText(w, "Token generation latency")
+	}
+	if r.WarmLatency != nil {
+		r.WarmLatency.writeText(w, "After the first")
+	}
+	for _, msg := range r.Errors {
+		fmt.Fprintf(w, "  error: %s\n", msg)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies this tool's spans.
+const tracerName = "dsql-connectivity-experiment"
+
+// setupTracing installs an OTLP/HTTP trace exporter for endpoint (e.g.
+// http://localhost:4318) and returns a function that flushes it. With no
+// endpoint the global no-op provider stays in place, so spans cost nothing.
+func setupTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	if endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "dsql-connectivity-test"),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// startSpan starts a client span for a phase of the test against cfg's
+// cluster.
+func startSpan(ctx context.Context, name string, cfg testConfig) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.namespace", cfg.conn.Database),
+			attribute.String("db.user", cfg.conn.User),
+			attribute.String("server.address", cfg.conn.Hostname),
+			attribute.Int("server.port", cfg.conn.Port),
+			attribute.String("network.peer.address", cfg.conn.HostAddr),
+			attribute.String("dsql.cluster_id", clusterID(cfg.conn.Hostname)),
+		))
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// clusterID returns the cluster identifier, the first label of a DSQL
+// endpoint such as <id>.dsql.us-east-1.on.aws.
+func clusterID(hostname string) string {
+	id, _, _ := strings.Cut(hostname, ".")
+	return id
+}
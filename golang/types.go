@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// typesCheck writes one row covering common column types and verifies that
+// every value pgx scans back matches what was written.
+var typesCheck = check{name: "types", run: runTypes}
+
+// typeCase is one column of the types table: its SQL type, the value
+// written, a pointer to scan into and a comparison of the scanned value.
+type typeCase struct {
+	sqlType string
+	value   any
+	dest    any
+	verify  func() error
+}
+
+// newTypeCases returns the values written by the types check. Each is
+// chosen to catch a lossy encoding: a 32-bit extreme, a bigint beyond
+// float64 precision, non-ASCII text, microsecond timestamps and a numeric
+// with more digits than a float64 holds.
+func newTypeCases() []typeCase {
+	const (
+		wantInt    int32  = math.MinInt32
+		wantBigint int64  = 1<<62 + 1
+		wantText   string = "dsql ✓ données 数据"
+		wantBool   bool   = true
+	)
+	wantTime := time.Now().UTC().Truncate(time.Microsecond)
+	wantUUID := newUUID()
+	var wantNumeric pgtype.Numeric
+	if err := wantNumeric.Scan("123456789012345678.0123456789"); err != nil {
+		panic(err)
+	}
+	wantJSON := map[string]any{"name": "dsql", "count": 3, "tags": []any{"a", "b"}, "nested": map[string]any{"ok": true}}
+
+	var (
+		gotInt     int32
+		gotBigint  int64
+		gotText    string
+		gotBool    bool
+		gotTime    time.Time
+		gotUUID    pgtype.UUID
+		gotNumeric pgtype.Numeric
+		gotJSON    map[string]any
+	)
+	return []typeCase{
+		{"integer", wantInt, &gotInt, func() error { return compareValues(wantInt, gotInt) }},
+		{"bigint", wantBigint, &gotBigint, func() error { return compareValues(wantBigint, gotBigint) }},
+		{"text", wantText, &gotText, func() error { return compareValues(wantText, gotText) }},
+		{"boolean", wantBool, &gotBool, func() error { return compareValues(wantBool, gotBool) }},
+		{"timestamptz", wantTime, &gotTime, func() error {
+			if !gotTime.Equal(wantTime) {
+				return fmt.Errorf("wrote %s, read %s", wantTime.Format(time.RFC3339Nano), gotTime.Format(time.RFC3339Nano))
+			}
+			return nil
+		}},
+		{"numeric", wantNumeric, &gotNumeric, func() error {
+			want, got := numericRat(wantNumeric), numericRat(gotNumeric)
+			if got == nil || want.Cmp(got) != 0 {
+				w, _ := wantNumeric.MarshalJSON()
+				g, _ := gotNumeric.MarshalJSON()
+				return fmt.Errorf("wrote %s, read %s", w, g)
+			}
+			return nil
+		}},
+		{"uuid", wantUUID, &gotUUID, func() error { return compareValues(wantUUID, gotUUID.String()) }},
+		{"jsonb", wantJSON, &gotJSON, func() error {
+			// Numbers come back as float64, so compare the encoded documents
+			w, _ := json.Marshal(wantJSON)
+			g, err := json.Marshal(gotJSON)
+			if err != nil || !bytes.Equal(w, g) {
+				return fmt.Errorf("wrote %s, read %s", w, g)
+			}
+			return nil
+		}},
+	}
+}
+
+func runTypes(ctx context.Context, s *session, r *checkResult) (err error) {
+	table := pgx.Identifier{newTestTableName("types")}.Sanitize()
+	cases := newTypeCases()
+
+	columns := make([]string, len(cases))
+	defs := []string{"id uuid PRIMARY KEY"}
+	for i, tc := range cases {
+		columns[i] = "c_" + tc.sqlType
+		defs = append(defs, columns[i]+" "+tc.sqlType)
+	}
+
+	if err := r.step("create table", execStmt(ctx, s.conn,
+		"CREATE TABLE "+table+" ("+strings.Join(defs, ", ")+")")); err != nil {
+		// Find out which types are to blame before giving up
+		if rejected := rejectedTypes(ctx, s.conn, cases); len(rejected) > 0 {
+			r.detail("rejected_types", rejected)
+		}
+		return err
+	}
+
+	defer func() {
+		dropCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if dropErr := r.step("drop table", execStmt(dropCtx, s.conn, "DROP TABLE "+table)); dropErr != nil && err == nil {
+			err = dropErr
+		}
+	}()
+
+	id := newUUID()
+	args := []any{id}
+	placeholders := []string{"$1"}
+	dests := make([]any, len(cases))
+	for i, tc := range cases {
+		args = append(args, tc.value)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+2))
+		dests[i] = tc.dest
+	}
+	insert := "INSERT INTO " + table + " (id, " + strings.Join(columns, ", ") + ") VALUES (" + strings.Join(placeholders, ", ") + ")"
+	retries, err := retryOnConflict(ctx, s.cfg.retries, func(ctx context.Context) error {
+		return execStmt(ctx, s.conn, insert, args...)
+	}, conflictRetries)
+	r.detail("conflict_retries", retries)
+	if err := r.step("insert row", err); err != nil {
+		return err
+	}
+
+	if err := r.step("select row", s.conn.QueryRow(ctx,
+		"SELECT "+strings.Join(columns, ", ")+" FROM "+table+" WHERE id = $1", id).Scan(dests...)); err != nil {
+		return err
+	}
+
+	// Verify every type so the output lists all mismatches, not just the first
+	var mismatched []string
+	for _, tc := range cases {
+		if r.step("verify "+tc.sqlType, tc.verify()) != nil {
+			mismatched = append(mismatched, tc.sqlType)
+		}
+	}
+	if len(mismatched) > 0 {
+		r.detail("mismatched_types", mismatched)
+		return fmt.Errorf("values did not round-trip for: %s", strings.Join(mismatched, ", "))
+	}
+	return nil
+}
+
+// rejectedTypes returns the types in cases that the server refuses as
+// column types, by creating a single-column table for each.
+func rejectedTypes(ctx context.Context, conn *pgx.Conn, cases []typeCase) []string {
+	var rejected []string
+	for _, tc := range cases {
+		table := pgx.Identifier{newTestTableName("types")}.Sanitize()
+		_, err := conn.Exec(ctx, "CREATE TABLE "+table+" (id uuid PRIMARY KEY, v "+tc.sqlType+")")
+		if err == nil {
+			dropTestObject(conn, "TABLE "+table)
+			continue
+		}
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			rejected = append(rejected, tc.sqlType)
+		}
+	}
+	return rejected
+}
+
+// compareValues reports a mismatch between wrote and read.
+func compareValues[T comparable](wrote, read T) error {
+	if wrote != read {
+		return fmt.Errorf("wrote %v, read %v", wrote, read)
+	}
+	return nil
+}
+
+// numericRat returns n as an exact rational, or nil if it isn't a finite
+// number.
+func numericRat(n pgtype.Numeric) *big.Rat {
+	if !n.Valid || n.NaN || n.InfinityModifier != pgtype.Finite || n.Int == nil {
+		return nil
+	}
+	rat := new(big.Rat).SetInt(n.Int)
+	exp := int64(n.Exp)
+	scale := new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(max(exp, -exp)), nil))
+	if exp < 0 {
+		return rat.Quo(rat, scale)
+	}
+	return rat.Mul(rat, scale)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// unsupportedStatement is one statement --unsupported-probe expects DSQL to
+// refuse. In sql, {table} is replaced with a scratch table the probe creates
+// with columns id int and note text, and {scratch} with a fresh name nothing
+// uses yet. Unless noTx is set the statement runs in a transaction that is
+// rolled back, so one the server does accept leaves nothing behind.
+type unsupportedStatement struct {
+	name string
+	sql  string
+	noTx bool // Postgres refuses it inside a transaction block
+}
+
+// unsupportedStatements is the checklist run by --unsupported-probe. Add an
+// entry to probe another statement.
+var unsupportedStatements = []unsupportedStatement{
+	{name: "vacuum", sql: "VACUUM {table}", noTx: true},
+	{name: "create_extension", sql: "CREATE EXTENSION pg_trgm"},
+	{name: "truncate", sql: "TRUNCATE {table}"},
+	{name: "alter_table_drop_column", sql: "ALTER TABLE {table} DROP COLUMN note"},
+	{name: "alter_column_type", sql: "ALTER TABLE {table} ALTER COLUMN note TYPE varchar(64)"},
+	{name: "lock_table", sql: "LOCK TABLE {table} IN ACCESS EXCLUSIVE MODE"},
+	{name: "serializable_isolation", sql: "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE"},
+	{name: "plpgsql_function", sql: "CREATE FUNCTION {scratch}() RETURNS int LANGUAGE plpgsql AS $$BEGIN RETURN 1; END$$"},
+	{name: "jsonb_column", sql: "CREATE TABLE {scratch} (id int PRIMARY KEY, doc jsonb)"},
+}
+
+// unsupportedProbeCheck runs each of unsupportedStatements and records
+// whether the server accepted it or, if not, its exact error. Either answer
+// passes: the result is a compatibility checklist, not a verdict. Only
+// errors that aren't server rejections, such as a dropped connection, fail
+// the check.
+var unsupportedProbeCheck = check{name: "unsupported-probe", run: runUnsupportedProbe}
+
+func runUnsupportedProbe(ctx context.Context, s *session, r *checkResult) error {
+	table := pgx.Identifier{newTestTableName("unsupported")}.Sanitize()
+	err := execStmt(ctx, s.conn, "CREATE TABLE "+table+" (id int PRIMARY KEY, note text)")
+	if err := r.step("create scratch table", err); err != nil {
+		return err
+	}
+	defer dropTestObject(s.conn, "TABLE IF EXISTS "+table)
+
+	var accepted []string
+	for _, stmt := range unsupportedStatements {
+		sql := strings.NewReplacer("{table}", table, "{scratch}", pgx.Identifier{newTestTableName("scratch")}.Sanitize()).Replace(stmt.sql)
+		err := runRolledBack(ctx, s.conn, sql, stmt.noTx)
+		var pgErr *pgconn.PgError
+		switch {
+		case err == nil:
+			r.detail(stmt.name, "accepted")
+			accepted = append(accepted, stmt.name)
+		case errors.As(err, &pgErr) && !isConnectionClass(pgErr.Code):
+			r.detail(stmt.name, fmt.Sprintf("rejected (%s: %s)", pgErr.Code, pgErr.Message))
+		default:
+			return r.step("probe "+stmt.name, err)
+		}
+	}
+	if len(accepted) > 0 {
+		r.detail("accepted_statements", accepted)
+	}
+	return nil
+}
+
+// runRolledBack runs sql inside a transaction that is always rolled back,
+// or on its own when noTx is set.
+func runRolledBack(ctx context.Context, conn *pgx.Conn, sql string, noTx bool) error {
+	if noTx {
+		return execStmt(ctx, conn, sql)
+	}
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(context.Background())
+	_, err = tx.Exec(ctx, sql)
+	return err
+}
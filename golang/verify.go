@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// verifyQueryCheck runs --verify-query and passes only if it returns a
+// single true boolean, e.g. SELECT current_user = 'admin'.
+var verifyQueryCheck = check{name: "verify-query", run: runVerifyQuery}
+
+func runVerifyQuery(ctx context.Context, s *session, r *checkResult) error {
+	r.detail("query", s.cfg.verifyQuery)
+	rows, err := s.conn.Query(ctx, s.cfg.verifyQuery)
+	if err != nil {
+		return r.step("run verify query", err)
+	}
+	values, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*bool, error) {
+		fields := row.FieldDescriptions()
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("verify query must return a single boolean column, got %d columns", len(fields))
+		}
+		if oid := fields[0].DataTypeOID; oid != pgtype.BoolOID {
+			return nil, fmt.Errorf("verify query must return a boolean, got column %q of type %s", fields[0].Name, typeName(s.conn.TypeMap(), oid))
+		}
+		var v *bool
+		return v, row.Scan(&v)
+	})
+	if err := r.step("run verify query", err); err != nil {
+		return err
+	}
+
+	var assertErr error
+	switch {
+	case len(values) != 1:
+		assertErr = fmt.Errorf("verify query returned %d rows, want 1", len(values))
+	case values[0] == nil:
+		assertErr = errors.New("verify query returned NULL, want true")
+	case !*values[0]:
+		assertErr = errors.New("verify query returned false")
+	}
+	if len(values) == 1 && values[0] != nil {
+		r.detail("result", *values[0])
+	}
+	return r.step("result is true", assertErr)
+}
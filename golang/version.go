@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+// Build metadata, set with -ldflags, e.g.
+//
+//	-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)
+//
+// Values left unset are filled in from the build info the Go toolchain
+// embeds, which carries the module version for `go install` builds and the
+// VCS revision for builds inside a git checkout.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// pgxModule is the dependency whose version is reported, since behavior
+// against DSQL can differ between pgx releases.
+const pgxModule = "github.com/jackc/pgx/v5"
+
+// versionInfo is the output of --version.
+type versionInfo struct {
+	Version    string `json:"version"`
+	Commit     string `json:"commit"`
+	Modified   bool   `json:"modified,omitempty"`
+	BuildDate  string `json:"build_date"`
+	GoVersion  string `json:"go_version"`
+	PGXVersion string `json:"pgx_version"`
+}
+
+// buildInfo is resolved once, since the application name is computed from
+// it on every run.
+var buildInfo = sync.OnceValue(func() versionInfo {
+	info := versionInfo{
+		Version:    version,
+		Commit:     commit,
+		BuildDate:  buildDate,
+		GoVersion:  runtime.Version(),
+		PGXVersion: "unknown",
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			info.Commit = firstNonEmpty(info.Commit, s.Value)
+		case "vcs.time":
+			info.BuildDate = firstNonEmpty(info.BuildDate, s.Value)
+		case "vcs.modified":
+			info.Modified = s.Value == "true"
+		}
+	}
+	for _, dep := range bi.Deps {
+		if dep.Path == pgxModule {
+			if dep.Replace != nil {
+				dep = dep.Replace
+			}
+			info.PGXVersion = dep.Version
+		}
+	}
+	return info
+})
+
+// writeText prints the build metadata, one field per line.
+func (v versionInfo) writeText(w io.Writer) {
+	fmt.Fprintf(w, "dsql-conn-test %s\n", v.Version)
+	commit := valueOrUnknown(v.Commit)
+	if v.Modified {
+		commit += " (modified)"
+	}
+	fmt.Fprintf(w, "Commit: %s\n", commit)
+	fmt.Fprintf(w, "Build Date: %s\n", valueOrUnknown(v.BuildDate))
+	fmt.Fprintf(w, "Go: %s\n", v.GoVersion)
+	fmt.Fprintf(w, "pgx: %s\n", v.PGXVersion)
+}
+
+// writeJSON prints the build metadata as an indented JSON object.
+func (v versionInfo) writeJSON(w io.Writer) error {
+	return writeJSONReport(w, v)
+}
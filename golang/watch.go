@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"dsql-connectivity-experiment/dsqltest"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// defaultWatchInterval is the delay between probes in --watch mode.
+const defaultWatchInterval = 10 * time.Second
+
+// watchSummary accumulates probe outcomes across a --watch run.
+type watchSummary struct {
+	CorrelationID         string      `json:"correlation_id"`
+	Probes                int         `json:"probes"`
+	Successes             int         `json:"successes"`
+	Failures              int         `json:"failures"`
+	ConsecutiveSuccesses  int         `json:"consecutive_successes"`
+	ConsecutiveFailures   int         `json:"consecutive_failures"`
+	MaxConsecutiveFailure int         `json:"max_consecutive_failures"`
+	UptimePercent         float64     `json:"uptime_percent"`
+	DurationSeconds       float64     `json:"duration_seconds"`
+	Reconnects            int         `json:"reconnects,omitempty"`
+	ForcedReconnects      int         `json:"forced_reconnects,omitempty"`
+	PoolStats             *poolStats  `json:"pool_stats,omitempty"`
+	Rate                  *rateReport `json:"rate,omitempty"`
+	BreakerTrips          int         `json:"breaker_trips,omitempty"`
+	BreakerState          string      `json:"breaker_state,omitempty"`
+
+	// Health is the --until-healthy outcome
+	Health *healthReport `json:"until_healthy,omitempty"`
+
+	RoundRobin []addressStats `json:"round_robin,omitempty"`
+
+	elapsed time.Duration
+}
+
+// record updates the counters with the outcome of one probe.
+func (s *watchSummary) record(ok bool) {
+	s.Probes++
+	if ok {
+		s.Successes++
+		s.ConsecutiveSuccesses++
+		s.ConsecutiveFailures = 0
+	} else {
+		s.Failures++
+		s.ConsecutiveFailures++
+		s.ConsecutiveSuccesses = 0
+		s.MaxConsecutiveFailure = max(s.MaxConsecutiveFailure, s.ConsecutiveFailures)
+	}
+	s.UptimePercent = 100 * float64(s.Successes) / float64(s.Probes)
+}
+
+// writeText prints the final watch summary.
+func (s *watchSummary) writeText(w io.Writer) {
+	fmt.Fprintln(w, "\nWatch Summary:")
+	fmt.Fprintln(w, "==============")
+	fmt.Fprintf(w, "Probes: %d (%d OK, %d FAIL)\n", s.Probes, s.Successes, s.Failures)
+	fmt.Fprintf(w, "Consecutive: %d OK, %d FAIL (max %d FAIL)\n",
+		s.ConsecutiveSuccesses, s.ConsecutiveFailures, s.MaxConsecutiveFailure)
+	fmt.Fprintf(w, "Uptime: %.2f%% over %s\n", s.UptimePercent, s.elapsed.Round(time.Second))
+	if s.Reconnects > 0 {
+		fmt.Fprintf(w, "Reconnects: %d (%d forced by connection errors)\n", s.Reconnects, s.ForcedReconnects)
+	}
+	if s.PoolStats != nil {
+		s.PoolStats.writeText(w)
+	}
+	if s.Rate != nil {
+		s.Rate.writeText(w)
+	}
+	writeAddressStats(w, s.RoundRobin)
+	if s.BreakerState != "" {
+		fmt.Fprintf(w, "Circuit breaker: %s (opened %d times)\n", s.BreakerState, s.BreakerTrips)
+	}
+	if s.Health != nil {
+		s.Health.writeText(w)
+	}
+}
+
+// watchRecord is one probe in --format jsonl output.
+type watchRecord struct {
+	Type             string  `json:"type"`
+	Timestamp        string  `json:"timestamp"`
+	CorrelationID    string  `json:"correlation_id"`
+	Success          bool    `json:"success"`
+	LatencyMs        float64 `json:"latency_ms"`
+	ConnectLatencyMs float64 `json:"connect_latency_ms"`
+	QueryLatencyMs   float64 `json:"query_latency_ms"`
+	Error            string  `json:"error,omitempty"`
+	ErrorCategory    string  `json:"error_category,omitempty"`
+	SQLState         string  `json:"sqlstate,omitempty"`
+	ExitCode         int     `json:"exit_code,omitempty"`
+	BreakerState     string  `json:"breaker_state,omitempty"`
+	BackendPID       uint32  `json:"backend_pid,omitempty"`
+
+	PoolStats *poolStats `json:"pool_stats,omitempty"`
+}
+
+// runWatch probes the cluster every interval until SIGINT or SIGTERM, then
+// prints a summary. With format jsonl each probe is written to stdout as
+// soon as it completes, as one compact JSON record per line, and the
+// summary follows as a final record of type "summary". Without --pool each
+// probe is a fresh connect and info query, so connections DSQL has closed
+// server-side never get reused; with --pool a long-lived pool is pinged and
+// replaces dead connections itself; with --reuse-conn one connection is
+// kept and, after any connection-level error, re-established with a
+// backoff and a new IAM token, while errors in the query alone leave it in
+// place. A non-empty metricsAddr serves each probe's outcome as Prometheus
+// metrics and the latest result at /healthz. A non-nil breaker stretches
+// the interval while the cluster keeps failing, and a non-nil goal stops
+// the run once the cluster is healthy or the attempts run out, deciding the
+// exit code. A non-nil dedup holds back repeated passing probe lines and
+// prints a periodic rollup instead.
+func runWatch(ctx context.Context, cfg testConfig, interval time.Duration, breaker *circuitBreaker, goal *healthGoal, dedup *probeDedup, out, stdout io.Writer, format, metricsAddr string) int {
+	// stdout is an unbuffered file, so each encoded record reaches the reader immediately
+	var stream *json.Encoder
+	if format == "jsonl" {
+		stream = json.NewEncoder(stdout)
+	}
+
+	var metrics *probeMetrics
+	if metricsAddr != "" {
+		metrics = newProbeMetrics()
+		if err := metrics.serve(ctx, metricsAddr); err != nil {
+			slog.Error("failed to start metrics server", "addr", metricsAddr, "error", err)
+			return exitConfig
+		}
+	}
+
+	probe := func(ctx context.Context) (*ConnectionResult, error) {
+		result := &ConnectionResult{CorrelationID: cfg.runID, Host: cfg.conn.HostAddr, Port: cfg.conn.Port, SSLMode: cfg.conn.SSLMode}
+		return result, runConnectivityTest(ctx, cfg, io.Discard, result)
+	}
+
+	if cfg.usePool {
+		tlsObs := &tlsObserver{}
+		pool, err := connectPool(ctx, cfg, tlsObs)
+		if err != nil {
+			slog.Error("failed to create connection pool", "error", err)
+			return exitConfig
+		}
+		defer pool.Close()
+		if cfg.prewarm {
+			warmCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+			warm, err := dsqltest.PrewarmPool(warmCtx, pool)
+			cancel()
+			if err != nil {
+				err = interruptedError(ctx, connectFailure(err))
+				slog.Error("failed to pre-warm connection pool", "error", err, "exit_code", exitCodeOf(err))
+				return exitCodeOf(err)
+			}
+			fmt.Fprintf(out, "Connection pool pre-warmed: %s\n", newPrewarmReport(warm))
+		}
+		probe = func(ctx context.Context) (*ConnectionResult, error) {
+			return pingPool(ctx, pool, cfg, tlsObs)
+		}
+	}
+
+	var rc *reconnectingConn
+	if cfg.reuseConn {
+		dialCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+		var err error
+		rc, err = newReconnectingConn(dialCtx, cfg)
+		cancel()
+		if err != nil {
+			err = interruptedError(ctx, err)
+			slog.Error("failed to open connection", "error", err)
+			return exitCodeOf(err)
+		}
+		defer rc.close()
+		probe = func(ctx context.Context) (*ConnectionResult, error) {
+			return queryReused(ctx, rc, cfg)
+		}
+	}
+
+	if goal != nil {
+		fmt.Fprintf(out, "Watching DSQL cluster %s via %s every %s until %d consecutive probes pass (Ctrl-C to stop)\n",
+			cfg.conn.Hostname, cfg.conn.Address(), interval, goal.threshold)
+	} else {
+		fmt.Fprintf(out, "Watching DSQL cluster %s via %s every %s (Ctrl-C to stop)\n",
+			cfg.conn.Hostname, cfg.conn.Address(), interval)
+	}
+
+	summary := &watchSummary{CorrelationID: cfg.runID}
+	started := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	current := interval
+	code := exitOK
+	reached := false
+
+	for {
+		breaker.beforeProbe()
+		probeCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+		result, err := probe(probeCtx)
+		cancel()
+
+		// A probe cut short by shutdown isn't a cluster failure
+		if ctx.Err() != nil {
+			break
+		}
+
+		summary.record(err == nil)
+		breaker.record(err == nil)
+		if metrics != nil {
+			metrics.observe(result, err)
+		}
+		cfg.statsd.observe(result, err)
+		now := time.Now().UTC()
+		timestamp := now.Format(time.RFC3339)
+		if stream != nil {
+			rec := watchRecord{Type: "probe", Timestamp: now.Format(time.RFC3339Nano), CorrelationID: cfg.runID, Success: err == nil, BreakerState: breaker.currentState()}
+			if result != nil {
+				rec.LatencyMs = result.LatencyMs
+				rec.ConnectLatencyMs = result.ConnectLatencyMs
+				rec.QueryLatencyMs = result.QueryLatencyMs
+				rec.PoolStats = result.PoolStats
+				rec.BackendPID = result.BackendPID
+			}
+			if err != nil {
+				rec.Error = err.Error()
+				rec.ErrorCategory = string(classifyError(err))
+				if d := newPGErrorDetail(err); d != nil {
+					rec.SQLState = d.Code
+				}
+				rec.ExitCode = exitCodeOf(err)
+			}
+			if err := stream.Encode(rec); err != nil {
+				slog.Error("failed to write JSON record", "error", err)
+				return exitFailure
+			}
+		}
+		var poolSuffix string
+		if result != nil && result.PoolStats != nil {
+			summary.PoolStats = result.PoolStats
+			poolSuffix = " " + result.PoolStats.String()
+		}
+		switch {
+		case dedup.suppress(err == nil, now, out):
+		case err != nil:
+			fmt.Fprintf(out, "%s %s %v%s\n", timestamp, okOrFail(false, "FAIL"), err, poolSuffix)
+		default:
+			fmt.Fprintf(out, "%s %s connect=%.2fms query=%.2fms pid=%d%s\n", timestamp, okOrFail(true, "OK"), result.ConnectLatencyMs, result.QueryLatencyMs, result.BackendPID, poolSuffix)
+		}
+		if summary.Health, code, reached = goal.check(summary, time.Since(started), err); reached {
+			break
+		}
+
+		// The ticker is only reset when the breaker changes the interval, so
+		// probes otherwise keep their fixed cadence
+		if next := breaker.nextInterval(interval); next != current {
+			ticker.Reset(next)
+			current = next
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	dedup.flush(time.Now().UTC(), out)
+	if rc != nil {
+		summary.Reconnects = rc.Reconnects()
+		summary.ForcedReconnects = rc.ForcedReconnects()
+	}
+	summary.Rate = cfg.rate.report()
+	summary.RoundRobin = cfg.rotation.report()
+	if breaker != nil {
+		summary.BreakerTrips = breaker.trips
+		summary.BreakerState = breaker.state
+	}
+	summary.elapsed = time.Since(started)
+	summary.DurationSeconds = summary.elapsed.Seconds()
+	// Stopping before the goal was decided proves nothing either way
+	if goal != nil && !reached {
+		code = exitInterrupted
+		if summary.Health == nil {
+			summary.Health = &healthReport{Threshold: goal.threshold, MaxAttempts: goal.maxAttempts}
+		}
+	}
+	if stream != nil {
+		rec := struct {
+			Type string `json:"type"`
+			*watchSummary
+		}{"summary", summary}
+		if err := stream.Encode(rec); err != nil {
+			slog.Error("failed to write JSON summary", "error", err)
+			return exitFailure
+		}
+		return code
+	}
+	return writeReport(stdout, out, summary, format == "json", code)
+}
+
+// pingPool acquires a pooled connection and pings it. A connection DSQL has
+// closed fails the probe and is discarded by the pool on release, so the
+// next probe dials a replacement. The result carries the pool statistics
+// taken after the connection is released.
+func pingPool(ctx context.Context, pool *pgxpool.Pool, cfg testConfig, tlsObs *tlsObserver) (*ConnectionResult, error) {
+	result := &ConnectionResult{CorrelationID: cfg.runID, Host: cfg.conn.HostAddr, Port: cfg.conn.Port, SSLMode: cfg.conn.SSLMode}
+	defer func() { result.PoolStats = newPoolStats(pool) }()
+
+	connectStart := time.Now()
+	pooled, err := pool.Acquire(ctx)
+	if err != nil {
+		return result, connectFailure(phaseError(ctx, "connect", cfg.timeout, fmt.Errorf("failed to acquire connection from pool: %w", err)))
+	}
+	defer pooled.Release()
+	result.ConnectLatencyMs = durationMs(time.Since(connectStart))
+	result.BackendPID = pooled.Conn().PgConn().PID()
+	if err := requireTLS(pooled.Conn()); err != nil {
+		return result, err
+	}
+	result.SSL = true
+
+	queryStart := time.Now()
+	if err := pooled.Ping(ctx); err != nil {
+		return result, withExitCode(exitQuery, phaseError(ctx, "query", cfg.timeout, fmt.Errorf("ping failed: %w", err)))
+	}
+	result.QueryLatencyMs = durationMs(time.Since(queryStart))
+	result.LatencyMs = result.ConnectLatencyMs + result.QueryLatencyMs
+	result.TLSVersion = tlsObs.version()
+	result.TLSCipher = tlsObs.cipherSuite()
+	result.Success = true
+	return result, nil
+}
+
+// queryReused runs the info query on the --reuse-conn connection. Connect
+// latency is zero unless the probe had to reconnect, in which case it's
+// the time spent reconnecting, and is left out of the query latency.
+func queryReused(ctx context.Context, rc *reconnectingConn, cfg testConfig) (*ConnectionResult, error) {
+	result := &ConnectionResult{CorrelationID: cfg.runID, Host: cfg.conn.HostAddr, Port: cfg.conn.Port, SSLMode: cfg.conn.SSLMode}
+
+	start := time.Now()
+	var info dsqltest.ConnectionInfo
+	reconnecting, err := rc.do(ctx, func(conn *pgx.Conn) error {
+		if err := requireTLS(conn); err != nil {
+			return err
+		}
+		result.SSL = true
+		result.BackendPID = conn.PgConn().PID()
+		var err error
+		info, err = dsqltest.QueryConnectionInfo(ctx, cfg.infoQuerier(conn))
+		return err
+	})
+	result.ConnectLatencyMs = durationMs(reconnecting)
+	if err != nil {
+		if exitCodeOf(err) == exitFailure {
+			err = withExitCode(exitQuery, err)
+		}
+		return result, phaseError(ctx, "query", cfg.timeout, err)
+	}
+	result.QueryLatencyMs = durationMs(time.Since(start) - reconnecting)
+	result.LatencyMs = result.ConnectLatencyMs + result.QueryLatencyMs
+	result.Database = info.Database
+	result.User = info.User
+	result.ServerVersion = info.ServerVersion
+	result.AppName = info.ApplicationName
+	result.UnavailableFields = info.Unavailable
+	result.Success = true
+	return result, nil
+}